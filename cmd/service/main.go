@@ -1,16 +1,34 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"ozonProject/config"
 	"ozonProject/graph"
+	"ozonProject/internal/cache"
+	"ozonProject/internal/filter"
+	"ozonProject/internal/outbox"
 	"ozonProject/internal/pubsub"
+	"ozonProject/internal/ratelimit"
+	"ozonProject/internal/reqctx"
 	"ozonProject/internal/service"
 	"ozonProject/internal/storage"
+	"ozonProject/internal/transfer"
+	"ozonProject/internal/validation"
+	"ozonProject/internal/webhook"
 
 	"ozonProject/pkg/postgres"
+	"ozonProject/pkg/sqlite"
+	"syscall"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
@@ -21,6 +39,8 @@ import (
 const (
 	queryPath      = "/query"
 	playgroundPath = "/playground"
+
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
@@ -29,30 +49,299 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	if len(os.Args) > 1 {
+		runCommand(config, os.Args[1], os.Args[2:])
+		return
+	}
+
 	runApp(config)
 }
 
+// runCommand executes a one-off maintenance command instead of the server.
+func runCommand(config config.Config, name string, args []string) {
+	var run func(repo storage.Storage) error
+	switch name {
+	case "reconcile-counters":
+		run = func(repo storage.Storage) error {
+			fixed, err := repo.ReconcileCounters(context.Background())
+			if err == nil {
+				log.Printf("reconciled counters, %d rows fixed", fixed)
+			}
+			return err
+		}
+	case "audit-export":
+		run = func(repo storage.Storage) error { return exportAudit(repo, args) }
+	case "export":
+		run = func(repo storage.Storage) error { return exportThreads(repo, args) }
+	case "import":
+		run = func(repo storage.Storage) error { return importThreads(repo, args) }
+	default:
+		log.Fatalf("unknown command %q", name)
+	}
+
+	repo := newStorage(config)
+	err := run(repo)
+	closeStorage(repo)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// closeStorage flushes storages that buffer writes, such as the in-memory
+// storage with its write-ahead log.
+func closeStorage(repo storage.Storage) {
+	if c, ok := repo.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("close storage: %v", err)
+		}
+	}
+}
+
+// exportAudit writes audit entries matching the flags to stdout as JSON lines.
+func exportAudit(repo storage.Storage, args []string) error {
+	var (
+		filter       storage.AuditFilter
+		since, until string
+	)
+
+	fs := flag.NewFlagSet("audit-export", flag.ExitOnError)
+	fs.StringVar(&filter.Actor, "actor", "", "only entries of this actor")
+	fs.StringVar(&filter.Action, "action", "", "only entries with this action, e.g. comment.delete")
+	fs.StringVar(&filter.TargetType, "target-type", "", "only entries of this target type")
+	fs.StringVar(&filter.TargetID, "target-id", "", "only entries of this target id")
+	fs.StringVar(&since, "since", "", "RFC 3339 time of the first entry")
+	fs.StringVar(&until, "until", "", "RFC 3339 time to stop before")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return fmt.Errorf("parse -since: %w", err)
+		}
+	}
+	if until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return fmt.Errorf("parse -until: %w", err)
+		}
+	}
+
+	const page = 500
+	enc := json.NewEncoder(os.Stdout)
+	for offset := 0; ; offset += page {
+		entries, err := repo.GetAuditLog(context.Background(), filter, page, offset)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		if len(entries) < page {
+			return nil
+		}
+	}
+}
+
+// exportThreads writes posts and their threads as NDJSON to the -o file or stdout.
+func exportThreads(repo storage.Storage, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "", "file to write, stdout when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	stats, err := transfer.Export(context.Background(), repo, w)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		if err := w.Sync(); err != nil {
+			return err
+		}
+	}
+	log.Printf("exported %d posts and %d comments", stats.Posts, stats.Comments)
+
+	return nil
+}
+
+// importThreads reads an NDJSON dump from the file given as argument or stdin.
+func importThreads(repo storage.Storage, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	skipExisting := fs.Bool("skip-existing", false, "skip posts and comments that already exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := os.Stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stats, err := transfer.Import(context.Background(), repo, r, transfer.ImportOptions{SkipExisting: *skipExisting})
+	log.Printf("imported %d posts and %d comments, skipped %d", stats.Posts, stats.Comments, stats.Skipped)
+
+	return err
+}
+
+func newStorage(config config.Config) storage.Storage {
+	switch config.StorageDriver {
+	case "postgres":
+		return usePostgres(config)
+	case "sqlite":
+		return useSQLite(config)
+	case "memory", "":
+		return useInMemory(config)
+	}
+
+	log.Fatalf("unknown storage driver %q, expected memory, postgres or sqlite", config.StorageDriver)
+	return nil
+}
+
 func usePostgres(config config.Config) storage.Storage {
 	connectionString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", config.DbUser, config.DbPassword, config.DbHost, config.DbPort, config.DbName)
-	pool := postgres.New(connectionString)
-	return storage.NewPostgresStorage(pool.Pool)
+	pool := postgres.New(connectionString, config.DbReplicas...)
+	if len(pool.Replicas) == 0 {
+		return storage.NewPostgresStorage(pool.Pool)
+	}
+
+	replicas := make([]storage.PgxPoolIface, 0, len(pool.Replicas))
+	for _, r := range pool.Replicas {
+		replicas = append(replicas, r)
+	}
+
+	repo := storage.NewPostgresStorage(pool.Pool, storage.WithReplicas(storage.ReplicaConfig{
+		MaxLag:         config.DbReplicaMaxLag,
+		HealthInterval: config.DbReplicaCheckInterval,
+	}, replicas...))
+	go repo.RunReplicaChecks(context.Background())
+
+	return repo
 }
 
-func useInMemory() storage.Storage {
-	return storage.NewInMemoryStorage()
+func useSQLite(config config.Config) storage.Storage {
+	db, err := sqlite.New(config.SqlitePath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return storage.NewSQLiteStorage(db.DB)
 }
 
-func runApp(config config.Config) {
-	var repo storage.Storage
-	if config.PersistanceEnabled {
-		repo = usePostgres(config)
-	} else {
-		repo = useInMemory()
+func useInMemory(config config.Config) storage.Storage {
+	if config.InMemoryDataDir == "" {
+		return storage.NewInMemoryStorage()
 	}
 
-	service := service.New(repo)
+	repo, err := storage.OpenInMemoryStorage(storage.PersistenceConfig{
+		Dir:               config.InMemoryDataDir,
+		Fsync:             storage.FsyncPolicy(config.InMemoryFsync),
+		FsyncInterval:     config.InMemoryFsyncInterval,
+		SnapshotInterval:  config.InMemorySnapshotInterval,
+		CompactAfterBytes: config.InMemoryCompactAfterBytes,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return repo
+}
+
+func useRateLimiter(config config.Config) *ratelimit.Limiter {
+	ipRule := ratelimit.PerMinute(config.RateLimitIPMutationsPerMinute)
+
+	store := ratelimit.NewMemoryStore()
+	if config.RateLimitSweepInterval > 0 {
+		go store.Run(context.Background(), config.RateLimitSweepInterval, max(config.RateLimitIdleTTL, config.RateLimitNewAuthorSpan))
+	}
+
+	return ratelimit.New(store, map[string]ratelimit.Policy{
+		service.OpCreatePost: {
+			PerAuthor: ratelimit.PerMinute(config.RateLimitPostsPerMinute),
+			PerIP:     ipRule,
+		},
+		service.OpCreateComment: {
+			PerAuthor:     ratelimit.PerMinute(config.RateLimitCommentsPerMinute),
+			NewAuthor:     ratelimit.PerMinute(config.RateLimitNewAuthorPerMinute),
+			NewAuthorSpan: config.RateLimitNewAuthorSpan,
+			PerIP:         ipRule,
+		},
+	})
+}
+
+func useContentFilter(config config.Config) *filter.Pipeline {
+	return &filter.Pipeline{
+		Filters: []filter.ContentFilter{
+			filter.NewBannedWords(config.ContentFilterBannedWords),
+			&filter.LinkLimit{Max: config.ContentFilterMaxLinks},
+			filter.NewDuplicates(config.ContentFilterDuplicateWindow),
+		},
+		HoldScore: config.ContentFilterHoldScore,
+	}
+}
+
+// useCache puts the read-through cache in front of repo and publishes its
+// hit and miss counters at /debug/vars.
+func useCache(config config.Config, repo storage.Storage, bus *pubsub.Bus) storage.Storage {
+	cached := storage.NewCachedStorage(repo, cache.NewLRU(config.CacheSize), bus, config.CacheTTL)
+	expvar.Publish("storage_cache", expvar.Func(func() any { return cached.Stats() }))
+
+	return cached
+}
+
+func runApp(config config.Config) {
 	bus := pubsub.New()
 
+	base := newStorage(config)
+	repo := base
+	if config.CacheEnabled {
+		repo = useCache(config, repo, bus)
+	}
+
+	opts := []service.Option{
+		service.WithIdempotencyTTL(config.IdempotencyKeyTTL),
+		service.WithLimits(validation.Limits{
+			MaxTitleLen:   config.MaxPostTitleLength,
+			MaxPostLen:    config.MaxPostContentLength,
+			MaxCommentLen: config.MaxCommentLength,
+			MaxAuthorLen:  config.MaxAuthorLength,
+		}),
+		service.WithMarkdownCacheSize(config.MarkdownCacheSize),
+		service.WithAdmins(config.Admins),
+		service.WithMaxReplyDepth(config.MaxReplyDepth, config.ReplyDepthOverflow == "flatten"),
+	}
+	if config.RateLimitEnabled {
+		opts = append(opts, service.WithRateLimiter(useRateLimiter(config)))
+	}
+	if config.ContentFilterEnabled {
+		opts = append(opts, service.WithContentFilter(useContentFilter(config)))
+	}
+
+	if config.IdempotencyPurgeInterval > 0 {
+		go purgeIdempotencyKeys(repo, config.IdempotencyPurgeInterval)
+	}
+
+	sender := useWebhooks(config, repo)
+	opts = append(opts, service.WithBus(bus), service.WithOutbox(useOutbox(config, base, repo, bus, sender)), service.WithWebhooks(sender))
+	service := service.New(repo, opts...)
+
 	server := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{
 		Resolvers: &graph.Resolver{Service: service, Bus: bus},
 	}))
@@ -61,9 +350,95 @@ func runApp(config config.Config) {
 	})
 
 	http.Handle(playgroundPath, playground.Handler("Playground", queryPath))
-	http.Handle(queryPath, server)
+	proxies, err := reqctx.ParseProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	adminTokens, err := reqctx.ParseAdminTokens(config.AdminTokens)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	http.Handle(queryPath, reqctx.Middleware(proxies, adminTokens)(server))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: config.AppPort}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
 
 	log.Printf("listening on %s", config.AppPort)
 	log.Printf("Sandbox:  http://localhost:%s%s", config.AppPort, playgroundPath)
-	log.Fatal(http.ListenAndServe(config.AppPort, nil))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err.Error())
+	}
+
+	// Requests still running are waited for, so the storage is closed only
+	// once nothing writes to it.
+	<-drained
+	closeStorage(base)
+	log.Printf("stopped")
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval.
+func purgeIdempotencyKeys(repo storage.Storage, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := repo.PurgeIdempotencyKeys(context.Background())
+		if err != nil {
+			log.Printf("purge idempotency keys: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("purged %d expired idempotency keys", n)
+		}
+	}
+}
+
+// useOutbox starts delivering outbox events to subscribers of bus and to
+// webhooks and publishes delivery counters at /debug/vars. When base is shared
+// by several instances, published comments are broadcast through it so
+// subscribers of every instance get them.
+func useOutbox(config config.Config, base, repo storage.Storage, bus *pubsub.Bus, sender *webhook.Sender) *outbox.Dispatcher {
+	var sink outbox.Sink = outbox.NewBusSink(bus)
+	if b, ok := base.(storage.Broadcaster); ok {
+		sink = outbox.NewBroadcastSink(b)
+		go outbox.Listen(context.Background(), b, repo, bus)
+	}
+
+	dispatcher := outbox.NewDispatcher(repo, outbox.Config{
+		Interval:  config.OutboxPollInterval,
+		BatchSize: config.OutboxBatchSize,
+		Lease:     config.OutboxLease,
+	}, sink, sender)
+	expvar.Publish("outbox", expvar.Func(func() any { return dispatcher.Stats() }))
+
+	go dispatcher.Run(context.Background())
+
+	return dispatcher
+}
+
+// useWebhooks starts sending queued webhook deliveries and publishes its
+// counters at /debug/vars.
+func useWebhooks(config config.Config, repo storage.Storage) *webhook.Sender {
+	sender := webhook.NewSender(repo, webhook.Config{
+		Interval:     config.WebhookPollInterval,
+		MaxAttempts:  config.WebhookMaxAttempts,
+		Backoff:      config.WebhookBackoff,
+		MaxBackoff:   config.WebhookMaxBackoff,
+		Timeout:      config.WebhookTimeout,
+		AllowPrivate: config.WebhookAllowPrivate,
+	})
+	expvar.Publish("webhooks", expvar.Func(func() any { return sender.Stats() }))
+
+	go sender.Run(context.Background())
+
+	return sender
 }
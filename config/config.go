@@ -2,18 +2,77 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	AppPort            string `mapstructure:"APP_PORT"`
-	PersistanceEnabled bool   `mapstructure:"PERSISTANCE_ENABLED"`
-	DbHost             string `mapstructure:"DB_HOST"`
-	DbPort             string `mapstructure:"DB_PORT"`
-	DbUser             string `mapstructure:"DB_USER"`
-	DbPassword         string `mapstructure:"DB_PASSWORD"`
-	DbName             string `mapstructure:"DB_NAME"`
+	AppPort       string `mapstructure:"APP_PORT"`
+	StorageDriver string `mapstructure:"STORAGE_DRIVER"`
+	DbHost        string `mapstructure:"DB_HOST"`
+	DbPort        string `mapstructure:"DB_PORT"`
+	DbUser        string `mapstructure:"DB_USER"`
+	DbPassword    string `mapstructure:"DB_PASSWORD"`
+	DbName        string `mapstructure:"DB_NAME"`
+	SqlitePath    string `mapstructure:"SQLITE_PATH"`
+
+	DbReplicas             []string      `mapstructure:"DB_REPLICAS"`
+	DbReplicaMaxLag        time.Duration `mapstructure:"DB_REPLICA_MAX_LAG"`
+	DbReplicaCheckInterval time.Duration `mapstructure:"DB_REPLICA_CHECK_INTERVAL"`
+
+	RateLimitEnabled              bool          `mapstructure:"RATE_LIMIT_ENABLED"`
+	RateLimitPostsPerMinute       int           `mapstructure:"RATE_LIMIT_POSTS_PER_MINUTE"`
+	RateLimitCommentsPerMinute    int           `mapstructure:"RATE_LIMIT_COMMENTS_PER_MINUTE"`
+	RateLimitNewAuthorPerMinute   int           `mapstructure:"RATE_LIMIT_NEW_AUTHOR_PER_MINUTE"`
+	RateLimitNewAuthorSpan        time.Duration `mapstructure:"RATE_LIMIT_NEW_AUTHOR_SPAN"`
+	RateLimitIPMutationsPerMinute int           `mapstructure:"RATE_LIMIT_IP_MUTATIONS_PER_MINUTE"`
+	RateLimitSweepInterval        time.Duration `mapstructure:"RATE_LIMIT_SWEEP_INTERVAL"`
+	RateLimitIdleTTL              time.Duration `mapstructure:"RATE_LIMIT_IDLE_TTL"`
+	TrustedProxies                []string      `mapstructure:"TRUSTED_PROXIES"`
+
+	IdempotencyKeyTTL        time.Duration `mapstructure:"IDEMPOTENCY_KEY_TTL"`
+	IdempotencyPurgeInterval time.Duration `mapstructure:"IDEMPOTENCY_PURGE_INTERVAL"`
+
+	MaxPostTitleLength   int `mapstructure:"MAX_POST_TITLE_LENGTH"`
+	MaxPostContentLength int `mapstructure:"MAX_POST_CONTENT_LENGTH"`
+	MaxCommentLength     int `mapstructure:"MAX_COMMENT_LENGTH"`
+	MaxAuthorLength      int `mapstructure:"MAX_AUTHOR_LENGTH"`
+
+	MarkdownCacheSize int `mapstructure:"MARKDOWN_CACHE_SIZE"`
+
+	ContentFilterEnabled         bool          `mapstructure:"CONTENT_FILTER_ENABLED"`
+	ContentFilterBannedWords     []string      `mapstructure:"CONTENT_FILTER_BANNED_WORDS"`
+	ContentFilterMaxLinks        int           `mapstructure:"CONTENT_FILTER_MAX_LINKS"`
+	ContentFilterDuplicateWindow time.Duration `mapstructure:"CONTENT_FILTER_DUPLICATE_WINDOW"`
+	ContentFilterHoldScore       float64       `mapstructure:"CONTENT_FILTER_HOLD_SCORE"`
+
+	Admins      []string `mapstructure:"ADMINS"`
+	AdminTokens []string `mapstructure:"ADMIN_TOKENS"`
+
+	MaxReplyDepth      int    `mapstructure:"MAX_REPLY_DEPTH"`
+	ReplyDepthOverflow string `mapstructure:"REPLY_DEPTH_OVERFLOW"`
+
+	InMemoryDataDir           string        `mapstructure:"INMEMORY_DATA_DIR"`
+	InMemoryFsync             string        `mapstructure:"INMEMORY_FSYNC"`
+	InMemoryFsyncInterval     time.Duration `mapstructure:"INMEMORY_FSYNC_INTERVAL"`
+	InMemorySnapshotInterval  time.Duration `mapstructure:"INMEMORY_SNAPSHOT_INTERVAL"`
+	InMemoryCompactAfterBytes int64         `mapstructure:"INMEMORY_COMPACT_AFTER_BYTES"`
+
+	CacheEnabled bool          `mapstructure:"CACHE_ENABLED"`
+	CacheSize    int           `mapstructure:"CACHE_SIZE"`
+	CacheTTL     time.Duration `mapstructure:"CACHE_TTL"`
+
+	OutboxPollInterval time.Duration `mapstructure:"OUTBOX_POLL_INTERVAL"`
+	OutboxBatchSize    int           `mapstructure:"OUTBOX_BATCH_SIZE"`
+	OutboxLease        time.Duration `mapstructure:"OUTBOX_LEASE"`
+
+	WebhookPollInterval time.Duration `mapstructure:"WEBHOOK_POLL_INTERVAL"`
+	WebhookMaxAttempts  int           `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookBackoff      time.Duration `mapstructure:"WEBHOOK_BACKOFF"`
+	WebhookMaxBackoff   time.Duration `mapstructure:"WEBHOOK_MAX_BACKOFF"`
+	WebhookTimeout      time.Duration `mapstructure:"WEBHOOK_TIMEOUT"`
+	WebhookAllowPrivate bool          `mapstructure:"WEBHOOK_ALLOW_PRIVATE"`
 }
 
 func Load() (config Config, err error) {
@@ -8,11 +8,17 @@ import (
 	"context"
 	"ozonProject/internal/models"
 	"ozonProject/internal/service"
+	"time"
 )
 
+// ContentHTML is the resolver for the contentHtml field.
+func (r *commentResolver) ContentHTML(ctx context.Context, obj *models.Comment) (string, error) {
+	return r.Service.RenderContent(obj.Content), nil
+}
+
 // Children is the resolver for the children field.
 func (r *commentResolver) Children(ctx context.Context, obj *models.Comment, limit *int, offset *int) ([]*models.Comment, error) {
-	comments, err := r.Service.ListComments(ctx, obj.PostID, obj.ParentID, limit, offset)
+	comments, err := r.Service.ListComments(ctx, obj.PostID, &obj.ID, limit, offset)
 	if err != nil {
 		return nil, service.ToUserError(err)
 	}
@@ -20,9 +26,59 @@ func (r *commentResolver) Children(ctx context.Context, obj *models.Comment, lim
 	return comments, nil
 }
 
+// Descendants is the resolver for the descendants field.
+func (r *commentResolver) Descendants(ctx context.Context, obj *models.Comment, limit *int, offset *int) ([]*models.Comment, error) {
+	return r.Service.Thread(ctx, obj.PostID, &obj.ID, limit, offset)
+}
+
+// DescendantCount is the resolver for the descendantCount field.
+func (r *commentResolver) DescendantCount(ctx context.Context, obj *models.Comment) (int, error) {
+	return r.Service.DescendantCount(ctx, obj.ID)
+}
+
+// Ancestors is the resolver for the ancestors field.
+func (r *commentResolver) Ancestors(ctx context.Context, obj *models.Comment) ([]*models.Comment, error) {
+	return r.Service.Ancestors(ctx, obj.ID)
+}
+
+// Post is the resolver for the post field.
+func (r *commentResolver) Post(ctx context.Context, obj *models.Comment) (*models.Post, error) {
+	return r.Service.CommentPost(ctx, obj)
+}
+
+// Parent is the resolver for the parent field.
+func (r *commentResolver) Parent(ctx context.Context, obj *models.Comment) (*models.Comment, error) {
+	return r.Service.CommentParent(ctx, obj)
+}
+
+// Posts is the resolver for the posts field.
+func (r *communityResolver) Posts(ctx context.Context, obj *models.Community, limit *int, offset *int, tag *string, sort *models.PostSort, window *models.TopWindow) ([]*models.Post, error) {
+	return r.Service.ListPosts(ctx, limit, offset, tag, &obj.Name, sort, window)
+}
+
+// CreateCommunity is the resolver for the createCommunity field.
+func (r *mutationResolver) CreateCommunity(ctx context.Context, name string, description *string, rules []string, creator string, moderators []string, commentsEnabled *bool, maxCommentLength *int) (*models.Community, error) {
+	community, err := r.Service.CreateCommunity(ctx, name, description, rules, creator, moderators, commentsEnabled, maxCommentLength)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return community, nil
+}
+
 // CreatePost is the resolver for the createPost field.
-func (r *mutationResolver) CreatePost(ctx context.Context, title string, content string, author string, commentsEnabled *bool) (*models.Post, error) {
-	post, err := r.Service.CreatePost(ctx, title, content, author, commentsEnabled)
+func (r *mutationResolver) CreatePost(ctx context.Context, community string, title string, content string, author string, commentsEnabled *bool, tags []string, clientMutationID *string) (*models.Post, error) {
+	post, err := r.Service.CreatePost(ctx, community, title, content, author, commentsEnabled, tags, clientMutationID)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return post, nil
+}
+
+// VotePost is the resolver for the votePost field.
+func (r *mutationResolver) VotePost(ctx context.Context, postID string, voter string, value int) (*models.Post, error) {
+	post, err := r.Service.VotePost(ctx, postID, voter, value)
 	if err != nil {
 		return nil, service.ToUserError(err)
 	}
@@ -31,20 +87,93 @@ func (r *mutationResolver) CreatePost(ctx context.Context, title string, content
 }
 
 // CreateComment is the resolver for the createComment field.
-func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentId *string, author string, content string) (*models.Comment, error) {
-	c, err := r.Service.CreateComment(ctx, postID, parentId, author, content)
+func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentID *string, author string, content string, clientMutationID *string) (*models.Comment, error) {
+	c, err := r.Service.CreateComment(ctx, postID, parentID, author, content, clientMutationID)
 	if err != nil {
 		return nil, service.ToUserError(err)
 	}
 
-	r.Bus.Publish(c)
+	return c, nil
+}
+
+// DeleteComment is the resolver for the deleteComment field.
+func (r *mutationResolver) DeleteComment(ctx context.Context, id string, author string) (*models.Comment, error) {
+	c, err := r.Service.DeleteComment(ctx, id, author)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
 
 	return c, nil
 }
 
+// ReportContent is the resolver for the reportContent field.
+func (r *mutationResolver) ReportContent(ctx context.Context, targetType models.ReportTargetType, targetID string, reason string, reporter string) (*models.Report, error) {
+	report, err := r.Service.ReportContent(ctx, targetType, targetID, reason, reporter)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return report, nil
+}
+
+// ResolveReport is the resolver for the resolveReport field.
+func (r *mutationResolver) ResolveReport(ctx context.Context, reportID string, moderator string, action models.ModerationAction, note *string) (*models.Report, error) {
+	report, err := r.Service.ResolveReport(ctx, reportID, moderator, action, note)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return report, nil
+}
+
+// MarkNotificationsRead is the resolver for the markNotificationsRead field.
+func (r *mutationResolver) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	return r.Service.MarkNotificationsRead(ctx, recipient, ids)
+}
+
+// BanUser is the resolver for the banUser field.
+func (r *mutationResolver) BanUser(ctx context.Context, userID string, scope models.BanScope, scopeID *string, until *time.Time, reason string, moderator string) (*models.Ban, error) {
+	ban, err := r.Service.BanUser(ctx, userID, scope, scopeID, until, reason, moderator)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return ban, nil
+}
+
+// CreateWebhook is the resolver for the createWebhook field.
+func (r *mutationResolver) CreateWebhook(ctx context.Context, url string, secret string, events []models.WebhookEvent) (*models.Webhook, error) {
+	webhook, err := r.Service.CreateWebhook(ctx, url, secret, events)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook is the resolver for the deleteWebhook field.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	return r.Service.DeleteWebhook(ctx, id)
+}
+
+// RetryWebhookDelivery is the resolver for the retryWebhookDelivery field.
+func (r *mutationResolver) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	return r.Service.RetryWebhookDelivery(ctx, id)
+}
+
+// ContentHTML is the resolver for the contentHtml field.
+func (r *postResolver) ContentHTML(ctx context.Context, obj *models.Post) (string, error) {
+	return r.Service.RenderContent(obj.Content), nil
+}
+
+// Community is the resolver for the community field.
+func (r *postResolver) Community(ctx context.Context, obj *models.Post) (*models.Community, error) {
+	return r.Service.GetCommunity(ctx, obj.CommunityName)
+}
+
 // Comments is the resolver for the comments field.
-func (r *postResolver) Comments(ctx context.Context, obj *models.Post, limit *int, offset *int, parentId *string) ([]*models.Comment, error) {
-	comments, err := r.Service.ListComments(ctx, obj.ID, parentId, limit, offset)
+func (r *postResolver) Comments(ctx context.Context, obj *models.Post, limit *int, offset *int, parentID *string) ([]*models.Comment, error) {
+	comments, err := r.Service.ListComments(ctx, obj.ID, parentID, limit, offset)
 	if err != nil {
 		return nil, service.ToUserError(err)
 	}
@@ -52,9 +181,14 @@ func (r *postResolver) Comments(ctx context.Context, obj *models.Post, limit *in
 	return comments, nil
 }
 
+// Thread is the resolver for the thread field.
+func (r *postResolver) Thread(ctx context.Context, obj *models.Post, limit *int, offset *int) ([]*models.Comment, error) {
+	return r.Service.Thread(ctx, obj.ID, nil, limit, offset)
+}
+
 // Posts is the resolver for the posts field.
-func (r *queryResolver) Posts(ctx context.Context, limit *int, offset *int) ([]*models.Post, error) {
-	return r.Service.ListPosts(ctx, limit, offset)
+func (r *queryResolver) Posts(ctx context.Context, limit *int, offset *int, tag *string, community *string, sort *models.PostSort, window *models.TopWindow) ([]*models.Post, error) {
+	return r.Service.ListPosts(ctx, limit, offset, tag, community, sort, window)
 }
 
 // Post is the resolver for the post field.
@@ -62,6 +196,66 @@ func (r *queryResolver) Post(ctx context.Context, id string) (*models.Post, erro
 	return r.Service.GetPost(ctx, id)
 }
 
+// Comment is the resolver for the comment field.
+func (r *queryResolver) Comment(ctx context.Context, id string, contextDepth *int, childrenLimit *int) (*models.CommentPermalink, error) {
+	return r.Service.GetCommentPermalink(ctx, id, contextDepth, childrenLimit)
+}
+
+// Community is the resolver for the community field.
+func (r *queryResolver) Community(ctx context.Context, name string) (*models.Community, error) {
+	return r.Service.GetCommunity(ctx, name)
+}
+
+// Communities is the resolver for the communities field.
+func (r *queryResolver) Communities(ctx context.Context, limit *int, offset *int) ([]*models.Community, error) {
+	return r.Service.ListCommunities(ctx, limit, offset)
+}
+
+// Search is the resolver for the search field.
+func (r *queryResolver) Search(ctx context.Context, query string, typeArg *models.SearchType, limit *int, after *string) (*models.SearchConnection, error) {
+	conn, err := r.Service.Search(ctx, query, typeArg, limit, after)
+	if err != nil {
+		return nil, service.ToUserError(err)
+	}
+
+	return conn, nil
+}
+
+// Tags is the resolver for the tags field.
+func (r *queryResolver) Tags(ctx context.Context, limit *int) ([]*models.Tag, error) {
+	return r.Service.ListTags(ctx, limit)
+}
+
+// ModerationQueue is the resolver for the moderationQueue field.
+func (r *queryResolver) ModerationQueue(ctx context.Context, community string, moderator string, status *models.ReportStatus, limit *int, offset *int) ([]*models.Report, error) {
+	return r.Service.ModerationQueue(ctx, community, moderator, status, limit, offset)
+}
+
+// Notifications is the resolver for the notifications field.
+func (r *queryResolver) Notifications(ctx context.Context, recipient string, unreadOnly *bool, limit *int, offset *int) ([]*models.Notification, error) {
+	return r.Service.ListNotifications(ctx, recipient, unreadOnly, limit, offset)
+}
+
+// Bans is the resolver for the bans field.
+func (r *queryResolver) Bans(ctx context.Context, moderator string, scope models.BanScope, scopeID *string, limit *int, offset *int) ([]*models.Ban, error) {
+	return r.Service.ListBans(ctx, moderator, scope, scopeID, limit, offset)
+}
+
+// AuditLog is the resolver for the auditLog field.
+func (r *queryResolver) AuditLog(ctx context.Context, filter *models.AuditFilter, limit *int, offset *int) ([]*models.AuditEntry, error) {
+	return r.Service.AuditLog(ctx, filter, limit, offset)
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return r.Service.Webhooks(ctx)
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID *string, status *models.WebhookDeliveryStatus, limit *int, offset *int) ([]*models.WebhookDelivery, error) {
+	return r.Service.WebhookDeliveries(ctx, webhookID, status, limit, offset)
+}
+
 // CommentAdded is the resolver for the commentAdded field.
 func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string) (<-chan *models.Comment, error) {
 	ch := r.Bus.Subscribe(postID)
@@ -74,9 +268,24 @@ func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string)
 	return ch, nil
 }
 
+// NotificationAdded is the resolver for the notificationAdded field.
+func (r *subscriptionResolver) NotificationAdded(ctx context.Context, recipient string) (<-chan *models.Notification, error) {
+	ch := r.Bus.SubscribeNotifications(recipient)
+
+	go func() {
+		<-ctx.Done()
+		r.Bus.UnsubscribeNotifications(recipient, ch)
+	}()
+
+	return ch, nil
+}
+
 // Comment returns CommentResolver implementation.
 func (r *Resolver) Comment() CommentResolver { return &commentResolver{r} }
 
+// Community returns CommunityResolver implementation.
+func (r *Resolver) Community() CommunityResolver { return &communityResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
@@ -90,6 +299,7 @@ func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
 
 type commentResolver struct{ *Resolver }
+type communityResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
@@ -0,0 +1,82 @@
+package graph_test
+
+import (
+	"context"
+	"ozonProject/graph"
+	"ozonProject/internal/models"
+	"ozonProject/internal/service"
+	"ozonProject/internal/storage"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComment_ChildrenAreRepliesOfTheComment(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, post.ID, nil, "bob", "root", nil)
+	require.NoError(t, err)
+	reply, err := s.CreateComment(ctx, post.ID, &root.ID, "carol", "reply", nil)
+	require.NoError(t, err)
+
+	c := client.New(handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{
+		Resolvers: &graph.Resolver{Service: s},
+	})))
+
+	var resp struct {
+		Comment struct {
+			Comment struct {
+				Children []struct{ ID string }
+			}
+		}
+	}
+	c.MustPost(`query($id: ID!) { comment(id: $id) { comment { children { id } } } }`, &resp, client.Var("id", root.ID))
+
+	require.Len(t, resp.Comment.Comment.Children, 1)
+	require.Equal(t, reply.ID, resp.Comment.Comment.Children[0].ID)
+}
+
+func TestComment_HiddenParentAndPostAreNull(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	s := service.New(repo)
+	ctx := context.Background()
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, post.ID, nil, "bob", "root", nil)
+	require.NoError(t, err)
+	reply, err := s.CreateComment(ctx, post.ID, &root.ID, "carol", "reply", nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, root.ID))
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, post.ID))
+
+	c := client.New(handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{
+		Resolvers: &graph.Resolver{Service: s},
+	})))
+
+	var resp struct {
+		Comment struct {
+			Comment struct {
+				ID     string
+				Parent *struct{ ID string }
+				Post   *struct{ ID string }
+			}
+		}
+	}
+	c.MustPost(`query($id: ID!) { comment(id: $id) { comment { id parent { id } post { id } } } }`, &resp, client.Var("id", reply.ID))
+
+	require.Equal(t, reply.ID, resp.Comment.Comment.ID)
+	require.Nil(t, resp.Comment.Comment.Parent)
+	require.Nil(t, resp.Comment.Comment.Post)
+
+	var postResp struct{ Post *struct{ ID string } }
+	err = c.Post(`query($id: ID!) { post(id: $id) { id } }`, &postResp, client.Var("id", post.ID))
+	require.ErrorContains(t, err, storage.ErrPostNotFound.Error())
+}
@@ -0,0 +1,50 @@
+package cache_test
+
+import (
+	"context"
+	"ozonProject/internal/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLRU(2)
+
+	require.NoError(t, c.Set(ctx, "a", []byte("1"), 0))
+	require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+
+	_, ok, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, c.Set(ctx, "c", []byte("3"), 0))
+
+	_, ok, _ = c.Get(ctx, "b")
+	require.False(t, ok, "b was used least recently")
+
+	v, ok, _ := c.Get(ctx, "a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), v)
+
+	require.NoError(t, c.Delete(ctx, "a"))
+	_, ok, _ = c.Get(ctx, "a")
+	require.False(t, ok)
+}
+
+func TestLRU_ExpiresAfterTTL(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLRU(10)
+
+	require.NoError(t, c.Set(ctx, "short", []byte("1"), time.Millisecond))
+	require.NoError(t, c.Set(ctx, "forever", []byte("2"), 0))
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok, _ := c.Get(ctx, "short")
+	require.False(t, ok)
+	_, ok, _ = c.Get(ctx, "forever")
+	require.True(t, ok)
+}
@@ -0,0 +1,119 @@
+package filter
+
+import (
+	"context"
+	"crypto/sha256"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BannedWords flags content containing any of the configured words, case-insensitively.
+type BannedWords struct {
+	words map[string]struct{}
+}
+
+func NewBannedWords(words []string) *BannedWords {
+	f := &BannedWords{words: make(map[string]struct{}, len(words))}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words[w] = struct{}{}
+		}
+	}
+
+	return f
+}
+
+func (f *BannedWords) Check(ctx context.Context, c Content) (Result, error) {
+	words := strings.FieldsFunc(strings.ToLower(c.Title+" "+c.Body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, w := range words {
+		if _, ok := f.words[w]; ok {
+			return Result{Score: 1, Reason: "banned word"}, nil
+		}
+	}
+
+	return Result{}, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkLimit flags content with more than Max links.
+type LinkLimit struct {
+	Max int
+}
+
+func (f *LinkLimit) Check(ctx context.Context, c Content) (Result, error) {
+	if len(linkPattern.FindAllStringIndex(c.Body, f.Max+1)) > f.Max {
+		return Result{Score: 1, Reason: "too many links"}, nil
+	}
+
+	return Result{}, nil
+}
+
+// Duplicates flags an author repeating the same text within Window. Only
+// recorded, i.e. stored, content counts. State is kept in process memory, so
+// replicas detect duplicates independently.
+type Duplicates struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]time.Time
+	order []seenEntry
+}
+
+type seenEntry struct {
+	key [sha256.Size]byte
+	at  time.Time
+}
+
+func NewDuplicates(window time.Duration) *Duplicates {
+	return &Duplicates{
+		window: window,
+		seen:   make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+func duplicateKey(c Content) [sha256.Size]byte {
+	text := strings.Join(strings.Fields(strings.ToLower(c.Title+" "+c.Body)), " ")
+	return sha256.Sum256([]byte(c.Author + "\x00" + text))
+}
+
+// expire drops entries older than the window, f.mu must be held.
+func (f *Duplicates) expire(now time.Time) {
+	for len(f.order) > 0 && now.Sub(f.order[0].at) > f.window {
+		if e := f.order[0]; f.seen[e.key].Equal(e.at) {
+			delete(f.seen, e.key)
+		}
+		f.order = f.order[1:]
+	}
+}
+
+func (f *Duplicates) Check(ctx context.Context, c Content) (Result, error) {
+	key := duplicateKey(c)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expire(time.Now())
+	if _, dup := f.seen[key]; dup {
+		return Result{Score: 1, Reason: "duplicate content"}, nil
+	}
+
+	return Result{}, nil
+}
+
+func (f *Duplicates) Record(ctx context.Context, c Content) {
+	key, now := duplicateKey(c), time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expire(now)
+	f.seen[key] = now
+	f.order = append(f.order, seenEntry{key: key, at: now})
+}
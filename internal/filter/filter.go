@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// AutomodReporter is the reporter name of reports filed for held content.
+const AutomodReporter = "automod"
+
+var ErrRejected = errors.New("content rejected by filter")
+
+type Kind string
+
+const (
+	KindPost    Kind = "post"
+	KindComment Kind = "comment"
+)
+
+// Content is what a filter sees of a post or comment before it is stored.
+type Content struct {
+	Kind      Kind
+	Author    string
+	Community string
+	Title     string
+	Body      string
+}
+
+// Result scores content between 0 (clean) and 1 (certainly unwanted).
+type Result struct {
+	Score  float64
+	Reason string
+}
+
+type ContentFilter interface {
+	Check(ctx context.Context, c Content) (Result, error)
+}
+
+// Recorder is implemented by filters that remember content, Record is called
+// only after the content has been stored.
+type Recorder interface {
+	Record(ctx context.Context, c Content)
+}
+
+// RejectedError lists the reasons content was refused.
+type RejectedError struct {
+	Reasons []string
+}
+
+func (e *RejectedError) Error() string {
+	return ErrRejected.Error() + ": " + strings.Join(e.Reasons, ", ")
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Verdict is the outcome of a pipeline run that did not reject the content.
+type Verdict struct {
+	Hold    bool
+	Reasons []string
+}
+
+// Pipeline runs filters in order and sums their scores. With HoldScore unset
+// any filter scoring 1 rejects the content. In scoring mode, HoldScore > 0,
+// content reaching the threshold is held for moderation instead.
+type Pipeline struct {
+	Filters   []ContentFilter
+	HoldScore float64
+}
+
+func (p *Pipeline) Run(ctx context.Context, c Content) (Verdict, error) {
+	var (
+		total   float64
+		reasons []string
+	)
+
+	for _, f := range p.Filters {
+		res, err := f.Check(ctx, c)
+		if err != nil {
+			return Verdict{}, err
+		}
+		if res.Score <= 0 {
+			continue
+		}
+
+		total += res.Score
+		reasons = append(reasons, res.Reason)
+
+		if p.HoldScore <= 0 && res.Score >= 1 {
+			return Verdict{}, &RejectedError{Reasons: reasons}
+		}
+	}
+
+	if p.HoldScore > 0 && total >= p.HoldScore {
+		return Verdict{Hold: true, Reasons: reasons}, nil
+	}
+
+	return Verdict{Reasons: reasons}, nil
+}
+
+// Record passes stored content to the filters remembering it.
+func (p *Pipeline) Record(ctx context.Context, c Content) {
+	for _, f := range p.Filters {
+		if r, ok := f.(Recorder); ok {
+			r.Record(ctx, c)
+		}
+	}
+}
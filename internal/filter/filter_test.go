@@ -0,0 +1,66 @@
+package filter_test
+
+import (
+	"context"
+	"errors"
+	"ozonProject/internal/filter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_RejectsBannedWord(t *testing.T) {
+	p := &filter.Pipeline{Filters: []filter.ContentFilter{filter.NewBannedWords([]string{"Casino"})}}
+
+	_, err := p.Run(context.Background(), filter.Content{Kind: filter.KindComment, Author: "bob", Body: "best CASINO, join now"})
+	require.ErrorIs(t, err, filter.ErrRejected)
+
+	var rejected *filter.RejectedError
+	require.True(t, errors.As(err, &rejected))
+	require.Equal(t, []string{"banned word"}, rejected.Reasons)
+
+	v, err := p.Run(context.Background(), filter.Content{Kind: filter.KindComment, Author: "bob", Body: "casinos are boring"})
+	require.NoError(t, err)
+	require.False(t, v.Hold)
+}
+
+func TestPipeline_HoldsInScoringMode(t *testing.T) {
+	p := &filter.Pipeline{
+		Filters:   []filter.ContentFilter{&filter.LinkLimit{Max: 1}, filter.NewDuplicates(time.Minute)},
+		HoldScore: 1,
+	}
+	c := filter.Content{Kind: filter.KindComment, Author: "bob", Body: "see https://a.io and www.b.io"}
+
+	v, err := p.Run(context.Background(), c)
+	require.NoError(t, err)
+	require.True(t, v.Hold)
+	require.Equal(t, []string{"too many links"}, v.Reasons)
+
+	v, err = p.Run(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"too many links"}, v.Reasons, "content that was not stored is no duplicate")
+
+	p.Record(context.Background(), c)
+	v, err = p.Run(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"too many links", "duplicate content"}, v.Reasons)
+}
+
+func TestDuplicates_PerAuthor(t *testing.T) {
+	f := filter.NewDuplicates(time.Minute)
+	ctx := context.Background()
+
+	res, err := f.Check(ctx, filter.Content{Author: "bob", Body: "Hello  world"})
+	require.NoError(t, err)
+	require.Zero(t, res.Score)
+	f.Record(ctx, filter.Content{Author: "bob", Body: "Hello  world"})
+
+	res, err = f.Check(ctx, filter.Content{Author: "alice", Body: "hello world"})
+	require.NoError(t, err)
+	require.Zero(t, res.Score)
+
+	res, err = f.Check(ctx, filter.Content{Author: "bob", Body: "hello world"})
+	require.NoError(t, err)
+	require.Equal(t, 1.0, res.Score)
+}
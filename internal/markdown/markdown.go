@@ -0,0 +1,110 @@
+package markdown
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+const DefaultCacheSize = 10000
+
+// version is part of the cache key, bump it when the output of Render changes.
+const version = "1"
+
+type cacheKey [sha256.Size]byte
+
+type cacheEntry struct {
+	key  cacheKey
+	html string
+}
+
+// Renderer converts the supported Markdown subset to sanitized HTML and keeps
+// the most recently rendered revisions in an LRU cache.
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+}
+
+func NewRenderer(cacheSize int) *Renderer {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+
+	return &Renderer{
+		md: goldmark.New(
+			goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+			goldmark.WithRendererOptions(html.WithHardWraps()),
+		),
+		policy:  newPolicy(),
+		size:    cacheSize,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// newPolicy allows only the tags the Markdown subset produces, raw HTML in
+// the source is dropped by goldmark and anything else is removed here.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "em", "strong", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w-]+$`)).OnElements("code")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return p
+}
+
+// Render returns sanitized HTML for the Markdown source. The cache is keyed by
+// the content hash, so every revision of a post or comment is rendered once.
+func (r *Renderer) Render(source string) string {
+	key := sha256.Sum256([]byte(version + "\x00" + source))
+
+	r.mu.Lock()
+	if el, ok := r.entries[key]; ok {
+		r.lru.MoveToFront(el)
+		r.mu.Unlock()
+		return el.Value.(*cacheEntry).html
+	}
+	r.mu.Unlock()
+
+	out := r.render(source)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[key]; !ok {
+		r.entries[key] = r.lru.PushFront(&cacheEntry{key: key, html: out})
+		if r.lru.Len() > r.size {
+			oldest := r.lru.Back()
+			r.lru.Remove(oldest)
+			delete(r.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+
+	return out
+}
+
+func (r *Renderer) render(source string) string {
+	var buf bytes.Buffer
+	if err := r.md.Convert([]byte(source), &buf); err != nil {
+		return r.policy.Sanitize(source)
+	}
+
+	return r.policy.Sanitize(buf.String())
+}
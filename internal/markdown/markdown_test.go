@@ -0,0 +1,39 @@
+package markdown_test
+
+import (
+	"ozonProject/internal/markdown"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender_Subset(t *testing.T) {
+	t.Parallel()
+	r := markdown.NewRenderer(0)
+
+	require.Equal(t, "<p><strong>bold</strong> and <em>it</em> and <del>gone</del></p>\n",
+		r.Render("**bold** and *it* and ~~gone~~"))
+	require.Equal(t, "<pre><code class=\"language-go\">fmt.Println(&#34;hi&#34;)\n</code></pre>\n",
+		r.Render("```go\nfmt.Println(\"hi\")\n```"))
+	require.Equal(t, "<p>line<br>\nbreak</p>\n", r.Render("line\nbreak"))
+}
+
+func TestRender_Sanitizes(t *testing.T) {
+	t.Parallel()
+	r := markdown.NewRenderer(0)
+
+	require.NotContains(t, r.Render("<script>alert(1)</script>"), "<script")
+	require.NotContains(t, r.Render("<img src=x onerror=alert(1)>"), "onerror")
+	require.NotContains(t, r.Render("[click](javascript:alert(1))"), "javascript")
+	require.Equal(t, "<p><a href=\"https://example.com\" rel=\"nofollow noopener\" target=\"_blank\">site</a></p>\n",
+		r.Render("[site](https://example.com)"))
+}
+
+func TestRender_CachedOutputIsStable(t *testing.T) {
+	t.Parallel()
+	r := markdown.NewRenderer(1)
+
+	first := r.Render("*a*")
+	require.Equal(t, "<p><em>b</em></p>\n", r.Render("*b*"))
+	require.Equal(t, first, r.Render("*a*"))
+}
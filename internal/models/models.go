@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Post struct {
 	ID              string    `json:"id"`
@@ -9,13 +12,112 @@ type Post struct {
 	Author          string    `json:"author"`
 	CommentsEnabled bool      `json:"commentsEnabled"`
 	CreatedAt       time.Time `json:"createdAt"`
+	Score           int       `json:"score"`
+	CommentCount    int       `json:"commentCount"`
+	HotRank         float64   `json:"-"`
+	Tags            []string  `json:"tags"`
+	CommunityName   string    `json:"communityName"`
+	Hidden          bool      `json:"hidden"`
+	Deleted         bool      `json:"deleted"`
+}
+
+type Community struct {
+	Name             string    `json:"name"`
+	Description      string    `json:"description"`
+	Rules            []string  `json:"rules"`
+	Moderators       []string  `json:"moderators"`
+	CommentsEnabled  bool      `json:"commentsEnabled"`
+	MaxCommentLength int       `json:"maxCommentLength"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+func (c *Community) IsModerator(user string) bool {
+	for _, m := range c.Moderators {
+		if m == user {
+			return true
+		}
+	}
+	return false
 }
 
 type Comment struct {
-	ID        string    `json:"id"`
-	PostID    string    `json:"postId"`
-	ParentID  *string   `json:"parentId,omitempty"`
-	Author    string    `json:"author"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID         string    `json:"id"`
+	PostID     string    `json:"postId"`
+	ParentID   *string   `json:"parentId,omitempty"`
+	Author     string    `json:"author"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ReplyCount int       `json:"replyCount"`
+	Depth      int       `json:"depth"`
+	Pending    bool      `json:"pending"`
+	Hidden     bool      `json:"hidden"`
+	Deleted    bool      `json:"deleted"`
+}
+
+type Report struct {
+	ID           string                `json:"id"`
+	TargetType   ReportTargetType      `json:"targetType"`
+	TargetID     string                `json:"targetId"`
+	TargetAuthor string                `json:"targetAuthor"`
+	Community    string                `json:"community"`
+	Reporter     string                `json:"reporter"`
+	Reason       string                `json:"reason"`
+	Status       ReportStatus          `json:"status"`
+	CreatedAt    time.Time             `json:"createdAt"`
+	Decisions    []*ModerationDecision `json:"decisions"`
+}
+
+// ModerationDecision is an audit trail entry of a moderator acting on a report.
+type ModerationDecision struct {
+	ID        string           `json:"id"`
+	ReportID  string           `json:"reportId"`
+	Moderator string           `json:"moderator"`
+	Action    ModerationAction `json:"action"`
+	Note      string           `json:"note"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+type OutboxEventType string
+
+const (
+	// OutboxCommentPublished carries a comment that became visible, either
+	// created or approved by a moderator.
+	OutboxCommentPublished OutboxEventType = "comment.published"
+	// OutboxCommentDeleted carries a published comment after its removal,
+	// content and author are already replaced by the placeholder.
+	OutboxCommentDeleted OutboxEventType = "comment.deleted"
+	// OutboxCommentHidden carries a published comment a moderator hid, it is
+	// not repeated when the comment is hidden again.
+	OutboxCommentHidden OutboxEventType = "comment.hidden"
+	OutboxPostCreated   OutboxEventType = "post.created"
+	OutboxPostDeleted   OutboxEventType = "post.deleted"
+	// OutboxPostHidden carries a post a moderator hid, like
+	// OutboxCommentHidden.
+	OutboxPostHidden OutboxEventType = "post.hidden"
+)
+
+// Posts and comments cannot be edited, so there are no events of edits.
+
+// OutboxEvent is a change written in the same transaction as the entity it
+// describes and delivered to subscribers after the commit.
+type OutboxEvent struct {
+	ID   string          `json:"id"`
+	Type OutboxEventType `json:"type"`
+	// Key is the post the event belongs to.
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Webhook subscribes an external URL to events, payloads are signed with
+// Secret. The secret is stored in plaintext, since signing needs it, and is
+// left out of JSON and of the GraphQL type.
+type Webhook struct {
+	ID        string         `json:"id"`
+	URL       string         `json:"url"`
+	Secret    string         `json:"-"`
+	Events    []WebhookEvent `json:"events"`
+	CreatedBy string         `json:"createdBy"`
+	CreatedAt time.Time      `json:"createdAt"`
 }
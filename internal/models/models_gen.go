@@ -2,11 +2,683 @@
 
 package models
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type AuditEntry struct {
+	ID         string    `json:"id"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"targetType"`
+	TargetID   string    `json:"targetId"`
+	Before     *string   `json:"before,omitempty"`
+	After      *string   `json:"after,omitempty"`
+	RequestID  string    `json:"requestId"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type AuditFilter struct {
+	Actor      *string    `json:"actor,omitempty"`
+	Action     *string    `json:"action,omitempty"`
+	TargetType *string    `json:"targetType,omitempty"`
+	TargetID   *string    `json:"targetId,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+type Ban struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	Scope     BanScope   `json:"scope"`
+	ScopeID   string     `json:"scopeId"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Until     *time.Time `json:"until,omitempty"`
+}
+
+type CommentPermalink struct {
+	Comment   *Comment   `json:"comment"`
+	Ancestors []*Comment `json:"ancestors"`
+	Children  []*Comment `json:"children"`
+}
+
 type Mutation struct {
 }
 
+type Notification struct {
+	ID        string           `json:"id"`
+	Recipient string           `json:"recipient"`
+	Type      NotificationType `json:"type"`
+	Actor     string           `json:"actor"`
+	PostID    string           `json:"postId"`
+	CommentID string           `json:"commentId"`
+	Read      bool             `json:"read"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
 type Query struct {
 }
 
+type SearchConnection struct {
+	Results     []*SearchResult `json:"results"`
+	EndCursor   *string         `json:"endCursor,omitempty"`
+	HasNextPage bool            `json:"hasNextPage"`
+}
+
+type SearchResult struct {
+	Type    SearchType `json:"type"`
+	Rank    float64    `json:"rank"`
+	Snippet string     `json:"snippet"`
+	Post    *Post      `json:"post,omitempty"`
+	Comment *Comment   `json:"comment,omitempty"`
+}
+
 type Subscription struct {
 }
+
+type Tag struct {
+	Name      string `json:"name"`
+	PostCount int    `json:"postCount"`
+}
+
+type WebhookDelivery struct {
+	ID             string                `json:"id"`
+	WebhookID      string                `json:"webhookId"`
+	EventID        string                `json:"eventId"`
+	Event          WebhookEvent          `json:"event"`
+	Payload        string                `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	LastStatusCode *int                  `json:"lastStatusCode,omitempty"`
+	LastError      *string               `json:"lastError,omitempty"`
+	CreatedAt      time.Time             `json:"createdAt"`
+	NextAttemptAt  *time.Time            `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time            `json:"deliveredAt,omitempty"`
+}
+
+type BanScope string
+
+const (
+	BanScopeGlobal    BanScope = "GLOBAL"
+	BanScopeCommunity BanScope = "COMMUNITY"
+	BanScopePost      BanScope = "POST"
+)
+
+var AllBanScope = []BanScope{
+	BanScopeGlobal,
+	BanScopeCommunity,
+	BanScopePost,
+}
+
+func (e BanScope) IsValid() bool {
+	switch e {
+	case BanScopeGlobal, BanScopeCommunity, BanScopePost:
+		return true
+	}
+	return false
+}
+
+func (e BanScope) String() string {
+	return string(e)
+}
+
+func (e *BanScope) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = BanScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid BanScope", str)
+	}
+	return nil
+}
+
+func (e BanScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *BanScope) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e BanScope) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type ModerationAction string
+
+const (
+	ModerationActionDismiss ModerationAction = "DISMISS"
+	ModerationActionHide    ModerationAction = "HIDE"
+	ModerationActionDelete  ModerationAction = "DELETE"
+	ModerationActionBan     ModerationAction = "BAN"
+	ModerationActionApprove ModerationAction = "APPROVE"
+)
+
+var AllModerationAction = []ModerationAction{
+	ModerationActionDismiss,
+	ModerationActionHide,
+	ModerationActionDelete,
+	ModerationActionBan,
+	ModerationActionApprove,
+}
+
+func (e ModerationAction) IsValid() bool {
+	switch e {
+	case ModerationActionDismiss, ModerationActionHide, ModerationActionDelete, ModerationActionBan, ModerationActionApprove:
+		return true
+	}
+	return false
+}
+
+func (e ModerationAction) String() string {
+	return string(e)
+}
+
+func (e *ModerationAction) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ModerationAction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ModerationAction", str)
+	}
+	return nil
+}
+
+func (e ModerationAction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *ModerationAction) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e ModerationAction) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type NotificationType string
+
+const (
+	NotificationTypeReply   NotificationType = "REPLY"
+	NotificationTypeMention NotificationType = "MENTION"
+)
+
+var AllNotificationType = []NotificationType{
+	NotificationTypeReply,
+	NotificationTypeMention,
+}
+
+func (e NotificationType) IsValid() bool {
+	switch e {
+	case NotificationTypeReply, NotificationTypeMention:
+		return true
+	}
+	return false
+}
+
+func (e NotificationType) String() string {
+	return string(e)
+}
+
+func (e *NotificationType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationType", str)
+	}
+	return nil
+}
+
+func (e NotificationType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *NotificationType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e NotificationType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PostSort string
+
+const (
+	PostSortNew PostSort = "NEW"
+	PostSortTop PostSort = "TOP"
+	PostSortHot PostSort = "HOT"
+)
+
+var AllPostSort = []PostSort{
+	PostSortNew,
+	PostSortTop,
+	PostSortHot,
+}
+
+func (e PostSort) IsValid() bool {
+	switch e {
+	case PostSortNew, PostSortTop, PostSortHot:
+		return true
+	}
+	return false
+}
+
+func (e PostSort) String() string {
+	return string(e)
+}
+
+func (e *PostSort) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostSort", str)
+	}
+	return nil
+}
+
+func (e PostSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PostSort) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PostSort) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type ReportStatus string
+
+const (
+	ReportStatusOpen      ReportStatus = "OPEN"
+	ReportStatusDismissed ReportStatus = "DISMISSED"
+	ReportStatusActioned  ReportStatus = "ACTIONED"
+)
+
+var AllReportStatus = []ReportStatus{
+	ReportStatusOpen,
+	ReportStatusDismissed,
+	ReportStatusActioned,
+}
+
+func (e ReportStatus) IsValid() bool {
+	switch e {
+	case ReportStatusOpen, ReportStatusDismissed, ReportStatusActioned:
+		return true
+	}
+	return false
+}
+
+func (e ReportStatus) String() string {
+	return string(e)
+}
+
+func (e *ReportStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ReportStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ReportStatus", str)
+	}
+	return nil
+}
+
+func (e ReportStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *ReportStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e ReportStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type ReportTargetType string
+
+const (
+	ReportTargetTypePost    ReportTargetType = "POST"
+	ReportTargetTypeComment ReportTargetType = "COMMENT"
+)
+
+var AllReportTargetType = []ReportTargetType{
+	ReportTargetTypePost,
+	ReportTargetTypeComment,
+}
+
+func (e ReportTargetType) IsValid() bool {
+	switch e {
+	case ReportTargetTypePost, ReportTargetTypeComment:
+		return true
+	}
+	return false
+}
+
+func (e ReportTargetType) String() string {
+	return string(e)
+}
+
+func (e *ReportTargetType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ReportTargetType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ReportTargetType", str)
+	}
+	return nil
+}
+
+func (e ReportTargetType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *ReportTargetType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e ReportTargetType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type SearchType string
+
+const (
+	SearchTypeAll     SearchType = "ALL"
+	SearchTypePost    SearchType = "POST"
+	SearchTypeComment SearchType = "COMMENT"
+)
+
+var AllSearchType = []SearchType{
+	SearchTypeAll,
+	SearchTypePost,
+	SearchTypeComment,
+}
+
+func (e SearchType) IsValid() bool {
+	switch e {
+	case SearchTypeAll, SearchTypePost, SearchTypeComment:
+		return true
+	}
+	return false
+}
+
+func (e SearchType) String() string {
+	return string(e)
+}
+
+func (e *SearchType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SearchType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SearchType", str)
+	}
+	return nil
+}
+
+func (e SearchType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *SearchType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e SearchType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type TopWindow string
+
+const (
+	TopWindowDay   TopWindow = "DAY"
+	TopWindowWeek  TopWindow = "WEEK"
+	TopWindowMonth TopWindow = "MONTH"
+	TopWindowYear  TopWindow = "YEAR"
+	TopWindowAll   TopWindow = "ALL"
+)
+
+var AllTopWindow = []TopWindow{
+	TopWindowDay,
+	TopWindowWeek,
+	TopWindowMonth,
+	TopWindowYear,
+	TopWindowAll,
+}
+
+func (e TopWindow) IsValid() bool {
+	switch e {
+	case TopWindowDay, TopWindowWeek, TopWindowMonth, TopWindowYear, TopWindowAll:
+		return true
+	}
+	return false
+}
+
+func (e TopWindow) String() string {
+	return string(e)
+}
+
+func (e *TopWindow) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TopWindow(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TopWindow", str)
+	}
+	return nil
+}
+
+func (e TopWindow) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *TopWindow) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e TopWindow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookDeliveryStatusDead      WebhookDeliveryStatus = "DEAD"
+)
+
+var AllWebhookDeliveryStatus = []WebhookDeliveryStatus{
+	WebhookDeliveryStatusPending,
+	WebhookDeliveryStatusDelivered,
+	WebhookDeliveryStatusDead,
+}
+
+func (e WebhookDeliveryStatus) IsValid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending, WebhookDeliveryStatusDelivered, WebhookDeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (e WebhookDeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookDeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookDeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e WebhookDeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookDeliveryStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookEvent string
+
+const (
+	WebhookEventPostCreated    WebhookEvent = "POST_CREATED"
+	WebhookEventPostDeleted    WebhookEvent = "POST_DELETED"
+	WebhookEventPostHidden     WebhookEvent = "POST_HIDDEN"
+	WebhookEventCommentCreated WebhookEvent = "COMMENT_CREATED"
+	WebhookEventCommentDeleted WebhookEvent = "COMMENT_DELETED"
+	WebhookEventCommentHidden  WebhookEvent = "COMMENT_HIDDEN"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventPostCreated,
+	WebhookEventPostDeleted,
+	WebhookEventPostHidden,
+	WebhookEventCommentCreated,
+	WebhookEventCommentDeleted,
+	WebhookEventCommentHidden,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventPostCreated, WebhookEventPostDeleted, WebhookEventPostHidden, WebhookEventCommentCreated, WebhookEventCommentDeleted, WebhookEventCommentHidden:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookEvent) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookEvent) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,253 @@
+// Package outbox delivers events that storage writes in the same transaction
+// as the entity they describe. Delivery is at-least-once: an event is
+// removed only after every sink accepted it, so an event whose delivery was
+// interrupted by a crash is delivered again after the restart.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"ozonProject/internal/cache"
+	"ozonProject/internal/models"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/storage"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultInterval  = time.Second
+	DefaultBatchSize = 100
+	DefaultLease     = 30 * time.Second
+)
+
+// Sink receives events, Bus for GraphQL subscriptions or an external system.
+type Sink interface {
+	// Name identifies the sink in logs and deduplication keys.
+	Name() string
+	Deliver(ctx context.Context, ev *models.OutboxEvent) error
+}
+
+type Config struct {
+	// Interval is how often the outbox is polled when nobody calls Wake.
+	Interval  time.Duration
+	BatchSize int
+	// Lease is how long a claimed event is hidden from other dispatchers,
+	// a failed event is retried once it runs out.
+	Lease time.Duration
+	// Dedup remembers which sinks already got an event, so a retry after a
+	// failure of one sink does not repeat it to the others. Nil uses an
+	// in-process LRU, a shared store extends it to every instance.
+	Dedup cache.Store
+}
+
+// Stats counts deliveries since start, Skipped are repeats caught by Dedup.
+type Stats struct {
+	Delivered int64
+	Failed    int64
+	Skipped   int64
+}
+
+type Dispatcher struct {
+	repo  storage.Storage
+	sinks []Sink
+	cfg   Config
+	wake  chan struct{}
+
+	delivered, failed, skipped atomic.Int64
+}
+
+func NewDispatcher(repo storage.Storage, cfg Config, sinks ...Sink) *Dispatcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultLease
+	}
+	if cfg.Dedup == nil {
+		cfg.Dedup = cache.NewLRU(cache.DefaultSize)
+	}
+
+	return &Dispatcher{repo: repo, sinks: sinks, cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Wake makes Run poll right away, writers call it after a commit to cut the latency.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls the outbox until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.DispatchOnce(ctx)
+			if err != nil {
+				log.Printf("outbox: %v", err)
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DispatchOnce delivers one batch and returns how many events were removed
+// from the outbox. A failed event keeps its lease and waits for it to run out.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	events, err := d.repo.ClaimOutboxEvents(ctx, d.cfg.BatchSize, d.cfg.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("claim events: %w", err)
+	}
+
+	done := make([]string, 0, len(events))
+	for _, ev := range events {
+		if err := d.deliver(ctx, ev); err != nil {
+			d.failed.Add(1)
+			log.Printf("outbox: event %s (%s), attempt %d: %v", ev.ID, ev.Type, ev.Attempts, err)
+			continue
+		}
+		done = append(done, ev.ID)
+	}
+
+	if err := d.repo.AckOutboxEvents(ctx, done); err != nil {
+		return 0, fmt.Errorf("ack events: %w", err)
+	}
+
+	return len(done), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, ev *models.OutboxEvent) error {
+	for _, sink := range d.sinks {
+		key := sink.Name() + ":" + ev.ID
+
+		_, seen, err := d.cfg.Dedup.Get(ctx, key)
+		if err != nil {
+			log.Printf("outbox: dedup lookup: %v", err)
+		}
+		if seen {
+			d.skipped.Add(1)
+			continue
+		}
+
+		if err := sink.Deliver(ctx, ev); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+		d.delivered.Add(1)
+
+		if err := d.cfg.Dedup.Set(ctx, key, nil, 0); err != nil {
+			log.Printf("outbox: dedup store: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{Delivered: d.delivered.Load(), Failed: d.failed.Load(), Skipped: d.skipped.Load()}
+}
+
+// BusSink hands published comments to GraphQL subscriptions of this
+// instance. Dispatchers compete for events, so with several instances
+// sharing the storage use BroadcastSink instead. Subscribers may see a
+// comment twice after a restart and should key comments by id.
+type BusSink struct {
+	bus *pubsub.Bus
+}
+
+func NewBusSink(bus *pubsub.Bus) *BusSink {
+	return &BusSink{bus: bus}
+}
+
+func (s *BusSink) Name() string {
+	return "bus"
+}
+
+func (s *BusSink) Deliver(ctx context.Context, ev *models.OutboxEvent) error {
+	if ev.Type != models.OutboxCommentPublished {
+		return nil
+	}
+
+	var c models.Comment
+	if err := json.Unmarshal(ev.Payload, &c); err != nil {
+		return err
+	}
+	s.bus.Publish(&c)
+
+	return nil
+}
+
+// BroadcastSink announces published comments to every instance sharing the
+// storage, each of them hands the comment to its own bus in Listen.
+type BroadcastSink struct {
+	b storage.Broadcaster
+}
+
+func NewBroadcastSink(b storage.Broadcaster) *BroadcastSink {
+	return &BroadcastSink{b: b}
+}
+
+func (s *BroadcastSink) Name() string {
+	return "bus"
+}
+
+func (s *BroadcastSink) Deliver(ctx context.Context, ev *models.OutboxEvent) error {
+	if ev.Type != models.OutboxCommentPublished {
+		return nil
+	}
+
+	var c models.Comment
+	if err := json.Unmarshal(ev.Payload, &c); err != nil {
+		return err
+	}
+
+	return s.b.BroadcastComment(ctx, c.ID)
+}
+
+// listenRetry is the pause before listening again after the connection failed.
+const listenRetry = time.Second
+
+// Listen publishes comments broadcast by any instance to the bus until ctx
+// is done, reconnecting when the connection fails. Comments broadcast while
+// it reconnects are missed.
+func Listen(ctx context.Context, b storage.Broadcaster, repo storage.Storage, bus *pubsub.Bus) {
+	for {
+		err := b.ListenComments(ctx, func(id string) {
+			c, err := repo.GetCommentByID(ctx, id)
+			if err != nil {
+				log.Printf("outbox: broadcast comment %s: %v", id, err)
+				return
+			}
+			bus.Publish(c)
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("outbox: listen for comments: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetry):
+		}
+	}
+}
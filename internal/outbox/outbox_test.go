@@ -0,0 +1,161 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"ozonProject/internal/models"
+	"ozonProject/internal/outbox"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/storage/storagetest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	name string
+
+	mu   sync.Mutex
+	got  []string
+	fail error
+}
+
+func (s *recordingSink) Name() string {
+	return s.name
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, ev *models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != nil {
+		return s.fail
+	}
+	s.got = append(s.got, ev.ID)
+
+	return nil
+}
+
+func (s *recordingSink) setFail(err error) {
+	s.mu.Lock()
+	s.fail = err
+	s.mu.Unlock()
+}
+
+func (s *recordingSink) delivered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.got...)
+}
+
+func TestDispatcher_RetriesOnlyFailedSinks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+
+	post, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	created, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{created[0].ID}))
+	_, err = repo.CreateComment(ctx, post.ID, "", "bob", "hi", false)
+	require.NoError(t, err)
+
+	index := &recordingSink{name: "index"}
+	hooks := &recordingSink{name: "hooks", fail: errors.New("receiver down")}
+	d := outbox.NewDispatcher(repo, outbox.Config{Lease: time.Millisecond}, index, hooks)
+
+	n, err := d.DispatchOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Len(t, index.delivered(), 1)
+	require.Empty(t, hooks.delivered())
+
+	hooks.setFail(nil)
+	time.Sleep(5 * time.Millisecond)
+
+	n, err = d.DispatchOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Len(t, index.delivered(), 1, "sinks that accepted the event do not get it again")
+	require.Equal(t, index.delivered(), hooks.delivered())
+	require.Equal(t, outbox.Stats{Delivered: 2, Failed: 1, Skipped: 1}, d.Stats())
+
+	n, err = d.DispatchOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestDispatcher_PublishesCommentsToBus(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := pubsub.New()
+	repo := storage.NewInMemoryStorage()
+	d := outbox.NewDispatcher(repo, outbox.Config{Interval: time.Hour}, outbox.NewBusSink(bus))
+	go d.Run(ctx)
+
+	post, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+
+	live := bus.Subscribe(post.ID)
+	defer bus.Unsubscribe(post.ID, live)
+
+	c, err := repo.CreateComment(ctx, post.ID, "", "bob", "hi", false)
+	require.NoError(t, err)
+	d.Wake()
+
+	select {
+	case got := <-live:
+		require.Equal(t, c, got)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for comment")
+	}
+}
+
+func TestBroadcastSink_ReachesEveryInstance(t *testing.T) {
+	t.Parallel()
+	open := storagetest.LocalPostgres(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := storage.NewPostgresStorage(open(t))
+	post, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+
+	// Two instances share the storage, only the first one dispatches.
+	var subs []chan *models.Comment
+	for range 2 {
+		bus := pubsub.New()
+		live := bus.Subscribe(post.ID)
+		defer bus.Unsubscribe(post.ID, live)
+		subs = append(subs, live)
+		go outbox.Listen(ctx, repo, repo, bus)
+	}
+	d := outbox.NewDispatcher(repo, outbox.Config{Interval: 50 * time.Millisecond}, outbox.NewBroadcastSink(repo))
+	go d.Run(ctx)
+
+	// LISTEN starts asynchronously, keep commenting until both instances got one.
+	seen := make([]bool, len(subs))
+	deadline := time.After(5 * time.Second)
+	for !seen[0] || !seen[1] {
+		_, err := repo.CreateComment(ctx, post.ID, "", "bob", "hi", false)
+		require.NoError(t, err)
+		d.Wake()
+
+		for i, live := range subs {
+			select {
+			case <-live:
+				seen[i] = true
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("timeout waiting for broadcast comments")
+			}
+		}
+	}
+}
@@ -6,56 +6,126 @@ import (
 	"ozonProject/internal/models"
 )
 
-type Bus struct {
+// topics fans messages out to subscribers of a key without blocking publishers.
+type topics[T any] struct {
 	mu   sync.RWMutex
-	subs map[string]map[chan *models.Comment]struct{}
+	subs map[string]map[chan T]struct{}
 }
 
-func New() *Bus {
-	return &Bus{subs: make(map[string]map[chan *models.Comment]struct{})}
+func newTopics[T any]() *topics[T] {
+	return &topics[T]{subs: make(map[string]map[chan T]struct{})}
 }
 
-func (b *Bus) Subscribe(postID string) chan *models.Comment {
-	ch := make(chan *models.Comment, 1)
-	b.mu.Lock()
+func (t *topics[T]) subscribe(key string) chan T {
+	ch := make(chan T, 1)
+	t.mu.Lock()
 
-	if _, ok := b.subs[postID]; !ok {
-		b.subs[postID] = make(map[chan *models.Comment]struct{})
+	if _, ok := t.subs[key]; !ok {
+		t.subs[key] = make(map[chan T]struct{})
 	}
 
-	b.subs[postID][ch] = struct{}{}
-	b.mu.Unlock()
+	t.subs[key][ch] = struct{}{}
+	t.mu.Unlock()
 
 	return ch
 }
 
-func (b *Bus) Unsubscribe(postID string, ch chan *models.Comment) {
-	b.mu.Lock()
-	if m, ok := b.subs[postID]; ok {
+func (t *topics[T]) unsubscribe(key string, ch chan T) {
+	t.mu.Lock()
+	if m, ok := t.subs[key]; ok {
 		if _, ok := m[ch]; ok {
 			delete(m, ch)
 			close(ch)
 		}
 		if len(m) == 0 {
-			delete(b.subs, postID)
+			delete(t.subs, key)
 		}
 	}
-	b.mu.Unlock()
+	t.mu.Unlock()
 }
 
-func (b *Bus) Publish(c *models.Comment) {
-	b.mu.RLock()
-	m := b.subs[c.PostID]
-	var targets []chan *models.Comment
+func (t *topics[T]) publish(key string, msg T) {
+	t.mu.RLock()
+	m := t.subs[key]
+	var targets []chan T
 	for ch := range m {
 		targets = append(targets, ch)
 	}
-	b.mu.RUnlock()
+	t.mu.RUnlock()
 
 	for _, ch := range targets {
 		select {
-		case ch <- c:
+		case ch <- msg:
 		default:
 		}
 	}
 }
+
+// Invalidation tells caches which reads a write has made stale.
+type Invalidation struct {
+	PostID string
+	// Parents lists comments whose page of direct replies changed, an empty
+	// string stands for the top-level comments of the post.
+	Parents []string
+	// All is set when the write may have touched any post.
+	All bool
+}
+
+type Bus struct {
+	comments      *topics[*models.Comment]
+	notifications *topics[*models.Notification]
+
+	mu           sync.RWMutex
+	invalidators []func(Invalidation)
+}
+
+func New() *Bus {
+	return &Bus{
+		comments:      newTopics[*models.Comment](),
+		notifications: newTopics[*models.Notification](),
+	}
+}
+
+func (b *Bus) Subscribe(postID string) chan *models.Comment {
+	return b.comments.subscribe(postID)
+}
+
+func (b *Bus) Unsubscribe(postID string, ch chan *models.Comment) {
+	b.comments.unsubscribe(postID, ch)
+}
+
+func (b *Bus) Publish(c *models.Comment) {
+	b.comments.publish(c.PostID, c)
+}
+
+func (b *Bus) SubscribeNotifications(recipient string) chan *models.Notification {
+	return b.notifications.subscribe(recipient)
+}
+
+func (b *Bus) UnsubscribeNotifications(recipient string, ch chan *models.Notification) {
+	b.notifications.unsubscribe(recipient, ch)
+}
+
+func (b *Bus) PublishNotification(n *models.Notification) {
+	b.notifications.publish(n.Recipient, n)
+}
+
+// OnInvalidate registers fn to be called for every published invalidation.
+// Unlike subscriptions it is called synchronously, so no event is dropped.
+func (b *Bus) OnInvalidate(fn func(Invalidation)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.invalidators = append(b.invalidators, fn)
+}
+
+// PublishInvalidation returns after every registered handler has run.
+func (b *Bus) PublishInvalidation(inv Invalidation) {
+	b.mu.RLock()
+	handlers := b.invalidators
+	b.mu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(inv)
+	}
+}
@@ -40,3 +40,41 @@ func TestBus_Unsubscribe_ClosesChannel(t *testing.T) {
 		t.Fatal("channel not closed after unsubscribe")
 	}
 }
+
+func TestBus_PublishNotification_ByRecipient(t *testing.T) {
+	b := pubsub.New()
+
+	mine := b.SubscribeNotifications("alice")
+	defer b.UnsubscribeNotifications("alice", mine)
+	other := b.SubscribeNotifications("bob")
+	defer b.UnsubscribeNotifications("bob", other)
+
+	n := &models.Notification{ID: "1", Recipient: "alice", Type: models.NotificationTypeMention}
+	b.PublishNotification(n)
+
+	select {
+	case got := <-mine:
+		require.Equal(t, n, got)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timeout waiting for notification")
+	}
+
+	select {
+	case <-other:
+		t.Fatal("notification delivered to another recipient")
+	default:
+	}
+}
+
+func TestBus_PublishInvalidation_ReachesEveryHandler(t *testing.T) {
+	b := pubsub.New()
+
+	var got []pubsub.Invalidation
+	b.OnInvalidate(func(inv pubsub.Invalidation) { got = append(got, inv) })
+	b.OnInvalidate(func(inv pubsub.Invalidation) { got = append(got, inv) })
+
+	inv := pubsub.Invalidation{PostID: "1", Parents: []string{""}}
+	b.PublishInvalidation(inv)
+
+	require.Equal(t, []pubsub.Invalidation{inv, inv}, got)
+}
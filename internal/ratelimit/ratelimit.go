@@ -0,0 +1,240 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Rule describes a token bucket: Burst tokens at most, refilled at Burst per Per.
+type Rule struct {
+	Burst int
+	Per   time.Duration
+}
+
+func PerMinute(n int) Rule {
+	return Rule{Burst: n, Per: time.Minute}
+}
+
+func (r Rule) disabled() bool {
+	return r.Burst <= 0 || r.Per <= 0
+}
+
+// Policy is the set of rules applied to a single operation.
+type Policy struct {
+	PerAuthor     Rule
+	NewAuthor     Rule
+	PerIP         Rule
+	NewAuthorSpan time.Duration
+}
+
+type LimitError struct {
+	Operation  string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", ErrRateLimited, e.Operation, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Store keeps bucket state. The in-process MemoryStore is used by default,
+// a shared backend (e.g. Redis) can be plugged in for multi-instance setups.
+type Store interface {
+	// Take removes one token from the bucket identified by key and reports
+	// how long the caller has to wait when the bucket is empty.
+	Take(ctx context.Context, key string, rule Rule, now time.Time) (bool, time.Duration, error)
+	// FirstSeen returns the first time the key was observed, recording now
+	// when the key is new.
+	FirstSeen(ctx context.Context, key string, now time.Time) (time.Time, error)
+}
+
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+	now      func() time.Time
+}
+
+func New(store Store, policies map[string]Policy) *Limiter {
+	return &Limiter{
+		store:    store,
+		policies: policies,
+		now:      time.Now,
+	}
+}
+
+// Allow checks author and ip against the policy configured for operation.
+// Empty author or ip skip the corresponding rule.
+func (l *Limiter) Allow(ctx context.Context, operation, author, ip string) error {
+	policy, ok := l.policies[operation]
+	if !ok {
+		return nil
+	}
+
+	now := l.now()
+
+	if author != "" {
+		rule := policy.PerAuthor
+		if policy.NewAuthorSpan > 0 && !policy.NewAuthor.disabled() {
+			first, err := l.store.FirstSeen(ctx, "seen:"+author, now)
+			if err != nil {
+				return err
+			}
+			if now.Sub(first) < policy.NewAuthorSpan {
+				rule = policy.NewAuthor
+			}
+		}
+
+		if err := l.take(ctx, operation, "author:"+operation+":"+author, rule, now); err != nil {
+			return err
+		}
+	}
+
+	if ip != "" {
+		if err := l.take(ctx, operation, "ip:"+operation+":"+ip, policy.PerIP, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Limiter) take(ctx context.Context, operation, key string, rule Rule, now time.Time) error {
+	if rule.disabled() {
+		return nil
+	}
+
+	ok, retryAfter, err := l.store.Take(ctx, key, rule, now)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return &LimitError{Operation: operation, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+type seen struct {
+	first, last time.Time
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	seen    map[string]*seen
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		seen:    make(map[string]*seen),
+	}
+}
+
+// Sweep drops buckets that have refilled completely, they behave exactly
+// like missing ones, and forgets keys not seen for longer than idle, such
+// an author counts as new again.
+func (s *MemoryStore) Sweep(now time.Time, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if now.Sub(b.last) >= b.per {
+			delete(s.buckets, key)
+		}
+	}
+
+	if idle <= 0 {
+		return
+	}
+	for key, v := range s.seen {
+		if now.Sub(v.last) > idle {
+			delete(s.seen, key)
+		}
+	}
+}
+
+// Run sweeps the store every interval until ctx is done.
+func (s *MemoryStore) Run(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Sweep(now, idle)
+		}
+	}
+}
+
+// Len returns the number of buckets and first-seen records held.
+func (s *MemoryStore) Len() (buckets, seen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets), len(s.seen)
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rule Rule, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := float64(rule.Burst) / float64(rule.Per)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.per = rule.Per
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) * rate
+		if b.tokens > float64(rule.Burst) {
+			b.tokens = float64(rule.Burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate)
+
+	return false, wait, nil
+}
+
+func (s *MemoryStore) FirstSeen(ctx context.Context, key string, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.seen[key]
+	if !ok {
+		s.seen[key] = &seen{first: now, last: now}
+		return now, nil
+	}
+	if now.After(v.last) {
+		v.last = now
+	}
+
+	return v.first, nil
+}
@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"ozonProject/internal/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Take_RefillsOverTime(t *testing.T) {
+	s := ratelimit.NewMemoryStore()
+	rule := ratelimit.PerMinute(2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := s.Take(context.Background(), "k", rule, now)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	ok, wait, err := s.Take(context.Background(), "k", rule, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+
+	ok, _, err = s.Take(context.Background(), "k", rule, now.Add(30*time.Second))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestLimiter_Allow_PerAuthor(t *testing.T) {
+	l := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{
+		"createComment": {PerAuthor: ratelimit.PerMinute(1)},
+	})
+
+	require.NoError(t, l.Allow(context.Background(), "createComment", "alice", ""))
+	require.NoError(t, l.Allow(context.Background(), "createComment", "bob", ""))
+
+	err := l.Allow(context.Background(), "createComment", "alice", "")
+	require.True(t, errors.Is(err, ratelimit.ErrRateLimited))
+
+	var limitErr *ratelimit.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Greater(t, limitErr.RetryAfter, time.Duration(0))
+
+	require.NoError(t, l.Allow(context.Background(), "createPost", "alice", ""))
+}
+
+func TestLimiter_Allow_NewAuthorIsStricter(t *testing.T) {
+	l := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{
+		"createComment": {
+			PerAuthor:     ratelimit.PerMinute(10),
+			NewAuthor:     ratelimit.PerMinute(1),
+			NewAuthorSpan: time.Hour,
+		},
+	})
+
+	require.NoError(t, l.Allow(context.Background(), "createComment", "newbie", ""))
+	require.Error(t, l.Allow(context.Background(), "createComment", "newbie", ""))
+}
+
+func TestLimiter_Allow_PerIP(t *testing.T) {
+	l := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{
+		"createPost": {PerIP: ratelimit.PerMinute(1)},
+	})
+
+	require.NoError(t, l.Allow(context.Background(), "createPost", "alice", "10.0.0.1"))
+	require.Error(t, l.Allow(context.Background(), "createPost", "bob", "10.0.0.1"))
+	require.NoError(t, l.Allow(context.Background(), "createPost", "bob", "10.0.0.2"))
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	ctx := context.Background()
+	s := ratelimit.NewMemoryStore()
+	rule := ratelimit.PerMinute(2)
+	now := time.Now()
+
+	_, _, err := s.Take(ctx, "refilled", rule, now)
+	require.NoError(t, err)
+	_, _, err = s.Take(ctx, "busy", rule, now.Add(50*time.Second))
+	require.NoError(t, err)
+	_, err = s.FirstSeen(ctx, "idle", now)
+	require.NoError(t, err)
+	_, err = s.FirstSeen(ctx, "active", now)
+	require.NoError(t, err)
+	_, err = s.FirstSeen(ctx, "active", now.Add(50*time.Minute))
+	require.NoError(t, err)
+
+	s.Sweep(now.Add(time.Minute), 30*time.Minute)
+	buckets, seen := s.Len()
+	require.Equal(t, 1, buckets, "refilled buckets are dropped")
+	require.Equal(t, 2, seen)
+
+	s.Sweep(now.Add(time.Hour), 30*time.Minute)
+	buckets, seen = s.Len()
+	require.Zero(t, buckets)
+	require.Equal(t, 1, seen)
+
+	first, err := s.FirstSeen(ctx, "active", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, now, first)
+}
@@ -0,0 +1,197 @@
+package reqctx
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type ctxKey int
+
+const (
+	clientIPKey ctxKey = iota
+	requestIDKey
+	adminKey
+)
+
+// RequestIDHeader carries the request id, a missing one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// AdminTokenHeader carries the token an admin authenticates with.
+const AdminTokenHeader = "X-Admin-Token"
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithAdmin(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, adminKey, name)
+}
+
+// Admin is the admin authenticated by the request token, empty for everyone
+// else.
+func Admin(ctx context.Context) string {
+	name, _ := ctx.Value(adminKey).(string)
+	return name
+}
+
+// AdminTokens maps admin names to the tokens they authenticate with.
+type AdminTokens map[string]string
+
+// ParseAdminTokens accepts name:token pairs.
+func ParseAdminTokens(list []string) (AdminTokens, error) {
+	tokens := AdminTokens{}
+	for i, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		// The entry is not echoed, it may hold a token.
+		name, token, ok := strings.Cut(s, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("admin token #%d: want name:token", i+1)
+		}
+		if _, dup := tokens[name]; dup {
+			return nil, fmt.Errorf("admin token %q: duplicate name", name)
+		}
+		tokens[name] = token
+	}
+
+	return tokens, nil
+}
+
+// admin returns the name whose token matches, every token is compared so the
+// time taken does not tell which name is close.
+func (a AdminTokens) admin(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	var found string
+	for name, t := range a {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = name
+		}
+	}
+
+	return found
+}
+
+// Proxies are the networks of reverse proxies whose X-Forwarded-For is
+// trusted.
+type Proxies []netip.Prefix
+
+// ParseProxies accepts addresses and CIDR networks.
+func ParseProxies(list []string) (Proxies, error) {
+	var proxies Proxies
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p Proxies) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware stores the caller address, request id and the admin named by
+// X-Admin-Token in the request context and echoes the request id in the
+// response. X-Forwarded-For is only read when the connection comes from one
+// of proxies.
+func Middleware(proxies Proxies, admins AdminTokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimSpace(r.Header.Get(RequestIDHeader))
+			if id == "" || len(id) > 200 {
+				id = uuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := WithRequestID(WithClientIP(r.Context(), proxies.remoteIP(r)), id)
+			if name := admins.admin(r.Header.Get(AdminTokenHeader)); name != "" {
+				ctx = WithAdmin(ctx, name)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// remoteIP walks X-Forwarded-For from the right, every trusted proxy appends
+// the address it got the request from, so the first untrusted one is the
+// client. Entries left of it may be forged and are ignored.
+func (p Proxies) remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !p.trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !p.trusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
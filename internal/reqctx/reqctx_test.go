@@ -0,0 +1,75 @@
+package reqctx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"ozonProject/internal/reqctx"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func clientIP(t *testing.T, proxies reqctx.Proxies, remoteAddr string, forwarded ...string) string {
+	t.Helper()
+
+	var got string
+	h := reqctx.Middleware(proxies, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = reqctx.ClientIP(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.RemoteAddr = remoteAddr
+	for _, f := range forwarded {
+		req.Header.Add("X-Forwarded-For", f)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	return got
+}
+
+func TestMiddleware_ForwardedForOnlyFromTrustedProxies(t *testing.T) {
+	proxies, err := reqctx.ParseProxies([]string{"10.0.0.0/8", "::1"})
+	require.NoError(t, err)
+
+	require.Equal(t, "203.0.113.7", clientIP(t, nil, "203.0.113.7:5000", "1.2.3.4"), "no proxies are trusted by default")
+	require.Equal(t, "203.0.113.7", clientIP(t, proxies, "203.0.113.7:5000", "1.2.3.4"), "a direct client cannot spoof its address")
+	require.Equal(t, "198.51.100.1", clientIP(t, proxies, "10.0.0.5:5000", "198.51.100.1"))
+	require.Equal(t, "198.51.100.1", clientIP(t, proxies, "[::1]:5000", "1.2.3.4, 198.51.100.1", "10.0.0.9"),
+		"addresses added before the last untrusted hop are ignored")
+	require.Equal(t, "10.0.0.9", clientIP(t, proxies, "10.0.0.5:5000", "10.0.0.9"))
+	require.Equal(t, "10.0.0.5", clientIP(t, proxies, "10.0.0.5:5000"))
+
+	_, err = reqctx.ParseProxies([]string{"proxy.local"})
+	require.Error(t, err)
+}
+
+func TestMiddleware_AdminFromToken(t *testing.T) {
+	admins, err := reqctx.ParseAdminTokens([]string{"root:s3cret", " ops : t0ken "})
+	require.NoError(t, err)
+
+	admin := func(token string) string {
+		var got string
+		h := reqctx.Middleware(nil, admins)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = reqctx.Admin(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		if token != "" {
+			req.Header.Set(reqctx.AdminTokenHeader, token)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		return got
+	}
+
+	require.Equal(t, "root", admin("s3cret"))
+	require.Equal(t, "ops", admin("t0ken"))
+	require.Empty(t, admin("wrong"))
+	require.Empty(t, admin(""))
+
+	for _, bad := range [][]string{{"root"}, {"root:"}, {":s3cret"}, {"root:a", "root:b"}} {
+		_, err = reqctx.ParseAdminTokens(bad)
+		require.Error(t, err, bad)
+		require.NotContains(t, err.Error(), "s3cret", "tokens stay out of errors")
+	}
+}
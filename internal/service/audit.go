@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"ozonProject/internal/models"
+	"ozonProject/internal/reqctx"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/utils"
+	"time"
+)
+
+// Audit actions, named <target>.<verb>.
+const (
+	AuditCreateCommunity = "community.create"
+	AuditCreatePost      = "post.create"
+	AuditDeletePost      = "post.delete"
+	AuditVotePost        = "post.vote"
+	AuditCreateComment   = "comment.create"
+	AuditDeleteComment   = "comment.delete"
+	AuditReportContent   = "report.create"
+	AuditResolveReport   = "report.resolve"
+	AuditBanUser         = "user.ban"
+	AuditCreateWebhook   = "webhook.create"
+	AuditDeleteWebhook   = "webhook.delete"
+	AuditRetryDelivery   = "webhook.retry"
+)
+
+// Audit target types.
+const (
+	TargetCommunity = "community"
+	TargetPost      = "post"
+	TargetComment   = "comment"
+	TargetReport    = "report"
+	TargetUser      = "user"
+	TargetWebhook   = "webhook"
+	TargetDelivery  = "webhook_delivery"
+)
+
+// audit records who changed what along with the request id and address of
+// the caller. The change is already stored when audit runs, so a failed write
+// is logged with the entry rather than failing a request whose retry would
+// repeat the change.
+func (s *Service) audit(ctx context.Context, actor, action, targetType, targetID string, before, after any) {
+	entry := &models.AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     snapshot(before),
+		After:      snapshot(after),
+		RequestID:  reqctx.RequestID(ctx),
+		IP:         reqctx.ClientIP(ctx),
+	}
+
+	if err := s.storage.AppendAudit(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("append audit entry %s %s/%s by %s (request %s): %v", action, targetType, targetID, actor, entry.RequestID, err)
+	}
+}
+
+// snapshot encodes v as JSON, nil stays absent.
+func snapshot(v any) *string {
+	if v == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("encode audit snapshot: %v", err)
+		return nil
+	}
+	s := string(raw)
+
+	return &s
+}
+
+// AuditLog lists audit entries in the order they were written, only admins may read it.
+func (s *Service) AuditLog(ctx context.Context, filter *models.AuditFilter, limit, offset *int) ([]*models.AuditEntry, error) {
+	if _, err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	n, skip, err := page(limit, offset, 50)
+	if err != nil {
+		return nil, err
+	}
+
+	var f storage.AuditFilter
+	if filter != nil {
+		f = storage.AuditFilter{
+			Actor:      utils.ValueOrDefault(filter.Actor, ""),
+			Action:     utils.ValueOrDefault(filter.Action, ""),
+			TargetType: utils.ValueOrDefault(filter.TargetType, ""),
+			TargetID:   utils.ValueOrDefault(filter.TargetID, ""),
+			Since:      utils.ValueOrDefault(filter.Since, time.Time{}),
+			Until:      utils.ValueOrDefault(filter.Until, time.Time{}),
+		}
+	}
+
+	return s.storage.GetAuditLog(ctx, f, n, skip)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"ozonProject/internal/models"
+	"ozonProject/internal/reqctx"
+	"ozonProject/internal/utils"
+	"ozonProject/internal/validation"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BannedError rejects a write of a banned user and carries the ban so the
+// client can tell when it is lifted.
+type BannedError struct {
+	Ban *models.Ban
+}
+
+func (e *BannedError) Error() string {
+	return validation.ErrBanned.Error()
+}
+
+func (e *BannedError) Unwrap() error {
+	return validation.ErrBanned
+}
+
+// WithAdmins sets the users allowed to issue and list global bans.
+func WithAdmins(admins []string) Option {
+	return func(s *Service) {
+		s.admins = admins
+	}
+}
+
+// BanUser bans the user globally, in a community or under a single post
+// until the given time, a nil until bans permanently.
+func (s *Service) BanUser(ctx context.Context, userId string, scope models.BanScope, scopeId *string, until *time.Time, reason, moderator string) (*models.Ban, error) {
+	var v validation.Validator
+	v.Field("userId", &userId, validation.Normalize, validation.NotBlank, validation.SingleLine, validation.MaxLen(s.limits.MaxAuthorLen))
+	v.Field("reason", &reason, validation.Normalize, validation.NotBlank, validation.NoControl, validation.MaxLen(MaxReportReasonLen))
+	if until != nil && !until.After(time.Now()) {
+		v.Check("until", validation.ErrBanExpired)
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	scopeID, err := s.authorizeBans(ctx, moderator, scope, utils.ValueOrDefault(scopeId, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if until != nil {
+		utc := until.UTC()
+		until = &utc
+	}
+
+	ban, err := s.storage.BanUser(ctx, &models.Ban{
+		UserID:    userId,
+		Scope:     scope,
+		ScopeID:   scopeID,
+		Reason:    reason,
+		CreatedBy: moderator,
+		Until:     until,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, moderator, AuditBanUser, TargetUser, userId, nil, ban)
+
+	return ban, nil
+}
+
+// ListBans returns active bans of the scope, expired bans are lifted and not listed.
+func (s *Service) ListBans(ctx context.Context, moderator string, scope models.BanScope, scopeId *string, limit, offset *int) ([]*models.Ban, error) {
+	n, skip, err := page(limit, offset, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	scopeID, err := s.authorizeBans(ctx, moderator, scope, utils.ValueOrDefault(scopeId, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetBans(ctx, scope, scopeID, n, skip)
+}
+
+// authorizeBans checks that the user manages bans of the scope and returns
+// the normalized scope id: admins own global bans, community moderators own
+// bans of the community and of its posts.
+func (s *Service) authorizeBans(ctx context.Context, user string, scope models.BanScope, scopeID string) (string, error) {
+	scopeID = strings.TrimSpace(scopeID)
+
+	switch scope {
+	case models.BanScopeGlobal:
+		if !s.isAdmin(user) {
+			return "", validation.ErrNotAdmin
+		}
+		return "", nil
+	case models.BanScopeCommunity:
+		if scopeID == "" {
+			return "", validation.ErrEmptyBanScopeID
+		}
+		c, err := s.requireModerator(ctx, scopeID, user)
+		if err != nil {
+			return "", err
+		}
+		return c.Name, nil
+	default:
+		if scopeID == "" {
+			return "", validation.ErrEmptyBanScopeID
+		}
+		post, err := s.storage.GetPostByID(ctx, scopeID)
+		if err != nil {
+			return "", err
+		}
+		if _, err := s.requireModerator(ctx, post.CommunityName, user); err != nil {
+			return "", err
+		}
+		return post.ID, nil
+	}
+}
+
+func (s *Service) isAdmin(user string) bool {
+	return user != "" && slices.Contains(s.admins, user)
+}
+
+// requireAdmin returns the admin authenticated by the request token, the name
+// must also be listed in admins.
+func (s *Service) requireAdmin(ctx context.Context) (string, error) {
+	admin := reqctx.Admin(ctx)
+	if !s.isAdmin(admin) {
+		return "", validation.ErrNotAdmin
+	}
+
+	return admin, nil
+}
+
+// checkBanned rejects writes of users banned globally, from the community or
+// from the post, empty community and postId skip those scopes.
+func (s *Service) checkBanned(ctx context.Context, community, postId, author string) error {
+	ban, err := s.storage.ActiveBan(ctx, author, community, postId)
+	if err != nil {
+		return err
+	}
+
+	if ban != nil {
+		return &BannedError{Ban: ban}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"log"
+	"ozonProject/internal/filter"
+	"ozonProject/internal/models"
+	"strings"
+)
+
+// WithContentFilter screens posts and comments before they are stored.
+func WithContentFilter(p *filter.Pipeline) Option {
+	return func(s *Service) {
+		s.filter = p
+	}
+}
+
+// screen runs the content filter, a rejected content returns *filter.RejectedError.
+func (s *Service) screen(ctx context.Context, c filter.Content) (filter.Verdict, error) {
+	if s.filter == nil {
+		return filter.Verdict{}, nil
+	}
+
+	return s.filter.Run(ctx, c)
+}
+
+// record lets the filters remember stored content, a failed write is not
+// taken for a duplicate when the author retries.
+func (s *Service) record(ctx context.Context, c filter.Content) {
+	if s.filter != nil {
+		s.filter.Record(ctx, c)
+	}
+}
+
+// hold files an automod report so moderators can approve or remove a held
+// comment. The comment is already stored, a failed report is logged and the
+// comment is still returned, so a retry of the request does not store it twice.
+func (s *Service) hold(ctx context.Context, c *models.Comment, verdict filter.Verdict) {
+	report, err := s.storage.CreateReport(context.WithoutCancel(ctx), &models.Report{
+		TargetType: models.ReportTargetTypeComment,
+		TargetID:   c.ID,
+		Reporter:   filter.AutomodReporter,
+		Reason:     "held by content filter: " + strings.Join(verdict.Reasons, ", "),
+	})
+	if err != nil {
+		log.Printf("report held comment %s: %v", c.ID, err)
+		return
+	}
+
+	s.audit(ctx, filter.AutomodReporter, AuditReportContent, TargetReport, report.ID, nil, report)
+}
+
+// publish notifies about a visible comment. The comment itself is delivered
+// by the outbox dispatcher from the event stored along with it, or straight
+// to the bus when there is no dispatcher.
+func (s *Service) publish(ctx context.Context, c *models.Comment) {
+	s.notify(ctx, c)
+	if s.outbox == nil && s.bus != nil {
+		s.bus.Publish(c)
+	}
+	s.wakeOutbox()
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/utils"
+	"ozonProject/internal/validation"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const MaxReportReasonLen = 500
+
+func (s *Service) ReportContent(ctx context.Context, targetType models.ReportTargetType, targetId, reason, reporter string) (*models.Report, error) {
+	var v validation.Validator
+	v.Field("reason", &reason, validation.Normalize, validation.NotBlank, validation.NoControl, validation.MaxLen(MaxReportReasonLen))
+	v.Field("reporter", &reporter, validation.Normalize, validation.NotBlank, validation.SingleLine, validation.MaxLen(s.limits.MaxAuthorLen))
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	report, err := s.storage.CreateReport(ctx, &models.Report{
+		TargetType: targetType,
+		TargetID:   targetId,
+		Reporter:   reporter,
+		Reason:     reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, reporter, AuditReportContent, TargetReport, report.ID, nil, report)
+
+	return report, nil
+}
+
+func (s *Service) ModerationQueue(ctx context.Context, community, moderator string, status *models.ReportStatus, limit, offset *int) ([]*models.Report, error) {
+	n, skip, err := page(limit, offset, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.requireModerator(ctx, community, moderator)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetReports(ctx, c.Name, utils.ValueOrDefault(status, models.ReportStatusOpen), n, skip)
+}
+
+// ResolveReport applies the moderator action to the reported content and
+// records the decision in the report audit trail. The open report is claimed
+// before the action, so concurrent decisions apply it once, and reopened
+// when the action fails.
+func (s *Service) ResolveReport(ctx context.Context, reportId, moderator string, action models.ModerationAction, note *string) (*models.Report, error) {
+	report, err := s.storage.GetReport(ctx, reportId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.requireModerator(ctx, report.Community, moderator); err != nil {
+		return nil, err
+	}
+	if report.Status != models.ReportStatusOpen {
+		return nil, storage.ErrReportResolved
+	}
+	if action == models.ModerationActionApprove {
+		if err := s.checkApprovable(ctx, report); err != nil {
+			return nil, err
+		}
+	}
+
+	status := models.ReportStatusActioned
+	if action == models.ModerationActionDismiss {
+		status = models.ReportStatusDismissed
+	}
+
+	decision := &models.ModerationDecision{
+		ID:        uuid.New().String(),
+		ReportID:  report.ID,
+		Moderator: moderator,
+		Action:    action,
+		Note:      strings.TrimSpace(utils.ValueOrDefault(note, "")),
+	}
+	resolved, err := s.storage.ResolveReport(ctx, status, decision)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.applyModeration(ctx, report, moderator, action); err != nil {
+		if reopenErr := s.storage.ReopenReport(context.WithoutCancel(ctx), report.ID, decision.ID); reopenErr != nil {
+			return nil, errors.Join(err, reopenErr)
+		}
+		return nil, err
+	}
+
+	s.audit(ctx, moderator, AuditResolveReport, TargetReport, report.ID, report, resolved)
+
+	return resolved, nil
+}
+
+func (s *Service) applyModeration(ctx context.Context, report *models.Report, moderator string, action models.ModerationAction) error {
+	var err error
+	switch action {
+	case models.ModerationActionHide:
+		if err = s.storage.HideContent(ctx, report.TargetType, report.TargetID); err == nil {
+			s.wakeOutbox()
+		}
+	case models.ModerationActionDelete:
+		err = s.deleteReported(ctx, report, moderator)
+	case models.ModerationActionBan:
+		if err = s.deleteReported(ctx, report, moderator); err == nil {
+			_, err = s.storage.BanUser(ctx, &models.Ban{
+				UserID:    report.TargetAuthor,
+				Scope:     models.BanScopeCommunity,
+				ScopeID:   report.Community,
+				Reason:    report.Reason,
+				CreatedBy: moderator,
+			})
+		}
+	case models.ModerationActionApprove:
+		err = s.approveReported(ctx, report)
+	}
+
+	return err
+}
+
+// deleteReported soft-deletes the target and audits it with the content it
+// had before, content deleted earlier by its author is not an error.
+func (s *Service) deleteReported(ctx context.Context, report *models.Report, moderator string) error {
+	var before, after any
+	action, targetType := AuditDeletePost, TargetPost
+
+	var err error
+	if report.TargetType == models.ReportTargetTypePost {
+		var p *models.Post
+		if p, err = s.storage.GetPostByID(ctx, report.TargetID); err == nil {
+			before = p
+			after, err = s.storage.DeletePost(ctx, report.TargetID, "")
+		}
+	} else {
+		action, targetType = AuditDeleteComment, TargetComment
+		var c *models.Comment
+		if c, err = s.storage.GetCommentByID(ctx, report.TargetID); err == nil {
+			before = c
+			after, err = s.storage.DeleteComment(ctx, report.TargetID, "")
+		}
+	}
+
+	if errors.Is(err, storage.ErrPostNotFound) || errors.Is(err, storage.ErrCommentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.wakeOutbox()
+
+	s.audit(ctx, moderator, action, targetType, report.TargetID, before, after)
+
+	return nil
+}
+
+// checkApprovable allows APPROVE only on reports of held comments, there is
+// nothing to publish otherwise.
+func (s *Service) checkApprovable(ctx context.Context, report *models.Report) error {
+	if report.TargetType != models.ReportTargetTypeComment {
+		return validation.ErrNotApprovable
+	}
+
+	c, err := s.storage.GetCommentByID(ctx, report.TargetID)
+	if err != nil {
+		return err
+	}
+	if !c.Pending || c.Deleted {
+		return validation.ErrNotApprovable
+	}
+
+	return nil
+}
+
+// approveReported publishes a comment held by the content filter. A comment
+// approved or deleted since checkApprovable fails the decision, which
+// reopens the report.
+func (s *Service) approveReported(ctx context.Context, report *models.Report) error {
+	c, err := s.storage.ApproveComment(ctx, report.TargetID)
+	if errors.Is(err, storage.ErrCommentNotFound) {
+		return validation.ErrNotApprovable
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publish(ctx, c)
+
+	return nil
+}
+
+func (s *Service) requireModerator(ctx context.Context, community, user string) (*models.Community, error) {
+	c, err := s.storage.GetCommunity(ctx, strings.ToLower(strings.TrimSpace(community)))
+	if err != nil {
+		return nil, err
+	}
+
+	if user == "" || !c.IsModerator(user) {
+		return nil, validation.ErrNotModerator
+	}
+
+	return c, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"log"
+	"ozonProject/internal/models"
+	"ozonProject/internal/outbox"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/utils"
+	"regexp"
+	"strings"
+)
+
+// MaxMentionsPerComment caps notifications a single comment can trigger.
+const MaxMentionsPerComment = 10
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.-]+)`)
+
+// WithBus delivers created notifications to subscribers. Published comments
+// reach them through the outbox dispatcher, or directly without WithOutbox.
+func WithBus(bus *pubsub.Bus) Option {
+	return func(s *Service) {
+		s.bus = bus
+	}
+}
+
+// WithOutbox wakes the dispatcher after a change that stores an outbox
+// event, so the event is delivered without waiting for the next poll.
+func WithOutbox(d *outbox.Dispatcher) Option {
+	return func(s *Service) {
+		s.outbox = d
+	}
+}
+
+func (s *Service) wakeOutbox() {
+	if s.outbox != nil {
+		s.outbox.Wake()
+	}
+}
+
+// parseMentions returns unique @usernames in order of appearance.
+func parseMentions(content string) []string {
+	var out []string
+	seen := make(map[string]struct{})
+
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+
+		if len(out) == MaxMentionsPerComment {
+			break
+		}
+	}
+
+	return out
+}
+
+// notify creates reply and mention notifications for a new comment. Failures
+// are logged only, the comment itself is already stored.
+func (s *Service) notify(ctx context.Context, c *models.Comment) {
+	var created []*models.Notification
+
+	reply, err := s.storage.CreateReplyNotification(ctx, c)
+	if err != nil {
+		log.Printf("create reply notification: %v", err)
+	}
+	if reply != nil {
+		created = append(created, reply)
+	}
+
+	var mentions []*models.Notification
+	for _, name := range parseMentions(c.Content) {
+		if name == c.Author || (reply != nil && name == reply.Recipient) {
+			continue
+		}
+		mentions = append(mentions, &models.Notification{
+			Recipient: name,
+			Type:      models.NotificationTypeMention,
+			Actor:     c.Author,
+			PostID:    c.PostID,
+			CommentID: c.ID,
+		})
+	}
+
+	if len(mentions) > 0 {
+		stored, err := s.storage.CreateNotifications(ctx, mentions)
+		if err != nil {
+			log.Printf("create mention notifications: %v", err)
+		}
+		created = append(created, stored...)
+	}
+
+	if s.bus == nil {
+		return
+	}
+	for _, n := range created {
+		s.bus.PublishNotification(n)
+	}
+}
+
+func (s *Service) ListNotifications(ctx context.Context, recipient string, unreadOnly *bool, limit, offset *int) ([]*models.Notification, error) {
+	n, skip, err := page(limit, offset, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetNotifications(ctx, recipient, utils.ValueOrDefault(unreadOnly, false), n, skip)
+}
+
+func (s *Service) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	return s.storage.MarkNotificationsRead(ctx, recipient, ids)
+}
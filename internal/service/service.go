@@ -2,54 +2,694 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"log"
+	"math"
+	"ozonProject/internal/filter"
+	"ozonProject/internal/markdown"
 	"ozonProject/internal/models"
+	"ozonProject/internal/outbox"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/ratelimit"
+	"ozonProject/internal/reqctx"
 	"ozonProject/internal/storage"
 	"ozonProject/internal/utils"
 	"ozonProject/internal/validation"
+	"ozonProject/internal/webhook"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	OpCreatePost    = "createPost"
+	OpCreateComment = "createComment"
 )
 
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a reservation blocks retries when the request
+// holding it never completes or releases it.
+const idempotencyLease = time.Minute
+
 type Service struct {
-	storage storage.Storage
+	storage        storage.Storage
+	limiter        *ratelimit.Limiter
+	idempotencyTTL time.Duration
+	limits         validation.Limits
+	renderer       *markdown.Renderer
+	bus            *pubsub.Bus
+	outbox         *outbox.Dispatcher
+	webhooks       *webhook.Sender
+	filter         *filter.Pipeline
+	admins         []string
+	maxReplyDepth  int
+	flattenReplies bool
+}
+
+type Option func(*Service)
+
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return func(s *Service) {
+		s.limiter = l
+	}
+}
+
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		if ttl > 0 {
+			s.idempotencyTTL = ttl
+		}
+	}
+}
+
+// WithLimits overrides content length limits, unset fields keep their defaults.
+func WithLimits(l validation.Limits) Option {
+	return func(s *Service) {
+		s.limits = l.OrDefault()
+	}
+}
+
+// WithMarkdownCacheSize sets how many rendered contents are kept in memory.
+func WithMarkdownCacheSize(size int) Option {
+	return func(s *Service) {
+		s.renderer = markdown.NewRenderer(size)
+	}
+}
+
+// WithMaxReplyDepth limits comment nesting, top-level comments have depth 0.
+// Deeper replies are rejected, or with flatten attached to the deepest
+// allowed ancestor. Zero depth keeps nesting unlimited.
+func WithMaxReplyDepth(depth int, flatten bool) Option {
+	return func(s *Service) {
+		s.maxReplyDepth = max(depth, 0)
+		s.flattenReplies = flatten
+	}
 }
 
-func New(storage storage.Storage) *Service {
-	return &Service{
-		storage: storage,
+func New(storage storage.Storage, opts ...Option) *Service {
+	s := &Service{
+		storage:        storage,
+		idempotencyTTL: DefaultIdempotencyTTL,
+		limits:         validation.DefaultLimits,
+		renderer:       markdown.NewRenderer(markdown.DefaultCacheSize),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-func (s *Service) ListPosts(ctx context.Context, limit, offset *int) ([]*models.Post, error) {
-	return s.storage.GetPosts(ctx, utils.ValueOrDefault(limit, 0), utils.ValueOrDefault(offset, 0))
+var topWindows = map[models.TopWindow]time.Duration{
+	models.TopWindowDay:   24 * time.Hour,
+	models.TopWindowWeek:  7 * 24 * time.Hour,
+	models.TopWindowMonth: 30 * 24 * time.Hour,
+	models.TopWindowYear:  365 * 24 * time.Hour,
 }
 
+func (s *Service) ListPosts(ctx context.Context, limit, offset *int, tag, community *string, sort *models.PostSort, window *models.TopWindow) ([]*models.Post, error) {
+	n, skip, err := page(limit, offset, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := storage.PostFilter{
+		Tag:       strings.ToLower(strings.TrimSpace(utils.ValueOrDefault(tag, ""))),
+		Community: strings.ToLower(strings.TrimSpace(utils.ValueOrDefault(community, ""))),
+		Sort:      utils.ValueOrDefault(sort, models.PostSortNew),
+	}
+
+	if d, ok := topWindows[utils.ValueOrDefault(window, models.TopWindowAll)]; ok && filter.Sort == models.PostSortTop {
+		filter.Since = time.Now().UTC().Add(-d)
+	}
+
+	return s.storage.GetPosts(ctx, n, skip, filter)
+}
+
+// RenderContent returns sanitized HTML for Markdown content of a post or comment.
+func (s *Service) RenderContent(content string) string {
+	return s.renderer.Render(content)
+}
+
+// GetPost returns a post, hidden ones are not found like in CommentPost.
 func (s *Service) GetPost(ctx context.Context, id string) (*models.Post, error) {
-	return s.storage.GetPostByID(ctx, id)
+	p, err := s.storage.GetPostByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Hidden {
+		return nil, storage.ErrPostNotFound
+	}
+
+	return p, nil
+}
+
+// CommentPost returns the post a comment belongs to, nil when it is hidden.
+func (s *Service) CommentPost(ctx context.Context, c *models.Comment) (*models.Post, error) {
+	p, err := s.GetPost(ctx, c.PostID)
+	if errors.Is(err, storage.ErrPostNotFound) {
+		return nil, nil
+	}
+
+	return p, err
+}
+
+// CommentParent returns the comment a reply belongs to, nil for top-level
+// comments and when the parent is held or hidden, whose replies stay visible.
+func (s *Service) CommentParent(ctx context.Context, c *models.Comment) (*models.Comment, error) {
+	if c.ParentID == nil || *c.ParentID == "" {
+		return nil, nil
+	}
+
+	parent, err := s.GetComment(ctx, *c.ParentID)
+	if errors.Is(err, storage.ErrCommentNotFound) {
+		return nil, nil
+	}
+
+	return parent, err
+}
+
+func (s *Service) CreatePost(ctx context.Context, community, title, content, author string, commentsEnabled *bool, tags []string, clientMutationId *string) (*models.Post, error) {
+	request := []any{community, title, content, commentsEnabled, tags}
+	return idempotent(ctx, s, OpCreatePost, author, clientMutationId, request, func() (*models.Post, error) {
+		if err := validation.ValidatePost(s.limits, &title, &content, &author); err != nil {
+			return nil, err
+		}
+
+		tags, err := validation.NormalizeTags(tags)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := s.storage.GetCommunity(ctx, strings.ToLower(strings.TrimSpace(community)))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.checkBanned(ctx, c.Name, "", author); err != nil {
+			return nil, err
+		}
+
+		if err := s.allow(ctx, OpCreatePost, author); err != nil {
+			return nil, err
+		}
+
+		// Posts are not held, only rejected: there is no pending state for the
+		// feed, so in scoring mode content reaching the threshold is refused.
+		screened := filter.Content{Kind: filter.KindPost, Author: author, Community: c.Name, Title: title, Body: content}
+		verdict, err := s.screen(ctx, screened)
+		if err != nil {
+			return nil, err
+		}
+		if verdict.Hold {
+			return nil, &filter.RejectedError{Reasons: verdict.Reasons}
+		}
+
+		p, err := s.storage.CreatePost(ctx, c.Name, title, content, author, utils.ValueOrDefault(commentsEnabled, c.CommentsEnabled), tags)
+		if err != nil {
+			return nil, err
+		}
+		s.record(ctx, screened)
+
+		s.wakeOutbox()
+		s.audit(ctx, author, AuditCreatePost, TargetPost, p.ID, nil, p)
+
+		return p, nil
+	})
+}
+
+func (s *Service) VotePost(ctx context.Context, postId, voter string, value int) (*models.Post, error) {
+	if value < -1 || value > 1 {
+		return nil, validation.ErrInvalidVote
+	}
+
+	p, err := s.storage.VotePost(ctx, postId, voter, value)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, voter, AuditVotePost, TargetPost, p.ID, nil, map[string]int{"value": value, "score": p.Score})
+
+	return p, nil
+}
+
+func (s *Service) CreateCommunity(ctx context.Context, name string, description *string, rules []string, creator string, moderators []string, commentsEnabled *bool, maxCommentLength *int) (*models.Community, error) {
+	name, err := validation.NormalizeCommunityName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	maxLen := utils.ValueOrDefault(maxCommentLength, s.limits.MaxCommentLen)
+	if err := validation.ValidateMaxCommentLength(maxLen, s.limits.MaxCommentLen); err != nil {
+		return nil, err
+	}
+
+	mods := []string{creator}
+	for _, m := range moderators {
+		if m != creator {
+			mods = append(mods, m)
+		}
+	}
+
+	if rules == nil {
+		rules = []string{}
+	}
+
+	c, err := s.storage.CreateCommunity(ctx, &models.Community{
+		Name:             name,
+		Description:      utils.ValueOrDefault(description, ""),
+		Rules:            rules,
+		Moderators:       mods,
+		CommentsEnabled:  utils.ValueOrDefault(commentsEnabled, true),
+		MaxCommentLength: maxLen,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, creator, AuditCreateCommunity, TargetCommunity, c.Name, nil, c)
+
+	return c, nil
+}
+
+func (s *Service) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	return s.storage.GetCommunity(ctx, strings.ToLower(strings.TrimSpace(name)))
+}
+
+func (s *Service) ListCommunities(ctx context.Context, limit, offset *int) ([]*models.Community, error) {
+	n, skip, err := page(limit, offset, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetCommunities(ctx, n, skip)
+}
+
+func (s *Service) ListTags(ctx context.Context, limit *int) ([]*models.Tag, error) {
+	n, _, err := page(limit, nil, 50)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetTags(ctx, n)
+}
+
+func (s *Service) CreateComment(ctx context.Context, postId string, parentId *string, author, content string, clientMutationId *string) (*models.Comment, error) {
+	request := []any{postId, parentId, content}
+	return idempotent(ctx, s, OpCreateComment, author, clientMutationId, request, func() (*models.Comment, error) {
+		if err := validation.ValidateComment(s.limits, &author, &content); err != nil {
+			return nil, err
+		}
+
+		if err := s.storage.EnsureCommentsEnabled(ctx, postId); err != nil {
+			return nil, validation.ErrCommentsOff
+		}
+
+		community, err := s.checkCommunityLimits(ctx, postId, author, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.allow(ctx, OpCreateComment, author); err != nil {
+			return nil, err
+		}
+
+		screened := filter.Content{Kind: filter.KindComment, Author: author, Community: community, Body: content}
+		verdict, err := s.screen(ctx, screened)
+		if err != nil {
+			return nil, err
+		}
+
+		parent, err := s.replyParent(ctx, utils.ValueOrDefault(parentId, ""))
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := s.storage.CreateComment(ctx, postId, parent, author, content, verdict.Hold)
+		if err != nil {
+			return nil, err
+		}
+		s.record(ctx, screened)
+
+		s.audit(ctx, author, AuditCreateComment, TargetComment, c.ID, nil, c)
+
+		if c.Pending {
+			s.hold(ctx, c, verdict)
+		} else {
+			s.publish(ctx, c)
+		}
+
+		return c, nil
+	})
+}
+
+// replyParent returns the comment a reply is attached to under the max depth
+// limit, walking up to the deepest allowed ancestor when flattening.
+func (s *Service) replyParent(ctx context.Context, parentId string) (string, error) {
+	if parentId == "" || s.maxReplyDepth == 0 {
+		return parentId, nil
+	}
+
+	parent, err := s.storage.GetCommentByID(ctx, parentId)
+	if err != nil {
+		return "", err
+	}
+	if parent.Depth < s.maxReplyDepth {
+		return parent.ID, nil
+	}
+	if !s.flattenReplies {
+		return "", validation.ErrTooDeep
+	}
+
+	for parent.Depth >= s.maxReplyDepth {
+		if parent, err = s.storage.GetCommentByID(ctx, utils.ValueOrDefault(parent.ParentID, "")); err != nil {
+			return "", err
+		}
+	}
+
+	return parent.ID, nil
 }
 
-func (s *Service) CreatePost(ctx context.Context, title, content, author string, commentsEnabled *bool) (*models.Post, error) {
-	return s.storage.CreatePost(ctx, title, content, author, utils.ValueOrDefault(commentsEnabled, false))
+// checkCommunityLimits applies bans and the settings of the community owning
+// the post and returns its name.
+func (s *Service) checkCommunityLimits(ctx context.Context, postId, author, content string) (string, error) {
+	post, err := s.storage.GetPostByID(ctx, postId)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.checkBanned(ctx, post.CommunityName, post.ID, author); err != nil {
+		return "", err
+	}
+
+	if post.CommunityName == "" {
+		return "", nil
+	}
+
+	c, err := s.storage.GetCommunity(ctx, post.CommunityName)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Name, validation.ValidateCommentLength(content, c.MaxCommentLength)
 }
 
-func (s *Service) CreateComment(ctx context.Context, postId string, parentId *string, author, content string) (*models.Comment, error) {
-	if err := validation.ValidateCommentBody(content); err != nil {
+// DeleteComment soft-deletes a comment owned by author.
+func (s *Service) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	if author == "" {
+		return nil, validation.ErrEmptyAuthor
+	}
+
+	before, err := s.storage.GetCommentByID(ctx, id)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := s.storage.EnsureCommentsEnabled(ctx, postId); err != nil {
-		return nil, validation.ErrCommentsOff
+	c, err := s.storage.DeleteComment(ctx, id, author)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.storage.CreateComment(ctx, postId, utils.ValueOrDefault(parentId, ""), author, content)
+	s.wakeOutbox()
+	s.audit(ctx, author, AuditDeleteComment, TargetComment, c.ID, before, c)
+
+	return c, nil
+}
+
+func (s *Service) ReconcileCounters(ctx context.Context) (int64, error) {
+	return s.storage.ReconcileCounters(ctx)
 }
 
 func (s *Service) ListComments(ctx context.Context, postId string, parentId *string, limit, offset *int) ([]*models.Comment, error) {
-	return s.storage.GetComments(ctx, postId,
-		utils.ValueOrDefault(parentId, ""), utils.ValueOrDefault(limit, 0), utils.ValueOrDefault(offset, 0))
+	n, skip, err := page(limit, offset, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetComments(ctx, postId, utils.ValueOrDefault(parentId, ""), n, skip)
+}
+
+// GetComment returns a published comment, held and hidden ones are not found.
+func (s *Service) GetComment(ctx context.Context, id string) (*models.Comment, error) {
+	c, err := s.storage.GetCommentByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Pending || c.Hidden {
+		return nil, storage.ErrCommentNotFound
+	}
+
+	return c, nil
+}
+
+// published drops held and hidden comments, like GetComment does.
+func published(comments []*models.Comment) []*models.Comment {
+	out := make([]*models.Comment, 0, len(comments))
+	for _, c := range comments {
+		if !c.Pending && !c.Hidden {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// GetCommentPermalink returns the comment with up to contextDepth nearest
+// ancestors, top-level first, and the first page of its replies.
+func (s *Service) GetCommentPermalink(ctx context.Context, id string, contextDepth, childrenLimit *int) (*models.CommentPermalink, error) {
+	c, err := s.GetComment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors := []*models.Comment{}
+	if depth := utils.ValueOrDefault(contextDepth, 0); depth > 0 && c.Depth > 0 {
+		if ancestors, err = s.Ancestors(ctx, c.ID); err != nil {
+			return nil, err
+		}
+		ancestors = ancestors[max(len(ancestors)-depth, 0):]
+	}
+
+	children, err := s.storage.GetComments(ctx, c.PostID, c.ID, utils.ValueOrDefault(childrenLimit, 0), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CommentPermalink{Comment: c, Ancestors: ancestors, Children: children}, nil
+}
+
+// Thread returns comments of the post, or replies below rootId at any depth,
+// in depth-first thread order.
+func (s *Service) Thread(ctx context.Context, postId string, rootId *string, limit, offset *int) ([]*models.Comment, error) {
+	n, skip, err := page(limit, offset, 50)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetSubtree(ctx, postId, utils.ValueOrDefault(rootId, ""), n, skip)
+}
+
+// Ancestors returns the published part of the parent chain, top-level first.
+func (s *Service) Ancestors(ctx context.Context, commentId string) ([]*models.Comment, error) {
+	ancestors, err := s.storage.GetAncestors(ctx, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return published(ancestors), nil
+}
+
+func (s *Service) DescendantCount(ctx context.Context, commentId string) (int, error) {
+	return s.storage.CountDescendants(ctx, commentId)
+}
+
+func (s *Service) Search(ctx context.Context, query string, kind *models.SearchType, limit *int, after *string) (*models.SearchConnection, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, validation.ErrEmptyQuery
+	}
+
+	offset := 0
+	if after != nil && *after != "" {
+		var err error
+		if offset, err = decodeCursor(*after); err != nil {
+			return nil, err
+		}
+	}
+
+	n := utils.ValueOrDefault(limit, 10)
+	if err := validation.ValidatePage(n, offset); err != nil {
+		return nil, err
+	}
+
+	results, err := s.storage.Search(ctx, query, utils.ValueOrDefault(kind, models.SearchTypeAll), n+1, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &models.SearchConnection{Results: results}
+	if len(results) > n {
+		conn.Results = results[:n]
+		conn.HasNextPage = true
+	}
+	if len(conn.Results) > 0 {
+		cursor := encodeCursor(offset + len(conn.Results))
+		conn.EndCursor = &cursor
+	}
+
+	return conn, nil
+}
+
+// page applies the default limit of a list query and validates the page.
+func page(limit, offset *int, defaultLimit int) (int, int, error) {
+	n, skip := utils.ValueOrDefault(limit, defaultLimit), utils.ValueOrDefault(offset, 0)
+
+	return n, skip, validation.ValidatePage(n, skip)
+}
+
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, validation.ErrInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "offset:"))
+	if err != nil || offset < 0 {
+		return 0, validation.ErrInvalidCursor
+	}
+
+	return offset, nil
+}
+
+func (s *Service) allow(ctx context.Context, operation, author string) error {
+	if s.limiter == nil {
+		return nil
+	}
+
+	return s.limiter.Allow(ctx, operation, author, reqctx.ClientIP(ctx))
+}
+
+// idempotent runs create at most once per client mutation id. A retry with the
+// same id and request returns the entity stored by the first successful call,
+// reusing the id for different arguments fails with ErrIdempotencyKeyReused.
+func idempotent[T any](ctx context.Context, s *Service, operation, author string, clientMutationId *string, request any, create func() (*T, error)) (*T, error) {
+	if clientMutationId == nil || *clientMutationId == "" {
+		return create()
+	}
+
+	key := operation + ":" + author + ":" + *clientMutationId
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(body)
+
+	stored, err := s.storage.ReserveIdempotencyKey(ctx, key, hex.EncodeToString(hash[:]), idempotencyLease)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored != nil {
+		var entity T
+		if err := json.Unmarshal(stored, &entity); err != nil {
+			return nil, err
+		}
+		return &entity, nil
+	}
+
+	// The key is settled even when the client goes away, otherwise retries
+	// wait for the lease to run out.
+	detached := context.WithoutCancel(ctx)
+
+	entity, err := create()
+	if err != nil {
+		if releaseErr := s.storage.ReleaseIdempotencyKey(detached, key); releaseErr != nil {
+			return nil, errors.Join(err, releaseErr)
+		}
+		return nil, err
+	}
+
+	response, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	// The entity is stored, failing here would make the client retry and
+	// store it again once the lease runs out.
+	if err := s.storage.CompleteIdempotencyKey(detached, key, response, s.idempotencyTTL); err != nil {
+		log.Printf("complete idempotency key %s: %v", key, err)
+	}
+
+	return entity, nil
 }
 
 func ToUserError(err error) error {
+	var (
+		limitErr    *ratelimit.LimitError
+		fieldErrs   validation.Errors
+		rejectedErr *filter.RejectedError
+		bannedErr   *BannedError
+	)
+
 	switch {
+	case errors.As(err, &limitErr):
+		return &gqlerror.Error{
+			Err:     err,
+			Message: ratelimit.ErrRateLimited.Error(),
+			Extensions: map[string]interface{}{
+				"code":       "RATE_LIMITED",
+				"retryAfter": int(math.Ceil(limitErr.RetryAfter.Seconds())),
+			},
+		}
+	case errors.As(err, &fieldErrs):
+		fields := make([]map[string]string, 0, len(fieldErrs))
+		for _, fe := range fieldErrs {
+			fields = append(fields, map[string]string{"field": fe.Field, "message": fe.Err.Error()})
+		}
+		return &gqlerror.Error{
+			Err:     err,
+			Message: err.Error(),
+			Extensions: map[string]interface{}{
+				"code":   "VALIDATION_FAILED",
+				"fields": fields,
+			},
+		}
+	case errors.As(err, &rejectedErr):
+		return &gqlerror.Error{
+			Err:     err,
+			Message: filter.ErrRejected.Error(),
+			Extensions: map[string]interface{}{
+				"code":    "CONTENT_REJECTED",
+				"reasons": rejectedErr.Reasons,
+			},
+		}
+	case errors.As(err, &bannedErr):
+		ext := map[string]interface{}{
+			"code":  "BANNED",
+			"scope": bannedErr.Ban.Scope,
+		}
+		if bannedErr.Ban.Until != nil {
+			ext["until"] = bannedErr.Ban.Until
+		}
+		return &gqlerror.Error{
+			Err:        err,
+			Message:    err.Error(),
+			Extensions: ext,
+		}
 	case errors.Is(err, validation.ErrCommentsOff):
 		return err
 	case errors.Is(err, validation.ErrTooLong):
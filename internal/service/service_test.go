@@ -3,27 +3,51 @@ package service_test
 import (
 	"context"
 	"errors"
+	"ozonProject/internal/filter"
 	"ozonProject/internal/models"
+	"ozonProject/internal/outbox"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/ratelimit"
+	"ozonProject/internal/reqctx"
 	"ozonProject/internal/service"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/validation"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 type mockStore struct {
 	commentsEnabled bool
 }
 
-func (f *mockStore) CreatePost(ctx context.Context, title, content, author string, ce bool) (*models.Post, error) {
-	return &models.Post{ID: "1", Title: title, Content: content, Author: author, CommentsEnabled: ce}, nil
+func (f *mockStore) CreatePost(ctx context.Context, community, title, content, author string, ce bool, tags []string) (*models.Post, error) {
+	return &models.Post{ID: "1", Title: title, Content: content, Author: author, CommentsEnabled: ce, Tags: tags, CommunityName: community}, nil
 }
-func (f *mockStore) GetPosts(ctx context.Context, limit, offset int) ([]*models.Post, error) {
+func (f *mockStore) GetPosts(ctx context.Context, limit, offset int, filter storage.PostFilter) ([]*models.Post, error) {
 	return []*models.Post{{ID: "1", Title: "t"}}, nil
 }
 func (f *mockStore) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
 	return &models.Post{ID: id, CommentsEnabled: f.commentsEnabled}, nil
 }
-func (f *mockStore) CreateComment(ctx context.Context, postID string, parentID string, author, content string) (*models.Comment, error) {
+func (f *mockStore) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	return &models.Post{ID: postID, Score: value}, nil
+}
+func (f *mockStore) GetTags(ctx context.Context, limit int) ([]*models.Tag, error) {
+	return []*models.Tag{}, nil
+}
+func (f *mockStore) CreateCommunity(ctx context.Context, c *models.Community) (*models.Community, error) {
+	return c, nil
+}
+func (f *mockStore) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	return &models.Community{Name: name, CommentsEnabled: true, MaxCommentLength: 2000}, nil
+}
+func (f *mockStore) GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error) {
+	return []*models.Community{}, nil
+}
+func (f *mockStore) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
 	return &models.Comment{ID: "10", PostID: postID, ParentID: &parentID, Author: author, Content: content}, nil
 }
 func (f *mockStore) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
@@ -36,6 +60,127 @@ func (f *mockStore) EnsureCommentsEnabled(ctx context.Context, postID string) er
 	return nil
 }
 
+func (f *mockStore) ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error) {
+	return nil, nil
+}
+func (f *mockStore) CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	return nil
+}
+func (f *mockStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return nil
+}
+func (f *mockStore) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	return 0, nil
+}
+func (f *mockStore) Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error) {
+	return []*models.SearchResult{}, nil
+}
+func (f *mockStore) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	return &models.Comment{ID: id, Author: storage.DeletedPlaceholder, Content: storage.DeletedPlaceholder, Deleted: true}, nil
+}
+func (f *mockStore) ReconcileCounters(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+func (f *mockStore) ImportPost(ctx context.Context, post *models.Post) error {
+	return nil
+}
+func (f *mockStore) ImportComment(ctx context.Context, comment *models.Comment) error {
+	return nil
+}
+func (f *mockStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	return nil, nil
+}
+func (f *mockStore) AckOutboxEvents(ctx context.Context, ids []string) error {
+	return nil
+}
+func (f *mockStore) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	return webhook, nil
+}
+func (f *mockStore) GetWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return nil, nil
+}
+func (f *mockStore) DeleteWebhook(ctx context.Context, id string) error {
+	return nil
+}
+func (f *mockStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	return nil
+}
+func (f *mockStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	return nil, nil
+}
+func (f *mockStore) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return nil
+}
+func (f *mockStore) GetWebhookDeliveries(ctx context.Context, filter storage.DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error) {
+	return []*models.WebhookDelivery{}, nil
+}
+func (f *mockStore) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	return nil, storage.ErrDeliveryNotFound
+}
+func (f *mockStore) CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error) {
+	return nil, nil
+}
+func (f *mockStore) CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error) {
+	return notifications, nil
+}
+func (f *mockStore) GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error) {
+	return []*models.Notification{}, nil
+}
+func (f *mockStore) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	return 0, nil
+}
+func (f *mockStore) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	return nil
+}
+func (f *mockStore) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	return &models.Post{ID: id, Deleted: true}, nil
+}
+func (f *mockStore) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	return report, nil
+}
+func (f *mockStore) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	return nil, storage.ErrReportNotFound
+}
+func (f *mockStore) GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error) {
+	return []*models.Report{}, nil
+}
+func (f *mockStore) ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	return nil, storage.ErrReportNotFound
+}
+func (f *mockStore) ReopenReport(ctx context.Context, reportID, decisionID string) error {
+	return nil
+}
+func (f *mockStore) BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error) {
+	return ban, nil
+}
+func (f *mockStore) ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error) {
+	return nil, nil
+}
+func (f *mockStore) GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error) {
+	return []*models.Ban{}, nil
+}
+func (f *mockStore) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	return nil, storage.ErrCommentNotFound
+}
+func (f *mockStore) GetCommentByID(ctx context.Context, id string) (*models.Comment, error) {
+	return nil, storage.ErrCommentNotFound
+}
+func (f *mockStore) AppendAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return nil
+}
+func (f *mockStore) GetAuditLog(ctx context.Context, filter storage.AuditFilter, limit, offset int) ([]*models.AuditEntry, error) {
+	return []*models.AuditEntry{}, nil
+}
+func (f *mockStore) GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	return []*models.Comment{}, nil
+}
+func (f *mockStore) GetAncestors(ctx context.Context, id string) ([]*models.Comment, error) {
+	return []*models.Comment{}, nil
+}
+func (f *mockStore) CountDescendants(ctx context.Context, id string) (int, error) {
+	return 0, nil
+}
+
 func TestCreateComment_TooLong(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
@@ -43,7 +188,7 @@ func TestCreateComment_TooLong(t *testing.T) {
 	for i := range body {
 		body[i] = 'a'
 	}
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", string(body))
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", string(body), nil)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "content too long")
 }
@@ -51,7 +196,7 @@ func TestCreateComment_TooLong(t *testing.T) {
 func TestCreateComment_Empty(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", "")
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", "", nil)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "content is empty")
 }
@@ -59,7 +204,7 @@ func TestCreateComment_Empty(t *testing.T) {
 func TestCreateComment_Disabled(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: false})
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", "ok")
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
 	require.Error(t, err)
 	require.Equal(t, "comments are disabled for this post", err.Error())
 }
@@ -67,7 +212,7 @@ func TestCreateComment_Disabled(t *testing.T) {
 func TestCreateComment_Ok(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	c, err := s.CreateComment(context.Background(), "1", nil, "me", "ok")
+	c, err := s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
 	require.NoError(t, err)
 	require.Equal(t, "10", c.ID)
 	require.Equal(t, "1", c.PostID)
@@ -78,9 +223,625 @@ func TestCreateComment_Ok(t *testing.T) {
 func TestListPosts_Defaults(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	limit := 0
-	offset := 5
-	posts, err := s.ListPosts(context.Background(), &limit, &offset)
+	posts, err := s.ListPosts(context.Background(), nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	require.Len(t, posts, 1)
 }
+
+func TestCreateComment_RateLimited(t *testing.T) {
+	t.Parallel()
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), map[string]ratelimit.Policy{
+		service.OpCreateComment: {PerAuthor: ratelimit.PerMinute(1)},
+	})
+	s := service.New(&mockStore{commentsEnabled: true}, service.WithRateLimiter(limiter))
+
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
+	require.NoError(t, err)
+
+	_, err = s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
+	require.ErrorIs(t, err, ratelimit.ErrRateLimited)
+
+	var gqlErr *gqlerror.Error
+	require.ErrorAs(t, service.ToUserError(err), &gqlErr)
+	require.Equal(t, "RATE_LIMITED", gqlErr.Extensions["code"])
+	require.Equal(t, 60, gqlErr.Extensions["retryAfter"])
+}
+
+func TestCreatePost_ValidationFields(t *testing.T) {
+	t.Parallel()
+	s := service.New(&mockStore{commentsEnabled: true}, service.WithLimits(validation.Limits{MaxTitleLen: 3}))
+
+	_, err := s.CreatePost(context.Background(), "general", "long", "  ", "me", nil, nil, nil)
+	require.ErrorIs(t, err, validation.ErrTooLong)
+
+	var gqlErr *gqlerror.Error
+	require.ErrorAs(t, service.ToUserError(err), &gqlErr)
+	require.Equal(t, "VALIDATION_FAILED", gqlErr.Extensions["code"])
+	require.Equal(t, []map[string]string{
+		{"field": "title", "message": "content too long"},
+		{"field": "content", "message": "content is empty"},
+	}, gqlErr.Extensions["fields"])
+
+	p, err := s.CreatePost(context.Background(), "general", " hey ", "body", " me ", nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "hey", p.Title)
+	require.Equal(t, "me", p.Author)
+}
+
+func TestCreateComment_IdempotentRetry(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	s := service.New(repo)
+
+	enabled := true
+	post, err := s.CreatePost(context.Background(), "general", "t", "c", "me", &enabled, nil, nil)
+	require.NoError(t, err)
+
+	key := "retry-1"
+	first, err := s.CreateComment(context.Background(), post.ID, nil, "me", "ok", &key)
+	require.NoError(t, err)
+
+	second, err := s.CreateComment(context.Background(), post.ID, nil, "me", "ok", &key)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, second.ID)
+
+	_, err = s.CreateComment(context.Background(), post.ID, nil, "me", "changed", &key)
+	require.ErrorIs(t, err, storage.ErrIdempotencyKeyReused, "a key is not replayed for a different request")
+
+	comments, err := repo.GetComments(context.Background(), post.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+}
+
+func TestCreateComment_FailedAttemptReleasesKey(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	s := service.New(repo)
+
+	enabled := true
+	post, err := s.CreatePost(context.Background(), "general", "t", "c", "me", &enabled, nil, nil)
+	require.NoError(t, err)
+
+	key := "retry-2"
+	_, err = s.CreateComment(context.Background(), post.ID, nil, "me", "", &key)
+	require.Error(t, err)
+
+	c, err := s.CreateComment(context.Background(), post.ID, nil, "me", "ok", &key)
+	require.NoError(t, err)
+	require.Equal(t, "ok", c.Content)
+}
+
+func TestCreateComment_PublishedToBusWithoutOutbox(t *testing.T) {
+	t.Parallel()
+	bus := pubsub.New()
+	s := service.New(storage.NewInMemoryStorage(), service.WithBus(bus))
+	ctx := context.Background()
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+
+	live := bus.Subscribe(post.ID)
+	defer bus.Unsubscribe(post.ID, live)
+
+	c, err := s.CreateComment(ctx, post.ID, nil, "bob", "hi", nil)
+	require.NoError(t, err)
+
+	select {
+	case got := <-live:
+		require.Equal(t, c.ID, got.ID)
+	default:
+		t.Fatal("comment not published")
+	}
+}
+
+func TestCreateComment_NotifiesReplyAndMentions(t *testing.T) {
+	t.Parallel()
+	bus := pubsub.New()
+	s := service.New(storage.NewInMemoryStorage(), service.WithBus(bus))
+	ctx := context.Background()
+
+	live := bus.SubscribeNotifications("carol")
+	defer bus.UnsubscribeNotifications("carol", live)
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, post.ID, nil, "bob", "hi @alice", nil)
+	require.NoError(t, err)
+	_, err = s.CreateComment(ctx, post.ID, &root.ID, "dave", "@bob @carol, @carol and me@mail.com", nil)
+	require.NoError(t, err)
+
+	unread, limit := true, 10
+	alice, err := s.ListNotifications(ctx, "alice", &unread, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, alice, 1)
+	require.Equal(t, models.NotificationTypeReply, alice[0].Type)
+
+	bob, err := s.ListNotifications(ctx, "bob", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, bob, 1)
+	require.Equal(t, models.NotificationTypeReply, bob[0].Type)
+	require.Equal(t, "dave", bob[0].Actor)
+
+	select {
+	case n := <-live:
+		require.Equal(t, models.NotificationTypeMention, n.Type)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timeout waiting for notification")
+	}
+
+	mail, err := s.ListNotifications(ctx, "mail.com", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Empty(t, mail)
+
+	marked, err := s.MarkNotificationsRead(ctx, "alice", nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, marked)
+
+	alice, err = s.ListNotifications(ctx, "alice", &unread, &limit, nil)
+	require.NoError(t, err)
+	require.Empty(t, alice)
+}
+
+func TestCreateComment_HeldByFilterUntilApproved(t *testing.T) {
+	t.Parallel()
+	bus := pubsub.New()
+	repo := storage.NewInMemoryStorage()
+	dispatcher := outbox.NewDispatcher(repo, outbox.Config{Interval: time.Hour}, outbox.NewBusSink(bus))
+	s := service.New(repo, service.WithBus(bus), service.WithOutbox(dispatcher), service.WithContentFilter(&filter.Pipeline{
+		Filters:   []filter.ContentFilter{filter.NewBannedWords([]string{"casino"})},
+		HoldScore: 1,
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Run(ctx)
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+	post, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+
+	live := bus.Subscribe(post.ID)
+	defer bus.Unsubscribe(post.ID, live)
+
+	c, err := s.CreateComment(ctx, post.ID, nil, "bob", "visit my casino", nil)
+	require.NoError(t, err)
+	require.True(t, c.Pending)
+
+	select {
+	case <-live:
+		t.Fatal("held comment published")
+	default:
+	}
+
+	limit := 10
+	thread, err := s.ListComments(ctx, post.ID, nil, &limit, nil)
+	require.NoError(t, err)
+	require.Empty(t, thread)
+
+	queue, err := s.ModerationQueue(ctx, "golang", "mod", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	require.Equal(t, filter.AutomodReporter, queue[0].Reporter)
+
+	report, err := s.ResolveReport(ctx, queue[0].ID, "mod", models.ModerationActionApprove, nil)
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusActioned, report.Status)
+
+	select {
+	case got := <-live:
+		require.Equal(t, c.ID, got.ID)
+		require.False(t, got.Pending)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timeout waiting for approved comment")
+	}
+
+	post, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, post.CommentCount)
+}
+
+func TestListQueries_RejectInvalidPages(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage(), service.WithAdmins([]string{"root"}))
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		limit, offset int
+		want          error
+	}{
+		{-1, 0, validation.ErrInvalidLimit},
+		{0, 0, validation.ErrInvalidLimit},
+		{validation.MaxPageSize + 1, 0, validation.ErrInvalidLimit},
+		{10, -1, validation.ErrInvalidOffset},
+	} {
+		_, err := s.ListNotifications(ctx, "alice", nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.ModerationQueue(ctx, "general", "root", nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.ListBans(ctx, "root", models.BanScopeGlobal, nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.AuditLog(reqctx.WithAdmin(ctx, "root"), nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.ListPosts(ctx, &tc.limit, &tc.offset, nil, nil, nil, nil)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.ListComments(ctx, "post", nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.Thread(ctx, "post", nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.ListCommunities(ctx, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		_, err = s.WebhookDeliveries(reqctx.WithAdmin(ctx, "root"), nil, nil, &tc.limit, &tc.offset)
+		require.ErrorIs(t, err, tc.want)
+		if tc.want == validation.ErrInvalidLimit {
+			_, err = s.ListTags(ctx, &tc.limit)
+			require.ErrorIs(t, err, tc.want)
+		}
+	}
+
+	notifications, err := s.ListNotifications(ctx, "alice", nil, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, notifications)
+}
+
+func TestBanUser_Scopes(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage(), service.WithAdmins([]string{"root"}))
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+	post, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	other, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = s.BanUser(ctx, "troll", models.BanScopeGlobal, nil, nil, "spam", "mod")
+	require.ErrorIs(t, err, validation.ErrNotAdmin)
+
+	past := time.Now().Add(-time.Minute)
+	_, err = s.BanUser(ctx, "troll", models.BanScopePost, &post.ID, &past, "spam", "mod")
+	var fieldErrs validation.Errors
+	require.True(t, errors.As(err, &fieldErrs))
+	require.Equal(t, "until", fieldErrs[0].Field)
+
+	until := time.Now().Add(time.Hour)
+	ban, err := s.BanUser(ctx, "troll", models.BanScopePost, &post.ID, &until, "spam", "mod")
+	require.NoError(t, err)
+	require.Equal(t, post.ID, ban.ScopeID)
+
+	_, err = s.CreateComment(ctx, post.ID, nil, "troll", "hi", nil)
+	var bannedErr *service.BannedError
+	require.True(t, errors.As(err, &bannedErr))
+	require.ErrorIs(t, err, validation.ErrBanned)
+	require.Equal(t, models.BanScopePost, bannedErr.Ban.Scope)
+
+	_, err = s.CreateComment(ctx, other.ID, nil, "troll", "hi", nil)
+	require.NoError(t, err)
+
+	_, err = s.BanUser(ctx, "troll", models.BanScopeGlobal, nil, nil, "spam", "root")
+	require.NoError(t, err)
+	_, err = s.CreatePost(ctx, "general", "t", "c", "troll", nil, nil, nil)
+	require.ErrorIs(t, err, validation.ErrBanned)
+
+	scope := "golang"
+	_, err = s.ListBans(ctx, "alice", models.BanScopeCommunity, &scope, nil, nil)
+	require.ErrorIs(t, err, validation.ErrNotModerator)
+
+	limit := 10
+	bans, err := s.ListBans(ctx, "mod", models.BanScopePost, &post.ID, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+}
+
+func TestAuditLog_RecordsMutations(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage(), service.WithAdmins([]string{"root"}))
+	ctx := reqctx.WithRequestID(reqctx.WithClientIP(context.Background(), "10.0.0.1"), "req-1")
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	c, err := s.CreateComment(ctx, post.ID, nil, "bob", "hi", nil)
+	require.NoError(t, err)
+	_, err = s.DeleteComment(ctx, c.ID, "bob")
+	require.NoError(t, err)
+
+	_, err = s.AuditLog(ctx, nil, nil, nil)
+	require.ErrorIs(t, err, validation.ErrNotAdmin, "the caller did not authenticate")
+	_, err = s.AuditLog(reqctx.WithAdmin(ctx, "bob"), nil, nil, nil)
+	require.ErrorIs(t, err, validation.ErrNotAdmin, "bob is not listed in admins")
+
+	admin := reqctx.WithAdmin(ctx, "root")
+	limit := 10
+	entries, err := s.AuditLog(admin, nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, service.AuditCreatePost, entries[0].Action)
+	require.Equal(t, "req-1", entries[0].RequestID)
+	require.Equal(t, "10.0.0.1", entries[0].IP)
+
+	actor := "bob"
+	action := service.AuditDeleteComment
+	entries, err = s.AuditLog(admin, &models.AuditFilter{Actor: &actor, Action: &action}, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, c.ID, entries[0].TargetID)
+	require.Contains(t, *entries[0].Before, `"content":"hi"`)
+	require.Contains(t, *entries[0].After, `"deleted":true`)
+}
+
+func TestCreateComment_MaxReplyDepth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	for _, flatten := range []bool{false, true} {
+		s := service.New(storage.NewInMemoryStorage(), service.WithMaxReplyDepth(1, flatten))
+		post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+		require.NoError(t, err)
+
+		root, err := s.CreateComment(ctx, post.ID, nil, "bob", "root", nil)
+		require.NoError(t, err)
+		require.Equal(t, 0, root.Depth)
+		reply, err := s.CreateComment(ctx, post.ID, &root.ID, "carol", "reply", nil)
+		require.NoError(t, err)
+		require.Equal(t, 1, reply.Depth)
+
+		deep, err := s.CreateComment(ctx, post.ID, &reply.ID, "dave", "deep", nil)
+		if !flatten {
+			require.ErrorIs(t, err, validation.ErrTooDeep)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, 1, deep.Depth)
+		require.Equal(t, root.ID, *deep.ParentID)
+	}
+}
+
+func TestGetCommentPermalink_Context(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, post.ID, nil, "bob", "root", nil)
+	require.NoError(t, err)
+	mid, err := s.CreateComment(ctx, post.ID, &root.ID, "carol", "mid", nil)
+	require.NoError(t, err)
+	leaf, err := s.CreateComment(ctx, post.ID, &mid.ID, "dave", "leaf", nil)
+	require.NoError(t, err)
+	reply, err := s.CreateComment(ctx, post.ID, &leaf.ID, "erin", "reply", nil)
+	require.NoError(t, err)
+
+	depth, limit := 1, 10
+	link, err := s.GetCommentPermalink(ctx, leaf.ID, &depth, &limit)
+	require.NoError(t, err)
+	require.Equal(t, leaf.ID, link.Comment.ID)
+	require.Len(t, link.Ancestors, 1)
+	require.Equal(t, mid.ID, link.Ancestors[0].ID)
+	require.Len(t, link.Children, 1)
+	require.Equal(t, reply.ID, link.Children[0].ID)
+
+	depth = 5
+	link, err = s.GetCommentPermalink(ctx, leaf.ID, &depth, &limit)
+	require.NoError(t, err)
+	require.Len(t, link.Ancestors, 2)
+	require.Equal(t, root.ID, link.Ancestors[0].ID)
+
+	_, err = s.GetCommentPermalink(ctx, "missing", &depth, &limit)
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+}
+
+func TestAncestors_SkipHiddenContent(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	s := service.New(repo)
+	ctx := context.Background()
+
+	post, err := s.CreatePost(ctx, "general", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	root, err := s.CreateComment(ctx, post.ID, nil, "bob", "root", nil)
+	require.NoError(t, err)
+	mid, err := s.CreateComment(ctx, post.ID, &root.ID, "carol", "mid", nil)
+	require.NoError(t, err)
+	leaf, err := s.CreateComment(ctx, post.ID, &mid.ID, "dave", "leaf", nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, root.ID))
+
+	ancestors, err := s.Ancestors(ctx, leaf.ID)
+	require.NoError(t, err)
+	require.Len(t, ancestors, 1)
+	require.Equal(t, mid.ID, ancestors[0].ID)
+
+	depth := 5
+	link, err := s.GetCommentPermalink(ctx, leaf.ID, &depth, nil)
+	require.NoError(t, err)
+	require.Len(t, link.Ancestors, 1)
+	require.Equal(t, mid.ID, link.Ancestors[0].ID)
+
+	p, err := s.CommentPost(ctx, leaf)
+	require.NoError(t, err)
+	require.Equal(t, post.ID, p.ID)
+
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, post.ID))
+	p, err = s.CommentPost(ctx, leaf)
+	require.NoError(t, err)
+	require.Nil(t, p)
+}
+
+func TestCreateWebhook_Validation(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage(), service.WithAdmins([]string{"root"}))
+	ctx := context.Background()
+	events := []models.WebhookEvent{models.WebhookEventPostCreated, models.WebhookEventPostCreated}
+
+	_, err := s.CreateWebhook(ctx, "https://search.test/hook", "0123456789abcdef", events)
+	require.ErrorIs(t, err, validation.ErrNotAdmin)
+
+	ctx = reqctx.WithAdmin(ctx, "root")
+	_, err = s.CreateWebhook(ctx, "ftp://search.test", "short", nil)
+	var fieldErrs validation.Errors
+	require.True(t, errors.As(err, &fieldErrs))
+	require.Len(t, fieldErrs, 3)
+	require.ErrorIs(t, err, validation.ErrInvalidWebhookURL)
+	require.ErrorIs(t, err, validation.ErrShortWebhookSecret)
+	require.ErrorIs(t, err, validation.ErrNoWebhookEvents)
+
+	hook, err := s.CreateWebhook(ctx, " https://search.test/hook ", "0123456789abcdef", events)
+	require.NoError(t, err)
+	require.Equal(t, "https://search.test/hook", hook.URL)
+	require.Equal(t, []models.WebhookEvent{models.WebhookEventPostCreated}, hook.Events)
+
+	limit := 10
+	entries, err := s.AuditLog(ctx, nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "root", entries[0].Actor)
+	require.Equal(t, service.AuditCreateWebhook, entries[0].Action)
+	require.NotContains(t, *entries[0].After, "0123456789abcdef", "secrets stay out of the audit log")
+
+	_, err = s.RetryWebhookDelivery(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrDeliveryNotFound)
+	ok, err := s.DeleteWebhook(ctx, hook.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	hooks, err := s.Webhooks(ctx)
+	require.NoError(t, err)
+	require.Empty(t, hooks)
+}
+
+type failOnceStore struct {
+	storage.Storage
+	failed bool
+}
+
+func (f *failOnceStore) CreatePost(ctx context.Context, community, title, content, author string, ce bool, tags []string) (*models.Post, error) {
+	if !f.failed {
+		f.failed = true
+		return nil, errors.New("connection reset")
+	}
+	return f.Storage.CreatePost(ctx, community, title, content, author, ce, tags)
+}
+
+func TestCreatePost_FilterInScoringMode(t *testing.T) {
+	t.Parallel()
+	repo := &failOnceStore{Storage: storage.NewInMemoryStorage()}
+	s := service.New(repo, service.WithContentFilter(&filter.Pipeline{
+		Filters:   []filter.ContentFilter{filter.NewBannedWords([]string{"casino"}), filter.NewDuplicates(time.Hour)},
+		HoldScore: 1,
+	}))
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = s.CreatePost(ctx, "golang", "t", "visit my casino", "alice", nil, nil, nil)
+	var rejected *filter.RejectedError
+	require.ErrorAs(t, err, &rejected)
+	require.Equal(t, []string{"banned word"}, rejected.Reasons)
+
+	_, err = s.CreatePost(ctx, "golang", "t", "hello", "alice", nil, nil, nil)
+	require.EqualError(t, err, "connection reset")
+
+	_, err = s.CreatePost(ctx, "golang", "t", "hello", "alice", nil, nil, nil)
+	require.NoError(t, err, "a retry after a failed write is no duplicate")
+
+	_, err = s.CreatePost(ctx, "golang", "t", "hello", "alice", nil, nil, nil)
+	require.ErrorAs(t, err, &rejected)
+	require.Equal(t, []string{"duplicate content"}, rejected.Reasons)
+}
+
+type failAuditStore struct {
+	storage.Storage
+}
+
+func (f failAuditStore) AppendAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+func (f failAuditStore) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	return nil, errors.New("reports unavailable")
+}
+
+func TestAudit_FailureKeepsTheChange(t *testing.T) {
+	t.Parallel()
+	s := service.New(failAuditStore{Storage: storage.NewInMemoryStorage()}, service.WithContentFilter(&filter.Pipeline{
+		Filters:   []filter.ContentFilter{filter.NewBannedWords([]string{"casino"})},
+		HoldScore: 1,
+	}))
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+
+	key := "post-1"
+	post, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, &key)
+	require.NoError(t, err)
+	again, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, &key)
+	require.NoError(t, err)
+	require.Equal(t, post.ID, again.ID, "the key is completed, a retry does not create the post twice")
+
+	// Neither the audit entry nor the automod report can be stored.
+	key = "comment-1"
+	held, err := s.CreateComment(ctx, post.ID, nil, "bob", "visit my casino", &key)
+	require.NoError(t, err)
+	require.True(t, held.Pending)
+	heldAgain, err := s.CreateComment(ctx, post.ID, nil, "bob", "visit my casino", &key)
+	require.NoError(t, err)
+	require.Equal(t, held.ID, heldAgain.ID)
+}
+
+func TestResolveReport_ApproveOnlyHeldComments(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	s := service.New(repo)
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+	post, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	c, err := s.CreateComment(ctx, post.ID, nil, "bob", "hi", nil)
+	require.NoError(t, err)
+
+	for _, target := range []struct {
+		typ models.ReportTargetType
+		id  string
+	}{
+		{models.ReportTargetTypePost, post.ID},
+		{models.ReportTargetTypeComment, c.ID},
+	} {
+		report, err := s.ReportContent(ctx, target.typ, target.id, "spam", "carol")
+		require.NoError(t, err)
+		_, err = s.ResolveReport(ctx, report.ID, "mod", models.ModerationActionApprove, nil)
+		require.ErrorIs(t, err, validation.ErrNotApprovable)
+
+		report, err = repo.GetReport(ctx, report.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.ReportStatusOpen, report.Status, "the report is not resolved")
+	}
+}
+
+func TestAuditLog_ModerationDeleteKeepsContent(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage(), service.WithAdmins([]string{"root"}))
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+	post, err := s.CreatePost(ctx, "golang", "t", "buy pills", "troll", nil, nil, nil)
+	require.NoError(t, err)
+	report, err := s.ReportContent(ctx, models.ReportTargetTypePost, post.ID, "spam", "alice")
+	require.NoError(t, err)
+	_, err = s.ResolveReport(ctx, report.ID, "mod", models.ModerationActionDelete, nil)
+	require.NoError(t, err)
+
+	limit := 10
+	action := service.AuditDeletePost
+	entries, err := s.AuditLog(reqctx.WithAdmin(ctx, "root"), &models.AuditFilter{Action: &action}, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "mod", entries[0].Actor)
+	require.Equal(t, post.ID, entries[0].TargetID)
+	require.Contains(t, *entries[0].Before, `"content":"buy pills"`)
+	require.NotContains(t, *entries[0].After, "buy pills")
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/utils"
+	"ozonProject/internal/validation"
+	"ozonProject/internal/webhook"
+	"slices"
+)
+
+const (
+	MinWebhookSecretLen = 16
+	MaxWebhookURLLen    = 2000
+)
+
+// WithWebhooks wakes the sender after a dead delivery is retried.
+func WithWebhooks(sender *webhook.Sender) Option {
+	return func(s *Service) {
+		s.webhooks = sender
+	}
+}
+
+// CreateWebhook subscribes url to the events, requests are signed with the
+// secret. Only admins may manage webhooks.
+func (s *Service) CreateWebhook(ctx context.Context, rawURL, secret string, events []models.WebhookEvent) (*models.Webhook, error) {
+	admin, err := s.requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var v validation.Validator
+	v.Field("url", &rawURL, validation.Normalize, validation.NotBlank, validation.SingleLine, validation.MaxLen(MaxWebhookURLLen))
+	if u, err := url.Parse(rawURL); rawURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		v.Check("url", validation.ErrInvalidWebhookURL)
+	}
+	if len([]rune(secret)) < MinWebhookSecretLen {
+		v.Check("secret", validation.ErrShortWebhookSecret)
+	}
+	if len(events) == 0 {
+		v.Check("events", validation.ErrNoWebhookEvents)
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	unique := make([]models.WebhookEvent, 0, len(events))
+	for _, e := range events {
+		if !slices.Contains(unique, e) {
+			unique = append(unique, e)
+		}
+	}
+
+	w, err := s.storage.CreateWebhook(ctx, &models.Webhook{URL: rawURL, Secret: secret, Events: unique, CreatedBy: admin})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, admin, AuditCreateWebhook, TargetWebhook, w.ID, nil, w)
+
+	return w, nil
+}
+
+// DeleteWebhook stops deliveries to the webhook and drops its delivery log.
+func (s *Service) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	admin, err := s.requireAdmin(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.storage.DeleteWebhook(ctx, id); err != nil {
+		return false, err
+	}
+
+	s.audit(ctx, admin, AuditDeleteWebhook, TargetWebhook, id, nil, nil)
+
+	return true, nil
+}
+
+// Webhooks lists registered webhooks. The GraphQL type has no secret field,
+// but secrets are stored in plaintext and readable by anyone with access to
+// the storage.
+func (s *Service) Webhooks(ctx context.Context) ([]*models.Webhook, error) {
+	if _, err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetWebhooks(ctx)
+}
+
+// WebhookDeliveries is the delivery log, newest first. Dead deliveries
+// form the dead-letter list.
+func (s *Service) WebhookDeliveries(ctx context.Context, webhookID *string, status *models.WebhookDeliveryStatus, limit, offset *int) ([]*models.WebhookDelivery, error) {
+	if _, err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	n, skip, err := page(limit, offset, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := storage.DeliveryFilter{
+		WebhookID: utils.ValueOrDefault(webhookID, ""),
+		Status:    utils.ValueOrDefault(status, ""),
+	}
+
+	return s.storage.GetWebhookDeliveries(ctx, filter, n, skip)
+}
+
+// RetryWebhookDelivery sends a dead delivery again with a fresh attempt budget.
+func (s *Service) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	admin, err := s.requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.storage.RetryWebhookDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.webhooks != nil {
+		s.webhooks.Wake()
+	}
+
+	s.audit(ctx, admin, AuditRetryDelivery, TargetDelivery, id, nil, nil)
+
+	return d, nil
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"ozonProject/internal/cache"
+	"ozonProject/internal/models"
+	"ozonProject/internal/pubsub"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CacheStats counts reads served by CachedStorage.
+type CacheStats struct {
+	PostHits      int64 `json:"postHits"`
+	PostMisses    int64 `json:"postMisses"`
+	CommentHits   int64 `json:"commentHits"`
+	CommentMisses int64 `json:"commentMisses"`
+}
+
+// CachedStorage is a read-through cache in front of another Storage for post
+// lookups and pages of direct replies. Writes going through it publish a
+// pubsub.Invalidation, and every CachedStorage listening on the bus drops
+// the entries it names.
+//
+// Entries are stored under versioned keys: invalidation deletes the version
+// of a post or a reply list, so a read that raced with the write fills a key
+// nobody reads anymore instead of bringing stale data back.
+type CachedStorage struct {
+	Storage
+
+	store cache.Store
+	bus   *pubsub.Bus
+	ttl   time.Duration
+
+	postHits      atomic.Int64
+	postMisses    atomic.Int64
+	commentHits   atomic.Int64
+	commentMisses atomic.Int64
+}
+
+// epochKey versions every other key, bumping it drops the whole cache.
+const epochKey = "epoch"
+
+func NewCachedStorage(next Storage, store cache.Store, bus *pubsub.Bus, ttl time.Duration) *CachedStorage {
+	s := &CachedStorage{
+		Storage: next,
+		store:   store,
+		bus:     bus,
+		ttl:     ttl,
+	}
+	bus.OnInvalidate(s.invalidate)
+
+	return s
+}
+
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		PostHits:      s.postHits.Load(),
+		PostMisses:    s.postMisses.Load(),
+		CommentHits:   s.commentHits.Load(),
+		CommentMisses: s.commentMisses.Load(),
+	}
+}
+
+func postGroup(id string) string {
+	return "post:" + id
+}
+
+func repliesGroup(postID, parentID string) string {
+	return "replies:" + postID + ":" + parentID
+}
+
+// version returns the current version of key, creating one when there is
+// none, and an empty string when the store is unavailable.
+func (s *CachedStorage) version(ctx context.Context, key string) string {
+	raw, ok, err := s.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("cache: get %s: %v", key, err)
+		return ""
+	}
+	if ok {
+		return string(raw)
+	}
+
+	v := uuid.New().String()
+	if err := s.store.Set(ctx, key, []byte(v), 0); err != nil {
+		log.Printf("cache: set %s: %v", key, err)
+		return ""
+	}
+
+	return v
+}
+
+func (s *CachedStorage) groupKey(ctx context.Context, group string) string {
+	epoch := s.version(ctx, epochKey)
+	if epoch == "" {
+		return ""
+	}
+
+	return s.version(ctx, "version:"+epoch+":"+group)
+}
+
+// readThrough returns the value cached for group and suffix, calling load and
+// caching its result on a miss. Store failures fall back to load.
+func readThrough[T any](ctx context.Context, s *CachedStorage, group, suffix string, hits, misses *atomic.Int64, load func() (T, error)) (T, error) {
+	version := s.groupKey(ctx, group)
+	if version == "" {
+		return load()
+	}
+	key := group + ":" + version + suffix
+
+	raw, ok, err := s.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("cache: get %s: %v", key, err)
+	}
+	if ok {
+		var v T
+		if err := json.Unmarshal(raw, &v); err == nil {
+			hits.Add(1)
+			return v, nil
+		}
+	}
+	misses.Add(1)
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+
+	if raw, err := json.Marshal(v); err == nil {
+		if err := s.store.Set(ctx, key, raw, s.ttl); err != nil {
+			log.Printf("cache: set %s: %v", key, err)
+		}
+	}
+
+	return v, nil
+}
+
+func (s *CachedStorage) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
+	return readThrough(ctx, s, postGroup(id), "", &s.postHits, &s.postMisses, func() (*models.Post, error) {
+		return s.Storage.GetPostByID(ctx, id)
+	})
+}
+
+func (s *CachedStorage) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
+	suffix := fmt.Sprintf(":%d:%d", limit, offset)
+
+	return readThrough(ctx, s, repliesGroup(postID, parentID), suffix, &s.commentHits, &s.commentMisses, func() ([]*models.Comment, error) {
+		return s.Storage.GetComments(ctx, postID, parentID, limit, offset)
+	})
+}
+
+func (s *CachedStorage) invalidate(inv pubsub.Invalidation) {
+	ctx := context.Background()
+
+	keys := []string{epochKey}
+	if !inv.All {
+		epoch := s.version(ctx, epochKey)
+		if epoch == "" {
+			return
+		}
+
+		keys = []string{"version:" + epoch + ":" + postGroup(inv.PostID)}
+		for _, parentID := range inv.Parents {
+			keys = append(keys, "version:"+epoch+":"+repliesGroup(inv.PostID, parentID))
+		}
+	}
+
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("cache: delete %s: %v", key, err)
+		}
+	}
+}
+
+// commentChanged invalidates the post of c, the replies c belongs to and
+// the replies holding its parent, whose reply counter may have changed.
+func (s *CachedStorage) commentChanged(ctx context.Context, c *models.Comment) {
+	inv := pubsub.Invalidation{PostID: c.PostID, Parents: []string{""}}
+
+	if c.ParentID != nil && *c.ParentID != "" {
+		parent, err := s.Storage.GetCommentByID(ctx, *c.ParentID)
+		if err != nil {
+			log.Printf("cache: get parent of %s: %v", c.ID, err)
+			inv = pubsub.Invalidation{All: true}
+		} else {
+			inv.Parents = []string{*c.ParentID, ""}
+			if parent.ParentID != nil {
+				inv.Parents[1] = *parent.ParentID
+			}
+		}
+	}
+
+	s.bus.PublishInvalidation(inv)
+}
+
+func (s *CachedStorage) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	p, err := s.Storage.VotePost(ctx, postID, voter, value)
+	if err == nil {
+		s.bus.PublishInvalidation(pubsub.Invalidation{PostID: postID})
+	}
+
+	return p, err
+}
+
+func (s *CachedStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
+	c, err := s.Storage.CreateComment(ctx, postID, parentID, author, content, pending)
+	if err == nil && !c.Pending {
+		s.commentChanged(ctx, c)
+	}
+
+	return c, err
+}
+
+func (s *CachedStorage) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	c, err := s.Storage.ApproveComment(ctx, id)
+	if err == nil {
+		s.commentChanged(ctx, c)
+	}
+
+	return c, err
+}
+
+func (s *CachedStorage) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	c, err := s.Storage.DeleteComment(ctx, id, author)
+	if err == nil {
+		s.commentChanged(ctx, c)
+	}
+
+	return c, err
+}
+
+func (s *CachedStorage) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	if err := s.Storage.HideContent(ctx, targetType, id); err != nil {
+		return err
+	}
+
+	if targetType == models.ReportTargetTypePost {
+		s.bus.PublishInvalidation(pubsub.Invalidation{PostID: id})
+		return nil
+	}
+
+	c, err := s.Storage.GetCommentByID(ctx, id)
+	if err != nil {
+		log.Printf("cache: get hidden comment %s: %v", id, err)
+		s.bus.PublishInvalidation(pubsub.Invalidation{All: true})
+		return nil
+	}
+	s.commentChanged(ctx, c)
+
+	return nil
+}
+
+func (s *CachedStorage) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	p, err := s.Storage.DeletePost(ctx, id, author)
+	if err == nil {
+		s.bus.PublishInvalidation(pubsub.Invalidation{PostID: id})
+	}
+
+	return p, err
+}
+
+func (s *CachedStorage) ReconcileCounters(ctx context.Context) (int64, error) {
+	fixed, err := s.Storage.ReconcileCounters(ctx)
+	if err == nil && fixed > 0 {
+		s.bus.PublishInvalidation(pubsub.Invalidation{All: true})
+	}
+
+	return fixed, err
+}
+
+func (s *CachedStorage) ImportComment(ctx context.Context, comment *models.Comment) error {
+	if err := s.Storage.ImportComment(ctx, comment); err != nil {
+		return err
+	}
+	if !comment.Pending {
+		s.commentChanged(ctx, comment)
+	}
+
+	return nil
+}
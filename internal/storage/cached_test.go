@@ -0,0 +1,72 @@
+package storage_test
+
+import (
+	"context"
+	"ozonProject/internal/cache"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedStorage_HitsAndInvalidation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewCachedStorage(storage.NewInMemoryStorage(), cache.NewLRU(100), pubsub.New(), time.Minute)
+
+	p, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	root, err := repo.CreateComment(ctx, p.ID, "", "bob", "root", false)
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err := repo.GetPostByID(ctx, p.ID)
+		require.NoError(t, err)
+		_, err = repo.GetComments(ctx, p.ID, "", 10, 0)
+		require.NoError(t, err)
+	}
+	require.Equal(t, storage.CacheStats{PostHits: 2, PostMisses: 1, CommentHits: 2, CommentMisses: 1}, repo.Stats())
+
+	_, err = repo.CreateComment(ctx, p.ID, root.ID, "carol", "reply", false)
+	require.NoError(t, err)
+
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, got.CommentCount)
+
+	top, err := repo.GetComments(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, top[0].ReplyCount, "the page holding the parent is dropped too")
+	require.Equal(t, storage.CacheStats{PostHits: 2, PostMisses: 2, CommentHits: 2, CommentMisses: 2}, repo.Stats())
+
+	_, err = repo.CreateComment(ctx, p.ID, "", "eve", "held", true)
+	require.NoError(t, err)
+	_, err = repo.GetComments(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, repo.Stats().CommentHits, "pending comments change nothing visible")
+}
+
+func TestCachedStorage_InvalidatesInstancesSharingTheBus(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	next := storage.NewInMemoryStorage()
+	bus := pubsub.New()
+	reader := storage.NewCachedStorage(next, cache.NewLRU(100), bus, time.Minute)
+	writer := storage.NewCachedStorage(next, cache.NewLRU(100), bus, time.Minute)
+
+	p, err := writer.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+
+	_, err = reader.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+
+	_, err = writer.VotePost(ctx, p.ID, "bob", 1)
+	require.NoError(t, err)
+
+	got, err := reader.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, got.Score)
+	require.Zero(t, reader.Stats().PostHits)
+}
@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"ozonProject/internal/cache"
+	"ozonProject/internal/pubsub"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/storage/storagetest"
+	"testing"
+	"time"
+)
+
+func TestInMemoryConformance(t *testing.T) {
+	t.Parallel()
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewInMemoryStorage()
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	t.Parallel()
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newSQLiteStorage(t)
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	t.Parallel()
+
+	open := storagetest.LocalPostgres(t)
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewPostgresStorage(open(t))
+	})
+}
+
+func TestCachedConformance(t *testing.T) {
+	t.Parallel()
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewCachedStorage(storage.NewInMemoryStorage(), cache.NewLRU(100), pubsub.New(), time.Minute)
+	})
+}
@@ -2,8 +2,13 @@ package storage
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"ozonProject/internal/models"
+	"ozonProject/internal/utils"
+	"ozonProject/internal/validation"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,35 +16,170 @@ import (
 )
 
 type postsStore struct {
-	mu    sync.RWMutex
-	byID  map[string]*models.Post
-	order []string
+	mu          sync.RWMutex
+	byID        map[string]*models.Post
+	order       []string
+	byTag       map[string][]string
+	byCommunity map[string][]string
+	byHot       *rankedIDs
+	byTop       *rankedIDs
+	votes       map[string]map[string]int
 }
 
 func newPostsStore() *postsStore {
 	return &postsStore{
-		byID:  make(map[string]*models.Post),
-		order: make([]string, 0, 200),
+		byID:        make(map[string]*models.Post),
+		order:       make([]string, 0, 200),
+		byTag:       make(map[string][]string),
+		byCommunity: make(map[string][]string),
+		byHot:       &rankedIDs{before: hotBefore},
+		byTop:       &rankedIDs{before: topBefore},
+		votes:       make(map[string]map[string]int),
 	}
 }
 
-func (s *postsStore) create(title, content, author string, commentsEnabled bool) *models.Post {
+func (s *postsStore) create(post *models.Post) *models.Post {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	p := &models.Post{
-		ID:              uuid.New().String(),
-		Title:           title,
-		Content:         content,
-		Author:          author,
-		CommentsEnabled: commentsEnabled,
-		CreatedAt:       time.Now().UTC(),
+	return s.insertLocked(post)
+}
+
+// insert stores the post unless its id is taken.
+func (s *postsStore) insert(post *models.Post) (*models.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[post.ID]; ok {
+		return nil, ErrPostExists
 	}
 
-	s.byID[p.ID] = p
+	return s.insertLocked(post), nil
+}
+
+// insertLocked stores a copy of the post and adds it to every index.
+func (s *postsStore) insertLocked(post *models.Post) *models.Post {
+	p := *post
+	p.Tags = append([]string{}, post.Tags...)
+	sort.Strings(p.Tags)
+	p.HotRank = hotRank(p.Score, p.CreatedAt)
+
+	s.byID[p.ID] = &p
 	s.order = append(s.order, p.ID)
+	s.byCommunity[p.CommunityName] = append(s.byCommunity[p.CommunityName], p.ID)
+	for _, tag := range p.Tags {
+		s.byTag[tag] = append(s.byTag[tag], p.ID)
+	}
+	s.byHot.insert(&p, s.byID)
+	s.byTop.insert(&p, s.byID)
+
+	cp := p
+
+	return &cp
+}
+
+// vote records the voter's value for the post and moves the post within the
+// ranked indexes according to its new score.
+func (s *postsStore) vote(postID, voter string, value int) (*models.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.byID[postID]
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+
+	votes, ok := s.votes[postID]
+	if !ok {
+		votes = make(map[string]int)
+		s.votes[postID] = votes
+	}
+
+	delta := value - votes[voter]
+	if value == 0 {
+		delete(votes, voter)
+	} else {
+		votes[voter] = value
+	}
+
+	if delta != 0 {
+		s.byHot.remove(p, s.byID)
+		s.byTop.remove(p, s.byID)
+
+		p.Score += delta
+		p.HotRank = hotRank(p.Score, p.CreatedAt)
+
+		s.byHot.insert(p, s.byID)
+		s.byTop.insert(p, s.byID)
+	}
+
+	cp := *p
+
+	return &cp, nil
+}
+
+// addComments moves the post comment counter by delta.
+func (s *postsStore) addComments(postID string, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.byID[postID]; ok {
+		p.CommentCount += delta
+	}
+}
+
+// setCommentCounts overwrites comment counters with counts and returns the
+// number of posts that were out of sync.
+func (s *postsStore) setCommentCounts(counts map[string]int) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var fixed int64
+	for id, p := range s.byID {
+		if p.CommentCount != counts[id] {
+			p.CommentCount = counts[id]
+			fixed++
+		}
+	}
+
+	return fixed
+}
+
+// hide returns the post when it was visible before and nil otherwise.
+func (s *postsStore) hide(id string) (*models.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.byID[id]
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+	if p.Hidden || p.Deleted {
+		p.Hidden = true
+		return nil, nil
+	}
+	p.Hidden = true
+
+	return p, nil
+}
+
+func (s *postsStore) delete(id, author string) (*models.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.byID[id]
+	if !ok || p.Deleted || (author != "" && p.Author != author) {
+		return nil, ErrPostNotFound
+	}
+
+	p.Title = DeletedPlaceholder
+	p.Content = DeletedPlaceholder
+	p.Author = DeletedPlaceholder
+	p.Deleted = true
+
+	cp := *p
 
-	return p
+	return &cp, nil
 }
 
 func (s *postsStore) getByID(id string) (*models.Post, error) {
@@ -48,40 +188,206 @@ func (s *postsStore) getByID(id string) (*models.Post, error) {
 
 	p, ok := s.byID[id]
 	if !ok {
-		return nil, errors.New("post not found")
+		return nil, ErrPostNotFound
 	}
 	cp := *p
 
 	return &cp, nil
 }
 
-func (s *postsStore) list(limit, offset int) ([]*models.Post, error) {
+func (s *postsStore) list(limit, offset int, filter PostFilter) ([]*models.Post, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	n := len(s.order)
-	if offset >= n {
-		return []*models.Post{}, nil
+	// order is kept oldest first, ranked indexes best first.
+	order, newest := s.order, true
+	switch {
+	case filter.Sort == models.PostSortHot:
+		order, newest = s.byHot.ids, false
+	case filter.Sort == models.PostSortTop:
+		order, newest = s.byTop.ids, false
+	case filter.Community != "":
+		order = s.byCommunity[filter.Community]
+	case filter.Tag != "":
+		order = s.byTag[filter.Tag]
+	}
+
+	start := 0
+	if newest && filter.AfterID != "" {
+		// Posts are never removed from order, newer ones are appended, so
+		// the position of the cursor is stable.
+		start = len(order)
+		for j, id := range order {
+			if id == filter.AfterID {
+				start = len(order) - j
+				break
+			}
+		}
 	}
 
-	start := n - 1 - offset
 	res := make([]*models.Post, 0, limit)
-	for i := start; i >= 0 && len(res) < limit; i-- {
-		id := s.order[i]
-		if p, ok := s.byID[id]; ok {
-			cp := *p
-			res = append(res, &cp)
+	skipped := 0
+	for i := start; i < len(order) && len(res) < limit; i++ {
+		id := order[i]
+		if newest {
+			id = order[len(order)-1-i]
 		}
+
+		p, ok := s.byID[id]
+		if !ok || !matchesFilter(p, filter) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		cp := *p
+		res = append(res, &cp)
 	}
 
 	return res, nil
 }
 
+func matchesFilter(p *models.Post, filter PostFilter) bool {
+	if p.Hidden || p.Deleted {
+		return false
+	}
+
+	if filter.Community != "" && p.CommunityName != filter.Community {
+		return false
+	}
+
+	if !filter.Since.IsZero() && p.CreatedAt.Before(filter.Since) {
+		return false
+	}
+
+	if filter.Tag != "" {
+		for _, tag := range p.Tags {
+			if tag == filter.Tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (s *postsStore) tags(limit int) []*models.Tag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Like the tag feed, counts leave out hidden and deleted posts.
+	out := make([]*models.Tag, 0, len(s.byTag))
+	for name, ids := range s.byTag {
+		n := 0
+		for _, id := range ids {
+			if p := s.byID[id]; !p.Hidden && !p.Deleted {
+				n++
+			}
+		}
+		if n > 0 {
+			out = append(out, &models.Tag{Name: name, PostCount: n})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].PostCount != out[j].PostCount {
+			return out[i].PostCount > out[j].PostCount
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	if len(out) > limit {
+		out = out[:limit]
+	}
+
+	return out
+}
+
+type communitiesStore struct {
+	mu     sync.RWMutex
+	byName map[string]*models.Community
+	order  []string
+}
+
+func newCommunitiesStore() *communitiesStore {
+	s := &communitiesStore{
+		byName: make(map[string]*models.Community),
+	}
+
+	s.create(&models.Community{
+		Name:             DefaultCommunity,
+		Description:      "Default community",
+		CommentsEnabled:  true,
+		MaxCommentLength: validation.MaxCommentLen,
+	})
+
+	return s
+}
+
+func (s *communitiesStore) create(c *models.Community) (*models.Community, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byName[c.Name]; ok {
+		return nil, ErrCommunityExists
+	}
+
+	cp := *c
+	cp.Rules = append([]string{}, c.Rules...)
+	cp.Moderators = append([]string{}, c.Moderators...)
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now().UTC()
+	}
+
+	s.byName[cp.Name] = &cp
+	s.order = append(s.order, cp.Name)
+
+	out := cp
+	return &out, nil
+}
+
+func (s *communitiesStore) get(name string) (*models.Community, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.byName[name]
+	if !ok {
+		return nil, ErrCommunityNotFound
+	}
+	cp := *c
+
+	return &cp, nil
+}
+
+func (s *communitiesStore) list(limit, offset int) []*models.Community {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset >= len(s.order) {
+		return []*models.Community{}
+	}
+
+	names := s.order[offset:min(offset+limit, len(s.order))]
+	out := make([]*models.Community, 0, len(names))
+	for _, name := range names {
+		cp := *s.byName[name]
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
 type commentsStore struct {
 	mu         sync.RWMutex
 	byID       map[string]*models.Comment
 	byPostRoot map[string][]string
 	byParent   map[parentKey][]string
+	// paths hold materialized paths: one fixed-width segment per ancestor,
+	// so sorting by path yields thread order.
+	paths map[string]string
+	seq   int64
 }
 
 type parentKey struct {
@@ -94,124 +400,943 @@ func newCommentsStore() *commentsStore {
 		byID:       make(map[string]*models.Comment),
 		byPostRoot: make(map[string][]string),
 		byParent:   make(map[parentKey][]string),
+		paths:      make(map[string]string),
 	}
 }
 
-func (s *commentsStore) create(postID string, parentID string, author, content string) *models.Comment {
+func (s *commentsStore) create(comment *models.Comment) *models.Comment {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	c := &models.Comment{
-		ID:        uuid.New().String(),
-		PostID:    postID,
-		ParentID:  &parentID,
-		Author:    author,
-		Content:   content,
-		CreatedAt: time.Now().UTC(),
+	return s.createLocked(comment)
+}
+
+// insert stores the comment unless its id is taken.
+func (s *commentsStore) insert(comment *models.Comment) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[comment.ID]; ok {
+		return nil, ErrCommentExists
+	}
+
+	return s.createLocked(comment), nil
+}
+
+// createLocked derives depth and path of the comment from its parent and
+// counts it as a reply unless it is pending or deleted.
+func (s *commentsStore) createLocked(comment *models.Comment) *models.Comment {
+	c := *comment
+	parentID := utils.ValueOrDefault(c.ParentID, "")
+	c.ParentID = &parentID
+
+	s.seq++
+	path := s.paths[parentID] + pathSegment(s.seq)
+
+	if parent, ok := s.byID[parentID]; ok {
+		c.Depth = parent.Depth + 1
+		if !c.Pending && !c.Deleted {
+			parent.ReplyCount++
+		}
 	}
+	s.putLocked(&c, path)
+
+	cp := c
+
+	return &cp
+}
+
+// putLocked indexes the comment as is, counters of its parent are left alone.
+func (s *commentsStore) putLocked(c *models.Comment, path string) {
 	s.byID[c.ID] = c
-	s.byPostRoot[postID] = append(s.byPostRoot[postID], c.ID)
+	s.byPostRoot[c.PostID] = append(s.byPostRoot[c.PostID], c.ID)
+	s.paths[c.ID] = path
 
-	pk := parentKey{
-		postID: postID,
+	pk := parentKey{postID: c.PostID, parent: utils.ValueOrDefault(c.ParentID, "")}
+	s.byParent[pk] = append(s.byParent[pk], c.ID)
+}
+
+// hide returns the comment when it was published before and nil otherwise.
+func (s *commentsStore) hide(id string) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.byID[id]
+	if !ok {
+		return nil, ErrCommentNotFound
 	}
+	if c.Hidden || c.Deleted || c.Pending {
+		c.Hidden = true
+		return nil, nil
+	}
+	c.Hidden = true
 
-	if parentID != "" {
-		pk.parent = parentID
+	return c, nil
+}
+
+// approve publishes a pending comment and increments the reply counter of its parent.
+func (s *commentsStore) approve(id string) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.byID[id]
+	if !ok || !c.Pending || c.Deleted {
+		return nil, ErrCommentNotFound
+	}
+	c.Pending = false
+
+	if c.ParentID != nil && *c.ParentID != "" {
+		if parent, ok := s.byID[*c.ParentID]; ok {
+			parent.ReplyCount++
+		}
 	}
-	s.byParent[pk] = append(s.byParent[pk], c.ID)
 
-	return c
+	cp := *c
+
+	return &cp, nil
 }
 
-func (s *commentsStore) list(postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
+func (s *commentsStore) get(id string) (*models.Comment, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	pk := parentKey{
-		postID: postID,
+	c, ok := s.byID[id]
+	if !ok {
+		return nil, ErrCommentNotFound
 	}
+	cp := *c
 
-	if parentID != "" {
-		pk.parent = parentID
-	}
-	ids := s.byParent[pk]
+	return &cp, nil
+}
 
-	if offset >= len(ids) {
-		return []*models.Comment{}, nil
-	}
+// delete replaces the comment body with DeletedPlaceholder and decrements
+// the reply counter of its parent.
+func (s *commentsStore) delete(id, author string) (*models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	end := offset + limit
-	if end > len(ids) {
-		end = len(ids)
+	c, ok := s.byID[id]
+	if !ok || c.Deleted || (author != "" && c.Author != author) {
+		return nil, ErrCommentNotFound
 	}
-	slice := ids[offset:end]
-	out := make([]*models.Comment, 0, len(slice))
 
-	for _, id := range slice {
-		if c, ok := s.byID[id]; ok {
-			cp := *c
-			out = append(out, &cp)
+	c.Author = DeletedPlaceholder
+	c.Content = DeletedPlaceholder
+	c.Deleted = true
+
+	if c.ParentID != nil && *c.ParentID != "" && !c.Pending {
+		if parent, ok := s.byID[*c.ParentID]; ok {
+			parent.ReplyCount--
 		}
 	}
 
-	return out, nil
-}
+	cp := *c
 
-type InMemoryStorage struct {
-	posts    *postsStore
-	comments *commentsStore
+	return &cp, nil
 }
 
-func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{
-		posts:    newPostsStore(),
-		comments: newCommentsStore(),
+// reconcile recomputes reply counters and returns live comment counts per
+// post along with the number of comments that were out of sync.
+func (s *commentsStore) reconcile() (map[string]int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	perPost := make(map[string]int)
+	replies := make(map[string]int)
+	for _, c := range s.byID {
+		if c.Deleted || c.Pending {
+			continue
+		}
+		perPost[c.PostID]++
+		if c.ParentID != nil && *c.ParentID != "" {
+			replies[*c.ParentID]++
+		}
+	}
+
+	var fixed int64
+	for id, c := range s.byID {
+		if c.ReplyCount != replies[id] {
+			c.ReplyCount = replies[id]
+			fixed++
+		}
 	}
-}
 
-func (r *InMemoryStorage) CreatePost(ctx context.Context, title, content, author string, commentsEnabled bool) (*models.Post, error) {
-	return r.posts.create(title, content, author, commentsEnabled), nil
+	return perPost, fixed
 }
 
-func (r *InMemoryStorage) GetPosts(ctx context.Context, limit, offset int) ([]*models.Post, error) {
-	return r.posts.list(limit, offset)
+// pathSegment mirrors the fixed-width segments of comments.path in Postgres.
+func pathSegment(seq int64) string {
+	return fmt.Sprintf("%012x.", seq)
 }
 
-func (r *InMemoryStorage) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
-	return r.posts.getByID(id)
+// visible reports whether the comment is shown in threads.
+func visible(c *models.Comment) bool {
+	return !c.Hidden && !c.Pending
 }
 
-func (r *InMemoryStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string) (*models.Comment, error) {
-	if _, err := r.posts.getByID(postID); err != nil {
-		return nil, err
+// subtree returns descendants of rootID, or all comments of the post when
+// rootID is empty, in thread order.
+func (s *commentsStore) subtree(postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := ""
+	if rootID != "" {
+		root, ok := s.byID[rootID]
+		if !ok || root.PostID != postID {
+			return nil, ErrCommentNotFound
+		}
+		prefix = s.paths[rootID]
 	}
 
-	if parentID != "" {
-		r.comments.mu.RLock()
-		_, ok := r.comments.byID[parentID]
-		r.comments.mu.RUnlock()
-		if !ok {
-			return nil, errors.New("parent comment not found")
+	var matched []string
+	for _, id := range s.byPostRoot[postID] {
+		if id != rootID && strings.HasPrefix(s.paths[id], prefix) && visible(s.byID[id]) {
+			matched = append(matched, id)
 		}
 	}
+	sort.Slice(matched, func(i, j int) bool {
+		return s.paths[matched[i]] < s.paths[matched[j]]
+	})
+
+	out := make([]*models.Comment, 0, min(limit, len(matched)))
+	for i := offset; i < len(matched) && len(out) < limit; i++ {
+		cp := *s.byID[matched[i]]
+		out = append(out, &cp)
+	}
 
-	return r.comments.create(postID, parentID, author, content), nil
+	return out, nil
 }
 
-func (r *InMemoryStorage) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
-	return r.comments.list(postID, parentID, limit, offset)
+// ancestors returns the chain from the top-level comment down to the parent of id.
+func (s *commentsStore) ancestors(id string) ([]*models.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.byID[id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+
+	var out []*models.Comment
+	for c.ParentID != nil && *c.ParentID != "" {
+		if c, ok = s.byID[*c.ParentID]; !ok {
+			break
+		}
+		cp := *c
+		out = append(out, &cp)
+	}
+	slices.Reverse(out)
+
+	return out, nil
 }
 
-func (r *InMemoryStorage) EnsureCommentsEnabled(ctx context.Context, postID string) error {
-	p, err := r.posts.getByID(postID)
-	if err != nil {
-		return err
+// countDescendants counts live replies at any depth below id.
+func (s *commentsStore) countDescendants(id string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.byID[id]
+	if !ok {
+		return 0, ErrCommentNotFound
 	}
 
-	if !p.CommentsEnabled {
-		return errors.New("comments disabled")
+	prefix, n := s.paths[id], 0
+	for _, other := range s.byPostRoot[c.PostID] {
+		d := s.byID[other]
+		if other != id && strings.HasPrefix(s.paths[other], prefix) && visible(d) && !d.Deleted {
+			n++
+		}
 	}
 
-	return nil
+	return n, nil
+}
+
+func (s *commentsStore) list(postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pk := parentKey{
+		postID: postID,
+	}
+
+	if parentID != "" {
+		pk.parent = parentID
+	}
+	ids := s.byParent[pk]
+
+	out := make([]*models.Comment, 0, min(limit, len(ids)))
+	skipped := 0
+	for _, id := range ids {
+		if len(out) == limit {
+			break
+		}
+		c, ok := s.byID[id]
+		if !ok || c.Hidden || c.Pending {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		cp := *c
+		out = append(out, &cp)
+	}
+
+	return out, nil
+}
+
+type idempotencyEntry struct {
+	hash      string
+	response  []byte
+	expiresAt time.Time
+}
+
+type idempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]*idempotencyEntry
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{
+		keys: make(map[string]*idempotencyEntry),
+	}
+}
+
+func (s *idempotencyStore) reserve(key, hash string, lease time.Duration) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	if e, ok := s.keys[key]; ok && e.expiresAt.After(now) {
+		return storedIdempotencyResponse(e.response, e.hash, hash)
+	}
+
+	s.keys[key] = &idempotencyEntry{hash: hash, expiresAt: now.Add(lease)}
+
+	return nil, nil
+}
+
+func (s *idempotencyStore) complete(key string, response []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.keys[key]; ok {
+		e.response = response
+		e.expiresAt = time.Now().UTC().Add(ttl)
+	}
+}
+
+func (s *idempotencyStore) purge() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now, n := time.Now().UTC(), 0
+	for key, e := range s.keys {
+		if !e.expiresAt.After(now) {
+			delete(s.keys, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// storedIdempotencyResponse is what reserving a key held by another request
+// returns, a completed key is replayed only for the same request.
+func storedIdempotencyResponse(response []byte, stored, hash string) ([]byte, error) {
+	if stored != hash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if response == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	return response, nil
+}
+
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.keys[key]; ok && e.response == nil {
+		delete(s.keys, key)
+	}
+}
+
+type InMemoryStorage struct {
+	posts         *postsStore
+	comments      *commentsStore
+	communities   *communitiesStore
+	idempotency   *idempotencyStore
+	search        *searchIndex
+	notifications *notificationsStore
+	reports       *reportsStore
+	bans          *bansStore
+	audit         *auditStore
+	outbox        *outboxStore
+	webhooks      *webhooksStore
+
+	// wal is set when the storage was opened with persistence.
+	wal *walLog
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{
+		posts:         newPostsStore(),
+		comments:      newCommentsStore(),
+		communities:   newCommunitiesStore(),
+		idempotency:   newIdempotencyStore(),
+		search:        newSearchIndex(),
+		notifications: newNotificationsStore(),
+		reports:       newReportsStore(),
+		bans:          newBansStore(),
+		audit:         &auditStore{},
+		outbox:        newOutboxStore(),
+		webhooks:      newWebhooksStore(),
+	}
+}
+
+func (r *InMemoryStorage) CreatePost(ctx context.Context, community, title, content, author string, commentsEnabled bool, tags []string) (*models.Post, error) {
+	p := &models.Post{
+		ID:              uuid.New().String(),
+		Title:           title,
+		Content:         content,
+		Author:          author,
+		CommentsEnabled: commentsEnabled,
+		CreatedAt:       time.Now().UTC(),
+		Tags:            tags,
+		CommunityName:   community,
+	}
+
+	event := newOutboxStub()
+
+	return commit(r, &walRecord{Op: opCreatePost, Post: p, Event: event}, func() (*models.Post, error) {
+		return r.applyCreatePost(p, event)
+	})
+}
+
+func (r *InMemoryStorage) applyCreatePost(post *models.Post, event *models.OutboxEvent) (*models.Post, error) {
+	if _, err := r.communities.get(post.CommunityName); err != nil {
+		return nil, err
+	}
+
+	p := r.posts.create(post)
+	r.search.add(docKey{kind: models.SearchTypePost, id: p.ID}, p.Title+" "+p.Content)
+
+	return p, r.publish(event, models.OutboxPostCreated, p.ID, p)
+}
+
+func (r *InMemoryStorage) GetPosts(ctx context.Context, limit, offset int, filter PostFilter) ([]*models.Post, error) {
+	return r.posts.list(limit, offset, filter)
+}
+
+func (r *InMemoryStorage) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
+	return r.posts.getByID(id)
+}
+
+func (r *InMemoryStorage) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	rec := &walRecord{Op: opVotePost, ID: postID, Actor: voter, Value: value}
+
+	return commit(r, rec, func() (*models.Post, error) {
+		return r.posts.vote(postID, voter, value)
+	})
+}
+
+func (r *InMemoryStorage) GetTags(ctx context.Context, limit int) ([]*models.Tag, error) {
+	return r.posts.tags(limit), nil
+}
+
+func (r *InMemoryStorage) CreateCommunity(ctx context.Context, community *models.Community) (*models.Community, error) {
+	c := *community
+	c.CreatedAt = time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opCreateCommunity, Community: &c}, func() (*models.Community, error) {
+		return r.communities.create(&c)
+	})
+}
+
+func (r *InMemoryStorage) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	return r.communities.get(name)
+}
+
+func (r *InMemoryStorage) GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error) {
+	return r.communities.list(limit, offset), nil
+}
+
+func (r *InMemoryStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
+	c := &models.Comment{
+		ID:        uuid.New().String(),
+		PostID:    postID,
+		ParentID:  &parentID,
+		Author:    author,
+		Content:   content,
+		CreatedAt: time.Now().UTC(),
+		Pending:   pending,
+	}
+
+	var event *models.OutboxEvent
+	if !pending {
+		event = newOutboxStub()
+	}
+
+	return commit(r, &walRecord{Op: opCreateComment, Comment: c, Event: event}, func() (*models.Comment, error) {
+		return r.applyCreateComment(c, event)
+	})
+}
+
+func (r *InMemoryStorage) applyCreateComment(comment *models.Comment, event *models.OutboxEvent) (*models.Comment, error) {
+	p, err := r.posts.getByID(comment.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.CommentsEnabled {
+		return nil, ErrCommentsDisabled
+	}
+
+	if parentID := utils.ValueOrDefault(comment.ParentID, ""); parentID != "" {
+		parent, err := r.comments.get(parentID)
+		if err != nil {
+			return nil, ErrParentNotFound
+		}
+		if parent.PostID != comment.PostID {
+			return nil, ErrParentInOtherPost
+		}
+	}
+
+	c := r.comments.create(comment)
+	if !c.Pending {
+		r.posts.addComments(c.PostID, 1)
+		r.search.add(docKey{kind: models.SearchTypeComment, id: c.ID}, c.Content)
+	}
+
+	return c, r.publish(event, models.OutboxCommentPublished, c.PostID, c)
+}
+
+func (r *InMemoryStorage) ImportPost(ctx context.Context, post *models.Post) error {
+	p := *post
+	p.CommentCount = 0
+
+	_, err := commit(r, &walRecord{Op: opImportPost, Post: &p}, func() (*models.Post, error) {
+		return r.applyImportPost(&p)
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) applyImportPost(post *models.Post) (*models.Post, error) {
+	if _, err := r.communities.get(post.CommunityName); err != nil {
+		return nil, err
+	}
+
+	p, err := r.posts.insert(post)
+	if err != nil {
+		return nil, err
+	}
+	r.search.add(docKey{kind: models.SearchTypePost, id: p.ID}, p.Title+" "+p.Content)
+
+	return p, nil
+}
+
+func (r *InMemoryStorage) ImportComment(ctx context.Context, comment *models.Comment) error {
+	c := *comment
+	c.ReplyCount = 0
+
+	_, err := commit(r, &walRecord{Op: opImportComment, Comment: &c}, func() (*models.Comment, error) {
+		return r.applyImportComment(&c)
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) applyImportComment(comment *models.Comment) (*models.Comment, error) {
+	if _, err := r.posts.getByID(comment.PostID); err != nil {
+		return nil, err
+	}
+
+	if parentID := utils.ValueOrDefault(comment.ParentID, ""); parentID != "" {
+		parent, err := r.comments.get(parentID)
+		if err != nil {
+			return nil, ErrParentNotFound
+		}
+		if parent.PostID != comment.PostID {
+			return nil, ErrParentInOtherPost
+		}
+	}
+
+	c, err := r.comments.insert(comment)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Pending && !c.Deleted {
+		r.posts.addComments(c.PostID, 1)
+	}
+	if !c.Pending {
+		r.search.add(docKey{kind: models.SearchTypeComment, id: c.ID}, c.Content)
+	}
+
+	return c, nil
+}
+
+func (r *InMemoryStorage) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	event := newOutboxStub()
+
+	return commit(r, &walRecord{Op: opApproveComment, ID: id, Event: event}, func() (*models.Comment, error) {
+		return r.applyApproveComment(id, event)
+	})
+}
+
+func (r *InMemoryStorage) applyApproveComment(id string, event *models.OutboxEvent) (*models.Comment, error) {
+	c, err := r.comments.approve(id)
+	if err != nil {
+		return nil, err
+	}
+	r.posts.addComments(c.PostID, 1)
+	r.search.add(docKey{kind: models.SearchTypeComment, id: c.ID}, c.Content)
+
+	return c, r.publish(event, models.OutboxCommentPublished, c.PostID, c)
+}
+
+func (r *InMemoryStorage) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	event := newOutboxStub()
+
+	return commit(r, &walRecord{Op: opDeleteComment, ID: id, Actor: author, Event: event}, func() (*models.Comment, error) {
+		return r.applyDeleteComment(id, author, event)
+	})
+}
+
+func (r *InMemoryStorage) applyDeleteComment(id, author string, event *models.OutboxEvent) (*models.Comment, error) {
+	c, err := r.comments.delete(id, author)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pending {
+		return c, nil
+	}
+	r.posts.addComments(c.PostID, -1)
+
+	return c, r.publish(event, models.OutboxCommentDeleted, c.PostID, c)
+}
+
+func (r *InMemoryStorage) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	event := newOutboxStub()
+
+	_, err := commit(r, &walRecord{Op: opHideContent, ID: id, Target: targetType, Event: event}, func() (struct{}, error) {
+		return struct{}{}, r.applyHideContent(targetType, id, event)
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) applyHideContent(targetType models.ReportTargetType, id string, event *models.OutboxEvent) error {
+	if targetType == models.ReportTargetTypePost {
+		p, err := r.posts.hide(id)
+		if err != nil || p == nil {
+			return err
+		}
+		return r.publish(event, models.OutboxPostHidden, p.ID, p)
+	}
+
+	c, err := r.comments.hide(id)
+	if err != nil || c == nil {
+		return err
+	}
+
+	return r.publish(event, models.OutboxCommentHidden, c.PostID, c)
+}
+
+func (r *InMemoryStorage) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	event := newOutboxStub()
+
+	return commit(r, &walRecord{Op: opDeletePost, ID: id, Actor: author, Event: event}, func() (*models.Post, error) {
+		return r.applyDeletePost(id, author, event)
+	})
+}
+
+func (r *InMemoryStorage) applyDeletePost(id, author string, event *models.OutboxEvent) (*models.Post, error) {
+	p, err := r.posts.delete(id, author)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, r.publish(event, models.OutboxPostDeleted, p.ID, p)
+}
+
+func (r *InMemoryStorage) ReconcileCounters(ctx context.Context) (int64, error) {
+	return commit(r, &walRecord{Op: opReconcileCounters}, func() (int64, error) {
+		return r.applyReconcileCounters(), nil
+	})
+}
+
+func (r *InMemoryStorage) applyReconcileCounters() int64 {
+	perPost, fixed := r.comments.reconcile()
+
+	return fixed + r.posts.setCommentCounts(perPost)
+}
+
+func (r *InMemoryStorage) GetCommentByID(ctx context.Context, id string) (*models.Comment, error) {
+	return r.comments.get(id)
+}
+
+func (r *InMemoryStorage) GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	return r.comments.subtree(postID, rootID, limit, offset)
+}
+
+func (r *InMemoryStorage) GetAncestors(ctx context.Context, id string) ([]*models.Comment, error) {
+	return r.comments.ancestors(id)
+}
+
+func (r *InMemoryStorage) CountDescendants(ctx context.Context, id string) (int, error) {
+	return r.comments.countDescendants(id)
+}
+
+func (r *InMemoryStorage) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
+	return r.comments.list(postID, parentID, limit, offset)
+}
+
+func (r *InMemoryStorage) EnsureCommentsEnabled(ctx context.Context, postID string) error {
+	p, err := r.posts.getByID(postID)
+	if err != nil {
+		return err
+	}
+
+	if !p.CommentsEnabled {
+		return ErrCommentsDisabled
+	}
+
+	return nil
+}
+
+func (r *InMemoryStorage) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	rp := *report
+	rp.ID = uuid.New().String()
+	rp.Status = models.ReportStatusOpen
+	rp.CreatedAt = time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opCreateReport, Report: &rp}, func() (*models.Report, error) {
+		return r.applyCreateReport(&rp)
+	})
+}
+
+func (r *InMemoryStorage) applyCreateReport(report *models.Report) (*models.Report, error) {
+	target := *report
+
+	postID := report.TargetID
+	if report.TargetType == models.ReportTargetTypeComment {
+		c, err := r.comments.get(report.TargetID)
+		if err != nil {
+			return nil, err
+		}
+		if c.Deleted || c.Hidden {
+			return nil, ErrCommentNotFound
+		}
+		target.TargetAuthor = c.Author
+		postID = c.PostID
+	}
+
+	p, err := r.posts.getByID(postID)
+	if err != nil {
+		return nil, err
+	}
+	if report.TargetType == models.ReportTargetTypePost {
+		if p.Deleted || p.Hidden {
+			return nil, ErrPostNotFound
+		}
+		target.TargetAuthor = p.Author
+	}
+	target.Community = p.CommunityName
+
+	return r.reports.create(&target), nil
+}
+
+func (r *InMemoryStorage) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	return r.reports.get(id)
+}
+
+func (r *InMemoryStorage) GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error) {
+	return r.reports.list(community, status, limit, offset), nil
+}
+
+func (r *InMemoryStorage) ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	if decision.ID == "" {
+		decision.ID = uuid.New().String()
+	}
+	d := *decision
+	d.CreatedAt = time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opResolveReport, Status: status, Decision: &d}, func() (*models.Report, error) {
+		return r.reports.resolve(status, &d)
+	})
+}
+
+func (r *InMemoryStorage) ReopenReport(ctx context.Context, reportID, decisionID string) error {
+	_, err := commit(r, &walRecord{Op: opReopenReport, ID: reportID, IDs: []string{decisionID}}, func() (struct{}, error) {
+		r.reports.reopen(reportID, decisionID)
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error) {
+	b := *ban
+	b.ID = uuid.New().String()
+	b.CreatedAt = time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opBanUser, Ban: &b}, func() (*models.Ban, error) {
+		return r.bans.ban(&b), nil
+	})
+}
+
+func (r *InMemoryStorage) ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error) {
+	return r.bans.find(user, community, postID), nil
+}
+
+func (r *InMemoryStorage) GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error) {
+	return r.bans.list(scope, scopeID, limit, offset), nil
+}
+
+func (r *InMemoryStorage) AppendAudit(ctx context.Context, entry *models.AuditEntry) error {
+	e := *entry
+	e.ID = uuid.New().String()
+	e.CreatedAt = time.Now().UTC()
+
+	_, err := commit(r, &walRecord{Op: opAppendAudit, Audit: &e}, func() (struct{}, error) {
+		r.audit.append(&e)
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) GetAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]*models.AuditEntry, error) {
+	return r.audit.list(filter, limit, offset), nil
+}
+
+func (r *InMemoryStorage) CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error) {
+	var recipient string
+	if parentID := utils.ValueOrDefault(reply.ParentID, ""); parentID != "" {
+		parent, err := r.comments.get(parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.Deleted {
+			return nil, nil
+		}
+		recipient = parent.Author
+	} else {
+		post, err := r.posts.getByID(reply.PostID)
+		if err != nil {
+			return nil, err
+		}
+		recipient = post.Author
+	}
+
+	if recipient == reply.Author {
+		return nil, nil
+	}
+
+	created, err := r.CreateNotifications(ctx, []*models.Notification{{
+		Recipient: recipient,
+		Type:      models.NotificationTypeReply,
+		Actor:     reply.Author,
+		PostID:    reply.PostID,
+		CommentID: reply.ID,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return created[0], nil
+}
+
+func (r *InMemoryStorage) CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error) {
+	now := time.Now().UTC()
+	stamped := make([]*models.Notification, 0, len(notifications))
+	for _, n := range notifications {
+		cp := *n
+		cp.ID = uuid.New().String()
+		cp.CreatedAt = now
+		cp.Read = false
+		stamped = append(stamped, &cp)
+	}
+
+	return commit(r, &walRecord{Op: opCreateNotifications, Notifications: stamped}, func() ([]*models.Notification, error) {
+		return r.notifications.create(stamped), nil
+	})
+}
+
+func (r *InMemoryStorage) GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error) {
+	return r.notifications.list(recipient, unreadOnly, limit, offset), nil
+}
+
+func (r *InMemoryStorage) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	return commit(r, &walRecord{Op: opMarkNotificationsRead, Actor: recipient, IDs: ids}, func() (int, error) {
+		return r.notifications.markRead(recipient, ids), nil
+	})
+}
+
+func (r *InMemoryStorage) ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error) {
+	return r.idempotency.reserve(key, hash, lease)
+}
+
+func (r *InMemoryStorage) CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	r.idempotency.complete(key, response, ttl)
+	return nil
+}
+
+func (r *InMemoryStorage) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	r.idempotency.release(key)
+	return nil
+}
+
+func (r *InMemoryStorage) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	return r.idempotency.purge(), nil
+}
+
+func (r *InMemoryStorage) Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error) {
+	terms := uniqueTerms(query)
+	hits := r.search.search(terms, kind)
+
+	// Hidden and deleted documents stay in the index, so they are skipped
+	// before paginating to keep pages full.
+	out := make([]*models.SearchResult, 0, min(limit, len(hits)))
+	for _, hit := range hits {
+		if len(out) == limit {
+			break
+		}
+
+		res := &models.SearchResult{Type: hit.key.kind, Rank: hit.rank}
+
+		switch hit.key.kind {
+		case models.SearchTypePost:
+			p, err := r.posts.getByID(hit.key.id)
+			if err != nil {
+				continue
+			}
+			if p.Hidden || p.Deleted {
+				continue
+			}
+			res.Post = p
+			res.Snippet = highlight(p.Content, terms)
+			if !containsAny(p.Content, terms) {
+				res.Snippet = highlight(p.Title, terms)
+			}
+		case models.SearchTypeComment:
+			c, err := r.comments.get(hit.key.id)
+			if err != nil || c.Deleted || c.Hidden {
+				continue
+			}
+			res.Comment = c
+			res.Snippet = highlight(c.Content, terms)
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+		out = append(out, res)
+	}
+
+	return out, nil
 }
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"ozonProject/internal/models"
+	"sync"
+)
+
+// auditStore keeps entries in append order and exposes no way to change them.
+type auditStore struct {
+	mu      sync.RWMutex
+	entries []*models.AuditEntry
+}
+
+func (s *auditStore) append(entry *models.AuditEntry) {
+	e := *entry
+
+	s.mu.Lock()
+	s.entries = append(s.entries, &e)
+	s.mu.Unlock()
+}
+
+func (f AuditFilter) matches(e *models.AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.TargetType != "" && e.TargetType != f.TargetType:
+		return false
+	case f.TargetID != "" && e.TargetID != f.TargetID:
+		return false
+	case !f.Since.IsZero() && e.CreatedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.CreatedAt.Before(f.Until):
+		return false
+	}
+
+	return true
+}
+
+func (s *auditStore) list(filter AuditFilter, limit, offset int) []*models.AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.AuditEntry, 0, min(limit, len(s.entries)))
+	skipped := 0
+	for _, e := range s.entries {
+		if len(out) == limit {
+			break
+		}
+		if !filter.matches(e) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		cp := *e
+		out = append(out, &cp)
+	}
+
+	return out
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"ozonProject/internal/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type reportKey struct {
+	targetType models.ReportTargetType
+	targetID   string
+	reporter   string
+}
+
+type reportsStore struct {
+	mu    sync.RWMutex
+	byID  map[string]*models.Report
+	byKey map[reportKey]string
+	order []string
+}
+
+func newReportsStore() *reportsStore {
+	return &reportsStore{
+		byID:  make(map[string]*models.Report),
+		byKey: make(map[reportKey]string),
+	}
+}
+
+func copyReport(r *models.Report) *models.Report {
+	cp := *r
+	cp.Decisions = make([]*models.ModerationDecision, 0, len(r.Decisions))
+	for _, d := range r.Decisions {
+		dc := *d
+		cp.Decisions = append(cp.Decisions, &dc)
+	}
+
+	return &cp
+}
+
+func (s *reportsStore) create(report *models.Report) *models.Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := reportKey{targetType: report.TargetType, targetID: report.TargetID, reporter: report.Reporter}
+	if id, ok := s.byKey[key]; ok {
+		return copyReport(s.byID[id])
+	}
+
+	r := *report
+	r.Status = models.ReportStatusOpen
+	r.Decisions = nil
+
+	s.byID[r.ID] = &r
+	s.byKey[key] = r.ID
+	s.order = append(s.order, r.ID)
+
+	return copyReport(&r)
+}
+
+func (s *reportsStore) get(id string) (*models.Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.byID[id]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+
+	return copyReport(r), nil
+}
+
+// list returns reports of the community oldest first, so the queue is worked FIFO.
+func (s *reportsStore) list(community string, status models.ReportStatus, limit, offset int) []*models.Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.Report, 0, limit)
+	skipped := 0
+	for _, id := range s.order {
+		if len(out) == limit {
+			break
+		}
+		r := s.byID[id]
+		if r.Community != community || r.Status != status {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		out = append(out, copyReport(r))
+	}
+
+	return out
+}
+
+func (s *reportsStore) resolve(status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.byID[decision.ReportID]
+	if !ok {
+		return nil, ErrReportNotFound
+	}
+	if r.Status != models.ReportStatusOpen {
+		return nil, ErrReportResolved
+	}
+
+	d := *decision
+	r.Status = status
+	r.Decisions = append(r.Decisions, &d)
+
+	return copyReport(r), nil
+}
+
+func (s *reportsStore) reopen(reportID, decisionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.byID[reportID]; ok {
+		r.Status = models.ReportStatusOpen
+		r.Decisions = slices.DeleteFunc(r.Decisions, func(d *models.ModerationDecision) bool { return d.ID == decisionID })
+	}
+}
+
+type banKey struct {
+	user    string
+	scope   models.BanScope
+	scopeID string
+}
+
+type bansStore struct {
+	mu   sync.Mutex
+	bans map[banKey]*models.Ban
+}
+
+func newBansStore() *bansStore {
+	return &bansStore{bans: make(map[banKey]*models.Ban)}
+}
+
+func (s *bansStore) ban(ban *models.Ban) *models.Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := *ban
+	s.bans[banKey{user: b.UserID, scope: b.Scope, scopeID: b.ScopeID}] = &b
+
+	cp := b
+
+	return &cp
+}
+
+// active returns the ban under key, an expired ban is lifted on lookup.
+// Callers must hold the lock.
+func (s *bansStore) active(key banKey, now time.Time) (*models.Ban, bool) {
+	b, ok := s.bans[key]
+	if !ok {
+		return nil, false
+	}
+	if b.Until != nil && !b.Until.After(now) {
+		delete(s.bans, key)
+		return nil, false
+	}
+
+	return b, true
+}
+
+func (s *bansStore) find(user, community, postID string) *models.Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	keys := []banKey{{user: user, scope: models.BanScopeGlobal}}
+	if community != "" {
+		keys = append(keys, banKey{user: user, scope: models.BanScopeCommunity, scopeID: community})
+	}
+	if postID != "" {
+		keys = append(keys, banKey{user: user, scope: models.BanScopePost, scopeID: postID})
+	}
+
+	for _, k := range keys {
+		if b, ok := s.active(k, now); ok {
+			cp := *b
+			return &cp
+		}
+	}
+
+	return nil
+}
+
+func (s *bansStore) list(scope models.BanScope, scopeID string, limit, offset int) []*models.Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var matched []*models.Ban
+	for k := range s.bans {
+		if k.scope != scope || k.scopeID != scopeID {
+			continue
+		}
+		if b, ok := s.active(k, now); ok {
+			matched = append(matched, b)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	out := make([]*models.Ban, 0, min(limit, len(matched)))
+	for i := offset; i < len(matched) && len(out) < limit; i++ {
+		cp := *matched[i]
+		out = append(out, &cp)
+	}
+
+	return out
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"ozonProject/internal/models"
+	"sync"
+)
+
+type notificationsStore struct {
+	mu          sync.RWMutex
+	byRecipient map[string][]*models.Notification
+}
+
+func newNotificationsStore() *notificationsStore {
+	return &notificationsStore{
+		byRecipient: make(map[string][]*models.Notification),
+	}
+}
+
+func (s *notificationsStore) create(notifications []*models.Notification) []*models.Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*models.Notification, 0, len(notifications))
+	for _, n := range notifications {
+		stored := *n
+		s.byRecipient[n.Recipient] = append(s.byRecipient[n.Recipient], &stored)
+
+		cp := stored
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+// list returns notifications of the recipient newest first.
+func (s *notificationsStore) list(recipient string, unreadOnly bool, limit, offset int) []*models.Notification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.byRecipient[recipient]
+	out := make([]*models.Notification, 0, limit)
+	skipped := 0
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		n := all[i]
+		if unreadOnly && n.Read {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		cp := *n
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *notificationsStore) markRead(recipient string, ids []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
+	}
+
+	marked := 0
+	for _, n := range s.byRecipient[recipient] {
+		if n.Read {
+			continue
+		}
+		if _, ok := wanted[n.ID]; len(ids) > 0 && !ok {
+			continue
+		}
+		n.Read = true
+		marked++
+	}
+
+	return marked
+}
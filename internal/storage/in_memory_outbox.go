@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"ozonProject/internal/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// outboxStore keeps undelivered events in write order, leases are not
+// persisted, so a restart makes every event claimable again.
+type outboxStore struct {
+	mu     sync.Mutex
+	events []*models.OutboxEvent
+	leased map[string]time.Time
+}
+
+func newOutboxStore() *outboxStore {
+	return &outboxStore{leased: make(map[string]time.Time)}
+}
+
+func (s *outboxStore) add(ev *models.OutboxEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+}
+
+func (s *outboxStore) claim(limit int, lease time.Duration, now time.Time) []*models.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*models.OutboxEvent
+	for _, ev := range s.events {
+		if len(out) == limit {
+			break
+		}
+		if until, ok := s.leased[ev.ID]; ok && now.Before(until) {
+			continue
+		}
+		s.leased[ev.ID] = now.Add(lease)
+		ev.Attempts++
+
+		cp := *ev
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *outboxStore) ack(ids []string) {
+	drop := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		drop[id] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.events[:0]
+	for _, ev := range s.events {
+		if _, ok := drop[ev.ID]; ok {
+			delete(s.leased, ev.ID)
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	clear(s.events[len(kept):])
+	s.events = kept
+}
+
+func (s *outboxStore) dump() []*models.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*models.OutboxEvent, 0, len(s.events))
+	for _, ev := range s.events {
+		cp := *ev
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *outboxStore) restore(events []*models.OutboxEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = events
+	s.leased = make(map[string]time.Time)
+}
+
+// newOutboxStub reserves the id and time of an event before the write, the
+// payload is filled by the apply function, so a replayed record yields the
+// same event.
+func newOutboxStub() *models.OutboxEvent {
+	return &models.OutboxEvent{ID: uuid.New().String(), CreatedAt: time.Now().UTC()}
+}
+
+// publish queues an event of the entity, records written before the outbox
+// existed carry no stub.
+func (r *InMemoryStorage) publish(stub *models.OutboxEvent, typ models.OutboxEventType, key string, entity any) error {
+	if stub == nil {
+		return nil
+	}
+
+	ev, err := outboxEvent(stub.ID, typ, key, entity, stub.CreatedAt)
+	if err != nil {
+		return err
+	}
+	r.outbox.add(ev)
+
+	return nil
+}
+
+func (r *InMemoryStorage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	return r.outbox.claim(limit, lease, time.Now()), nil
+}
+
+func (r *InMemoryStorage) AckOutboxEvents(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := commit(r, &walRecord{Op: opAckOutbox, IDs: ids}, func() (struct{}, error) {
+		r.outbox.ack(ids)
+		return struct{}{}, nil
+	})
+
+	return err
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"html"
+	"math"
+	"ozonProject/internal/models"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	snippetWords   = 24
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+
+	// rawHighlightStart and rawHighlightStop delimit matches in ts_headline
+	// output, validated content never contains control characters.
+	rawHighlightStart = "\x02"
+	rawHighlightStop  = "\x03"
+)
+
+// markSnippet escapes a ts_headline snippet and turns its raw delimiters
+// into <mark> tags, so stored content never reaches clients as markup.
+func markSnippet(raw string) string {
+	return strings.NewReplacer(rawHighlightStart, highlightStart, rawHighlightStop, highlightStop).
+		Replace(html.EscapeString(raw))
+}
+
+type docKey struct {
+	kind models.SearchType
+	id   string
+}
+
+type scoredDoc struct {
+	key  docKey
+	rank float64
+}
+
+// searchIndex is an inverted index mirroring the Postgres tsvector columns.
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[docKey]int
+	lengths  map[docKey]int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[docKey]int),
+		lengths:  make(map[docKey]int),
+	}
+}
+
+func (ix *searchIndex) add(key docKey, text string) {
+	terms := tokenize(text)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, term := range terms {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = make(map[docKey]int)
+			ix.postings[term] = docs
+		}
+		docs[key]++
+	}
+	ix.lengths[key] += len(terms)
+}
+
+// search returns documents containing every term, best match first.
+func (ix *searchIndex) search(terms []string, kind models.SearchType) []scoredDoc {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if len(terms) == 0 {
+		return nil
+	}
+
+	total := float64(len(ix.lengths))
+	scores := make(map[docKey]float64)
+	for i, term := range terms {
+		docs := ix.postings[term]
+		idf := math.Log(1 + total/float64(len(docs)+1))
+
+		next := make(map[docKey]float64)
+		for key, freq := range docs {
+			if kind != models.SearchTypeAll && key.kind != kind {
+				continue
+			}
+			if _, ok := scores[key]; i > 0 && !ok {
+				continue
+			}
+			next[key] = scores[key] + float64(freq)/float64(ix.lengths[key])*idf
+		}
+		scores = next
+	}
+
+	out := make([]scoredDoc, 0, len(scores))
+	for key, rank := range scores {
+		out = append(out, scoredDoc{key: key, rank: rank})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].rank != out[j].rank {
+			return out[i].rank > out[j].rank
+		}
+		return out[i].key.id < out[j].key.id
+	})
+
+	return out
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+func uniqueTerms(query string) []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, term := range tokenize(query) {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+		out = append(out, term)
+	}
+	return out
+}
+
+// highlight cuts a window of words around the first match in text and wraps
+// matching words the same way ts_headline does for PostgresStorage. The text
+// is HTML-escaped, only the <mark> tags are markup.
+func highlight(text string, terms []string) string {
+	match := make(map[string]struct{}, len(terms))
+	for _, t := range terms {
+		match[t] = struct{}{}
+	}
+
+	type span struct{ start, end int }
+	var words []span
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			words = append(words, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(text)})
+	}
+
+	if len(words) == 0 {
+		return html.EscapeString(text)
+	}
+
+	first := 0
+	for i, w := range words {
+		if _, ok := match[strings.ToLower(text[w.start:w.end])]; ok {
+			first = i
+			break
+		}
+	}
+
+	from := max(first-snippetWords/4, 0)
+	to := min(from+snippetWords, len(words))
+
+	var b strings.Builder
+	pos := words[from].start
+	if from == 0 {
+		pos = 0
+	} else {
+		b.WriteString("… ")
+	}
+
+	for _, w := range words[from:to] {
+		b.WriteString(html.EscapeString(text[pos:w.start]))
+		word := html.EscapeString(text[w.start:w.end])
+		if _, ok := match[strings.ToLower(text[w.start:w.end])]; ok {
+			b.WriteString(highlightStart + word + highlightStop)
+		} else {
+			b.WriteString(word)
+		}
+		pos = w.end
+	}
+
+	if to < len(words) {
+		b.WriteString(" …")
+	} else {
+		b.WriteString(html.EscapeString(text[pos:]))
+	}
+
+	return b.String()
+}
+
+func containsAny(text string, terms []string) bool {
+	for _, t := range tokenize(text) {
+		for _, term := range terms {
+			if t == term {
+				return true
+			}
+		}
+	}
+	return false
+}
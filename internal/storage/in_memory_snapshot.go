@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"ozonProject/internal/models"
+	"path/filepath"
+)
+
+// inMemorySnapshot is the persisted state of the storage up to the log
+// record LSN.
+type inMemorySnapshot struct {
+	LSN         int64                     `json:"lsn"`
+	Communities []*models.Community       `json:"communities"`
+	Posts       []*models.Post            `json:"posts"`
+	Votes       map[string]map[string]int `json:"votes"`
+	Comments    []*snapshotComment        `json:"comments"`
+	CommentSeq  int64                     `json:"commentSeq"`
+	Outbox      []*models.OutboxEvent     `json:"outbox,omitempty"`
+
+	Reports       []*models.Report          `json:"reports,omitempty"`
+	Bans          []*models.Ban             `json:"bans,omitempty"`
+	Audit         []*models.AuditEntry      `json:"audit,omitempty"`
+	Notifications []*models.Notification    `json:"notifications,omitempty"`
+	Webhooks      []*storedWebhook          `json:"webhooks,omitempty"`
+	Deliveries    []*models.WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+type snapshotComment struct {
+	*models.Comment
+	Path string `json:"path"`
+}
+
+func (s *communitiesStore) dump() []*models.Community {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.Community, 0, len(s.order))
+	for _, name := range s.order {
+		cp := *s.byName[name]
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *communitiesStore) restore(communities []*models.Community) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byName = make(map[string]*models.Community, len(communities))
+	s.order = make([]string, 0, len(communities))
+	for _, c := range communities {
+		s.byName[c.Name] = c
+		s.order = append(s.order, c.Name)
+	}
+}
+
+func (s *postsStore) dump() ([]*models.Post, map[string]map[string]int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	posts := make([]*models.Post, 0, len(s.order))
+	for _, id := range s.order {
+		cp := *s.byID[id]
+		posts = append(posts, &cp)
+	}
+
+	votes := make(map[string]map[string]int, len(s.votes))
+	for id, byVoter := range s.votes {
+		cp := make(map[string]int, len(byVoter))
+		for voter, v := range byVoter {
+			cp[voter] = v
+		}
+		votes[id] = cp
+	}
+
+	return posts, votes
+}
+
+func (s *postsStore) restore(posts []*models.Post, votes map[string]map[string]int) {
+	fresh := newPostsStore()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byID, s.order, s.byTag, s.byCommunity = fresh.byID, fresh.order, fresh.byTag, fresh.byCommunity
+	s.byHot, s.byTop = fresh.byHot, fresh.byTop
+	for _, p := range posts {
+		s.insertLocked(p)
+	}
+
+	s.votes = votes
+	if s.votes == nil {
+		s.votes = make(map[string]map[string]int)
+	}
+}
+
+// dump returns comments post by post in creation order, so restoring them
+// in this order rebuilds reply lists in the same order.
+func (s *commentsStore) dump() ([]*snapshotComment, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*snapshotComment, 0, len(s.byID))
+	for _, ids := range s.byPostRoot {
+		for _, id := range ids {
+			cp := *s.byID[id]
+			out = append(out, &snapshotComment{Comment: &cp, Path: s.paths[id]})
+		}
+	}
+
+	return out, s.seq
+}
+
+func (s *commentsStore) restore(comments []*snapshotComment, seq int64) {
+	fresh := newCommentsStore()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byID, s.byPostRoot, s.byParent, s.paths = fresh.byID, fresh.byPostRoot, fresh.byParent, fresh.paths
+	for _, c := range comments {
+		s.putLocked(c.Comment, c.Path)
+	}
+	s.seq = seq
+}
+
+func (s *reportsStore) dump() []*models.Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.Report, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, copyReport(s.byID[id]))
+	}
+
+	return out
+}
+
+func (s *reportsStore) restore(reports []*models.Report) {
+	fresh := newReportsStore()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byID, s.byKey, s.order = fresh.byID, fresh.byKey, fresh.order
+	for _, r := range reports {
+		s.byID[r.ID] = r
+		s.byKey[reportKey{targetType: r.TargetType, targetID: r.TargetID, reporter: r.Reporter}] = r.ID
+		s.order = append(s.order, r.ID)
+	}
+}
+
+func (s *bansStore) dump() []*models.Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*models.Ban, 0, len(s.bans))
+	for _, b := range s.bans {
+		cp := *b
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *bansStore) restore(bans []*models.Ban) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bans = make(map[banKey]*models.Ban, len(bans))
+	for _, b := range bans {
+		s.bans[banKey{user: b.UserID, scope: b.Scope, scopeID: b.ScopeID}] = b
+	}
+}
+
+func (s *auditStore) dump() []*models.AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.AuditEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		cp := *e
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *auditStore) restore(entries []*models.AuditEntry) {
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
+}
+
+// dump returns notifications recipient by recipient in creation order.
+func (s *notificationsStore) dump() []*models.Notification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []*models.Notification
+	for _, list := range s.byRecipient {
+		for _, n := range list {
+			cp := *n
+			out = append(out, &cp)
+		}
+	}
+
+	return out
+}
+
+func (s *notificationsStore) restore(notifications []*models.Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byRecipient = make(map[string][]*models.Notification)
+	for _, n := range notifications {
+		s.byRecipient[n.Recipient] = append(s.byRecipient[n.Recipient], n)
+	}
+}
+
+func (s *webhooksStore) dump() ([]*storedWebhook, []*models.WebhookDelivery) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := make([]*storedWebhook, 0, len(s.hooks))
+	for _, w := range s.hooks {
+		cp := *w
+		hooks = append(hooks, newStoredWebhook(&cp))
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0, len(s.deliveries))
+	for _, d := range s.deliveries {
+		deliveries = append(deliveries, copyDelivery(d))
+	}
+
+	return hooks, deliveries
+}
+
+// restore rebuilds sent from the deliveries, every queued event has one
+// until its webhook is deleted.
+func (s *webhooksStore) restore(hooks []*storedWebhook, deliveries []*models.WebhookDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = make([]*models.Webhook, 0, len(hooks))
+	for _, w := range hooks {
+		s.hooks = append(s.hooks, w.webhook())
+	}
+
+	s.deliveries = deliveries
+	s.sent = make(map[[2]string]struct{}, len(deliveries))
+	for _, d := range deliveries {
+		s.sent[[2]string{d.WebhookID, d.EventID}] = struct{}{}
+	}
+}
+
+func (r *InMemoryStorage) writeSnapshot(path string, lsn int64) error {
+	snap := inMemorySnapshot{LSN: lsn, Communities: r.communities.dump()}
+	snap.Posts, snap.Votes = r.posts.dump()
+	snap.Comments, snap.CommentSeq = r.comments.dump()
+	snap.Outbox = r.outbox.dump()
+	snap.Reports = r.reports.dump()
+	snap.Bans = r.bans.dump()
+	snap.Audit = r.audit.dump()
+	snap.Notifications = r.notifications.dump()
+	snap.Webhooks, snap.Deliveries = r.webhooks.dump()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(&snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// loadSnapshot restores the state from path and returns the LSN it covers,
+// a missing snapshot leaves the storage empty.
+func (r *InMemoryStorage) loadSnapshot(path string) (int64, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snap inMemorySnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return 0, err
+	}
+
+	r.communities.restore(snap.Communities)
+	r.posts.restore(snap.Posts, snap.Votes)
+	r.comments.restore(snap.Comments, snap.CommentSeq)
+	r.outbox.restore(snap.Outbox)
+	r.reports.restore(snap.Reports)
+	r.bans.restore(snap.Bans)
+	r.audit.restore(snap.Audit)
+	r.notifications.restore(snap.Notifications)
+	r.webhooks.restore(snap.Webhooks, snap.Deliveries)
+
+	for _, p := range snap.Posts {
+		r.search.add(docKey{kind: models.SearchTypePost, id: p.ID}, p.Title+" "+p.Content)
+	}
+	for _, c := range snap.Comments {
+		if !c.Pending {
+			r.search.add(docKey{kind: models.SearchTypeComment, id: c.ID}, c.Content)
+		}
+	}
+
+	return snap.LSN, nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -3,9 +3,14 @@ package storage_test
 import (
 	"context"
 	"errors"
+	"os"
 	"ozonProject/internal/models"
 	"ozonProject/internal/service"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/validation"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -14,16 +19,31 @@ type mockStore struct {
 	commentsEnabled bool
 }
 
-func (f *mockStore) CreatePost(ctx context.Context, title, content, author string, ce bool) (*models.Post, error) {
-	return &models.Post{ID: "1", Title: title, Content: content, Author: author, CommentsEnabled: ce}, nil
+func (f *mockStore) CreatePost(ctx context.Context, community, title, content, author string, ce bool, tags []string) (*models.Post, error) {
+	return &models.Post{ID: "1", Title: title, Content: content, Author: author, CommentsEnabled: ce, Tags: tags, CommunityName: community}, nil
 }
-func (f *mockStore) GetPosts(ctx context.Context, limit, offset int) ([]*models.Post, error) {
+func (f *mockStore) GetPosts(ctx context.Context, limit, offset int, filter storage.PostFilter) ([]*models.Post, error) {
 	return []*models.Post{{ID: "1", Title: "t"}}, nil
 }
 func (f *mockStore) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
 	return &models.Post{ID: id, CommentsEnabled: f.commentsEnabled}, nil
 }
-func (f *mockStore) CreateComment(ctx context.Context, postID string, parentID string, author, content string) (*models.Comment, error) {
+func (f *mockStore) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	return &models.Post{ID: postID, Score: value}, nil
+}
+func (f *mockStore) GetTags(ctx context.Context, limit int) ([]*models.Tag, error) {
+	return []*models.Tag{}, nil
+}
+func (f *mockStore) CreateCommunity(ctx context.Context, c *models.Community) (*models.Community, error) {
+	return c, nil
+}
+func (f *mockStore) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	return &models.Community{Name: name, CommentsEnabled: true, MaxCommentLength: 2000}, nil
+}
+func (f *mockStore) GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error) {
+	return []*models.Community{}, nil
+}
+func (f *mockStore) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
 	return &models.Comment{ID: "10", PostID: postID, ParentID: &parentID, Author: author, Content: content}, nil
 }
 func (f *mockStore) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
@@ -36,6 +56,127 @@ func (f *mockStore) EnsureCommentsEnabled(ctx context.Context, postID string) er
 	return nil
 }
 
+func (f *mockStore) ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error) {
+	return nil, nil
+}
+func (f *mockStore) CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	return nil
+}
+func (f *mockStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return nil
+}
+func (f *mockStore) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	return 0, nil
+}
+func (f *mockStore) Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error) {
+	return []*models.SearchResult{}, nil
+}
+func (f *mockStore) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	return &models.Comment{ID: id, Author: storage.DeletedPlaceholder, Content: storage.DeletedPlaceholder, Deleted: true}, nil
+}
+func (f *mockStore) ReconcileCounters(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+func (f *mockStore) ImportPost(ctx context.Context, post *models.Post) error {
+	return nil
+}
+func (f *mockStore) ImportComment(ctx context.Context, comment *models.Comment) error {
+	return nil
+}
+func (f *mockStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	return nil, nil
+}
+func (f *mockStore) AckOutboxEvents(ctx context.Context, ids []string) error {
+	return nil
+}
+func (f *mockStore) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	return webhook, nil
+}
+func (f *mockStore) GetWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return nil, nil
+}
+func (f *mockStore) DeleteWebhook(ctx context.Context, id string) error {
+	return nil
+}
+func (f *mockStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	return nil
+}
+func (f *mockStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	return nil, nil
+}
+func (f *mockStore) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return nil
+}
+func (f *mockStore) GetWebhookDeliveries(ctx context.Context, filter storage.DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error) {
+	return []*models.WebhookDelivery{}, nil
+}
+func (f *mockStore) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	return nil, storage.ErrDeliveryNotFound
+}
+func (f *mockStore) CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error) {
+	return nil, nil
+}
+func (f *mockStore) CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error) {
+	return notifications, nil
+}
+func (f *mockStore) GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error) {
+	return []*models.Notification{}, nil
+}
+func (f *mockStore) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	return 0, nil
+}
+func (f *mockStore) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	return nil
+}
+func (f *mockStore) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	return &models.Post{ID: id, Deleted: true}, nil
+}
+func (f *mockStore) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	return report, nil
+}
+func (f *mockStore) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	return nil, storage.ErrReportNotFound
+}
+func (f *mockStore) GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error) {
+	return []*models.Report{}, nil
+}
+func (f *mockStore) ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	return nil, storage.ErrReportNotFound
+}
+func (f *mockStore) ReopenReport(ctx context.Context, reportID, decisionID string) error {
+	return nil
+}
+func (f *mockStore) BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error) {
+	return ban, nil
+}
+func (f *mockStore) ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error) {
+	return nil, nil
+}
+func (f *mockStore) GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error) {
+	return []*models.Ban{}, nil
+}
+func (f *mockStore) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	return nil, storage.ErrCommentNotFound
+}
+func (f *mockStore) GetCommentByID(ctx context.Context, id string) (*models.Comment, error) {
+	return nil, storage.ErrCommentNotFound
+}
+func (f *mockStore) AppendAudit(ctx context.Context, entry *models.AuditEntry) error {
+	return nil
+}
+func (f *mockStore) GetAuditLog(ctx context.Context, filter storage.AuditFilter, limit, offset int) ([]*models.AuditEntry, error) {
+	return []*models.AuditEntry{}, nil
+}
+func (f *mockStore) GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	return []*models.Comment{}, nil
+}
+func (f *mockStore) GetAncestors(ctx context.Context, id string) ([]*models.Comment, error) {
+	return []*models.Comment{}, nil
+}
+func (f *mockStore) CountDescendants(ctx context.Context, id string) (int, error) {
+	return 0, nil
+}
+
 func TestCreateComment_TooLong(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
@@ -43,7 +184,7 @@ func TestCreateComment_TooLong(t *testing.T) {
 	for i := range body {
 		body[i] = 'a'
 	}
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", string(body))
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", string(body), nil)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "content too long")
 }
@@ -51,7 +192,7 @@ func TestCreateComment_TooLong(t *testing.T) {
 func TestCreateComment_Empty(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", "")
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", "", nil)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "content is empty")
 }
@@ -59,7 +200,7 @@ func TestCreateComment_Empty(t *testing.T) {
 func TestCreateComment_Disabled(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: false})
-	_, err := s.CreateComment(context.Background(), "1", nil, "me", "ok")
+	_, err := s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
 	require.Error(t, err)
 	require.Equal(t, "comments are disabled for this post", err.Error())
 }
@@ -67,7 +208,7 @@ func TestCreateComment_Disabled(t *testing.T) {
 func TestCreateComment_Ok(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	c, err := s.CreateComment(context.Background(), "1", nil, "me", "ok")
+	c, err := s.CreateComment(context.Background(), "1", nil, "me", "ok", nil)
 	require.NoError(t, err)
 	require.Equal(t, "10", c.ID)
 	require.Equal(t, "1", c.PostID)
@@ -78,9 +219,561 @@ func TestCreateComment_Ok(t *testing.T) {
 func TestListPosts_Defaults(t *testing.T) {
 	t.Parallel()
 	s := service.New(&mockStore{commentsEnabled: true})
-	limit := 0
-	offset := -5
-	posts, err := s.ListPosts(context.Background(), &limit, &offset)
+	posts, err := s.ListPosts(context.Background(), nil, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	require.Len(t, posts, 1)
 }
+
+func TestInMemorySearch_RanksAndHighlights(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	p1, err := repo.CreatePost(ctx, "general", "Go generics", "Generics in Go make containers easy", "alice", true, nil)
+	require.NoError(t, err)
+	_, err = repo.CreatePost(ctx, "general", "Rust", "Ownership and borrowing", "bob", true, nil)
+	require.NoError(t, err)
+	c, err := repo.CreateComment(ctx, p1.ID, "", "bob", "I prefer Go without generics", false)
+	require.NoError(t, err)
+
+	res, err := repo.Search(ctx, "generics", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, models.SearchTypePost, res[0].Type)
+	require.Equal(t, p1.ID, res[0].Post.ID)
+	require.Contains(t, res[0].Snippet, "<mark>Generics</mark>")
+
+	res, err = repo.Search(ctx, "go generics", models.SearchTypeComment, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, c.ID, res[0].Comment.ID)
+	require.Equal(t, "I prefer <mark>Go</mark> without <mark>generics</mark>", res[0].Snippet)
+
+	res, err = repo.Search(ctx, "generics ownership", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, res)
+}
+
+func TestSearch_Pagination(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.CreatePost(ctx, "general", "news", "daily news", "alice", nil, nil, nil)
+		require.NoError(t, err)
+	}
+
+	limit := 2
+	page, err := s.Search(ctx, "news", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 2)
+	require.True(t, page.HasNextPage)
+
+	page, err = s.Search(ctx, "news", nil, &limit, page.EndCursor)
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	require.False(t, page.HasNextPage)
+
+	for _, limit := range []int{0, -1, -2, validation.MaxPageSize + 1} {
+		_, err = s.Search(ctx, "news", nil, &limit, nil)
+		require.ErrorIs(t, err, validation.ErrInvalidLimit)
+	}
+}
+
+func TestInMemoryTags_FilterAndCounts(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	first, err := s.CreatePost(ctx, "general", "a", "a", "alice", nil, []string{"Go", " go ", "news"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"go", "news"}, first.Tags)
+
+	_, err = s.CreatePost(ctx, "general", "b", "b", "alice", nil, []string{"go"}, nil)
+	require.NoError(t, err)
+	_, err = s.CreatePost(ctx, "general", "c", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+
+	limit, offset, tag := 10, 0, "GO"
+	posts, err := s.ListPosts(ctx, &limit, &offset, &tag, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	require.Equal(t, "b", posts[0].Title)
+
+	tags, err := s.ListTags(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Tag{{Name: "go", PostCount: 2}, {Name: "news", PostCount: 1}}, tags)
+
+	_, err = s.CreatePost(ctx, "general", "d", "d", "alice", nil, []string{"no spaces"}, nil)
+	require.ErrorIs(t, err, validation.ErrInvalidTag)
+}
+
+func TestCommunity_DefaultsAndPosts(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	disabled, maxLen := false, 5
+	c, err := s.CreateCommunity(ctx, "GoLang", nil, []string{"be nice"}, "alice", []string{"bob", "alice"}, &disabled, &maxLen)
+	require.NoError(t, err)
+	require.Equal(t, "golang", c.Name)
+	require.Equal(t, []string{"alice", "bob"}, c.Moderators)
+
+	_, err = s.CreateCommunity(ctx, "golang", nil, nil, "carol", nil, nil, nil)
+	require.ErrorIs(t, err, storage.ErrCommunityExists)
+
+	p, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	require.False(t, p.CommentsEnabled)
+	require.Equal(t, "golang", p.CommunityName)
+
+	enabled := true
+	p, err = s.CreatePost(ctx, "golang", "t2", "c", "alice", &enabled, nil, nil)
+	require.NoError(t, err)
+
+	_, err = s.CreateComment(ctx, p.ID, nil, "bob", "too long", nil)
+	require.ErrorIs(t, err, validation.ErrTooLong)
+
+	_, err = s.CreateComment(ctx, p.ID, nil, "bob", "ok", nil)
+	require.NoError(t, err)
+
+	_, err = s.CreatePost(ctx, "general", "other", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+
+	limit, community := 10, "golang"
+	posts, err := s.ListPosts(ctx, &limit, nil, nil, &community, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+
+	_, err = s.CreatePost(ctx, "missing", "t", "c", "alice", nil, nil, nil)
+	require.ErrorIs(t, err, storage.ErrCommunityNotFound)
+}
+
+func TestInMemoryPosts_Sorting(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	older, err := repo.CreatePost(ctx, "general", "older", "c", "alice", true, nil)
+	require.NoError(t, err)
+	newer, err := repo.CreatePost(ctx, "general", "newer", "c", "alice", true, nil)
+	require.NoError(t, err)
+
+	for _, voter := range []string{"a", "b", "c"} {
+		_, err := repo.VotePost(ctx, older.ID, voter, 1)
+		require.NoError(t, err)
+	}
+	p, err := repo.VotePost(ctx, newer.ID, "a", 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Score)
+
+	p, err = repo.VotePost(ctx, older.ID, "a", -1)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Score)
+
+	titles := func(sort models.PostSort) []string {
+		posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: sort})
+		require.NoError(t, err)
+		var out []string
+		for _, p := range posts {
+			out = append(out, p.Title)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{"newer", "older"}, titles(models.PostSortNew))
+	require.Equal(t, []string{"newer", "older"}, titles(models.PostSortTop))
+
+	_, err = repo.VotePost(ctx, older.ID, "a", 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"older", "newer"}, titles(models.PostSortTop))
+	require.Equal(t, []string{"older", "newer"}, titles(models.PostSortHot))
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: models.PostSortTop, Since: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	_, err = repo.VotePost(ctx, "missing", "a", 1)
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+}
+
+func TestInMemoryComments_Counters(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, "general", "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	root, err := repo.CreateComment(ctx, p.ID, "", "bob", "root", false)
+	require.NoError(t, err)
+	reply, err := repo.CreateComment(ctx, p.ID, root.ID, "carol", "reply", false)
+	require.NoError(t, err)
+	_, err = repo.CreateComment(ctx, p.ID, root.ID, "dave", "reply", false)
+	require.NoError(t, err)
+
+	p, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.CommentCount)
+
+	comments, err := repo.GetComments(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, comments[0].ReplyCount)
+
+	_, err = repo.DeleteComment(ctx, reply.ID, "bob")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	deleted, err := repo.DeleteComment(ctx, reply.ID, "carol")
+	require.NoError(t, err)
+	require.True(t, deleted.Deleted)
+	require.Equal(t, storage.DeletedPlaceholder, deleted.Author)
+
+	p, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, p.CommentCount)
+
+	fixed, err := repo.ReconcileCounters(ctx)
+	require.NoError(t, err)
+	require.Zero(t, fixed)
+}
+
+func TestModeration_ReportAndResolve(t *testing.T) {
+	t.Parallel()
+	s := service.New(storage.NewInMemoryStorage())
+	ctx := context.Background()
+
+	_, err := s.CreateCommunity(ctx, "golang", nil, nil, "mod", nil, nil, nil)
+	require.NoError(t, err)
+	p, err := s.CreatePost(ctx, "golang", "t", "c", "alice", nil, nil, nil)
+	require.NoError(t, err)
+	c, err := s.CreateComment(ctx, p.ID, nil, "troll", "spam", nil)
+	require.NoError(t, err)
+
+	report, err := s.ReportContent(ctx, models.ReportTargetTypeComment, c.ID, "spam", "alice")
+	require.NoError(t, err)
+	require.Equal(t, "troll", report.TargetAuthor)
+	require.Equal(t, "golang", report.Community)
+
+	again, err := s.ReportContent(ctx, models.ReportTargetTypeComment, c.ID, "still spam", "alice")
+	require.NoError(t, err)
+	require.Equal(t, report.ID, again.ID)
+
+	_, err = s.ModerationQueue(ctx, "golang", "alice", nil, nil, nil)
+	require.ErrorIs(t, err, validation.ErrNotModerator)
+
+	limit := 10
+	queue, err := s.ModerationQueue(ctx, "golang", "mod", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	_, err = s.ResolveReport(ctx, report.ID, "alice", models.ModerationActionBan, nil)
+	require.ErrorIs(t, err, validation.ErrNotModerator)
+
+	note := "repeat offender"
+	resolved, err := s.ResolveReport(ctx, report.ID, "mod", models.ModerationActionBan, &note)
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusActioned, resolved.Status)
+	require.Len(t, resolved.Decisions, 1)
+	require.Equal(t, note, resolved.Decisions[0].Note)
+
+	_, err = s.ResolveReport(ctx, report.ID, "mod", models.ModerationActionBan, &note)
+	require.ErrorIs(t, err, storage.ErrReportResolved, "a report is resolved once")
+
+	comments, err := s.ListComments(ctx, p.ID, nil, &limit, nil)
+	require.NoError(t, err)
+	require.True(t, comments[0].Deleted)
+
+	_, err = s.CreateComment(ctx, p.ID, nil, "troll", "again", nil)
+	require.ErrorIs(t, err, validation.ErrBanned)
+
+	queue, err = s.ModerationQueue(ctx, "golang", "mod", nil, &limit, nil)
+	require.NoError(t, err)
+	require.Empty(t, queue)
+}
+
+func TestModeration_HideRemovesFromFeed(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, "general", "hidden", "c", "alice", true, nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, p.ID))
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	p, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.True(t, p.Hidden)
+}
+
+func TestInMemoryBans_ExpiredAreLifted(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Second)
+	_, err := repo.BanUser(ctx, &models.Ban{UserID: "troll", Scope: models.BanScopeCommunity, ScopeID: "golang", Until: &past})
+	require.NoError(t, err)
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "spammer", Scope: models.BanScopeCommunity, ScopeID: "golang"})
+	require.NoError(t, err)
+
+	ban, err := repo.ActiveBan(ctx, "troll", "golang", "")
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	ban, err = repo.ActiveBan(ctx, "spammer", "golang", "42")
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+
+	bans, err := repo.GetBans(ctx, models.BanScopeCommunity, "golang", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	require.Equal(t, "spammer", bans[0].UserID)
+}
+
+func TestInMemoryComments_ThreadOrder(t *testing.T) {
+	t.Parallel()
+	repo := storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	a, err := repo.CreateComment(ctx, p.ID, "", "bob", "a", false)
+	require.NoError(t, err)
+	b, err := repo.CreateComment(ctx, p.ID, "", "bob", "b", false)
+	require.NoError(t, err)
+	a1, err := repo.CreateComment(ctx, p.ID, a.ID, "carol", "a1", false)
+	require.NoError(t, err)
+	b1, err := repo.CreateComment(ctx, p.ID, b.ID, "carol", "b1", false)
+	require.NoError(t, err)
+	a11, err := repo.CreateComment(ctx, p.ID, a1.ID, "dave", "a11", false)
+	require.NoError(t, err)
+	_, err = repo.CreateComment(ctx, p.ID, a.ID, "dave", "held", true)
+	require.NoError(t, err)
+
+	ids := func(cs []*models.Comment) []string {
+		out := make([]string, 0, len(cs))
+		for _, c := range cs {
+			out = append(out, c.ID)
+		}
+		return out
+	}
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{a.ID, a1.ID, a11.ID, b.ID, b1.ID}, ids(thread))
+
+	sub, err := repo.GetSubtree(ctx, p.ID, a.ID, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{a1.ID, a11.ID}, ids(sub))
+
+	ancestors, err := repo.GetAncestors(ctx, a11.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{a.ID, a1.ID}, ids(ancestors))
+
+	n, err := repo.CountDescendants(ctx, a.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
+
+func TestInMemoryPersistence_RejectsUnknownFsync(t *testing.T) {
+	t.Parallel()
+
+	_, err := storage.OpenInMemoryStorage(storage.PersistenceConfig{Dir: t.TempDir(), Fsync: "sometimes"})
+	require.ErrorContains(t, err, `unknown fsync policy "sometimes"`)
+}
+
+func TestInMemoryPersistence_ReplayAndCompact(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ctx := context.Background()
+	cfg := storage.PersistenceConfig{Dir: dir, Fsync: storage.FsyncAlways}
+
+	repo, err := storage.OpenInMemoryStorage(cfg)
+	require.NoError(t, err)
+	_, err = repo.CreateCommunity(ctx, &models.Community{Name: "golang", CommentsEnabled: true})
+	require.NoError(t, err)
+	p, err := repo.CreatePost(ctx, "golang", "Generics", "go 1.18", "alice", true, []string{"go"})
+	require.NoError(t, err)
+	root, err := repo.CreateComment(ctx, p.ID, "", "bob", "root", false)
+	require.NoError(t, err)
+	_, err = repo.VotePost(ctx, p.ID, "bob", 1)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Compact())
+
+	delivered, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, delivered, 2)
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{delivered[0].ID, delivered[1].ID}))
+
+	reply, err := repo.CreateComment(ctx, p.ID, root.ID, "carol", "reply", false)
+	require.NoError(t, err)
+	_, err = repo.DeleteComment(ctx, root.ID, "bob")
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"lsn":99,"op":"createPo`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	repo, err = storage.OpenInMemoryStorage(cfg)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, got.Score)
+	require.Equal(t, 1, got.CommentCount)
+	require.Equal(t, "golang", got.CommunityName)
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, thread, 2)
+	require.True(t, thread[0].Deleted)
+	require.Equal(t, reply.ID, thread[1].ID)
+	require.Equal(t, 1, thread[1].Depth)
+
+	hits, err := repo.Search(ctx, "generics", models.SearchTypePost, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+
+	pending, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, pending, 2, "acknowledged events stay removed after replay")
+	require.Contains(t, string(pending[0].Payload), reply.ID)
+	require.Equal(t, models.OutboxCommentDeleted, pending[1].Type)
+	require.Contains(t, string(pending[1].Payload), root.ID)
+
+	next, err := repo.CreateComment(ctx, p.ID, reply.ID, "dave", "next", false)
+	require.NoError(t, err)
+	ancestors, err := repo.GetAncestors(ctx, next.ID)
+	require.NoError(t, err)
+	require.Len(t, ancestors, 2)
+}
+
+func TestInMemoryPersistence_ModerationAuditAndWebhooks(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ctx := context.Background()
+	cfg := storage.PersistenceConfig{Dir: dir, Fsync: storage.FsyncAlways}
+
+	repo, err := storage.OpenInMemoryStorage(cfg)
+	require.NoError(t, err)
+	_, err = repo.CreateCommunity(ctx, &models.Community{Name: "golang", CommentsEnabled: true})
+	require.NoError(t, err)
+	p, err := repo.CreatePost(ctx, "golang", "Generics", "go 1.18", "alice", true, nil)
+	require.NoError(t, err)
+	c, err := repo.CreateComment(ctx, p.ID, "", "bob", "spam", false)
+	require.NoError(t, err)
+
+	dismissed, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: p.ID, Reporter: "carol", Reason: "off-topic"})
+	require.NoError(t, err)
+	_, err = repo.ResolveReport(ctx, models.ReportStatusDismissed, &models.ModerationDecision{ReportID: dismissed.ID, Moderator: "mod", Action: models.ModerationActionDismiss})
+	require.NoError(t, err)
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "troll", Scope: models.BanScopeGlobal, Reason: "spam", CreatedBy: "root"})
+	require.NoError(t, err)
+	require.NoError(t, repo.AppendAudit(ctx, &models.AuditEntry{Actor: "root", Action: "user.ban", TargetType: "user", TargetID: "troll"}))
+	hook, err := repo.CreateWebhook(ctx, &models.Webhook{URL: "https://search.test/hook", Secret: "0123456789abcdef", Events: []models.WebhookEvent{models.WebhookEventPostCreated}, CreatedBy: "root"})
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Compact())
+
+	reopened, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: c.ID, Reporter: "carol", Reason: "spam"})
+	require.NoError(t, err)
+	decision := &models.ModerationDecision{ReportID: reopened.ID, Moderator: "mod", Action: models.ModerationActionHide}
+	_, err = repo.ResolveReport(ctx, models.ReportStatusActioned, decision)
+	require.NoError(t, err)
+	require.NoError(t, repo.ReopenReport(ctx, reopened.ID, decision.ID))
+
+	reply, err := repo.CreateComment(ctx, p.ID, c.ID, "dave", "reply", false)
+	require.NoError(t, err)
+	n, err := repo.CreateReplyNotification(ctx, reply)
+	require.NoError(t, err)
+	_, err = repo.CreateNotifications(ctx, []*models.Notification{{Recipient: "bob", Type: models.NotificationTypeMention, Actor: "dave", PostID: p.ID, CommentID: reply.ID}})
+	require.NoError(t, err)
+	marked, err := repo.MarkNotificationsRead(ctx, "bob", []string{n.ID})
+	require.NoError(t, err)
+	require.Equal(t, 1, marked)
+
+	require.NoError(t, repo.EnqueueWebhookDeliveries(ctx, []*models.WebhookDelivery{
+		{ID: "d1", WebhookID: hook.ID, EventID: "e1", Event: models.WebhookEventPostCreated, Payload: "{}"},
+		{ID: "d2", WebhookID: hook.ID, EventID: "e2", Event: models.WebhookEventPostCreated, Payload: "{}"},
+	}))
+	claimed, err := repo.ClaimWebhookDeliveries(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	claimed[0].Status, claimed[0].Attempts = models.WebhookDeliveryStatusDead, 8
+	require.NoError(t, repo.UpdateWebhookDelivery(ctx, claimed[0]))
+	_, err = repo.RetryWebhookDelivery(ctx, claimed[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	repo, err = storage.OpenInMemoryStorage(cfg)
+	require.NoError(t, err)
+
+	got, err := repo.GetReport(ctx, dismissed.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusDismissed, got.Status)
+	require.Len(t, got.Decisions, 1)
+	got, err = repo.GetReport(ctx, reopened.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusOpen, got.Status)
+	require.Empty(t, got.Decisions)
+	again, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: c.ID, Reporter: "carol", Reason: "spam"})
+	require.NoError(t, err)
+	require.Equal(t, reopened.ID, again.ID, "a repeated report is still deduplicated")
+
+	ban, err := repo.ActiveBan(ctx, "troll", "", "")
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+
+	entries, err := repo.GetAuditLog(ctx, storage.AuditFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "user.ban", entries[0].Action)
+
+	notifications, err := repo.GetNotifications(ctx, "bob", false, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	require.Equal(t, models.NotificationTypeMention, notifications[0].Type)
+	require.True(t, notifications[1].Read)
+
+	hooks, err := repo.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+	require.Equal(t, "0123456789abcdef", hooks[0].Secret, "the secret survives a restart")
+
+	deliveries, err := repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	for _, d := range deliveries {
+		require.Equal(t, models.WebhookDeliveryStatusPending, d.Status)
+		require.Zero(t, d.Attempts)
+	}
+	require.NoError(t, repo.EnqueueWebhookDeliveries(ctx, []*models.WebhookDelivery{{ID: "d3", WebhookID: hook.ID, EventID: "e1", Event: models.WebhookEventPostCreated}}))
+	deliveries, err = repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2, "an event is not queued twice for a webhook")
+
+	require.NoError(t, repo.Compact())
+	require.NoError(t, repo.Close())
+	repo, err = storage.OpenInMemoryStorage(cfg)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	hooks, err = repo.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789abcdef", hooks[0].Secret, "the secret survives a snapshot")
+	notifications, err = repo.GetNotifications(ctx, "bob", true, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	entries, err = repo.GetAuditLog(ctx, storage.AuditFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"ozonProject/internal/models"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy controls when appended log records are flushed to disk.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways flushes every record before the write returns.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval flushes in the background, a crash loses at most one interval.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+)
+
+var errStorageClosed = errors.New("storage is closed")
+
+// PersistenceConfig enables the write-ahead log and snapshots of InMemoryStorage.
+type PersistenceConfig struct {
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// SnapshotInterval writes a snapshot and truncates the log periodically, zero disables it.
+	SnapshotInterval time.Duration
+	// CompactAfterBytes triggers a snapshot once the log grows past it, zero disables it.
+	CompactAfterBytes int64
+}
+
+type walOp string
+
+const (
+	opCreateCommunity   walOp = "createCommunity"
+	opCreatePost        walOp = "createPost"
+	opVotePost          walOp = "votePost"
+	opCreateComment     walOp = "createComment"
+	opApproveComment    walOp = "approveComment"
+	opDeleteComment     walOp = "deleteComment"
+	opHideContent       walOp = "hideContent"
+	opDeletePost        walOp = "deletePost"
+	opReconcileCounters walOp = "reconcileCounters"
+	opImportPost        walOp = "importPost"
+	opImportComment     walOp = "importComment"
+	opAckOutbox         walOp = "ackOutbox"
+
+	opCreateReport          walOp = "createReport"
+	opResolveReport         walOp = "resolveReport"
+	opReopenReport          walOp = "reopenReport"
+	opBanUser               walOp = "banUser"
+	opAppendAudit           walOp = "appendAudit"
+	opCreateNotifications   walOp = "createNotifications"
+	opMarkNotificationsRead walOp = "markNotificationsRead"
+	opCreateWebhook         walOp = "createWebhook"
+	opDeleteWebhook         walOp = "deleteWebhook"
+	opEnqueueDeliveries     walOp = "enqueueDeliveries"
+	opUpdateDelivery        walOp = "updateDelivery"
+	opRetryDelivery         walOp = "retryDelivery"
+)
+
+// walRecord is one change of the storage. Generated ids and timestamps are
+// part of the record, so replaying it is deterministic.
+type walRecord struct {
+	LSN       int64                   `json:"lsn"`
+	Op        walOp                   `json:"op"`
+	Community *models.Community       `json:"community,omitempty"`
+	Post      *models.Post            `json:"post,omitempty"`
+	Comment   *models.Comment         `json:"comment,omitempty"`
+	ID        string                  `json:"id,omitempty"`
+	Actor     string                  `json:"actor,omitempty"`
+	Value     int                     `json:"value,omitempty"`
+	Target    models.ReportTargetType `json:"target,omitempty"`
+	IDs       []string                `json:"ids,omitempty"`
+	// Event reserves the id and time of the outbox event the change emits.
+	Event *models.OutboxEvent `json:"event,omitempty"`
+
+	Report        *models.Report             `json:"report,omitempty"`
+	Decision      *models.ModerationDecision `json:"decision,omitempty"`
+	Status        models.ReportStatus        `json:"status,omitempty"`
+	Ban           *models.Ban                `json:"ban,omitempty"`
+	Audit         *models.AuditEntry         `json:"audit,omitempty"`
+	Notifications []*models.Notification     `json:"notifications,omitempty"`
+	Webhook       *storedWebhook             `json:"webhook,omitempty"`
+	Deliveries    []*models.WebhookDelivery  `json:"deliveries,omitempty"`
+	// Time is when deliveries were queued or a dead one was retried.
+	Time time.Time `json:"time,omitzero"`
+}
+
+type walLog struct {
+	mu     sync.Mutex
+	cfg    PersistenceConfig
+	f      *os.File
+	lsn    int64
+	size   int64
+	dirty  bool
+	closed bool
+
+	compact chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (w *walLog) append(rec *walRecord) error {
+	w.lsn++
+	rec.LSN = w.lsn
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	n, err := w.f.Write(append(line, '\n'))
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("append wal: %w", err)
+	}
+
+	if w.cfg.Fsync == FsyncAlways {
+		if err := w.f.Sync(); err != nil {
+			return fmt.Errorf("sync wal: %w", err)
+		}
+	} else {
+		w.dirty = true
+	}
+
+	if w.cfg.CompactAfterBytes > 0 && w.size >= w.cfg.CompactAfterBytes {
+		select {
+		case w.compact <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// commit applies a change and, with persistence on, appends its record under
+// one lock, so the log order matches the order changes became visible. Only
+// changes that succeed are logged, so a change is visible before its record
+// is written and a failed append stops the process: serving a change the log
+// does not have would lose it on restart.
+func commit[T any](r *InMemoryStorage, rec *walRecord, apply func() (T, error)) (T, error) {
+	if r.wal == nil {
+		return apply()
+	}
+
+	r.wal.mu.Lock()
+	defer r.wal.mu.Unlock()
+
+	if r.wal.closed {
+		var zero T
+		return zero, errStorageClosed
+	}
+
+	res, err := apply()
+	if err != nil {
+		return res, err
+	}
+
+	if err := r.wal.append(rec); err != nil {
+		log.Fatalf("wal: %v", err)
+	}
+
+	return res, nil
+}
+
+func (r *InMemoryStorage) replay(rec *walRecord) error {
+	var err error
+	switch rec.Op {
+	case opCreateCommunity:
+		_, err = r.communities.create(rec.Community)
+	case opCreatePost:
+		_, err = r.applyCreatePost(rec.Post, rec.Event)
+	case opVotePost:
+		_, err = r.posts.vote(rec.ID, rec.Actor, rec.Value)
+	case opCreateComment:
+		_, err = r.applyCreateComment(rec.Comment, rec.Event)
+	case opApproveComment:
+		_, err = r.applyApproveComment(rec.ID, rec.Event)
+	case opDeleteComment:
+		_, err = r.applyDeleteComment(rec.ID, rec.Actor, rec.Event)
+	case opHideContent:
+		err = r.applyHideContent(rec.Target, rec.ID, rec.Event)
+	case opDeletePost:
+		_, err = r.applyDeletePost(rec.ID, rec.Actor, rec.Event)
+	case opReconcileCounters:
+		r.applyReconcileCounters()
+	case opImportPost:
+		_, err = r.applyImportPost(rec.Post)
+	case opImportComment:
+		_, err = r.applyImportComment(rec.Comment)
+	case opAckOutbox:
+		r.outbox.ack(rec.IDs)
+	case opCreateReport:
+		_, err = r.applyCreateReport(rec.Report)
+	case opResolveReport:
+		_, err = r.reports.resolve(rec.Status, rec.Decision)
+	case opReopenReport:
+		r.reports.reopen(rec.ID, rec.IDs[0])
+	case opBanUser:
+		r.bans.ban(rec.Ban)
+	case opAppendAudit:
+		r.audit.append(rec.Audit)
+	case opCreateNotifications:
+		r.notifications.create(rec.Notifications)
+	case opMarkNotificationsRead:
+		r.notifications.markRead(rec.Actor, rec.IDs)
+	case opCreateWebhook:
+		r.webhooks.create(rec.Webhook.webhook())
+	case opDeleteWebhook:
+		err = r.webhooks.delete(rec.ID)
+	case opEnqueueDeliveries:
+		r.webhooks.enqueue(rec.Deliveries, rec.Time)
+	case opUpdateDelivery:
+		err = r.webhooks.update(rec.Deliveries[0])
+	case opRetryDelivery:
+		_, err = r.webhooks.retry(rec.ID, rec.Time)
+	default:
+		err = fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	if err != nil {
+		return fmt.Errorf("replay wal record %d: %w", rec.LSN, err)
+	}
+
+	return nil
+}
+
+// OpenInMemoryStorage restores the state from the latest snapshot and the log
+// in cfg.Dir and keeps logging changes there. Idempotency keys and the leases
+// of outbox events and webhook deliveries are not persisted.
+func OpenInMemoryStorage(cfg PersistenceConfig) (*InMemoryStorage, error) {
+	switch cfg.Fsync {
+	case "":
+		cfg.Fsync = FsyncAlways
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q, expected always, interval or never", cfg.Fsync)
+	}
+	if cfg.FsyncInterval <= 0 {
+		cfg.FsyncInterval = time.Second
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	r := NewInMemoryStorage()
+
+	lsn, err := r.loadSnapshot(filepath.Join(cfg.Dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(cfg.Dir, walFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	lsn, size, err := r.replayLog(f, lsn)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r.wal = &walLog{
+		cfg:     cfg,
+		f:       f,
+		lsn:     lsn,
+		size:    size,
+		compact: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	r.startBackground()
+
+	return r, nil
+}
+
+// replayLog applies records newer than the snapshot and leaves the file
+// positioned for appends. A record torn by a crash at the end of the log is
+// cut off, damage in the middle is an error.
+func (r *InMemoryStorage) replayLog(f *os.File, lsn int64) (int64, int64, error) {
+	reader := bufio.NewReader(f)
+
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("wal: dropping torn record at offset %d", offset)
+			}
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return 0, 0, fmt.Errorf("corrupt wal record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+
+		if rec.LSN <= lsn {
+			continue
+		}
+		if err := r.replay(&rec); err != nil {
+			return 0, 0, err
+		}
+		lsn = rec.LSN
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return 0, 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	return lsn, offset, nil
+}
+
+func (r *InMemoryStorage) startBackground() {
+	w := r.wal
+
+	if w.cfg.Fsync == FsyncInterval {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			ticker := time.NewTicker(w.cfg.FsyncInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-w.done:
+					return
+				case <-ticker.C:
+					if err := r.syncLog(); err != nil {
+						log.Printf("wal: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		var tick <-chan time.Time
+		if w.cfg.SnapshotInterval > 0 {
+			ticker := time.NewTicker(w.cfg.SnapshotInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-tick:
+			case <-w.compact:
+			}
+			if err := r.Compact(); err != nil {
+				log.Printf("wal: compact: %v", err)
+			}
+		}
+	}()
+}
+
+func (r *InMemoryStorage) syncLog() error {
+	r.wal.mu.Lock()
+	defer r.wal.mu.Unlock()
+
+	if !r.wal.dirty {
+		return nil
+	}
+	r.wal.dirty = false
+
+	return r.wal.f.Sync()
+}
+
+// Compact writes a snapshot of the current state and truncates the log.
+// Writes are blocked while the state is captured.
+func (r *InMemoryStorage) Compact() error {
+	if r.wal == nil {
+		return nil
+	}
+
+	r.wal.mu.Lock()
+	defer r.wal.mu.Unlock()
+
+	if err := r.writeSnapshot(filepath.Join(r.wal.cfg.Dir, snapshotFile), r.wal.lsn); err != nil {
+		return err
+	}
+
+	// A crash before the truncation is harmless: records already in the
+	// snapshot are skipped by their LSN on replay.
+	if err := r.wal.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := r.wal.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.wal.size = 0
+	r.wal.dirty = false
+
+	return nil
+}
+
+// Close stops background flushing and snapshots and syncs the log, later
+// changes fail.
+func (r *InMemoryStorage) Close() error {
+	if r.wal == nil {
+		return nil
+	}
+
+	close(r.wal.done)
+	r.wal.wg.Wait()
+
+	r.wal.mu.Lock()
+	defer r.wal.mu.Unlock()
+
+	r.wal.closed = true
+	if err := r.wal.f.Sync(); err != nil {
+		r.wal.f.Close()
+		return err
+	}
+
+	return r.wal.f.Close()
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"ozonProject/internal/models"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webhooksStore keeps webhooks and their deliveries in creation order, sent
+// remembers which events were queued for which webhook.
+type webhooksStore struct {
+	mu         sync.RWMutex
+	hooks      []*models.Webhook
+	deliveries []*models.WebhookDelivery
+	sent       map[[2]string]struct{}
+}
+
+func newWebhooksStore() *webhooksStore {
+	return &webhooksStore{sent: make(map[[2]string]struct{})}
+}
+
+func copyDelivery(d *models.WebhookDelivery) *models.WebhookDelivery {
+	cp := *d
+	return &cp
+}
+
+// storedWebhook keeps the secret the JSON of models.Webhook leaves out, it
+// is how webhooks are written to the log and the snapshot.
+type storedWebhook struct {
+	*models.Webhook
+	Secret string `json:"secret"`
+}
+
+func newStoredWebhook(w *models.Webhook) *storedWebhook {
+	return &storedWebhook{Webhook: w, Secret: w.Secret}
+}
+
+func (w *storedWebhook) webhook() *models.Webhook {
+	cp := *w.Webhook
+	cp.Secret = w.Secret
+
+	return &cp
+}
+
+func (s *webhooksStore) create(webhook *models.Webhook) *models.Webhook {
+	w := *webhook
+	w.Events = slices.Clone(webhook.Events)
+
+	s.mu.Lock()
+	s.hooks = append(s.hooks, &w)
+	s.mu.Unlock()
+
+	cp := w
+
+	return &cp
+}
+
+func (s *webhooksStore) list() []*models.Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.Webhook, 0, len(s.hooks))
+	for _, w := range s.hooks {
+		cp := *w
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+func (s *webhooksStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.hooks, func(w *models.Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+	s.hooks = slices.Delete(s.hooks, i, i+1)
+
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d *models.WebhookDelivery) bool {
+		if d.WebhookID != id {
+			return false
+		}
+		delete(s.sent, [2]string{d.WebhookID, d.EventID})
+		return true
+	})
+
+	return nil
+}
+
+func (s *webhooksStore) enqueue(deliveries []*models.WebhookDelivery, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deliveries {
+		key := [2]string{d.WebhookID, d.EventID}
+		if _, ok := s.sent[key]; ok || !slices.ContainsFunc(s.hooks, func(w *models.Webhook) bool { return w.ID == d.WebhookID }) {
+			continue
+		}
+		s.sent[key] = struct{}{}
+
+		cp := copyDelivery(d)
+		cp.Status = models.WebhookDeliveryStatusPending
+		cp.CreatedAt = now
+		cp.NextAttemptAt = &now
+		s.deliveries = append(s.deliveries, cp)
+	}
+}
+
+func (s *webhooksStore) claim(limit int, lease time.Duration, now time.Time) []*models.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*models.WebhookDelivery
+	until := now.Add(lease)
+	for _, d := range s.deliveries {
+		if len(out) == limit {
+			break
+		}
+		if d.Status != models.WebhookDeliveryStatusPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = &until
+		out = append(out, copyDelivery(d))
+	}
+
+	return out
+}
+
+func (s *webhooksStore) update(delivery *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.ID == delivery.ID {
+			d.Status, d.Attempts = delivery.Status, delivery.Attempts
+			d.LastStatusCode, d.LastError = delivery.LastStatusCode, delivery.LastError
+			d.NextAttemptAt, d.DeliveredAt = delivery.NextAttemptAt, delivery.DeliveredAt
+			return nil
+		}
+	}
+
+	return ErrDeliveryNotFound
+}
+
+func (f DeliveryFilter) matches(d *models.WebhookDelivery) bool {
+	return (f.WebhookID == "" || d.WebhookID == f.WebhookID) && (f.Status == "" || d.Status == f.Status)
+}
+
+func (s *webhooksStore) listDeliveries(filter DeliveryFilter, limit, offset int) []*models.WebhookDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*models.WebhookDelivery, 0, min(limit, len(s.deliveries)))
+	skipped := 0
+	for i := len(s.deliveries) - 1; i >= 0 && len(out) < limit; i-- {
+		d := s.deliveries[i]
+		if !filter.matches(d) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		out = append(out, copyDelivery(d))
+	}
+
+	return out
+}
+
+func (s *webhooksStore) retry(id string, now time.Time) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.ID == id && d.Status == models.WebhookDeliveryStatusDead {
+			d.Status, d.Attempts, d.NextAttemptAt = models.WebhookDeliveryStatusPending, 0, &now
+			return copyDelivery(d), nil
+		}
+	}
+
+	return nil, ErrDeliveryNotFound
+}
+
+func (r *InMemoryStorage) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	w := *webhook
+	w.ID = uuid.New().String()
+	w.CreatedAt = time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opCreateWebhook, Webhook: newStoredWebhook(&w)}, func() (*models.Webhook, error) {
+		return r.webhooks.create(&w), nil
+	})
+}
+
+func (r *InMemoryStorage) GetWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return r.webhooks.list(), nil
+}
+
+func (r *InMemoryStorage) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := commit(r, &walRecord{Op: opDeleteWebhook, ID: id}, func() (struct{}, error) {
+		return struct{}{}, r.webhooks.delete(id)
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	now := time.Now().UTC()
+	queued := make([]*models.WebhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		cp := copyDelivery(d)
+		cp.Status = models.WebhookDeliveryStatusPending
+		queued = append(queued, cp)
+	}
+
+	_, err := commit(r, &walRecord{Op: opEnqueueDeliveries, Deliveries: queued, Time: now}, func() (struct{}, error) {
+		r.webhooks.enqueue(queued, now)
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	return r.webhooks.claim(limit, lease, time.Now().UTC()), nil
+}
+
+func (r *InMemoryStorage) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	d := copyDelivery(delivery)
+
+	_, err := commit(r, &walRecord{Op: opUpdateDelivery, Deliveries: []*models.WebhookDelivery{d}}, func() (struct{}, error) {
+		return struct{}{}, r.webhooks.update(d)
+	})
+
+	return err
+}
+
+func (r *InMemoryStorage) GetWebhookDeliveries(ctx context.Context, filter DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error) {
+	return r.webhooks.listDeliveries(filter, limit, offset), nil
+}
+
+func (r *InMemoryStorage) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+
+	return commit(r, &walRecord{Op: opRetryDelivery, ID: id, Time: now}, func() (*models.WebhookDelivery, error) {
+		return r.webhooks.retry(id, now)
+	})
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"encoding/json"
+	"ozonProject/internal/models"
+	"time"
+)
+
+// outboxEvent builds an event of the entity, stored in the transaction
+// that changed it.
+func outboxEvent(id string, typ models.OutboxEventType, key string, entity any, at time.Time) (*models.OutboxEvent, error) {
+	payload, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxEvent{ID: id, Type: typ, Key: key, Payload: payload, CreatedAt: at}, nil
+}
@@ -6,52 +6,121 @@ import (
 	"fmt"
 	"log"
 	"ozonProject/internal/models"
+	"ozonProject/internal/utils"
+	"sort"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+const foreignKeyViolation = "23503"
+
 type PostgresStorage struct {
-	pool PgxPoolIface
+	pool     PgxPoolIface
+	replicas *replicaSet
 }
 
-func NewPostgresStorage(pool PgxPoolIface) *PostgresStorage {
-	return &PostgresStorage{pool: pool}
+func NewPostgresStorage(pool PgxPoolIface, opts ...PostgresOption) *PostgresStorage {
+	s := &PostgresStorage{pool: pool}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-func (s *PostgresStorage) CreatePost(ctx context.Context, title, content, author string, commentsEnabled bool) (*models.Post, error) {
+// postTags selects the sorted tag names of the post aliased as posts.
+const postTags = `
+		ARRAY(
+			SELECT t.name FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id = posts.id ORDER BY t.name
+		)`
+
+func (s *PostgresStorage) CreatePost(ctx context.Context, community, title, content, author string, commentsEnabled bool, tags []string) (*models.Post, error) {
 	id := uuid.New().String()
 
 	const query = `
-		INSERT INTO posts (id, title, content, author, comments_enabled)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, title, content, author, comments_enabled, created_at
+		WITH inserted AS (
+			INSERT INTO posts (id, title, content, author, comments_enabled, community)
+			VALUES ($1, $2, $3, $4, $5, $7)
+			RETURNING id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community
+		), upserted_tags AS (
+			INSERT INTO tags (name)
+			SELECT DISTINCT unnest($6::text[])
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id
+		), linked AS (
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT inserted.id, upserted_tags.id FROM inserted, upserted_tags
+		)
+		SELECT id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community FROM inserted
 	`
 
 	log.Printf("Create post query") //
 
-	var p models.Post
-	err := s.pool.QueryRow(ctx, query, id, title, content, author, commentsEnabled).Scan(
-		&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt,
-	)
+	sortedTags := append([]string{}, tags...)
+	sort.Strings(sortedTags)
+
+	p := models.Post{Tags: sortedTags}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, id, title, content, author, commentsEnabled, sortedTags, community).Scan(
+			&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount, &p.CommunityName,
+		)
+		if err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxPostCreated, p.ID, &p)
+	})
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return nil, ErrCommunityNotFound
+		}
 		return nil, err
 	}
+	s.replicas.pin(pinnedFeed)
 
 	return &p, nil
 }
 
-func (s *PostgresStorage) GetPosts(ctx context.Context, limit, offset int) ([]*models.Post, error) {
-	const query = `
-		SELECT id, title, content, author, comments_enabled, created_at
+// postOrders maps feed sorts to ORDER BY clauses backed by indexes on posts.
+var postOrders = map[models.PostSort]string{
+	models.PostSortNew: "created_at DESC, id DESC",
+	models.PostSortTop: "score DESC, created_at DESC, id DESC",
+	models.PostSortHot: "hot_rank DESC, id DESC",
+}
+
+func (s *PostgresStorage) GetPosts(ctx context.Context, limit, offset int, filter PostFilter) ([]*models.Post, error) {
+	query := `
+		SELECT id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,` + postTags + `
 		FROM posts
-		ORDER BY id DESC
+		WHERE ($3 = '' OR EXISTS (
+			SELECT 1 FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id = posts.id AND t.name = $3
+		))
+		AND ($4 = '' OR community = $4)
+		AND created_at >= $5
+		AND ($6 = '' OR (created_at, id) < (SELECT created_at, id FROM posts WHERE id = $6))
+		AND hidden_at IS NULL AND deleted_at IS NULL
+		ORDER BY %s
 		LIMIT $1 OFFSET $2
 	`
 
 	log.Printf("Get post query.")
 
-	rows, err := s.pool.Query(ctx, query, limit, offset)
+	order, ok := postOrders[filter.Sort]
+	if !ok {
+		order = postOrders[models.PostSortNew]
+	}
+	afterID := ""
+	if order == postOrders[models.PostSortNew] {
+		afterID = filter.AfterID
+	}
+
+	rows, err := s.readQuery(ctx, []string{pinnedFeed}, fmt.Sprintf(query, order), limit, offset, filter.Tag, filter.Community, filter.Since, afterID)
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +129,8 @@ func (s *PostgresStorage) GetPosts(ctx context.Context, limit, offset int) ([]*m
 	var out []*models.Post
 	for rows.Next() {
 		var p models.Post
-		err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt)
+		err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount,
+			&p.CommunityName, &p.Tags)
 		if err != nil {
 			return nil, err
 		}
@@ -72,7 +142,8 @@ func (s *PostgresStorage) GetPosts(ctx context.Context, limit, offset int) ([]*m
 
 func (s *PostgresStorage) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
 	const query = `
-		SELECT id, title, content, author, comments_enabled, created_at
+		SELECT id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL,` + postTags + `
 		FROM posts
 		WHERE id = $1
 	`
@@ -80,51 +151,397 @@ func (s *PostgresStorage) GetPostByID(ctx context.Context, id string) (*models.P
 
 	var p models.Post
 	err := s.pool.QueryRow(ctx, query, id).Scan(
-		&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt,
+		&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount, &p.CommunityName,
+		&p.Hidden, &p.Deleted, &p.Tags,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (s *PostgresStorage) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	// Votes on a post are serialised by its row lock, the vote query runs
+	// after the lock is taken, so its snapshot sees the committed previous
+	// vote of a concurrent request by the same voter.
+	const queryLock = `SELECT id FROM posts WHERE id = $1 FOR NO KEY UPDATE`
+
+	// Sub-statements share one snapshot, so previous still sees the old vote
+	// while upserted replaces it and posts.hot_rank is regenerated from score.
+	const query = `
+		WITH previous AS (
+			SELECT value FROM post_votes WHERE post_id = $1 AND voter = $2
+		), removed AS (
+			DELETE FROM post_votes WHERE post_id = $1 AND voter = $2 AND $3 = 0
+		), upserted AS (
+			INSERT INTO post_votes (post_id, voter, value)
+			SELECT $1::VARCHAR, $2::VARCHAR, $3::INTEGER WHERE $3 <> 0
+			ON CONFLICT (post_id, voter) DO UPDATE SET value = EXCLUDED.value
+		)
+		UPDATE posts
+		SET score = score + $3 - COALESCE((SELECT value FROM previous), 0)
+		WHERE id = $1
+		RETURNING id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL,` + postTags + `
+	`
+
+	log.Printf("Vote post query.")
+
+	var p models.Post
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var id string
+		if err := tx.QueryRow(ctx, queryLock, postID).Scan(&id); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx, query, postID, voter, value).Scan(
+			&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount, &p.CommunityName,
+			&p.Hidden, &p.Deleted, &p.Tags,
+		)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
 		return nil, err
 	}
 
+	s.replicas.pin(postID)
+
 	return &p, nil
 }
 
-func (s *PostgresStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string) (*models.Comment, error) {
+func (s *PostgresStorage) GetTags(ctx context.Context, limit int) ([]*models.Tag, error) {
+	const query = `
+		SELECT t.name, COUNT(pt.post_id) AS post_count
+		FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.id
+		JOIN posts p ON p.id = pt.post_id
+		WHERE p.hidden_at IS NULL AND p.deleted_at IS NULL
+		GROUP BY t.name
+		ORDER BY post_count DESC, t.name ASC
+		LIMIT $1
+	`
+
+	log.Printf("Get tags query.")
+
+	rows, err := s.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.PostCount); err != nil {
+			return nil, err
+		}
+		out = append(out, &t)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) CreateCommunity(ctx context.Context, community *models.Community) (*models.Community, error) {
+	const query = `
+		INSERT INTO communities (name, description, rules, moderators, comments_enabled, max_comment_length)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING name, description, rules, moderators, comments_enabled, max_comment_length, created_at
+	`
+
+	log.Printf("Create community query.")
+
+	var c models.Community
+	err := s.pool.QueryRow(ctx, query, community.Name, community.Description, community.Rules, community.Moderators,
+		community.CommentsEnabled, community.MaxCommentLength).Scan(
+		&c.Name, &c.Description, &c.Rules, &c.Moderators, &c.CommentsEnabled, &c.MaxCommentLength, &c.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCommunityExists
+		}
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *PostgresStorage) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	const query = `
+		SELECT name, description, rules, moderators, comments_enabled, max_comment_length, created_at
+		FROM communities
+		WHERE name = $1
+	`
+
+	log.Printf("Get community query.")
+
+	var c models.Community
+	err := s.pool.QueryRow(ctx, query, name).Scan(
+		&c.Name, &c.Description, &c.Rules, &c.Moderators, &c.CommentsEnabled, &c.MaxCommentLength, &c.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCommunityNotFound
+		}
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *PostgresStorage) GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error) {
+	const query = `
+		SELECT name, description, rules, moderators, comments_enabled, max_comment_length, created_at
+		FROM communities
+		ORDER BY created_at ASC, name ASC
+		LIMIT $1 OFFSET $2
+	`
+
+	log.Printf("Get communities query.")
+
+	rows, err := s.pool.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Community
+	for rows.Next() {
+		var c models.Community
+		err := rows.Scan(&c.Name, &c.Description, &c.Rules, &c.Moderators, &c.CommentsEnabled, &c.MaxCommentLength, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &c)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
 	if err := s.EnsureCommentsEnabled(ctx, postID); err != nil {
 		return nil, err
 	}
 
+	depth, parentPath := 0, ""
 	if parentID != "" {
-		const queryPostId = `SELECT post_id FROM comments WHERE id = $1`
+		const queryPostId = `SELECT post_id, depth + 1, path FROM comments WHERE id = $1`
 
 		log.Printf("Create comment query.")
 
 		var parentPostID string
-		if err := s.pool.QueryRow(ctx, queryPostId, parentID).Scan(&parentPostID); err != nil {
+		if err := s.pool.QueryRow(ctx, queryPostId, parentID).Scan(&parentPostID, &depth, &parentPath); err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
-				return nil, errors.New("parent comment not found")
+				return nil, ErrParentNotFound
 			}
 			return nil, err
 		}
 		if parentPostID != postID {
-			return nil, errors.New("parent belongs to another post")
+			return nil, ErrParentInOtherPost
 		}
 	}
 
 	id := uuid.New().String()
 	const queryInsertComment = `
-		INSERT INTO comments (id, post_id, parent_id, author, content)
-		VALUES ($1, $2, $3 , $4, $5)
-		RETURNING id, post_id, parent_id, author, content, created_at
+		INSERT INTO comments (id, post_id, parent_id, author, content, pending, depth, path)
+		VALUES ($1, $2, $3 , $4, $5, $6, $7, $8 || ` + pathSegmentSQL + `)
+		RETURNING id, post_id, parent_id, author, content, created_at, reply_count, depth, pending, deleted_at IS NOT NULL
 	`
 
 	log.Printf("Create comment (insert comment): %s", queryInsertComment) //
 
 	var c models.Comment
-	err := s.pool.QueryRow(ctx, queryInsertComment, id, postID, parentID, author, content).Scan(
-		&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt,
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, queryInsertComment, id, postID, parentID, author, content, pending, depth, parentPath).Scan(
+			&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Deleted,
+		)
+		if err != nil || pending {
+			return err
+		}
+
+		if err := s.addCommentCounters(ctx, tx, postID, parentID, 1); err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentPublished, c.PostID, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.replicas.pin(postID)
+
+	return &c, nil
+}
+
+// addCommentCounters moves the denormalized post and parent counters by delta.
+func (s *PostgresStorage) addCommentCounters(ctx context.Context, tx pgx.Tx, postID, parentID string, delta int) error {
+	const queryPost = `UPDATE posts SET comment_count = comment_count + $2 WHERE id = $1`
+	const queryParent = `UPDATE comments SET reply_count = reply_count + $2 WHERE id = $1`
+
+	log.Printf("Update comment counters query.")
+
+	if _, err := tx.Exec(ctx, queryPost, postID, delta); err != nil {
+		return err
+	}
+
+	if parentID != "" {
+		if _, err := tx.Exec(ctx, queryParent, parentID, delta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	const queryDelete = `
+		UPDATE comments
+		SET deleted_at = NOW(), author = $3, content = $3
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = '' OR author = $2)
+		RETURNING id, post_id, parent_id, author, content, created_at, reply_count, depth, pending, deleted_at IS NOT NULL
+	`
+
+	log.Printf("Delete comment query.")
+
+	var c models.Comment
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, queryDelete, id, author, DeletedPlaceholder).Scan(
+			&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Deleted,
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrCommentNotFound
+		}
+		if err != nil || c.Pending {
+			return err
+		}
+
+		if err := s.addCommentCounters(ctx, tx, c.PostID, utils.ValueOrDefault(c.ParentID, ""), -1); err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentDeleted, c.PostID, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.replicas.pin(c.PostID)
+
+	return &c, nil
+}
+
+func (s *PostgresStorage) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	const queryApprove = `
+		UPDATE comments SET pending = FALSE
+		WHERE id = $1 AND pending AND deleted_at IS NULL
+		RETURNING id, post_id, parent_id, author, content, created_at, reply_count, depth, pending, deleted_at IS NOT NULL
+	`
+
+	log.Printf("Approve comment query.")
+
+	var c models.Comment
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, queryApprove, id).Scan(
+			&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Deleted,
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.addCommentCounters(ctx, tx, c.PostID, utils.ValueOrDefault(c.ParentID, ""), 1); err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentPublished, c.PostID, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.replicas.pin(c.PostID)
+
+	return &c, nil
+}
+
+func (s *PostgresStorage) ReconcileCounters(ctx context.Context) (int64, error) {
+	const queryPosts = `
+		UPDATE posts SET comment_count = counted.n
+		FROM (
+			SELECT p.id, COUNT(c.id) AS n
+			FROM posts p LEFT JOIN comments c ON c.post_id = p.id AND c.deleted_at IS NULL AND NOT c.pending
+			GROUP BY p.id
+		) counted
+		WHERE posts.id = counted.id AND posts.comment_count <> counted.n
+	`
+	const queryComments = `
+		UPDATE comments SET reply_count = counted.n
+		FROM (
+			SELECT c.id, COUNT(r.id) AS n
+			FROM comments c LEFT JOIN comments r ON r.parent_id = c.id AND r.deleted_at IS NULL AND NOT r.pending
+			GROUP BY c.id
+		) counted
+		WHERE comments.id = counted.id AND comments.reply_count <> counted.n
+	`
+
+	log.Printf("Reconcile counters query.")
+
+	var fixed int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		for _, query := range []string{queryPosts, queryComments} {
+			tag, err := tx.Exec(ctx, query)
+			if err != nil {
+				return err
+			}
+			fixed += tag.RowsAffected()
+		}
+		return nil
+	})
+	if err == nil && fixed > 0 {
+		s.replicas.pin(pinnedFeed)
+	}
+
+	return fixed, err
+}
+
+func (s *PostgresStorage) withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // no-op after commit
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) GetCommentByID(ctx context.Context, id string) (*models.Comment, error) {
+	const query = `
+		SELECT id, post_id, parent_id, author, content, created_at, reply_count, depth, pending,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL
+		FROM comments
+		WHERE id = $1
+	`
+
+	log.Printf("Get comment by id query.")
+
+	var c models.Comment
+	err := s.pool.QueryRow(ctx, query, id).Scan(
+		&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Hidden, &c.Deleted,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCommentNotFound
+		}
 		return nil, err
 	}
 
@@ -133,10 +550,10 @@ func (s *PostgresStorage) CreateComment(ctx context.Context, postID string, pare
 
 func (s *PostgresStorage) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
 	query := `
-		SELECT id, post_id, parent_id, author, content, created_at
+		SELECT id, post_id, parent_id, author, content, created_at, reply_count, depth, deleted_at IS NOT NULL
 		FROM comments
-		WHERE post_id = $1 %s
-		ORDER BY id ASC
+		WHERE post_id = $1 AND hidden_at IS NULL AND NOT pending %s
+		ORDER BY path ASC
 		LIMIT $2 OFFSET $3
 	`
 
@@ -145,9 +562,9 @@ func (s *PostgresStorage) GetComments(ctx context.Context, postID string, parent
 	var rows pgx.Rows
 	var err error
 	if parentID == "" {
-		rows, err = s.pool.Query(ctx, fmt.Sprintf(query, "AND parent_id = '' "), postID, limit, offset)
+		rows, err = s.readQuery(ctx, []string{pinnedFeed, postID}, fmt.Sprintf(query, "AND parent_id = '' "), postID, limit, offset)
 	} else {
-		rows, err = s.pool.Query(ctx, fmt.Sprintf(query, "AND parent_id = $4"), postID, limit, offset, parentID)
+		rows, err = s.readQuery(ctx, []string{pinnedFeed, postID}, fmt.Sprintf(query, "AND parent_id = $4"), postID, limit, offset, parentID)
 	}
 	if err != nil {
 		return nil, err
@@ -157,7 +574,7 @@ func (s *PostgresStorage) GetComments(ctx context.Context, postID string, parent
 	var out []*models.Comment
 	for rows.Next() {
 		var c models.Comment
-		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Deleted); err != nil {
 			return nil, err
 		}
 		out = append(out, &c)
@@ -173,12 +590,279 @@ func (s *PostgresStorage) EnsureCommentsEnabled(ctx context.Context, postID stri
 
 	var enabled bool
 	if err := s.pool.QueryRow(ctx, query, postID).Scan(&enabled); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrPostNotFound
+		}
 		return err
 	}
 
 	if !enabled {
-		return errors.New("comments disabled")
+		return ErrCommentsDisabled
 	}
 
 	return nil
 }
+
+func (s *PostgresStorage) ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error) {
+	const queryReserve = `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE
+			SET response = NULL, request_hash = EXCLUDED.request_hash, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < NOW()
+		RETURNING key
+	`
+
+	log.Printf("Reserve idempotency key query.")
+
+	var reserved string
+	err := s.pool.QueryRow(ctx, queryReserve, key, hash, lease.Seconds()).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	const queryResponse = `SELECT response, request_hash FROM idempotency_keys WHERE key = $1`
+
+	var (
+		response []byte
+		stored   string
+	)
+	if err := s.pool.QueryRow(ctx, queryResponse, key).Scan(&response, &stored); err != nil {
+		return nil, err
+	}
+
+	return storedIdempotencyResponse(response, stored, hash)
+}
+
+func (s *PostgresStorage) CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	const query = `UPDATE idempotency_keys SET response = $2, expires_at = NOW() + make_interval(secs => $3) WHERE key = $1`
+
+	log.Printf("Complete idempotency key query.")
+
+	_, err := s.pool.Exec(ctx, query, key, response, ttl.Seconds())
+
+	return err
+}
+
+func (s *PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	const query = `DELETE FROM idempotency_keys WHERE key = $1 AND response IS NULL`
+
+	log.Printf("Release idempotency key query.")
+
+	_, err := s.pool.Exec(ctx, query, key)
+
+	return err
+}
+
+func (s *PostgresStorage) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	const query = `DELETE FROM idempotency_keys WHERE expires_at < NOW()`
+
+	log.Printf("Purge idempotency keys query.")
+
+	tag, err := s.pool.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *PostgresStorage) Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error) {
+	const querySearch = `
+		WITH q AS (SELECT websearch_to_tsquery('simple', $1) AS query)
+		SELECT kind, id, post_id, parent_id, title, content, author, comments_enabled, created_at, score, comment_count, community, tags, rank, snippet
+		FROM (
+			SELECT 'POST' AS kind, posts.id, '' AS post_id, NULL AS parent_id, posts.title, posts.content, posts.author,
+				posts.comments_enabled, posts.created_at, posts.score, posts.comment_count, posts.community,` + postTags + ` AS tags,
+				ts_rank(posts.search_vector, q.query) AS rank,
+				ts_headline('simple', posts.title || ' ' || posts.content, q.query,
+					'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxWords=24, MinWords=8') AS snippet
+			FROM posts, q
+			WHERE $2 IN ('ALL', 'POST') AND posts.hidden_at IS NULL AND posts.deleted_at IS NULL
+				AND posts.search_vector @@ q.query
+			UNION ALL
+			SELECT 'COMMENT' AS kind, c.id, c.post_id, c.parent_id, '' AS title, c.content, c.author,
+				FALSE AS comments_enabled, c.created_at, 0 AS score, c.reply_count AS comment_count, '' AS community, '{}'::text[] AS tags,
+				ts_rank(c.search_vector, q.query) AS rank,
+				ts_headline('simple', c.content, q.query,
+					'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxWords=24, MinWords=8') AS snippet
+			FROM comments c, q
+			WHERE $2 IN ('ALL', 'COMMENT') AND c.deleted_at IS NULL AND c.hidden_at IS NULL AND NOT c.pending
+				AND c.search_vector @@ q.query
+		) hits
+		ORDER BY rank DESC, id ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	log.Printf("Search query.")
+
+	rows, err := s.pool.Query(ctx, querySearch, query, string(kind), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.SearchResult
+	for rows.Next() {
+		var (
+			res             models.SearchResult
+			id, postID      string
+			parentID        *string
+			title, content  string
+			author          string
+			commentsEnabled bool
+			createdAt       time.Time
+			score           int
+			commentCount    int
+			community       string
+			tags            []string
+		)
+		err := rows.Scan(&res.Type, &id, &postID, &parentID, &title, &content, &author, &commentsEnabled, &createdAt,
+			&score, &commentCount, &community, &tags, &res.Rank, &res.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Snippet = markSnippet(res.Snippet)
+
+		if res.Type == models.SearchTypePost {
+			res.Post = &models.Post{
+				ID: id, Title: title, Content: content, Author: author,
+				CommentsEnabled: commentsEnabled, CreatedAt: createdAt, Score: score, CommentCount: commentCount, Tags: tags, CommunityName: community,
+			}
+		} else {
+			res.Comment = &models.Comment{
+				ID: id, PostID: postID, ParentID: parentID, Author: author,
+				Content: content, CreatedAt: createdAt, ReplyCount: commentCount,
+			}
+		}
+		out = append(out, &res)
+	}
+
+	return out, rows.Err()
+}
+
+const notificationColumns = `id, recipient, type, actor, post_id, comment_id, read_at IS NOT NULL, created_at`
+
+func scanNotification(row pgx.Row) (*models.Notification, error) {
+	var n models.Notification
+	err := row.Scan(&n.ID, &n.Recipient, &n.Type, &n.Actor, &n.PostID, &n.CommentID, &n.Read, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (s *PostgresStorage) CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error) {
+	const query = `
+		INSERT INTO notifications (id, recipient, type, actor, post_id, comment_id)
+		SELECT $1, target.author, 'REPLY', $2, $3, $4
+		FROM (
+			SELECT author FROM comments WHERE id = $5 AND deleted_at IS NULL
+			UNION ALL
+			SELECT author FROM posts WHERE id = $3 AND $5 = ''
+		) target
+		WHERE target.author <> $2
+		RETURNING ` + notificationColumns
+
+	log.Printf("Create reply notification query.")
+
+	n, err := scanNotification(s.pool.QueryRow(ctx, query,
+		uuid.New().String(), reply.Author, reply.PostID, reply.ID, utils.ValueOrDefault(reply.ParentID, "")))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	return n, err
+}
+
+func (s *PostgresStorage) CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error) {
+	const query = `
+		INSERT INTO notifications (id, recipient, type, actor, post_id, comment_id, created_at)
+		SELECT unnest($1::text[]), unnest($2::text[]), unnest($3::text[]), unnest($4::text[]),
+			unnest($5::text[]), unnest($6::text[]), $7
+	`
+
+	if len(notifications) == 0 {
+		return []*models.Notification{}, nil
+	}
+
+	now := time.Now().UTC()
+	out := make([]*models.Notification, 0, len(notifications))
+	var ids, recipients, types, actors, postIDs, commentIDs []string
+	for _, n := range notifications {
+		stored := *n
+		stored.ID = uuid.New().String()
+		stored.CreatedAt = now
+		stored.Read = false
+		out = append(out, &stored)
+
+		ids = append(ids, stored.ID)
+		recipients = append(recipients, stored.Recipient)
+		types = append(types, string(stored.Type))
+		actors = append(actors, stored.Actor)
+		postIDs = append(postIDs, stored.PostID)
+		commentIDs = append(commentIDs, stored.CommentID)
+	}
+
+	log.Printf("Create notifications query.")
+
+	if _, err := s.pool.Exec(ctx, query, ids, recipients, types, actors, postIDs, commentIDs, now); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *PostgresStorage) GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error) {
+	const query = `
+		SELECT ` + notificationColumns + `
+		FROM notifications
+		WHERE recipient = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	log.Printf("Get notifications query.")
+
+	rows, err := s.pool.Query(ctx, query, recipient, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Notification, 0, limit)
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	const query = `
+		UPDATE notifications SET read_at = NOW()
+		WHERE recipient = $1 AND read_at IS NULL AND (cardinality($2::text[]) = 0 OR id = ANY($2))
+	`
+
+	log.Printf("Mark notifications read query.")
+
+	if ids == nil {
+		ids = []string{}
+	}
+
+	tag, err := s.pool.Exec(ctx, query, recipient, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"ozonProject/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func (s *PostgresStorage) AppendAudit(ctx context.Context, entry *models.AuditEntry) error {
+	const query = `
+		INSERT INTO audit_log (id, actor, action, target_type, target_id, before, after, request_id, ip)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7::jsonb, $8, $9)
+	`
+
+	log.Printf("Append audit query.")
+
+	_, err := s.pool.Exec(ctx, query, uuid.New().String(), entry.Actor, entry.Action, entry.TargetType, entry.TargetID,
+		entry.Before, entry.After, entry.RequestID, entry.IP)
+
+	return err
+}
+
+func (s *PostgresStorage) GetAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]*models.AuditEntry, error) {
+	const query = `
+		SELECT id, actor, action, target_type, target_id, before::text, after::text, request_id, ip, created_at
+		FROM audit_log
+		WHERE ($3 = '' OR actor = $3)
+		AND ($4 = '' OR action = $4)
+		AND ($5 = '' OR target_type = $5)
+		AND ($6 = '' OR target_id = $6)
+		AND created_at >= $7
+		AND ($8::timestamp = '0001-01-01' OR created_at < $8)
+		ORDER BY created_at ASC, id ASC
+		LIMIT $1 OFFSET $2
+	`
+
+	log.Printf("Get audit log query.")
+
+	rows, err := s.pool.Query(ctx, query, limit, offset,
+		filter.Actor, filter.Action, filter.TargetType, filter.TargetID, filter.Since, filter.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.AuditEntry, 0, limit)
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID, &e.Before, &e.After,
+			&e.RequestID, &e.IP, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &e)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+	"ozonProject/internal/utils"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s *PostgresStorage) ImportPost(ctx context.Context, post *models.Post) error {
+	// hidden_at and deleted_at get the import time, the original one is not
+	// part of the dump.
+	const query = `
+		WITH inserted AS (
+			INSERT INTO posts (id, title, content, author, comments_enabled, community, created_at, score, hidden_at, deleted_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $9 THEN NOW() END, CASE WHEN $10 THEN NOW() END)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		), upserted_tags AS (
+			INSERT INTO tags (name)
+			SELECT DISTINCT unnest($11::text[])
+			FROM inserted
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id
+		), linked AS (
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT inserted.id, upserted_tags.id FROM inserted, upserted_tags
+		)
+		SELECT id FROM inserted
+	`
+
+	log.Printf("Import post query.")
+
+	sortedTags := append([]string{}, post.Tags...)
+	sort.Strings(sortedTags)
+
+	var id string
+	err := s.pool.QueryRow(ctx, query, post.ID, post.Title, post.Content, post.Author, post.CommentsEnabled, post.CommunityName,
+		post.CreatedAt.UTC(), post.Score, post.Hidden, post.Deleted, sortedTags).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrPostExists
+		case errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation:
+			return ErrCommunityNotFound
+		}
+		return err
+	}
+	s.replicas.pin(pinnedFeed)
+
+	return nil
+}
+
+func (s *PostgresStorage) ImportComment(ctx context.Context, comment *models.Comment) error {
+	const queryPost = `SELECT 1 FROM posts WHERE id = $1`
+	const queryParent = `SELECT post_id, depth + 1, path FROM comments WHERE id = $1`
+	const queryInsert = `
+		INSERT INTO comments (id, post_id, parent_id, author, content, pending, depth, path, created_at, hidden_at, deleted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8 || ` + pathSegmentSQL + `, $9, CASE WHEN $10 THEN NOW() END, CASE WHEN $11 THEN NOW() END)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	log.Printf("Import comment query.")
+
+	parentID := utils.ValueOrDefault(comment.ParentID, "")
+
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var found int
+		if err := tx.QueryRow(ctx, queryPost, comment.PostID).Scan(&found); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrPostNotFound
+			}
+			return err
+		}
+
+		depth, parentPath := 0, ""
+		if parentID != "" {
+			var parentPostID string
+			if err := tx.QueryRow(ctx, queryParent, parentID).Scan(&parentPostID, &depth, &parentPath); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrParentNotFound
+				}
+				return err
+			}
+			if parentPostID != comment.PostID {
+				return ErrParentInOtherPost
+			}
+		}
+
+		tag, err := tx.Exec(ctx, queryInsert, comment.ID, comment.PostID, parentID, comment.Author, comment.Content,
+			comment.Pending, depth, parentPath, comment.CreatedAt.UTC(), comment.Hidden, comment.Deleted)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrCommentExists
+		}
+
+		if comment.Pending || comment.Deleted {
+			return nil
+		}
+
+		return s.addCommentCounters(ctx, tx, comment.PostID, parentID, 1)
+	})
+	if err != nil {
+		return err
+	}
+	s.replicas.pin(comment.PostID)
+
+	return nil
+}
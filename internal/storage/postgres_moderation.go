@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStorage) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	// Only visible content is announced, hiding it again, or hiding deleted
+	// and pending content, just sets hidden_at.
+	const queryPost = `
+		UPDATE posts SET hidden_at = NOW()
+		WHERE id = $1 AND hidden_at IS NULL AND deleted_at IS NULL
+		RETURNING id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL,` + postTags + `
+	`
+	const queryComment = `
+		UPDATE comments SET hidden_at = NOW()
+		WHERE id = $1 AND hidden_at IS NULL AND deleted_at IS NULL AND NOT pending
+		RETURNING id, post_id, parent_id, author, content, created_at, reply_count, depth, pending,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL
+	`
+
+	query, notFound := `UPDATE posts SET hidden_at = COALESCE(hidden_at, NOW()) WHERE id = $1`, ErrPostNotFound
+	if targetType == models.ReportTargetTypeComment {
+		query, notFound = `UPDATE comments SET hidden_at = COALESCE(hidden_at, NOW()) WHERE id = $1`, ErrCommentNotFound
+	}
+
+	log.Printf("Hide content query.")
+
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var (
+			err   error
+			typ   models.OutboxEventType
+			key   string
+			event any
+		)
+		if targetType == models.ReportTargetTypeComment {
+			var c models.Comment
+			err = tx.QueryRow(ctx, queryComment, id).Scan(
+				&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Hidden, &c.Deleted,
+			)
+			typ, key, event = models.OutboxCommentHidden, c.PostID, &c
+		} else {
+			var p models.Post
+			err = tx.QueryRow(ctx, queryPost, id).Scan(
+				&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount, &p.CommunityName,
+				&p.Hidden, &p.Deleted, &p.Tags,
+			)
+			typ, key, event = models.OutboxPostHidden, p.ID, &p
+		}
+		if err == nil {
+			return s.insertOutboxEvent(ctx, tx, typ, key, event)
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return notFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The post of a hidden comment is unknown here, so the feed is pinned
+	// together with every post.
+	s.replicas.pin(pinnedFeed)
+
+	return nil
+}
+
+func (s *PostgresStorage) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	const query = `
+		UPDATE posts
+		SET deleted_at = NOW(), title = $3, content = $3, author = $3
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = '' OR author = $2)
+		RETURNING id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,
+			hidden_at IS NOT NULL, deleted_at IS NOT NULL,` + postTags + `
+	`
+
+	log.Printf("Delete post query.")
+
+	var p models.Post
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, query, id, author, DeletedPlaceholder).Scan(
+			&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount, &p.CommunityName,
+			&p.Hidden, &p.Deleted, &p.Tags,
+		)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrPostNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxPostDeleted, p.ID, &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.replicas.pin(pinnedFeed)
+
+	return &p, nil
+}
+
+func (s *PostgresStorage) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	// When the insert conflicts the existing report is picked up by the
+	// second branch, a fresh row is not visible to it within the statement.
+	const query = `
+		WITH target AS (
+			SELECT p.id, p.author, p.community FROM posts p
+			WHERE $2 = 'POST' AND p.id = $3 AND p.deleted_at IS NULL AND p.hidden_at IS NULL
+			UNION ALL
+			SELECT c.id, c.author, p.community FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE $2 = 'COMMENT' AND c.id = $3 AND c.deleted_at IS NULL AND c.hidden_at IS NULL
+		), inserted AS (
+			INSERT INTO reports (id, target_type, target_id, target_author, community, reporter, reason)
+			SELECT $1, $2, target.id, target.author, target.community, $4, $5 FROM target
+			ON CONFLICT (target_type, target_id, reporter) DO NOTHING
+			RETURNING id
+		)
+		SELECT id FROM inserted
+		UNION ALL
+		SELECT r.id FROM reports r
+		WHERE r.target_type = $2 AND r.target_id = $3 AND r.reporter = $4
+		LIMIT 1
+	`
+
+	log.Printf("Create report query.")
+
+	var id string
+	err := s.pool.QueryRow(ctx, query,
+		uuid.New().String(), string(report.TargetType), report.TargetID, report.Reporter, report.Reason).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if report.TargetType == models.ReportTargetTypeComment {
+				return nil, ErrCommentNotFound
+			}
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	return s.GetReport(ctx, id)
+}
+
+const reportColumns = `id, target_type, target_id, target_author, community, reporter, reason, status, created_at`
+
+func scanReport(row pgx.Row) (*models.Report, error) {
+	var r models.Report
+	err := row.Scan(&r.ID, &r.TargetType, &r.TargetID, &r.TargetAuthor, &r.Community, &r.Reporter, &r.Reason, &r.Status, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Decisions = []*models.ModerationDecision{}
+
+	return &r, nil
+}
+
+func (s *PostgresStorage) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	const query = `SELECT ` + reportColumns + ` FROM reports WHERE id = $1`
+
+	log.Printf("Get report query.")
+
+	r, err := scanReport(s.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.loadDecisions(ctx, []*models.Report{r}); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *PostgresStorage) GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error) {
+	const query = `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE community = $1 AND status = $2
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	log.Printf("Get reports query.")
+
+	rows, err := s.pool.Query(ctx, query, community, string(status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Report, 0, limit)
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadDecisions(ctx, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// loadDecisions fills the audit trail of reports with a single query.
+func (s *PostgresStorage) loadDecisions(ctx context.Context, reports []*models.Report) error {
+	const query = `
+		SELECT id, report_id, moderator, action, note, created_at
+		FROM moderation_decisions
+		WHERE report_id = ANY($1)
+		ORDER BY created_at ASC, id ASC
+	`
+
+	if len(reports) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.Report, len(reports))
+	ids := make([]string, 0, len(reports))
+	for _, r := range reports {
+		byID[r.ID] = r
+		ids = append(ids, r.ID)
+	}
+
+	log.Printf("Get moderation decisions query.")
+
+	rows, err := s.pool.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.ModerationDecision
+		if err := rows.Scan(&d.ID, &d.ReportID, &d.Moderator, &d.Action, &d.Note, &d.CreatedAt); err != nil {
+			return err
+		}
+		r := byID[d.ReportID]
+		r.Decisions = append(r.Decisions, &d)
+	}
+
+	return rows.Err()
+}
+
+func (s *PostgresStorage) ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	const queryStatus = `UPDATE reports SET status = $2 WHERE id = $1 AND status = 'OPEN'`
+	const queryDecision = `
+		INSERT INTO moderation_decisions (id, report_id, moderator, action, note)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	log.Printf("Resolve report query.")
+
+	if decision.ID == "" {
+		decision.ID = uuid.New().String()
+	}
+
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, queryStatus, decision.ReportID, string(status))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			if _, err := s.GetReport(ctx, decision.ReportID); err != nil {
+				return err
+			}
+			return ErrReportResolved
+		}
+
+		_, err = tx.Exec(ctx, queryDecision,
+			decision.ID, decision.ReportID, decision.Moderator, string(decision.Action), decision.Note)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetReport(ctx, decision.ReportID)
+}
+
+func (s *PostgresStorage) ReopenReport(ctx context.Context, reportID, decisionID string) error {
+	const queryDecision = `DELETE FROM moderation_decisions WHERE id = $1 AND report_id = $2`
+	const queryStatus = `UPDATE reports SET status = 'OPEN' WHERE id = $1`
+
+	log.Printf("Reopen report query.")
+
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, queryDecision, decisionID, reportID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, queryStatus, reportID)
+		return err
+	})
+}
+
+const banColumns = `id, user_id, scope, scope_id, reason, created_by, created_at, expires_at`
+
+func scanBan(row pgx.Row) (*models.Ban, error) {
+	var b models.Ban
+	err := row.Scan(&b.ID, &b.UserID, &b.Scope, &b.ScopeID, &b.Reason, &b.CreatedBy, &b.CreatedAt, &b.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (s *PostgresStorage) BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error) {
+	const query = `
+		INSERT INTO bans (id, user_id, scope, scope_id, reason, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, scope, scope_id) DO UPDATE
+		SET reason = EXCLUDED.reason, created_by = EXCLUDED.created_by,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		RETURNING ` + banColumns + `
+	`
+
+	log.Printf("Ban user query.")
+
+	return scanBan(s.pool.QueryRow(ctx, query, uuid.New().String(),
+		ban.UserID, string(ban.Scope), ban.ScopeID, ban.Reason, ban.CreatedBy, ban.Until))
+}
+
+func (s *PostgresStorage) ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error) {
+	const query = `
+		SELECT ` + banColumns + `
+		FROM bans
+		WHERE user_id = $1
+		AND (scope = 'GLOBAL'
+			OR (scope = 'COMMUNITY' AND scope_id = $2 AND $2 <> '')
+			OR (scope = 'POST' AND scope_id = $3 AND $3 <> ''))
+		AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY expires_at DESC NULLS FIRST
+		LIMIT 1
+	`
+
+	log.Printf("Active ban query.")
+
+	b, err := scanBan(s.pool.QueryRow(ctx, query, user, community, postID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	return b, err
+}
+
+func (s *PostgresStorage) GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error) {
+	const query = `
+		SELECT ` + banColumns + `
+		FROM bans
+		WHERE scope = $1 AND scope_id = $2 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	log.Printf("Get bans query.")
+
+	rows, err := s.pool.Query(ctx, query, string(scope), scopeID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Ban, 0, limit)
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// commentsChannel is the NOTIFY channel of published comments.
+const commentsChannel = "comments_published"
+
+func (s *PostgresStorage) BroadcastComment(ctx context.Context, id string) error {
+	const query = `SELECT pg_notify('` + commentsChannel + `', $1)`
+
+	log.Printf("Broadcast comment query.")
+
+	_, err := s.pool.Exec(ctx, query, id)
+
+	return err
+}
+
+// ListenComments holds a connection of its own taken out of the pool, a
+// listening connection must not serve other queries.
+func (s *PostgresStorage) ListenComments(ctx context.Context, fn func(id string)) error {
+	pool, ok := s.pool.(*pgxpool.Pool)
+	if !ok {
+		return errors.New("listening for comments needs a pgxpool.Pool")
+	}
+
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, `LISTEN `+commentsChannel); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(n.Payload)
+	}
+}
+
+// insertOutboxEvent stores an event of the entity in the transaction that changed it.
+func (s *PostgresStorage) insertOutboxEvent(ctx context.Context, tx pgx.Tx, typ models.OutboxEventType, key string, entity any) error {
+	const query = `
+		INSERT INTO outbox (id, type, key, payload)
+		VALUES ($1, $2, $3, $4::jsonb)
+	`
+
+	log.Printf("Insert outbox event query.")
+
+	ev, err := outboxEvent(uuid.New().String(), typ, key, entity, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, ev.ID, ev.Type, ev.Key, string(ev.Payload))
+
+	return err
+}
+
+func (s *PostgresStorage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	// SKIP LOCKED lets concurrent dispatchers claim disjoint batches.
+	const query = `
+		WITH claimed AS (
+			UPDATE outbox SET locked_until = NOW() + $2 * INTERVAL '1 millisecond', attempts = attempts + 1
+			WHERE seq IN (
+				SELECT seq FROM outbox
+				WHERE locked_until IS NULL OR locked_until <= NOW()
+				ORDER BY seq
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING seq, id, type, key, payload, attempts, created_at
+		)
+		SELECT id, type, key, payload::text, attempts, created_at FROM claimed ORDER BY seq
+	`
+
+	log.Printf("Claim outbox events query.")
+
+	rows, err := s.pool.Query(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.OutboxEvent
+	for rows.Next() {
+		var ev models.OutboxEvent
+		var payload string
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.Key, &payload, &ev.Attempts, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		ev.Payload = []byte(payload)
+		out = append(out, &ev)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) AckOutboxEvents(ctx context.Context, ids []string) error {
+	const query = `DELETE FROM outbox WHERE id = ANY($1)`
+
+	log.Printf("Ack outbox events query.")
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := s.pool.Exec(ctx, query, ids)
+
+	return err
+}
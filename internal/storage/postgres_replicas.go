@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresOption func(*PostgresStorage)
+
+// ReplicaConfig controls routing of reads to replicas.
+type ReplicaConfig struct {
+	// MaxLag takes out replicas replaying further behind the primary. Writes
+	// pin reads of the touched post to the primary for the same time, so a
+	// client sees its own comment right after creating it. Zero disables both.
+	MaxLag time.Duration
+	// HealthInterval is how often lag is checked, zero disables the checks.
+	HealthInterval time.Duration
+	// RetryAfter is how long a replica whose read failed is skipped before a
+	// single read tries it again, zero means DefaultReplicaRetryAfter.
+	RetryAfter time.Duration
+}
+
+// DefaultReplicaRetryAfter is the RetryAfter used when it is not set.
+const DefaultReplicaRetryAfter = 5 * time.Second
+
+// WithReplicas routes GetPosts and GetComments to the replicas in turn,
+// falling back to the primary when none of them is healthy.
+func WithReplicas(cfg ReplicaConfig, replicas ...PgxPoolIface) PostgresOption {
+	return func(s *PostgresStorage) {
+		if len(replicas) == 0 {
+			return
+		}
+
+		if cfg.RetryAfter <= 0 {
+			cfg.RetryAfter = DefaultReplicaRetryAfter
+		}
+
+		set := &replicaSet{cfg: cfg, pinned: make(map[string]time.Time)}
+		for _, pool := range replicas {
+			r := &replica{pool: pool}
+			r.healthy.Store(true)
+			set.replicas = append(set.replicas, r)
+		}
+		s.replicas = set
+	}
+}
+
+type replica struct {
+	pool    PgxPoolIface
+	healthy atomic.Bool
+	// retryAt is when a replica taken out by a failed read gets a read again,
+	// in Unix nanoseconds. Zero leaves the replica to CheckReplicas.
+	retryAt atomic.Int64
+}
+
+// fail takes the replica out after a failed read until retryAfter passes.
+func (r *replica) fail(retryAfter time.Duration) {
+	r.healthy.Store(false)
+	r.retryAt.Store(time.Now().Add(retryAfter).UnixNano())
+}
+
+// probe lets a single read through to a replica taken out by a failed read
+// once its retry time has come, the next retry is scheduled right away.
+func (r *replica) probe(retryAfter time.Duration) bool {
+	at, now := r.retryAt.Load(), time.Now()
+	return at != 0 && now.UnixNano() >= at && r.retryAt.CompareAndSwap(at, now.Add(retryAfter).UnixNano())
+}
+
+type replicaSet struct {
+	cfg      ReplicaConfig
+	replicas []*replica
+	next     atomic.Uint64
+
+	mu sync.Mutex
+	// pinned maps post ids to the time their reads may go to replicas
+	// again, the empty id pins the feed and every post.
+	pinned map[string]time.Time
+}
+
+// pinnedFeed is the pinned key of writes that change the feed.
+const pinnedFeed = ""
+
+// pinAfter is how many pins are kept before expired ones are dropped.
+const pinAfter = 1024
+
+func (rs *replicaSet) pin(postID string) {
+	if rs == nil || rs.cfg.MaxLag <= 0 {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	if len(rs.pinned) >= pinAfter {
+		for id, until := range rs.pinned {
+			if !now.Before(until) {
+				delete(rs.pinned, id)
+			}
+		}
+	}
+	rs.pinned[postID] = now.Add(rs.cfg.MaxLag)
+}
+
+func (rs *replicaSet) isPinned(postIDs ...string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range postIDs {
+		if until, ok := rs.pinned[id]; ok && now.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pick returns a healthy replica allowed to serve reads of the posts, nil
+// when the read has to go to the primary.
+func (rs *replicaSet) pick(postIDs ...string) *replica {
+	if rs == nil || rs.isPinned(postIDs...) {
+		return nil
+	}
+
+	start := rs.next.Add(1)
+	for i := range uint64(len(rs.replicas)) {
+		r := rs.replicas[(start+i)%uint64(len(rs.replicas))]
+		if r.healthy.Load() || r.probe(rs.cfg.RetryAfter) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// readQuery runs a read on a replica when one may serve it and retries on
+// the primary when the replica fails. A cancelled or timed out read says
+// nothing about the replica and keeps it in, a successful read brings back
+// a replica taken out by a failed one.
+func (s *PostgresStorage) readQuery(ctx context.Context, postIDs []string, sql string, args ...interface{}) (pgx.Rows, error) {
+	r := s.replicas.pick(postIDs...)
+	if r == nil {
+		return s.pool.Query(ctx, sql, args...)
+	}
+
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err == nil {
+		if r.retryAt.Swap(0) != 0 && !r.healthy.Swap(true) {
+			log.Printf("Replica query succeeded, replica is back")
+		}
+		return rows, nil
+	}
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	log.Printf("Replica query failed, falling back to primary: %v", err)
+	r.fail(s.replicas.cfg.RetryAfter)
+
+	return s.pool.Query(ctx, sql, args...)
+}
+
+// CheckReplicas measures the lag of every replica and takes out the ones
+// failing or lagging more than MaxLag.
+func (s *PostgresStorage) CheckReplicas(ctx context.Context) {
+	const query = `
+		SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)::float8
+		END
+	`
+
+	if s.replicas == nil {
+		return
+	}
+
+	for i, r := range s.replicas.replicas {
+		var lag float64
+		err := r.pool.QueryRow(ctx, query).Scan(&lag)
+
+		healthy := err == nil && (s.replicas.cfg.MaxLag <= 0 || time.Duration(lag*float64(time.Second)) <= s.replicas.cfg.MaxLag)
+		r.retryAt.Store(0)
+		if healthy != r.healthy.Swap(healthy) {
+			log.Printf("Replica %d healthy: %t, lag %.1fs, err: %v", i, healthy, lag, err)
+		}
+	}
+}
+
+// RunReplicaChecks calls CheckReplicas every HealthInterval until ctx is done.
+func (s *PostgresStorage) RunReplicaChecks(ctx context.Context) {
+	if s.replicas == nil || s.replicas.cfg.HealthInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.replicas.cfg.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		s.CheckReplicas(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
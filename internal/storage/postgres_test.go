@@ -2,6 +2,8 @@ package storage_test
 
 import (
 	"context"
+	"errors"
+	"ozonProject/internal/models"
 	"ozonProject/internal/storage"
 	"testing"
 	"time"
@@ -17,27 +19,204 @@ func TestGetPosts_Ok(t *testing.T) {
 	}
 	require.NoError(t, err)
 
-	storage := storage.NewPostgresStorage(mockPool)
+	repo := storage.NewPostgresStorage(mockPool)
 
 	firstTime := time.Now().UTC()
 	secondTime := time.Now().UTC()
 
-	rows := pgxmock.NewRows([]string{"id", "title", "content", "author", "comments_enabled", "created_at"}).
-		AddRow("1", "first post", "Hello", "Yaroslav", true, firstTime).
-		AddRow("2", "second post", "Hi", "Sergey", false, secondTime)
+	rows := pgxmock.NewRows([]string{"id", "title", "content", "author", "comments_enabled", "created_at", "score", "hot_rank", "comment_count", "community", "tags"}).
+		AddRow("1", "first post", "Hello", "Yaroslav", true, firstTime, 3, 1.5, 42, "general", []string{"go"}).
+		AddRow("2", "second post", "Hi", "Sergey", false, secondTime, 0, 1.0, 0, "general", []string{})
 
 	const query = `
-		SELECT id, title, content, author, comments_enabled, created_at
+		SELECT id, title, content, author, comments_enabled, created_at,.+
 		FROM posts
-		ORDER BY id DESC
+		.+
+		ORDER BY created_at DESC, id DESC
 		LIMIT \$1 OFFSET \$2
 	`
 
-	mockPool.ExpectQuery(query).WithArgs(10, 0).WillReturnRows(rows)
-	posts, err := storage.GetPosts(context.Background(), 10, 0)
+	mockPool.ExpectQuery(query).WithArgs(10, 0, "", "", time.Time{}, "").WillReturnRows(rows)
+	posts, err := repo.GetPosts(context.Background(), 10, 0, storage.PostFilter{})
 
 	require.NoError(t, err)
 	require.Equal(t, "1", posts[0].ID)
 	require.Equal(t, "second post", posts[1].Title)
 	require.Equal(t, "Yaroslav", posts[0].Author)
+	require.Equal(t, []string{"go"}, posts[0].Tags)
+	require.Equal(t, "general", posts[0].CommunityName)
+	require.Equal(t, 3, posts[0].Score)
+	require.Equal(t, 42, posts[0].CommentCount)
+}
+
+func TestDeleteComment_UpdatesCountersInTx(t *testing.T) {
+	mockPool, err := pgxmock.NewConn()
+	require.NoError(t, err)
+
+	repo := storage.NewPostgresStorage(mockPool)
+	parentID := "p1"
+
+	mockPool.ExpectBegin()
+	mockPool.ExpectQuery(`UPDATE comments SET deleted_at = NOW\(\)`).
+		WithArgs("c1", "bob", storage.DeletedPlaceholder).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "post_id", "parent_id", "author", "content", "created_at", "reply_count", "depth", "pending", "deleted"}).
+			AddRow("c1", "1", &parentID, storage.DeletedPlaceholder, storage.DeletedPlaceholder, time.Now().UTC(), 0, 1, false, true))
+	mockPool.ExpectExec(`UPDATE posts SET comment_count = comment_count \+ \$2`).
+		WithArgs("1", -1).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockPool.ExpectExec(`UPDATE comments SET reply_count = reply_count \+ \$2`).
+		WithArgs("p1", -1).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockPool.ExpectExec(`INSERT INTO outbox`).WithArgs(pgxmock.AnyArg(), models.OutboxCommentDeleted, "1", pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockPool.ExpectCommit()
+
+	c, err := repo.DeleteComment(context.Background(), "c1", "bob")
+	require.NoError(t, err)
+	require.True(t, c.Deleted)
+	require.Equal(t, storage.DeletedPlaceholder, c.Content)
+	require.NoError(t, mockPool.ExpectationsWereMet())
+}
+
+func TestVotePost_LocksPostBeforeReadingPreviousVote(t *testing.T) {
+	mockPool, err := pgxmock.NewConn()
+	require.NoError(t, err)
+
+	repo := storage.NewPostgresStorage(mockPool)
+
+	mockPool.ExpectBegin()
+	mockPool.ExpectQuery(`SELECT id FROM posts WHERE id = \$1 FOR NO KEY UPDATE`).
+		WithArgs("missing").WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	mockPool.ExpectRollback()
+
+	_, err = repo.VotePost(context.Background(), "missing", "bob", 1)
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+
+	mockPool.ExpectBegin()
+	mockPool.ExpectQuery(`FOR NO KEY UPDATE`).WithArgs("1").WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow("1"))
+	mockPool.ExpectQuery(`WITH previous AS`).WithArgs("1", "bob", 1).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "title", "content", "author", "comments_enabled", "created_at", "score", "hot_rank",
+			"comment_count", "community", "hidden", "deleted", "tags"}).
+			AddRow("1", "t", "c", "alice", true, time.Now().UTC(), 1, 1.0, 0, "general", false, false, []string{}))
+	mockPool.ExpectCommit()
+
+	p, err := repo.VotePost(context.Background(), "1", "bob", 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Score)
+	require.NoError(t, mockPool.ExpectationsWereMet())
+}
+
+var commentColumns = []string{"id", "post_id", "parent_id", "author", "content", "created_at", "reply_count", "depth", "deleted"}
+
+func TestReplicas_ReadsGoToReplicaUntilWrite(t *testing.T) {
+	primary, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	replica, err := pgxmock.NewConn()
+	require.NoError(t, err)
+
+	repo := storage.NewPostgresStorage(primary, storage.WithReplicas(storage.ReplicaConfig{MaxLag: time.Minute}, replica))
+
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err)
+
+	parentID := "p1"
+	primary.ExpectBegin()
+	primary.ExpectQuery(`UPDATE comments SET deleted_at = NOW\(\)`).
+		WithArgs("c1", "bob", storage.DeletedPlaceholder).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "post_id", "parent_id", "author", "content", "created_at", "reply_count", "depth", "pending", "deleted"}).
+			AddRow("c1", "1", &parentID, storage.DeletedPlaceholder, storage.DeletedPlaceholder, time.Now().UTC(), 0, 1, true, true))
+	primary.ExpectCommit()
+	_, err = repo.DeleteComment(context.Background(), "c1", "bob")
+	require.NoError(t, err)
+
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err, "reads of the written post are pinned to the primary")
+
+	replica.ExpectQuery(`FROM comments`).WithArgs("2", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "2", "", 10, 0)
+	require.NoError(t, err, "other posts are still read from the replica")
+
+	primary.ExpectBegin()
+	primary.ExpectQuery(`FOR NO KEY UPDATE`).WithArgs("3").WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow("3"))
+	primary.ExpectQuery(`WITH previous AS`).WithArgs("3", "bob", 1).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "title", "content", "author", "comments_enabled", "created_at", "score", "hot_rank",
+			"comment_count", "community", "hidden", "deleted", "tags"}).
+			AddRow("3", "t", "c", "alice", true, time.Now().UTC(), 1, 1.0, 0, "general", false, false, []string{}))
+	primary.ExpectCommit()
+	_, err = repo.VotePost(context.Background(), "3", "bob", 1)
+	require.NoError(t, err)
+
+	primary.ExpectQuery(`FROM comments`).WithArgs("3", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "3", "", 10, 0)
+	require.NoError(t, err, "a vote pins reads of the post too")
+
+	require.NoError(t, primary.ExpectationsWereMet())
+	require.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestReplicas_RetryFailedReplicaWithoutChecks(t *testing.T) {
+	primary, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	replica, err := pgxmock.NewConn()
+	require.NoError(t, err)
+
+	repo := storage.NewPostgresStorage(primary, storage.WithReplicas(storage.ReplicaConfig{RetryAfter: 10 * time.Millisecond}, replica))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnError(context.Canceled)
+	_, err = repo.GetComments(ctx, "1", "", 10, 0)
+	require.ErrorIs(t, err, context.Canceled)
+
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnError(errors.New("connection refused"))
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err, "a cancelled read leaves the replica in")
+
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	for range 2 {
+		_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+		require.NoError(t, err, "the replica is tried again after RetryAfter and stays in once a read succeeds")
+	}
+
+	require.NoError(t, primary.ExpectationsWereMet())
+	require.NoError(t, replica.ExpectationsWereMet())
+}
+
+func TestReplicas_FallBackToPrimary(t *testing.T) {
+	primary, err := pgxmock.NewConn()
+	require.NoError(t, err)
+	replica, err := pgxmock.NewConn()
+	require.NoError(t, err)
+
+	repo := storage.NewPostgresStorage(primary, storage.WithReplicas(storage.ReplicaConfig{MaxLag: 5 * time.Second}, replica))
+
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnError(errors.New("connection refused"))
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err)
+
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err, "a failed replica is skipped until it passes a check or RetryAfter passes")
+
+	replica.ExpectQuery(`pg_last_wal_replay_lsn`).WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(60.0))
+	repo.CheckReplicas(context.Background())
+	primary.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err, "a lagging replica is skipped")
+
+	replica.ExpectQuery(`pg_last_wal_replay_lsn`).WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(0.5))
+	repo.CheckReplicas(context.Background())
+	replica.ExpectQuery(`FROM comments`).WithArgs("1", 10, 0).WillReturnRows(pgxmock.NewRows(commentColumns))
+	_, err = repo.GetComments(context.Background(), "1", "", 10, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, primary.ExpectationsWereMet())
+	require.NoError(t, replica.ExpectationsWereMet())
 }
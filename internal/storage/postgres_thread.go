@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pathSegmentSQL appends one fixed-width segment per level to comments.path, so
+// ordering by path walks the thread depth-first in creation order.
+const pathSegmentSQL = `lpad(to_hex(nextval('comments_path_seq')), 12, '0') || '.'`
+
+const threadColumns = `d.id, d.post_id, d.parent_id, d.author, d.content, d.created_at, d.reply_count, d.depth, d.deleted_at IS NOT NULL`
+
+func scanThread(rows pgx.Rows) ([]*models.Comment, error) {
+	defer rows.Close()
+
+	var out []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Deleted); err != nil {
+			return nil, err
+		}
+		out = append(out, &c)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	const query = `
+		SELECT ` + threadColumns + `
+		FROM comments d
+		WHERE d.post_id = $1
+		AND ($2 = '' OR (d.path LIKE (SELECT path FROM comments WHERE id = $2 AND post_id = $1) || '%' AND d.id <> $2))
+		AND d.hidden_at IS NULL AND NOT d.pending
+		ORDER BY d.path
+		LIMIT $3 OFFSET $4
+	`
+
+	log.Printf("Get subtree query.")
+
+	rows, err := s.pool.Query(ctx, query, postID, rootID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := scanThread(rows)
+	if err != nil || len(out) > 0 || rootID == "" {
+		return out, err
+	}
+
+	return out, s.commentInPost(ctx, postID, rootID)
+}
+
+// commentInPost returns ErrCommentNotFound unless the post has the comment.
+func (s *PostgresStorage) commentInPost(ctx context.Context, postID, id string) error {
+	const query = `SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND post_id = $2)`
+
+	log.Printf("Comment in post query.")
+
+	var ok bool
+	if err := s.pool.QueryRow(ctx, query, id, postID).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCommentNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetAncestors(ctx context.Context, id string) ([]*models.Comment, error) {
+	const query = `
+		SELECT ` + threadColumns + `
+		FROM comments c
+		JOIN comments d ON d.post_id = c.post_id AND c.path LIKE d.path || '%' AND d.id <> c.id
+		WHERE c.id = $1
+		ORDER BY d.path
+	`
+
+	log.Printf("Get ancestors query.")
+
+	rows, err := s.pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanThread(rows)
+}
+
+func (s *PostgresStorage) CountDescendants(ctx context.Context, id string) (int, error) {
+	const query = `
+		SELECT COUNT(d.id)
+		FROM comments c
+		LEFT JOIN comments d ON d.post_id = c.post_id AND d.path LIKE c.path || '%' AND d.id <> c.id
+			AND d.deleted_at IS NULL AND d.hidden_at IS NULL AND NOT d.pending
+		WHERE c.id = $1
+		GROUP BY c.id
+	`
+
+	log.Printf("Count descendants query.")
+
+	var n int
+	err := s.pool.QueryRow(ctx, query, id).Scan(&n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrCommentNotFound
+	}
+
+	return n, err
+}
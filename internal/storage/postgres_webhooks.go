@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const deliveryColumns = `id, webhook_id, event_id, event, payload, status, attempts, last_status_code, last_error,
+	created_at, next_attempt_at, delivered_at`
+
+func scanDelivery(row pgx.Row) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.LastStatusCode,
+		&d.LastError, &d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func (s *PostgresStorage) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	const query = `
+		INSERT INTO webhooks (id, url, secret, events, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+
+	log.Printf("Create webhook query.")
+
+	w := *webhook
+	w.ID = uuid.New().String()
+	if err := s.pool.QueryRow(ctx, query, w.ID, w.URL, w.Secret, eventNames(w.Events), w.CreatedBy).Scan(&w.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (s *PostgresStorage) GetWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	const query = `SELECT id, url, secret, events, created_by, created_at FROM webhooks ORDER BY created_at ASC, id ASC`
+
+	log.Printf("Get webhooks query.")
+
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		var events []string
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = webhookEvents(events)
+		out = append(out, &w)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteWebhook(ctx context.Context, id string) error {
+	// Deliveries go with the webhook by ON DELETE CASCADE.
+	const query = `DELETE FROM webhooks WHERE id = $1`
+
+	log.Printf("Delete webhook query.")
+
+	tag, err := s.pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	// The join drops deliveries of webhooks deleted in the meantime.
+	const query = `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, payload, next_attempt_at)
+		SELECT d.id, d.webhook_id, d.event_id, d.event, d.payload, NOW()
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::text[]) AS d(id, webhook_id, event_id, event, payload)
+		JOIN webhooks w ON w.id = d.webhook_id
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+	`
+
+	log.Printf("Enqueue webhook deliveries query.")
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	n := len(deliveries)
+	ids, hooks, events, kinds, payloads := make([]string, 0, n), make([]string, 0, n), make([]string, 0, n), make([]string, 0, n), make([]string, 0, n)
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+		hooks = append(hooks, d.WebhookID)
+		events = append(events, d.EventID)
+		kinds = append(kinds, string(d.Event))
+		payloads = append(payloads, d.Payload)
+	}
+
+	_, err := s.pool.Exec(ctx, query, ids, hooks, events, kinds, payloads)
+
+	return err
+}
+
+func (s *PostgresStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	const query = `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+			WHERE seq IN (
+				SELECT seq FROM webhook_deliveries
+				WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+				ORDER BY seq
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING seq, ` + deliveryColumns + `
+		)
+		SELECT ` + deliveryColumns + ` FROM claimed ORDER BY seq
+	`
+
+	log.Printf("Claim webhook deliveries query.")
+
+	rows, err := s.pool.Query(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	const query = `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_status_code = $4, last_error = $5, next_attempt_at = $6, delivered_at = $7
+		WHERE id = $1
+	`
+
+	log.Printf("Update webhook delivery query.")
+
+	tag, err := s.pool.Exec(ctx, query, delivery.ID, string(delivery.Status), delivery.Attempts,
+		delivery.LastStatusCode, delivery.LastError, delivery.NextAttemptAt, delivery.DeliveredAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetWebhookDeliveries(ctx context.Context, filter DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error) {
+	const query = `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries
+		WHERE ($3 = '' OR webhook_id = $3) AND ($4 = '' OR status = $4)
+		ORDER BY seq DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	log.Printf("Get webhook deliveries query.")
+
+	rows, err := s.pool.Query(ctx, query, limit, offset, filter.WebhookID, string(filter.Status))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.WebhookDelivery, 0, limit)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	const query = `
+		UPDATE webhook_deliveries SET status = 'PENDING', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status = 'DEAD'
+		RETURNING ` + deliveryColumns + `
+	`
+
+	log.Printf("Retry webhook delivery query.")
+
+	d, err := scanDelivery(s.pool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+
+	return d, err
+}
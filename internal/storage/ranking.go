@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"math"
+	"ozonProject/internal/models"
+	"sort"
+	"time"
+)
+
+// hotEpoch is the reference point of the Reddit hot formula, kept in sync
+// with post_hot_rank in migrations.
+const hotEpoch = 1134028003
+
+func hotRank(score int, createdAt time.Time) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+
+	var sign float64
+	switch {
+	case score > 0:
+		sign = 1
+	case score < 0:
+		sign = -1
+	}
+
+	seconds := float64(createdAt.UnixNano())/float64(time.Second) - hotEpoch
+
+	return math.Round((sign*order+seconds/45000)*1e7) / 1e7
+}
+
+func hotBefore(a, b *models.Post) bool {
+	if a.HotRank != b.HotRank {
+		return a.HotRank > b.HotRank
+	}
+	return a.ID > b.ID
+}
+
+func topBefore(a, b *models.Post) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// rankedIDs keeps post ids sorted by before. Callers must remove a post
+// before changing the fields before depends on and insert it afterwards.
+type rankedIDs struct {
+	ids    []string
+	before func(a, b *models.Post) bool
+}
+
+func (r *rankedIDs) insert(p *models.Post, byID map[string]*models.Post) {
+	i := sort.Search(len(r.ids), func(i int) bool {
+		return r.before(p, byID[r.ids[i]])
+	})
+
+	r.ids = append(r.ids, "")
+	copy(r.ids[i+1:], r.ids[i:])
+	r.ids[i] = p.ID
+}
+
+func (r *rankedIDs) remove(p *models.Post, byID map[string]*models.Post) {
+	i := sort.Search(len(r.ids), func(i int) bool {
+		return !r.before(byID[r.ids[i]], p)
+	})
+
+	for ; i < len(r.ids); i++ {
+		if r.ids[i] == p.ID {
+			r.ids = append(r.ids[:i], r.ids[i+1:]...)
+			return
+		}
+	}
+}
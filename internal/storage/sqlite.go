@@ -0,0 +1,867 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"ozonProject/internal/models"
+	"ozonProject/internal/utils"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLiteStorage keeps data in an embedded SQLite database, its schema lives
+// in migrations/sqlite. Timestamps are written from Go in UTC, so they
+// compare correctly as text.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{db: db}
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// stringList stores a []string as a JSON array, SQLite has no array type.
+type stringList []string
+
+func (l *stringList) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case nil:
+		*l = []string{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported list value %T", src)
+	}
+}
+
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	raw, err := json.Marshal([]string(l))
+
+	return string(raw), err
+}
+
+func sqliteNow() time.Time {
+	return time.Now().UTC()
+}
+
+// sqlitePostColumns selects a post and its sorted tag names.
+const sqlitePostColumns = `
+		id, title, content, author, comments_enabled, created_at, score, hot_rank, comment_count, community,
+		hidden_at IS NOT NULL, deleted_at IS NOT NULL,
+		(
+			SELECT json_group_array(name) FROM (
+				SELECT t.name FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+				WHERE pt.post_id = posts.id ORDER BY t.name
+			)
+		)`
+
+func scanSQLitePost(row rowScanner) (*models.Post, error) {
+	var p models.Post
+	err := row.Scan(&p.ID, &p.Title, &p.Content, &p.Author, &p.CommentsEnabled, &p.CreatedAt, &p.Score, &p.HotRank, &p.CommentCount,
+		&p.CommunityName, &p.Hidden, &p.Deleted, (*stringList)(&p.Tags))
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (s *SQLiteStorage) getPost(ctx context.Context, q sqlQuerier, id string) (*models.Post, error) {
+	const query = `SELECT ` + sqlitePostColumns + ` FROM posts WHERE id = ?1`
+
+	p, err := scanSQLitePost(q.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPostNotFound
+	}
+
+	return p, err
+}
+
+func (s *SQLiteStorage) CreatePost(ctx context.Context, community, title, content, author string, commentsEnabled bool, tags []string) (*models.Post, error) {
+	const queryCommunity = `SELECT 1 FROM communities WHERE name = ?1`
+	const queryInsert = `
+		INSERT INTO posts (id, title, content, author, comments_enabled, community, created_at, hot_rank)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+	`
+	const queryTag = `INSERT INTO tags (name) VALUES (?1) ON CONFLICT (name) DO NOTHING`
+	const queryLink = `
+		INSERT INTO post_tags (post_id, tag_id)
+		SELECT ?1, id FROM tags WHERE name = ?2
+		ON CONFLICT (post_id, tag_id) DO NOTHING
+	`
+
+	log.Printf("Create post query.")
+
+	id := uuid.New().String()
+	createdAt := sqliteNow()
+
+	sortedTags := append([]string{}, tags...)
+	sort.Strings(sortedTags)
+
+	var p *models.Post
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var found int
+		if err := tx.QueryRowContext(ctx, queryCommunity, community).Scan(&found); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrCommunityNotFound
+			}
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, queryInsert, id, title, content, author, commentsEnabled, community, createdAt, hotRank(0, createdAt))
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range sortedTags {
+			if _, err := tx.ExecContext(ctx, queryTag, tag); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, queryLink, id, tag); err != nil {
+				return err
+			}
+		}
+
+		if err := s.index(ctx, tx, models.SearchTypePost, id, title+" "+content); err != nil {
+			return err
+		}
+
+		p, err = s.getPost(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxPostCreated, p.ID, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s *SQLiteStorage) GetPosts(ctx context.Context, limit, offset int, filter PostFilter) ([]*models.Post, error) {
+	query := `
+		SELECT ` + sqlitePostColumns + `
+		FROM posts
+		WHERE (?3 = '' OR EXISTS (
+			SELECT 1 FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id = posts.id AND t.name = ?3
+		))
+		AND (?4 = '' OR community = ?4)
+		AND created_at >= ?5
+		AND (?6 = '' OR (created_at, id) < (SELECT created_at, id FROM posts WHERE id = ?6))
+		AND hidden_at IS NULL AND deleted_at IS NULL
+		ORDER BY %s
+		LIMIT ?1 OFFSET ?2
+	`
+
+	log.Printf("Get post query.")
+
+	order, ok := postOrders[filter.Sort]
+	if !ok {
+		order = postOrders[models.PostSortNew]
+	}
+	afterID := ""
+	if order == postOrders[models.PostSortNew] {
+		afterID = filter.AfterID
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(query, order), limit, offset, filter.Tag, filter.Community, filter.Since.UTC(), afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Post
+	for rows.Next() {
+		p, err := scanSQLitePost(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) GetPostByID(ctx context.Context, id string) (*models.Post, error) {
+	log.Printf("Get post by id query.")
+
+	return s.getPost(ctx, s.db, id)
+}
+
+func (s *SQLiteStorage) VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error) {
+	const queryPost = `SELECT score, created_at FROM posts WHERE id = ?1`
+	const queryPrevious = `SELECT value FROM post_votes WHERE post_id = ?1 AND voter = ?2`
+	const queryRemove = `DELETE FROM post_votes WHERE post_id = ?1 AND voter = ?2`
+	const queryUpsert = `
+		INSERT INTO post_votes (post_id, voter, value) VALUES (?1, ?2, ?3)
+		ON CONFLICT (post_id, voter) DO UPDATE SET value = excluded.value
+	`
+	const queryScore = `UPDATE posts SET score = ?2, hot_rank = ?3 WHERE id = ?1`
+
+	log.Printf("Vote post query.")
+
+	var p *models.Post
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var (
+			score     int
+			createdAt time.Time
+		)
+		if err := tx.QueryRowContext(ctx, queryPost, postID).Scan(&score, &createdAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrPostNotFound
+			}
+			return err
+		}
+
+		var previous int
+		err := tx.QueryRowContext(ctx, queryPrevious, postID, voter).Scan(&previous)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if value == 0 {
+			_, err = tx.ExecContext(ctx, queryRemove, postID, voter)
+		} else {
+			_, err = tx.ExecContext(ctx, queryUpsert, postID, voter, value)
+		}
+		if err != nil {
+			return err
+		}
+
+		score += value - previous
+		if _, err := tx.ExecContext(ctx, queryScore, postID, score, hotRank(score, createdAt)); err != nil {
+			return err
+		}
+
+		p, err = s.getPost(ctx, tx, postID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s *SQLiteStorage) GetTags(ctx context.Context, limit int) ([]*models.Tag, error) {
+	const query = `
+		SELECT t.name, COUNT(pt.post_id) AS post_count
+		FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.id
+		JOIN posts p ON p.id = pt.post_id
+		WHERE p.hidden_at IS NULL AND p.deleted_at IS NULL
+		GROUP BY t.name
+		ORDER BY post_count DESC, t.name ASC
+		LIMIT ?1
+	`
+
+	log.Printf("Get tags query.")
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.PostCount); err != nil {
+			return nil, err
+		}
+		out = append(out, &t)
+	}
+
+	return out, rows.Err()
+}
+
+const sqliteCommunityColumns = `name, description, rules, moderators, comments_enabled, max_comment_length, created_at`
+
+func scanSQLiteCommunity(row rowScanner) (*models.Community, error) {
+	var c models.Community
+	err := row.Scan(&c.Name, &c.Description, (*stringList)(&c.Rules), (*stringList)(&c.Moderators), &c.CommentsEnabled,
+		&c.MaxCommentLength, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *SQLiteStorage) CreateCommunity(ctx context.Context, community *models.Community) (*models.Community, error) {
+	const query = `
+		INSERT INTO communities (name, description, rules, moderators, comments_enabled, max_comment_length, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING ` + sqliteCommunityColumns
+
+	log.Printf("Create community query.")
+
+	c, err := scanSQLiteCommunity(s.db.QueryRowContext(ctx, query, community.Name, community.Description,
+		stringList(community.Rules), stringList(community.Moderators), community.CommentsEnabled, community.MaxCommentLength, sqliteNow()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCommunityExists
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *SQLiteStorage) GetCommunity(ctx context.Context, name string) (*models.Community, error) {
+	const query = `SELECT ` + sqliteCommunityColumns + ` FROM communities WHERE name = ?1`
+
+	log.Printf("Get community query.")
+
+	c, err := scanSQLiteCommunity(s.db.QueryRowContext(ctx, query, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCommunityNotFound
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *SQLiteStorage) GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error) {
+	const query = `
+		SELECT ` + sqliteCommunityColumns + `
+		FROM communities
+		ORDER BY created_at ASC, name ASC
+		LIMIT ?1 OFFSET ?2
+	`
+
+	log.Printf("Get communities query.")
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Community
+	for rows.Next() {
+		c, err := scanSQLiteCommunity(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+
+	return out, rows.Err()
+}
+
+const sqliteCommentColumns = `id, post_id, parent_id, author, content, created_at, reply_count, depth, pending,
+	hidden_at IS NOT NULL, deleted_at IS NOT NULL`
+
+func (s *SQLiteStorage) getComment(ctx context.Context, q sqlQuerier, id string) (*models.Comment, error) {
+	const query = `SELECT ` + sqliteCommentColumns + ` FROM comments WHERE id = ?1`
+
+	var c models.Comment
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Pending, &c.Hidden, &c.Deleted,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *SQLiteStorage) CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error) {
+	if err := s.EnsureCommentsEnabled(ctx, postID); err != nil {
+		return nil, err
+	}
+
+	const queryParent = `SELECT post_id, depth + 1, path FROM comments WHERE id = ?1`
+	const querySeq = `UPDATE sequences SET value = value + 1 WHERE name = 'comments_path_seq' RETURNING value`
+	const queryInsert = `
+		INSERT INTO comments (id, post_id, parent_id, author, content, pending, depth, path, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+	`
+
+	log.Printf("Create comment query.")
+
+	id := uuid.New().String()
+
+	var c *models.Comment
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		depth, parentPath := 0, ""
+		if parentID != "" {
+			var parentPostID string
+			if err := tx.QueryRowContext(ctx, queryParent, parentID).Scan(&parentPostID, &depth, &parentPath); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrParentNotFound
+				}
+				return err
+			}
+			if parentPostID != postID {
+				return ErrParentInOtherPost
+			}
+		}
+
+		var seq int64
+		if err := tx.QueryRowContext(ctx, querySeq).Scan(&seq); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, queryInsert, id, postID, parentID, author, content, pending, depth,
+			parentPath+pathSegment(seq), sqliteNow())
+		if err != nil {
+			return err
+		}
+
+		if !pending {
+			if err := s.addCommentCounters(ctx, tx, postID, parentID, 1); err != nil {
+				return err
+			}
+			if err := s.index(ctx, tx, models.SearchTypeComment, id, content); err != nil {
+				return err
+			}
+		}
+
+		if c, err = s.getComment(ctx, tx, id); err != nil || pending {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentPublished, c.PostID, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// addCommentCounters moves the denormalized post and parent counters by delta.
+func (s *SQLiteStorage) addCommentCounters(ctx context.Context, tx *sql.Tx, postID, parentID string, delta int) error {
+	const queryPost = `UPDATE posts SET comment_count = comment_count + ?2 WHERE id = ?1`
+	const queryParent = `UPDATE comments SET reply_count = reply_count + ?2 WHERE id = ?1`
+
+	log.Printf("Update comment counters query.")
+
+	if _, err := tx.ExecContext(ctx, queryPost, postID, delta); err != nil {
+		return err
+	}
+
+	if parentID != "" {
+		if _, err := tx.ExecContext(ctx, queryParent, parentID, delta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteComment(ctx context.Context, id, author string) (*models.Comment, error) {
+	const queryDelete = `
+		UPDATE comments
+		SET deleted_at = ?4, author = ?3, content = ?3
+		WHERE id = ?1 AND deleted_at IS NULL AND (?2 = '' OR author = ?2)
+	`
+
+	log.Printf("Delete comment query.")
+
+	var c *models.Comment
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryDelete, id, author, DeletedPlaceholder, sqliteNow())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrCommentNotFound
+		}
+
+		c, err = s.getComment(ctx, tx, id)
+		if err != nil || c.Pending {
+			return err
+		}
+
+		if err := s.addCommentCounters(ctx, tx, c.PostID, utils.ValueOrDefault(c.ParentID, ""), -1); err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentDeleted, c.PostID, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *SQLiteStorage) ApproveComment(ctx context.Context, id string) (*models.Comment, error) {
+	const queryApprove = `UPDATE comments SET pending = FALSE WHERE id = ?1 AND pending AND deleted_at IS NULL`
+
+	log.Printf("Approve comment query.")
+
+	var c *models.Comment
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryApprove, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrCommentNotFound
+		}
+
+		if c, err = s.getComment(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := s.index(ctx, tx, models.SearchTypeComment, c.ID, c.Content); err != nil {
+			return err
+		}
+
+		if err := s.addCommentCounters(ctx, tx, c.PostID, utils.ValueOrDefault(c.ParentID, ""), 1); err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxCommentPublished, c.PostID, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *SQLiteStorage) ReconcileCounters(ctx context.Context) (int64, error) {
+	const queryPosts = `
+		UPDATE posts SET comment_count = counted.n
+		FROM (
+			SELECT p.id, COUNT(c.id) AS n
+			FROM posts p LEFT JOIN comments c ON c.post_id = p.id AND c.deleted_at IS NULL AND NOT c.pending
+			GROUP BY p.id
+		) counted
+		WHERE posts.id = counted.id AND posts.comment_count <> counted.n
+	`
+	const queryComments = `
+		UPDATE comments SET reply_count = counted.n
+		FROM (
+			SELECT c.id, COUNT(r.id) AS n
+			FROM comments c LEFT JOIN comments r ON r.parent_id = c.id AND r.deleted_at IS NULL AND NOT r.pending
+			GROUP BY c.id
+		) counted
+		WHERE comments.id = counted.id AND comments.reply_count <> counted.n
+	`
+
+	log.Printf("Reconcile counters query.")
+
+	var fixed int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, query := range []string{queryPosts, queryComments} {
+			res, err := tx.ExecContext(ctx, query)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			fixed += n
+		}
+		return nil
+	})
+
+	return fixed, err
+}
+
+func (s *SQLiteStorage) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetCommentByID(ctx context.Context, id string) (*models.Comment, error) {
+	log.Printf("Get comment by id query.")
+
+	return s.getComment(ctx, s.db, id)
+}
+
+func (s *SQLiteStorage) GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error) {
+	const query = `
+		SELECT id, post_id, parent_id, author, content, created_at, reply_count, depth, deleted_at IS NOT NULL
+		FROM comments
+		WHERE post_id = ?1 AND parent_id = ?4 AND hidden_at IS NULL AND NOT pending
+		ORDER BY path ASC
+		LIMIT ?2 OFFSET ?3
+	`
+
+	log.Printf("Get comments query.")
+
+	rows, err := s.db.QueryContext(ctx, query, postID, limit, offset, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Deleted); err != nil {
+			return nil, err
+		}
+		out = append(out, &c)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) EnsureCommentsEnabled(ctx context.Context, postID string) error {
+	const query = `SELECT comments_enabled FROM posts WHERE id = ?1`
+
+	log.Printf("Enable comments query.")
+
+	var enabled bool
+	if err := s.db.QueryRowContext(ctx, query, postID).Scan(&enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPostNotFound
+		}
+		return err
+	}
+
+	if !enabled {
+		return ErrCommentsDisabled
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error) {
+	const queryReserve = `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (key) DO UPDATE
+			SET response = NULL, request_hash = excluded.request_hash, expires_at = excluded.expires_at
+			WHERE idempotency_keys.expires_at < ?4
+		RETURNING key
+	`
+
+	log.Printf("Reserve idempotency key query.")
+
+	now := sqliteNow()
+
+	var reserved string
+	err := s.db.QueryRowContext(ctx, queryReserve, key, hash, now.Add(lease), now).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	const queryResponse = `SELECT response, request_hash FROM idempotency_keys WHERE key = ?1`
+
+	var (
+		response []byte
+		stored   string
+	)
+	if err := s.db.QueryRowContext(ctx, queryResponse, key).Scan(&response, &stored); err != nil {
+		return nil, err
+	}
+
+	return storedIdempotencyResponse(response, stored, hash)
+}
+
+func (s *SQLiteStorage) CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	const query = `UPDATE idempotency_keys SET response = ?2, expires_at = ?3 WHERE key = ?1`
+
+	log.Printf("Complete idempotency key query.")
+
+	_, err := s.db.ExecContext(ctx, query, key, response, sqliteNow().Add(ttl))
+
+	return err
+}
+
+func (s *SQLiteStorage) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	const query = `DELETE FROM idempotency_keys WHERE key = ?1 AND response IS NULL`
+
+	log.Printf("Release idempotency key query.")
+
+	_, err := s.db.ExecContext(ctx, query, key)
+
+	return err
+}
+
+func (s *SQLiteStorage) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	const query = `DELETE FROM idempotency_keys WHERE expires_at < ?1`
+
+	log.Printf("Purge idempotency keys query.")
+
+	res, err := s.db.ExecContext(ctx, query, sqliteNow())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+const sqliteNotificationColumns = `id, recipient, type, actor, post_id, comment_id, read_at IS NOT NULL, created_at`
+
+func scanSQLiteNotification(row rowScanner) (*models.Notification, error) {
+	var n models.Notification
+	err := row.Scan(&n.ID, &n.Recipient, &n.Type, &n.Actor, &n.PostID, &n.CommentID, &n.Read, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (s *SQLiteStorage) CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error) {
+	const query = `
+		INSERT INTO notifications (id, recipient, type, actor, post_id, comment_id, created_at)
+		SELECT ?1, target.author, 'REPLY', ?2, ?3, ?4, ?6
+		FROM (
+			SELECT author FROM comments WHERE id = ?5 AND deleted_at IS NULL
+			UNION ALL
+			SELECT author FROM posts WHERE id = ?3 AND ?5 = ''
+		) target
+		WHERE target.author <> ?2
+		RETURNING ` + sqliteNotificationColumns
+
+	log.Printf("Create reply notification query.")
+
+	n, err := scanSQLiteNotification(s.db.QueryRowContext(ctx, query,
+		uuid.New().String(), reply.Author, reply.PostID, reply.ID, utils.ValueOrDefault(reply.ParentID, ""), sqliteNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return n, err
+}
+
+func (s *SQLiteStorage) CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error) {
+	const query = `
+		INSERT INTO notifications (id, recipient, type, actor, post_id, comment_id, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+	`
+
+	if len(notifications) == 0 {
+		return []*models.Notification{}, nil
+	}
+
+	log.Printf("Create notifications query.")
+
+	now := sqliteNow()
+	out := make([]*models.Notification, 0, len(notifications))
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, n := range notifications {
+			stored := *n
+			stored.ID = uuid.New().String()
+			stored.CreatedAt = now
+			stored.Read = false
+
+			_, err := tx.ExecContext(ctx, query, stored.ID, stored.Recipient, string(stored.Type), stored.Actor,
+				stored.PostID, stored.CommentID, now)
+			if err != nil {
+				return err
+			}
+			out = append(out, &stored)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *SQLiteStorage) GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error) {
+	const query = `
+		SELECT ` + sqliteNotificationColumns + `
+		FROM notifications
+		WHERE recipient = ?1 AND (NOT ?2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?3 OFFSET ?4
+	`
+
+	log.Printf("Get notifications query.")
+
+	rows, err := s.db.QueryContext(ctx, query, recipient, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Notification, 0, limit)
+	for rows.Next() {
+		n, err := scanSQLiteNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error) {
+	const query = `
+		UPDATE notifications SET read_at = ?3
+		WHERE recipient = ?1 AND read_at IS NULL
+		AND (json_array_length(?2) = 0 OR id IN (SELECT value FROM json_each(?2)))
+	`
+
+	log.Printf("Mark notifications read query.")
+
+	res, err := s.db.ExecContext(ctx, query, recipient, stringList(ids), sqliteNow())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
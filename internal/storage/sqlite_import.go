@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+	"ozonProject/internal/utils"
+	"sort"
+)
+
+// sqliteFlagTime is stored in hidden_at and deleted_at of imported rows,
+// the original time is not part of the dump.
+func sqliteFlagTime(set bool) any {
+	if !set {
+		return nil
+	}
+
+	return sqliteNow()
+}
+
+func (s *SQLiteStorage) ImportPost(ctx context.Context, post *models.Post) error {
+	const queryCommunity = `SELECT 1 FROM communities WHERE name = ?1`
+	const queryInsert = `
+		INSERT INTO posts (id, title, content, author, comments_enabled, community, created_at, score, hot_rank, hidden_at, deleted_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)
+		ON CONFLICT (id) DO NOTHING
+	`
+	const queryTag = `INSERT INTO tags (name) VALUES (?1) ON CONFLICT (name) DO NOTHING`
+	const queryLink = `
+		INSERT INTO post_tags (post_id, tag_id)
+		SELECT ?1, id FROM tags WHERE name = ?2
+		ON CONFLICT (post_id, tag_id) DO NOTHING
+	`
+
+	log.Printf("Import post query.")
+
+	createdAt := post.CreatedAt.UTC()
+
+	sortedTags := append([]string{}, post.Tags...)
+	sort.Strings(sortedTags)
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var found int
+		if err := tx.QueryRowContext(ctx, queryCommunity, post.CommunityName).Scan(&found); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrCommunityNotFound
+			}
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, queryInsert, post.ID, post.Title, post.Content, post.Author, post.CommentsEnabled,
+			post.CommunityName, createdAt, post.Score, hotRank(post.Score, createdAt), sqliteFlagTime(post.Hidden), sqliteFlagTime(post.Deleted))
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrPostExists
+		}
+
+		for _, tag := range sortedTags {
+			if _, err := tx.ExecContext(ctx, queryTag, tag); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, queryLink, post.ID, tag); err != nil {
+				return err
+			}
+		}
+
+		return s.index(ctx, tx, models.SearchTypePost, post.ID, post.Title+" "+post.Content)
+	})
+}
+
+func (s *SQLiteStorage) ImportComment(ctx context.Context, comment *models.Comment) error {
+	const queryPost = `SELECT 1 FROM posts WHERE id = ?1`
+	const queryParent = `SELECT post_id, depth + 1, path FROM comments WHERE id = ?1`
+	const querySeq = `UPDATE sequences SET value = value + 1 WHERE name = 'comments_path_seq' RETURNING value`
+	const queryInsert = `
+		INSERT INTO comments (id, post_id, parent_id, author, content, pending, depth, path, created_at, hidden_at, deleted_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	log.Printf("Import comment query.")
+
+	parentID := utils.ValueOrDefault(comment.ParentID, "")
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var found int
+		if err := tx.QueryRowContext(ctx, queryPost, comment.PostID).Scan(&found); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrPostNotFound
+			}
+			return err
+		}
+
+		depth, parentPath := 0, ""
+		if parentID != "" {
+			var parentPostID string
+			if err := tx.QueryRowContext(ctx, queryParent, parentID).Scan(&parentPostID, &depth, &parentPath); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrParentNotFound
+				}
+				return err
+			}
+			if parentPostID != comment.PostID {
+				return ErrParentInOtherPost
+			}
+		}
+
+		var seq int64
+		if err := tx.QueryRowContext(ctx, querySeq).Scan(&seq); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, queryInsert, comment.ID, comment.PostID, parentID, comment.Author, comment.Content,
+			comment.Pending, depth, parentPath+pathSegment(seq), comment.CreatedAt.UTC(),
+			sqliteFlagTime(comment.Hidden), sqliteFlagTime(comment.Deleted))
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrCommentExists
+		}
+
+		if comment.Pending {
+			return nil
+		}
+		if !comment.Deleted {
+			if err := s.addCommentCounters(ctx, tx, comment.PostID, parentID, 1); err != nil {
+				return err
+			}
+		}
+
+		return s.index(ctx, tx, models.SearchTypeComment, comment.ID, comment.Content)
+	})
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func (s *SQLiteStorage) HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error {
+	query := `UPDATE posts SET hidden_at = COALESCE(hidden_at, ?2) WHERE id = ?1`
+	if targetType == models.ReportTargetTypeComment {
+		query = `UPDATE comments SET hidden_at = COALESCE(hidden_at, ?2) WHERE id = ?1`
+	}
+
+	log.Printf("Hide content query.")
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		// Only visible content is announced, hiding it again, or hiding
+		// deleted and pending content, just sets hidden_at.
+		if targetType == models.ReportTargetTypeComment {
+			c, err := s.getComment(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, query, id, sqliteNow()); err != nil {
+				return err
+			}
+			if c.Hidden || c.Deleted || c.Pending {
+				return nil
+			}
+			c.Hidden = true
+			return s.insertOutboxEvent(ctx, tx, models.OutboxCommentHidden, c.PostID, c)
+		}
+
+		p, err := s.getPost(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, id, sqliteNow()); err != nil {
+			return err
+		}
+		if p.Hidden || p.Deleted {
+			return nil
+		}
+		p.Hidden = true
+		return s.insertOutboxEvent(ctx, tx, models.OutboxPostHidden, p.ID, p)
+	})
+}
+
+func (s *SQLiteStorage) DeletePost(ctx context.Context, id, author string) (*models.Post, error) {
+	const query = `
+		UPDATE posts
+		SET deleted_at = ?4, title = ?3, content = ?3, author = ?3
+		WHERE id = ?1 AND deleted_at IS NULL AND (?2 = '' OR author = ?2)
+	`
+
+	log.Printf("Delete post query.")
+
+	var p *models.Post
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, id, author, DeletedPlaceholder, sqliteNow())
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrPostNotFound
+		}
+
+		p, err = s.getPost(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return s.insertOutboxEvent(ctx, tx, models.OutboxPostDeleted, p.ID, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s *SQLiteStorage) CreateReport(ctx context.Context, report *models.Report) (*models.Report, error) {
+	const queryInsert = `
+		INSERT INTO reports (id, target_type, target_id, target_author, community, reporter, reason, created_at)
+		SELECT ?1, ?2, target.id, target.author, target.community, ?4, ?5, ?6
+		FROM (
+			SELECT p.id, p.author, p.community FROM posts p
+			WHERE ?2 = 'POST' AND p.id = ?3 AND p.deleted_at IS NULL AND p.hidden_at IS NULL
+			UNION ALL
+			SELECT c.id, c.author, p.community FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE ?2 = 'COMMENT' AND c.id = ?3 AND c.deleted_at IS NULL AND c.hidden_at IS NULL
+		) target
+		WHERE TRUE
+		ON CONFLICT (target_type, target_id, reporter) DO NOTHING
+	`
+	const queryID = `SELECT id FROM reports WHERE target_type = ?1 AND target_id = ?2 AND reporter = ?3`
+
+	log.Printf("Create report query.")
+
+	var id string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, queryInsert, uuid.New().String(), string(report.TargetType), report.TargetID,
+			report.Reporter, report.Reason, sqliteNow())
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, queryID, string(report.TargetType), report.TargetID, report.Reporter).Scan(&id)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			if report.TargetType == models.ReportTargetTypeComment {
+				return nil, ErrCommentNotFound
+			}
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	return s.GetReport(ctx, id)
+}
+
+func (s *SQLiteStorage) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	const query = `SELECT ` + reportColumns + ` FROM reports WHERE id = ?1`
+
+	log.Printf("Get report query.")
+
+	r, err := scanReport(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.loadDecisions(ctx, []*models.Report{r}); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *SQLiteStorage) GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error) {
+	const query = `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE community = ?1 AND status = ?2
+		ORDER BY created_at ASC, id ASC
+		LIMIT ?3 OFFSET ?4
+	`
+
+	log.Printf("Get reports query.")
+
+	rows, err := s.db.QueryContext(ctx, query, community, string(status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Report, 0, limit)
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.loadDecisions(ctx, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// loadDecisions fills the audit trail of reports with a single query.
+func (s *SQLiteStorage) loadDecisions(ctx context.Context, reports []*models.Report) error {
+	const query = `
+		SELECT id, report_id, moderator, action, note, created_at
+		FROM moderation_decisions
+		WHERE report_id IN (SELECT value FROM json_each(?1))
+		ORDER BY created_at ASC, id ASC
+	`
+
+	if len(reports) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.Report, len(reports))
+	ids := make([]string, 0, len(reports))
+	for _, r := range reports {
+		byID[r.ID] = r
+		ids = append(ids, r.ID)
+	}
+
+	log.Printf("Get moderation decisions query.")
+
+	rows, err := s.db.QueryContext(ctx, query, stringList(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.ModerationDecision
+		if err := rows.Scan(&d.ID, &d.ReportID, &d.Moderator, &d.Action, &d.Note, &d.CreatedAt); err != nil {
+			return err
+		}
+		r := byID[d.ReportID]
+		r.Decisions = append(r.Decisions, &d)
+	}
+
+	return rows.Err()
+}
+
+func (s *SQLiteStorage) ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error) {
+	const queryStatus = `UPDATE reports SET status = ?2 WHERE id = ?1 AND status = 'OPEN'`
+	const queryExists = `SELECT 1 FROM reports WHERE id = ?1`
+	const queryDecision = `
+		INSERT INTO moderation_decisions (id, report_id, moderator, action, note, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+	`
+
+	log.Printf("Resolve report query.")
+
+	if decision.ID == "" {
+		decision.ID = uuid.New().String()
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryStatus, decision.ReportID, string(status))
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			var exists int
+			if err := tx.QueryRowContext(ctx, queryExists, decision.ReportID).Scan(&exists); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrReportNotFound
+				}
+				return err
+			}
+			return ErrReportResolved
+		}
+
+		_, err = tx.ExecContext(ctx, queryDecision,
+			decision.ID, decision.ReportID, decision.Moderator, string(decision.Action), decision.Note, sqliteNow())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetReport(ctx, decision.ReportID)
+}
+
+func (s *SQLiteStorage) ReopenReport(ctx context.Context, reportID, decisionID string) error {
+	const queryDecision = `DELETE FROM moderation_decisions WHERE id = ?1 AND report_id = ?2`
+	const queryStatus = `UPDATE reports SET status = 'OPEN' WHERE id = ?1`
+
+	log.Printf("Reopen report query.")
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, queryDecision, decisionID, reportID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, queryStatus, reportID)
+		return err
+	})
+}
+
+func (s *SQLiteStorage) BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error) {
+	const query = `
+		INSERT INTO bans (id, user_id, scope, scope_id, reason, created_by, created_at, expires_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT (user_id, scope, scope_id) DO UPDATE
+		SET reason = excluded.reason, created_by = excluded.created_by,
+			created_at = excluded.created_at, expires_at = excluded.expires_at
+		RETURNING ` + banColumns + `
+	`
+
+	log.Printf("Ban user query.")
+
+	var until any
+	if ban.Until != nil {
+		until = ban.Until.UTC()
+	}
+
+	return scanBan(s.db.QueryRowContext(ctx, query, uuid.New().String(),
+		ban.UserID, string(ban.Scope), ban.ScopeID, ban.Reason, ban.CreatedBy, sqliteNow(), until))
+}
+
+func (s *SQLiteStorage) ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error) {
+	const query = `
+		SELECT ` + banColumns + `
+		FROM bans
+		WHERE user_id = ?1
+		AND (scope = 'GLOBAL'
+			OR (scope = 'COMMUNITY' AND scope_id = ?2 AND ?2 <> '')
+			OR (scope = 'POST' AND scope_id = ?3 AND ?3 <> ''))
+		AND (expires_at IS NULL OR expires_at > ?4)
+		ORDER BY expires_at DESC NULLS FIRST
+		LIMIT 1
+	`
+
+	log.Printf("Active ban query.")
+
+	b, err := scanBan(s.db.QueryRowContext(ctx, query, user, community, postID, sqliteNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return b, err
+}
+
+func (s *SQLiteStorage) GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error) {
+	const query = `
+		SELECT ` + banColumns + `
+		FROM bans
+		WHERE scope = ?1 AND scope_id = ?2 AND (expires_at IS NULL OR expires_at > ?5)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?3 OFFSET ?4
+	`
+
+	log.Printf("Get bans query.")
+
+	rows, err := s.db.QueryContext(ctx, query, string(scope), scopeID, limit, offset, sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.Ban, 0, limit)
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"ozonProject/internal/models"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// insertOutboxEvent stores an event of the entity in the transaction that changed it.
+func (s *SQLiteStorage) insertOutboxEvent(ctx context.Context, tx *sql.Tx, typ models.OutboxEventType, key string, entity any) error {
+	const query = `
+		INSERT INTO outbox (id, type, key, payload, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5)
+	`
+
+	log.Printf("Insert outbox event query.")
+
+	ev, err := outboxEvent(uuid.New().String(), typ, key, entity, sqliteNow())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, ev.ID, ev.Type, ev.Key, string(ev.Payload), ev.CreatedAt)
+
+	return err
+}
+
+func (s *SQLiteStorage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	// Writers are serialized by SQLite, so a single UPDATE claims a batch
+	// no other dispatcher can see.
+	const query = `
+		UPDATE outbox SET locked_until = ?3, attempts = attempts + 1
+		WHERE seq IN (
+			SELECT seq FROM outbox
+			WHERE locked_until IS NULL OR locked_until <= ?2
+			ORDER BY seq
+			LIMIT ?1
+		)
+		RETURNING seq, id, type, key, payload, attempts, created_at
+	`
+
+	log.Printf("Claim outbox events query.")
+
+	now := sqliteNow()
+	rows, err := s.db.QueryContext(ctx, query, limit, now, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type claimed struct {
+		seq int64
+		ev  *models.OutboxEvent
+	}
+
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		var ev models.OutboxEvent
+		var payload string
+		if err := rows.Scan(&c.seq, &ev.ID, &ev.Type, &ev.Key, &payload, &ev.Attempts, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		ev.Payload = []byte(payload)
+		c.ev = &ev
+		batch = append(batch, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	sort.Slice(batch, func(i, j int) bool { return batch[i].seq < batch[j].seq })
+
+	out := make([]*models.OutboxEvent, 0, len(batch))
+	for _, c := range batch {
+		out = append(out, c.ev)
+	}
+
+	return out, nil
+}
+
+func (s *SQLiteStorage) AckOutboxEvents(ctx context.Context, ids []string) error {
+	const query = `DELETE FROM outbox WHERE id IN (SELECT value FROM json_each(?1))`
+
+	log.Printf("Ack outbox events query.")
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, query, stringList(ids))
+
+	return err
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"ozonProject/internal/models"
+)
+
+// index adds the document to the search tables, the SQLite counterpart of
+// searchIndex.add.
+func (s *SQLiteStorage) index(ctx context.Context, tx *sql.Tx, kind models.SearchType, id, text string) error {
+	const queryDocument = `
+		INSERT INTO search_documents (kind, doc_id, length) VALUES (?1, ?2, ?3)
+		ON CONFLICT (kind, doc_id) DO UPDATE SET length = excluded.length
+	`
+	const queryTerm = `
+		INSERT INTO search_terms (term, kind, doc_id, freq) VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (term, kind, doc_id) DO UPDATE SET freq = excluded.freq
+	`
+
+	terms := tokenize(text)
+	freqs := make(map[string]int, len(terms))
+	for _, term := range terms {
+		freqs[term]++
+	}
+
+	log.Printf("Index search document query.")
+
+	if _, err := tx.ExecContext(ctx, queryDocument, string(kind), id, len(terms)); err != nil {
+		return err
+	}
+
+	for term, freq := range freqs {
+		if _, err := tx.ExecContext(ctx, queryTerm, term, string(kind), id, freq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type weightedTerm struct {
+	Term string  `json:"term"`
+	IDF  float64 `json:"idf"`
+}
+
+// Search ranks documents like InMemoryStorage does. Inverse document
+// frequencies are computed here because SQLite may be built without ln().
+func (s *SQLiteStorage) Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error) {
+	const queryStats = `
+		SELECT term, COUNT(*), (SELECT COUNT(*) FROM search_documents)
+		FROM search_terms
+		WHERE term IN (SELECT value FROM json_each(?1))
+		GROUP BY term
+	`
+	const querySearch = `
+		WITH q AS (
+			SELECT json_extract(value, '$.term') AS term, json_extract(value, '$.idf') AS idf FROM json_each(?1)
+		)
+		SELECT st.kind, st.doc_id, SUM(CAST(st.freq AS REAL) / sd.length * q.idf) AS rank
+		FROM q
+		JOIN search_terms st ON st.term = q.term
+		JOIN search_documents sd ON sd.kind = st.kind AND sd.doc_id = st.doc_id
+		LEFT JOIN posts p ON st.kind = 'POST' AND p.id = st.doc_id
+		LEFT JOIN comments c ON st.kind = 'COMMENT' AND c.id = st.doc_id
+		WHERE ?2 IN ('ALL', st.kind)
+		AND (st.kind <> 'POST' OR (p.hidden_at IS NULL AND p.deleted_at IS NULL))
+		AND (st.kind <> 'COMMENT' OR (c.deleted_at IS NULL AND c.hidden_at IS NULL AND NOT c.pending))
+		GROUP BY st.kind, st.doc_id
+		HAVING COUNT(*) = ?3
+		ORDER BY rank DESC, st.doc_id ASC
+		LIMIT ?4 OFFSET ?5
+	`
+
+	terms := uniqueTerms(query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	log.Printf("Search query.")
+
+	rows, err := s.db.QueryContext(ctx, queryStats, stringList(terms))
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(map[string]int, len(terms))
+	var total int
+	for rows.Next() {
+		var (
+			term string
+			n    int
+		)
+		if err := rows.Scan(&term, &n, &total); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		docs[term] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	weighted := make([]weightedTerm, 0, len(terms))
+	for _, term := range terms {
+		weighted = append(weighted, weightedTerm{Term: term, IDF: math.Log(1 + float64(total)/float64(docs[term]+1))})
+	}
+	raw, err := json.Marshal(weighted)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, querySearch, string(raw), string(kind), len(terms), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []scoredDoc
+	for rows.Next() {
+		var hit scoredDoc
+		if err := rows.Scan(&hit.key.kind, &hit.key.id, &hit.rank); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]*models.SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		res := &models.SearchResult{Type: hit.key.kind, Rank: hit.rank}
+
+		switch hit.key.kind {
+		case models.SearchTypePost:
+			p, err := s.getPost(ctx, s.db, hit.key.id)
+			if err != nil {
+				return nil, err
+			}
+			res.Post = p
+			res.Snippet = highlight(p.Content, terms)
+			if !containsAny(p.Content, terms) {
+				res.Snippet = highlight(p.Title, terms)
+			}
+		case models.SearchTypeComment:
+			c, err := s.getComment(ctx, s.db, hit.key.id)
+			if err != nil {
+				return nil, err
+			}
+			res.Comment = c
+			res.Snippet = highlight(c.Content, terms)
+		}
+
+		out = append(out, res)
+	}
+
+	return out, nil
+}
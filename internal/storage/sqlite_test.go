@@ -0,0 +1,227 @@
+package storage_test
+
+import (
+	"context"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"ozonProject/pkg/sqlite"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteStorage(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+
+	db, err := sqlite.New(filepath.Join(t.TempDir(), "ozon.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.DB.Close() })
+
+	return storage.NewSQLiteStorage(db.DB)
+}
+
+func TestSQLitePosts_SortingAndTags(t *testing.T) {
+	t.Parallel()
+	repo := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	older, err := repo.CreatePost(ctx, "general", "older", "c", "alice", true, []string{"go", "db"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"db", "go"}, older.Tags)
+	require.Equal(t, "general", older.CommunityName)
+	newer, err := repo.CreatePost(ctx, "general", "newer", "c", "alice", true, []string{"go"})
+	require.NoError(t, err)
+
+	for _, voter := range []string{"a", "b"} {
+		_, err := repo.VotePost(ctx, older.ID, voter, 1)
+		require.NoError(t, err)
+	}
+	p, err := repo.VotePost(ctx, older.ID, "a", -1)
+	require.NoError(t, err)
+	require.Equal(t, 0, p.Score)
+	p, err = repo.VotePost(ctx, older.ID, "a", 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, p.Score)
+
+	titles := func(filter storage.PostFilter) []string {
+		posts, err := repo.GetPosts(ctx, 10, 0, filter)
+		require.NoError(t, err)
+		var out []string
+		for _, p := range posts {
+			out = append(out, p.Title)
+		}
+		return out
+	}
+
+	require.Equal(t, []string{"newer", "older"}, titles(storage.PostFilter{Sort: models.PostSortNew}))
+	require.Equal(t, []string{"older", "newer"}, titles(storage.PostFilter{Sort: models.PostSortTop}))
+	require.Equal(t, []string{"older", "newer"}, titles(storage.PostFilter{Sort: models.PostSortHot}))
+	require.Equal(t, []string{"older"}, titles(storage.PostFilter{Tag: "db"}))
+	require.Empty(t, titles(storage.PostFilter{Since: time.Now().Add(time.Hour)}))
+
+	tags, err := repo.GetTags(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Tag{{Name: "go", PostCount: 2}, {Name: "db", PostCount: 1}}, tags)
+
+	_, err = repo.CreatePost(ctx, "missing", "t", "c", "alice", true, nil)
+	require.ErrorIs(t, err, storage.ErrCommunityNotFound)
+	_, err = repo.VotePost(ctx, "missing", "a", 1)
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+
+	_, err = repo.DeletePost(ctx, newer.ID, "alice")
+	require.NoError(t, err)
+	require.Equal(t, []string{"older"}, titles(storage.PostFilter{}))
+}
+
+func TestSQLiteComments_ThreadAndCounters(t *testing.T) {
+	t.Parallel()
+	repo := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, "general", "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	first, err := repo.CreateComment(ctx, p.ID, "", "bob", "first", false)
+	require.NoError(t, err)
+	second, err := repo.CreateComment(ctx, p.ID, "", "bob", "second", false)
+	require.NoError(t, err)
+	reply, err := repo.CreateComment(ctx, p.ID, first.ID, "carol", "reply", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, reply.Depth)
+	held, err := repo.CreateComment(ctx, p.ID, reply.ID, "dave", "held", true)
+	require.NoError(t, err)
+
+	p, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.CommentCount)
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	var ids []string
+	for _, c := range thread {
+		ids = append(ids, c.ID)
+	}
+	require.Equal(t, []string{first.ID, reply.ID, second.ID}, ids)
+
+	_, err = repo.ApproveComment(ctx, held.ID)
+	require.NoError(t, err)
+	ancestors, err := repo.GetAncestors(ctx, held.ID)
+	require.NoError(t, err)
+	require.Len(t, ancestors, 2)
+	require.Equal(t, first.ID, ancestors[0].ID)
+
+	n, err := repo.CountDescendants(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	_, err = repo.DeleteComment(ctx, reply.ID, "bob")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+	deleted, err := repo.DeleteComment(ctx, reply.ID, "carol")
+	require.NoError(t, err)
+	require.True(t, deleted.Deleted)
+	require.Equal(t, storage.DeletedPlaceholder, deleted.Content)
+
+	p, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.CommentCount)
+
+	fixed, err := repo.ReconcileCounters(ctx)
+	require.NoError(t, err)
+	require.Zero(t, fixed)
+}
+
+func TestSQLiteSearch_RanksAndHighlights(t *testing.T) {
+	t.Parallel()
+	repo := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, "general", "Go generics", "type parameters in go", "alice", true, nil)
+	require.NoError(t, err)
+	_, err = repo.CreatePost(ctx, "general", "Rust", "traits and go", "alice", true, nil)
+	require.NoError(t, err)
+	_, err = repo.CreateComment(ctx, p.ID, "", "bob", "generics are great", false)
+	require.NoError(t, err)
+	_, err = repo.CreateComment(ctx, p.ID, "", "bob", "generics pending", true)
+	require.NoError(t, err)
+
+	hits, err := repo.Search(ctx, "generics", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	require.GreaterOrEqual(t, hits[0].Rank, hits[1].Rank)
+
+	hits, err = repo.Search(ctx, "go generics", models.SearchTypePost, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, p.ID, hits[0].Post.ID)
+	require.Contains(t, hits[0].Snippet, "<mark>go</mark>")
+
+	hits, err = repo.Search(ctx, "!!", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, hits)
+}
+
+func TestSQLiteModeration_ReportsAndBans(t *testing.T) {
+	t.Parallel()
+	repo := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	p, err := repo.CreatePost(ctx, "general", "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+
+	r, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: p.ID, Reporter: "bob", Reason: "spam"})
+	require.NoError(t, err)
+	require.Equal(t, "alice", r.TargetAuthor)
+	again, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: p.ID, Reporter: "bob", Reason: "spam"})
+	require.NoError(t, err)
+	require.Equal(t, r.ID, again.ID)
+	_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: "missing", Reporter: "bob"})
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	resolved, err := repo.ResolveReport(ctx, models.ReportStatusActioned, &models.ModerationDecision{
+		ReportID: r.ID, Moderator: "mod", Action: models.ModerationActionHide,
+	})
+	require.NoError(t, err)
+	require.Len(t, resolved.Decisions, 1)
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, p.ID))
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	past := time.Now().Add(-time.Minute)
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "eve", Scope: models.BanScopeCommunity, ScopeID: "general", CreatedBy: "mod", Until: &past})
+	require.NoError(t, err)
+	ban, err := repo.ActiveBan(ctx, "eve", "general", p.ID)
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "eve", Scope: models.BanScopeCommunity, ScopeID: "general", CreatedBy: "mod"})
+	require.NoError(t, err)
+	ban, err = repo.ActiveBan(ctx, "eve", "general", p.ID)
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Nil(t, ban.Until)
+
+	bans, err := repo.GetBans(ctx, models.BanScopeCommunity, "general", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+}
+
+func TestSQLiteIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+	repo := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	resp, err := repo.ReserveIdempotencyKey(ctx, "k", "h", time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	_, err = repo.ReserveIdempotencyKey(ctx, "k", "h", time.Hour)
+	require.ErrorIs(t, err, storage.ErrIdempotencyInProgress)
+
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, "k", []byte(`{"id":"1"}`), time.Hour))
+	resp, err = repo.ReserveIdempotencyKey(ctx, "k", "h", time.Hour)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"1"}`, string(resp))
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+)
+
+func scanSQLiteThread(rows *sql.Rows) ([]*models.Comment, error) {
+	defer rows.Close()
+
+	var out []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Content, &c.CreatedAt, &c.ReplyCount, &c.Depth, &c.Deleted); err != nil {
+			return nil, err
+		}
+		out = append(out, &c)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error) {
+	const query = `
+		SELECT ` + threadColumns + `
+		FROM comments d
+		WHERE d.post_id = ?1
+		AND (?2 = '' OR (d.path LIKE (SELECT path FROM comments WHERE id = ?2 AND post_id = ?1) || '%' AND d.id <> ?2))
+		AND d.hidden_at IS NULL AND NOT d.pending
+		ORDER BY d.path
+		LIMIT ?3 OFFSET ?4
+	`
+
+	log.Printf("Get subtree query.")
+
+	rows, err := s.db.QueryContext(ctx, query, postID, rootID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := scanSQLiteThread(rows)
+	if err != nil || len(out) > 0 || rootID == "" {
+		return out, err
+	}
+
+	return out, s.commentInPost(ctx, postID, rootID)
+}
+
+// commentInPost returns ErrCommentNotFound unless the post has the comment.
+func (s *SQLiteStorage) commentInPost(ctx context.Context, postID, id string) error {
+	const query = `SELECT EXISTS (SELECT 1 FROM comments WHERE id = ?1 AND post_id = ?2)`
+
+	log.Printf("Comment in post query.")
+
+	var ok bool
+	if err := s.db.QueryRowContext(ctx, query, id, postID).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCommentNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetAncestors(ctx context.Context, id string) ([]*models.Comment, error) {
+	const query = `
+		SELECT ` + threadColumns + `
+		FROM comments c
+		JOIN comments d ON d.post_id = c.post_id AND c.path LIKE d.path || '%' AND d.id <> c.id
+		WHERE c.id = ?1
+		ORDER BY d.path
+	`
+
+	log.Printf("Get ancestors query.")
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSQLiteThread(rows)
+}
+
+func (s *SQLiteStorage) CountDescendants(ctx context.Context, id string) (int, error) {
+	const query = `
+		SELECT COUNT(d.id)
+		FROM comments c
+		LEFT JOIN comments d ON d.post_id = c.post_id AND d.path LIKE c.path || '%' AND d.id <> c.id
+			AND d.deleted_at IS NULL AND d.hidden_at IS NULL AND NOT d.pending
+		WHERE c.id = ?1
+		GROUP BY c.id
+	`
+
+	log.Printf("Count descendants query.")
+
+	var n int
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrCommentNotFound
+	}
+
+	return n, err
+}
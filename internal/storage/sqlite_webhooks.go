@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"ozonProject/internal/models"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (s *SQLiteStorage) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error) {
+	const query = `
+		INSERT INTO webhooks (id, url, secret, events, created_by, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+	`
+
+	log.Printf("Create webhook query.")
+
+	w := *webhook
+	w.ID = uuid.New().String()
+	w.CreatedAt = sqliteNow()
+	_, err := s.db.ExecContext(ctx, query, w.ID, w.URL, w.Secret, stringList(eventNames(w.Events)), w.CreatedBy, w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (s *SQLiteStorage) GetWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	const query = `SELECT id, url, secret, events, created_by, created_at FROM webhooks ORDER BY created_at ASC, id ASC`
+
+	log.Printf("Get webhooks query.")
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		var events stringList
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = webhookEvents(events)
+		out = append(out, &w)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteWebhook(ctx context.Context, id string) error {
+	const queryHook = `DELETE FROM webhooks WHERE id = ?1`
+	const queryDeliveries = `DELETE FROM webhook_deliveries WHERE webhook_id = ?1`
+
+	log.Printf("Delete webhook query.")
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryHook, id)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrWebhookNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, queryDeliveries, id)
+		return err
+	})
+}
+
+func (s *SQLiteStorage) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	// The select drops deliveries of webhooks deleted in the meantime.
+	const query = `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, payload, created_at, next_attempt_at)
+		SELECT ?1, id, ?3, ?4, ?5, ?6, ?6 FROM webhooks WHERE id = ?2
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+	`
+
+	log.Printf("Enqueue webhook deliveries query.")
+
+	now := sqliteNow()
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, d := range deliveries {
+			if _, err := tx.ExecContext(ctx, query, d.ID, d.WebhookID, d.EventID, string(d.Event), d.Payload, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	const query = `
+		UPDATE webhook_deliveries SET next_attempt_at = ?3
+		WHERE seq IN (
+			SELECT seq FROM webhook_deliveries
+			WHERE status = 'PENDING' AND next_attempt_at <= ?2
+			ORDER BY seq
+			LIMIT ?1
+		)
+		RETURNING seq, ` + deliveryColumns + `
+	`
+
+	log.Printf("Claim webhook deliveries query.")
+
+	now := sqliteNow()
+	rows, err := s.db.QueryContext(ctx, query, limit, now, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type claimed struct {
+		seq int64
+		d   *models.WebhookDelivery
+	}
+
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		var d models.WebhookDelivery
+		err := rows.Scan(&c.seq, &d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		c.d = &d
+		batch = append(batch, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	sort.Slice(batch, func(i, j int) bool { return batch[i].seq < batch[j].seq })
+
+	out := make([]*models.WebhookDelivery, 0, len(batch))
+	for _, c := range batch {
+		out = append(out, c.d)
+	}
+
+	return out, nil
+}
+
+func (s *SQLiteStorage) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	const query = `
+		UPDATE webhook_deliveries
+		SET status = ?2, attempts = ?3, last_status_code = ?4, last_error = ?5, next_attempt_at = ?6, delivered_at = ?7
+		WHERE id = ?1
+	`
+
+	log.Printf("Update webhook delivery query.")
+
+	res, err := s.db.ExecContext(ctx, query, delivery.ID, string(delivery.Status), delivery.Attempts,
+		delivery.LastStatusCode, delivery.LastError, utcOrNil(delivery.NextAttemptAt), utcOrNil(delivery.DeliveredAt))
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetWebhookDeliveries(ctx context.Context, filter DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error) {
+	const query = `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries
+		WHERE (?3 = '' OR webhook_id = ?3) AND (?4 = '' OR status = ?4)
+		ORDER BY seq DESC
+		LIMIT ?1 OFFSET ?2
+	`
+
+	log.Printf("Get webhook deliveries query.")
+
+	rows, err := s.db.QueryContext(ctx, query, limit, offset, filter.WebhookID, string(filter.Status))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*models.WebhookDelivery, 0, limit)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *SQLiteStorage) RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	const query = `
+		UPDATE webhook_deliveries SET status = 'PENDING', attempts = 0, next_attempt_at = ?2
+		WHERE id = ?1 AND status = 'DEAD'
+		RETURNING ` + deliveryColumns + `
+	`
+
+	log.Printf("Retry webhook delivery query.")
+
+	d, err := scanDelivery(s.db.QueryRowContext(ctx, query, id, sqliteNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+
+	return d, err
+}
+
+// utcOrNil stores optional times in UTC like sqliteNow, nil stays NULL.
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return t.UTC()
+}
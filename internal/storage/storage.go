@@ -2,22 +2,211 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"ozonProject/internal/models"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+const DefaultCommunity = "general"
+
+// DeletedPlaceholder replaces author and content of deleted comments so
+// their replies stay reachable in the thread.
+const DeletedPlaceholder = "[deleted]"
+
+var (
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is still in progress")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for a different request")
+	ErrPostNotFound          = errors.New("post not found")
+	ErrCommentNotFound       = errors.New("comment not found")
+	ErrCommunityNotFound     = errors.New("community not found")
+	ErrCommunityExists       = errors.New("community already exists")
+	ErrReportNotFound        = errors.New("report not found")
+	ErrReportResolved        = errors.New("report is already resolved")
+	ErrCommentsDisabled      = errors.New("comments disabled")
+	ErrParentNotFound        = errors.New("parent comment not found")
+	ErrParentInOtherPost     = errors.New("parent belongs to another post")
+	ErrPostExists            = errors.New("post already exists")
+	ErrCommentExists         = errors.New("comment already exists")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrDeliveryNotFound      = errors.New("webhook delivery not found")
+)
+
+// PostFilter narrows and orders GetPosts, empty fields are ignored.
+type PostFilter struct {
+	Tag       string
+	Community string
+	Sort      models.PostSort
+	Since     time.Time
+	// AfterID continues the NEW feed after this post. Unlike an offset the
+	// cursor does not shift while posts are added, hidden or deleted. Other
+	// sorts ignore it.
+	AfterID string
+}
+
+// AuditFilter narrows GetAuditLog, empty fields are ignored.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+}
+
+// DeliveryFilter narrows GetWebhookDeliveries, empty fields are ignored.
+type DeliveryFilter struct {
+	WebhookID string
+	Status    models.WebhookDeliveryStatus
+}
+
+// Broadcaster is implemented by storages several instances of the service
+// can share. It tells every instance about published comments, so their
+// subscribers get them whichever instance dispatched the outbox event.
+type Broadcaster interface {
+	// BroadcastComment announces the published comment to every listener.
+	BroadcastComment(ctx context.Context, id string) error
+	// ListenComments calls fn with ids of broadcast comments until ctx is
+	// done or the connection fails. Comments broadcast while nobody listens
+	// are not repeated.
+	ListenComments(ctx context.Context, fn func(id string)) error
+}
+
 type PgxPoolIface interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
 type Storage interface {
-	CreatePost(ctx context.Context, title, content, author string, commentsEnabled bool) (*models.Post, error)
-	GetPosts(ctx context.Context, limit, offset int) ([]*models.Post, error)
+	CreatePost(ctx context.Context, community, title, content, author string, commentsEnabled bool, tags []string) (*models.Post, error)
+	GetPosts(ctx context.Context, limit, offset int, filter PostFilter) ([]*models.Post, error)
 	GetPostByID(ctx context.Context, id string) (*models.Post, error)
+	// VotePost sets the voter's vote to value (-1, 0 or 1) and returns the post with its updated score.
+	VotePost(ctx context.Context, postID, voter string, value int) (*models.Post, error)
+	GetTags(ctx context.Context, limit int) ([]*models.Tag, error)
+
+	CreateCommunity(ctx context.Context, community *models.Community) (*models.Community, error)
+	GetCommunity(ctx context.Context, name string) (*models.Community, error)
+	GetCommunities(ctx context.Context, limit, offset int) ([]*models.Community, error)
 
-	CreateComment(ctx context.Context, postID string, parentID string, author, content string) (*models.Comment, error)
+	// CreateComment stores a comment, pending comments stay out of threads,
+	// search and counters until ApproveComment publishes them. It fails with
+	// ErrCommentsDisabled, ErrParentNotFound or ErrParentInOtherPost.
+	CreateComment(ctx context.Context, postID string, parentID string, author, content string, pending bool) (*models.Comment, error)
+	ApproveComment(ctx context.Context, id string) (*models.Comment, error)
+	GetCommentByID(ctx context.Context, id string) (*models.Comment, error)
+	// GetComments lists visible direct replies of parentID, or top-level
+	// comments when it is empty, in creation order.
 	GetComments(ctx context.Context, postID string, parentID string, limit, offset int) ([]*models.Comment, error)
+	// GetSubtree returns visible descendants of rootID, or the whole thread of
+	// the post when rootID is empty, in depth-first thread order. It fails
+	// with ErrCommentNotFound when rootID is not a comment of the post.
+	GetSubtree(ctx context.Context, postID, rootID string, limit, offset int) ([]*models.Comment, error)
+	// GetAncestors returns the parent chain of the comment starting from the top-level comment.
+	GetAncestors(ctx context.Context, id string) ([]*models.Comment, error)
+	CountDescendants(ctx context.Context, id string) (int, error)
+	// EnsureCommentsEnabled returns ErrCommentsDisabled when the post does not
+	// accept comments and ErrPostNotFound when there is no such post.
 	EnsureCommentsEnabled(ctx context.Context, postID string) error
+	// DeleteComment soft-deletes the comment, an empty author skips the ownership check.
+	DeleteComment(ctx context.Context, id, author string) (*models.Comment, error)
+	// HideContent removes a post or comment from feeds, threads and search
+	// while keeping it reachable by id.
+	HideContent(ctx context.Context, targetType models.ReportTargetType, id string) error
+	// DeletePost soft-deletes the post, an empty author skips the ownership check.
+	DeletePost(ctx context.Context, id, author string) (*models.Post, error)
+	// ReconcileCounters recomputes comment and reply counters and returns the number of fixed rows.
+	ReconcileCounters(ctx context.Context) (int64, error)
+
+	// ImportPost stores a post keeping its id, creation time, score and
+	// deleted and hidden flags, the comment counter starts at zero. It fails
+	// with ErrPostExists or ErrCommunityNotFound.
+	ImportPost(ctx context.Context, post *models.Post) error
+	// ImportComment stores a comment keeping its id, parent, creation time
+	// and flags, depth and counters are derived like in CreateComment and
+	// disabled comments are not checked. It fails with ErrCommentExists,
+	// ErrPostNotFound, ErrParentNotFound or ErrParentInOtherPost.
+	ImportComment(ctx context.Context, comment *models.Comment) error
+
+	Search(ctx context.Context, query string, kind models.SearchType, limit, offset int) ([]*models.SearchResult, error)
+
+	// CreateReport stores a report filling TargetAuthor and Community from the
+	// target, a repeated report of the same target by the same reporter
+	// returns the existing one. Deleted and hidden targets are not found.
+	CreateReport(ctx context.Context, report *models.Report) (*models.Report, error)
+	GetReport(ctx context.Context, id string) (*models.Report, error)
+	GetReports(ctx context.Context, community string, status models.ReportStatus, limit, offset int) ([]*models.Report, error)
+	// ResolveReport sets the status of an open report and appends the decision
+	// to its audit trail, ErrReportResolved means another decision came first.
+	// The decision id is generated when empty.
+	ResolveReport(ctx context.Context, status models.ReportStatus, decision *models.ModerationDecision) (*models.Report, error)
+	// ReopenReport undoes ResolveReport when its action could not be applied.
+	ReopenReport(ctx context.Context, reportID, decisionID string) error
+	// BanUser stores the ban, banning the user again in the same scope
+	// replaces the reason and expiry of the previous ban.
+	BanUser(ctx context.Context, ban *models.Ban) (*models.Ban, error)
+	// ActiveBan returns the unexpired ban blocking the user from writing to
+	// the community or post, including global bans, or nil when there is none.
+	ActiveBan(ctx context.Context, user, community, postID string) (*models.Ban, error)
+	// GetBans lists unexpired bans of the scope, newest first.
+	GetBans(ctx context.Context, scope models.BanScope, scopeID string, limit, offset int) ([]*models.Ban, error)
+
+	// CreateReplyNotification notifies the author of the parent comment, or of
+	// the post for top-level comments, and returns nil when they replied to themselves.
+	CreateReplyNotification(ctx context.Context, reply *models.Comment) (*models.Notification, error)
+	CreateNotifications(ctx context.Context, notifications []*models.Notification) ([]*models.Notification, error)
+	GetNotifications(ctx context.Context, recipient string, unreadOnly bool, limit, offset int) ([]*models.Notification, error)
+	// MarkNotificationsRead marks the given notifications, or all of them when
+	// ids is empty, and returns how many changed.
+	MarkNotificationsRead(ctx context.Context, recipient string, ids []string) (int, error)
+
+	// AppendAudit adds an entry to the audit log, entries are never updated or deleted.
+	AppendAudit(ctx context.Context, entry *models.AuditEntry) error
+	// GetAuditLog lists entries matching the filter in the order they were appended.
+	GetAuditLog(ctx context.Context, filter AuditFilter, limit, offset int) ([]*models.AuditEntry, error)
+
+	// ReserveIdempotencyKey returns the stored response for a completed key,
+	// nil when the key has just been reserved by the caller for lease,
+	// ErrIdempotencyInProgress when another request holds it and
+	// ErrIdempotencyKeyReused when the key was taken by a request with a
+	// different hash.
+	ReserveIdempotencyKey(ctx context.Context, key, hash string, lease time.Duration) ([]byte, error)
+	// CompleteIdempotencyKey stores the response, it is returned for ttl.
+	CompleteIdempotencyKey(ctx context.Context, key string, response []byte, ttl time.Duration) error
+	// ReleaseIdempotencyKey drops a reservation that was never completed.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	// PurgeIdempotencyKeys deletes expired keys and returns how many.
+	PurgeIdempotencyKeys(ctx context.Context) (int, error)
+
+	// ClaimOutboxEvents leases up to limit undelivered events, oldest first.
+	// A leased event is not claimed again until the lease runs out, so
+	// several dispatchers can share the outbox. Attempts counts the claims.
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxEvent, error)
+	// AckOutboxEvents removes delivered events, unknown ids are ignored.
+	AckOutboxEvents(ctx context.Context, ids []string) error
+
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.Webhook, error)
+	// GetWebhooks lists every webhook with its secret, oldest first. Secrets
+	// are stored in plaintext.
+	GetWebhooks(ctx context.Context) ([]*models.Webhook, error)
+	// DeleteWebhook removes the webhook together with its deliveries.
+	DeleteWebhook(ctx context.Context, id string) error
+	// EnqueueWebhookDeliveries stores pending deliveries due right away, a
+	// delivery of the same event to the same webhook is stored once.
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error
+	// ClaimWebhookDeliveries leases up to limit pending deliveries that are
+	// due, oldest first, by moving their next attempt past the lease.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	// UpdateWebhookDelivery stores the outcome of an attempt: status,
+	// attempts, last response and the time of the next attempt.
+	UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// GetWebhookDeliveries lists deliveries matching the filter, newest first.
+	GetWebhookDeliveries(ctx context.Context, filter DeliveryFilter, limit, offset int) ([]*models.WebhookDelivery, error)
+	// RetryWebhookDelivery makes a dead delivery pending and due again with
+	// its attempts reset, other deliveries fail with ErrDeliveryNotFound.
+	RetryWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error)
 }
@@ -0,0 +1,107 @@
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"ozonProject/migrations"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresBinDirs are probed when POSTGRES_BIN_DIR is unset and initdb is not on PATH.
+var postgresBinDirs = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/local/pgsql/bin",
+	"/usr/local/opt/postgresql*/bin",
+	"/opt/homebrew/opt/postgresql*/bin",
+}
+
+func findPostgres() (string, bool) {
+	if dir := os.Getenv("POSTGRES_BIN_DIR"); dir != "" {
+		return dir, true
+	}
+
+	if path, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(path), true
+	}
+
+	for _, pattern := range postgresBinDirs {
+		matches, _ := filepath.Glob(filepath.Join(pattern, "initdb"))
+		if len(matches) > 0 {
+			// The newest installed version sorts last.
+			sort.Strings(matches)
+			return filepath.Dir(matches[len(matches)-1]), true
+		}
+	}
+
+	return "", false
+}
+
+// LocalPostgres starts a throwaway server from the Postgres binaries
+// installed on the machine and stops it when t finishes, the test is
+// skipped when there are none. The returned function creates an empty
+// database with migrations/init.sql applied on every call.
+func LocalPostgres(t *testing.T) func(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	bin, ok := findPostgres()
+	if !ok {
+		t.Skip("postgres binaries not found, set POSTGRES_BIN_DIR to run against Postgres")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("postgres refuses to run as root")
+	}
+
+	dataDir := t.TempDir()
+
+	// Socket paths are limited to about a hundred bytes, t.TempDir can be longer.
+	socketDir, err := os.MkdirTemp("", "pg")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	run := func(name string, args ...string) {
+		out, err := exec.Command(filepath.Join(bin, name), args...).CombinedOutput()
+		require.NoError(t, err, "%s: %s", name, out)
+	}
+
+	run("initdb", "-D", dataDir, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync")
+	run("pg_ctl", "-D", dataDir, "-w", "-l", filepath.Join(dataDir, "server.log"),
+		"-o", fmt.Sprintf("-k %s -c listen_addresses='' -c fsync=off", socketDir), "start")
+	t.Cleanup(func() {
+		exec.Command(filepath.Join(bin, "pg_ctl"), "-D", dataDir, "-m", "immediate", "stop").Run() //nolint:errcheck // best effort
+	})
+
+	dsn := func(db string) string {
+		return fmt.Sprintf("host=%s user=postgres dbname=%s sslmode=disable", socketDir, db)
+	}
+
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, dsn("postgres"))
+	require.NoError(t, err)
+	t.Cleanup(admin.Close)
+
+	var databases atomic.Int64
+	return func(t *testing.T) *pgxpool.Pool {
+		t.Helper()
+
+		name := fmt.Sprintf("conformance_%d", databases.Add(1))
+		_, err := admin.Exec(ctx, "CREATE DATABASE "+name)
+		require.NoError(t, err)
+
+		pool, err := pgxpool.New(ctx, dsn(name))
+		require.NoError(t, err)
+		t.Cleanup(pool.Close)
+
+		_, err = pool.Exec(ctx, migrations.Postgres)
+		require.NoError(t, err)
+
+		return pool
+	}
+}
@@ -0,0 +1,1137 @@
+// Package storagetest is a conformance suite for storage.Storage
+// implementations: every backend must pass it, so the service behaves the
+// same whichever one is configured.
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns an empty storage for a single subtest.
+type Factory func(t *testing.T) storage.Storage
+
+// Run checks the storage returned by open against the Storage contract.
+func Run(t *testing.T, open Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo storage.Storage)
+	}{
+		{"PostsNewOrderAndPagination", testPostsNewOrderAndPagination},
+		{"PostsTopAndHot", testPostsTopAndHot},
+		{"PostsFilters", testPostsFilters},
+		{"Communities", testCommunities},
+		{"CommentsOrderAndPagination", testCommentsOrderAndPagination},
+		{"CommentsHierarchy", testCommentsHierarchy},
+		{"CommentsValidation", testCommentsValidation},
+		{"CommentsCounters", testCommentsCounters},
+		{"Search", testSearch},
+		{"Moderation", testModeration},
+		{"Bans", testBans},
+		{"Notifications", testNotifications},
+		{"Audit", testAudit},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"Import", testImport},
+		{"Outbox", testOutbox},
+		{"Webhooks", testWebhooks},
+		{"ConcurrentVotes", testConcurrentVotes},
+		{"ConcurrentVotesOfOneVoter", testConcurrentVotesOfOneVoter},
+		{"ConcurrentComments", testConcurrentComments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+func createPost(t *testing.T, repo storage.Storage, title string, tags ...string) *models.Post {
+	t.Helper()
+
+	p, err := repo.CreatePost(context.Background(), storage.DefaultCommunity, title, "content of "+title, "alice", true, tags)
+	require.NoError(t, err)
+
+	return p
+}
+
+func createComment(t *testing.T, repo storage.Storage, postID, parentID, content string) *models.Comment {
+	t.Helper()
+
+	c, err := repo.CreateComment(context.Background(), postID, parentID, "bob", content, false)
+	require.NoError(t, err)
+
+	return c
+}
+
+func postIDs(posts []*models.Post) []string {
+	out := make([]string, 0, len(posts))
+	for _, p := range posts {
+		out = append(out, p.ID)
+	}
+	return out
+}
+
+func commentIDs(comments []*models.Comment) []string {
+	out := make([]string, 0, len(comments))
+	for _, c := range comments {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+func testPostsNewOrderAndPagination(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	for i := range 5 {
+		createPost(t, repo, fmt.Sprintf("post %d", i))
+	}
+
+	all, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: models.PostSortNew})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	require.True(t, sort.SliceIsSorted(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
+		}
+		return all[i].ID > all[j].ID
+	}), "NEW must order by created_at and id descending")
+
+	var paged []*models.Post
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := repo.GetPosts(ctx, 2, offset, storage.PostFilter{Sort: models.PostSortNew})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+		paged = append(paged, page...)
+	}
+	require.Equal(t, postIDs(all), postIDs(paged))
+
+	// A cursor keeps its place while posts are added and removed.
+	first, err := repo.GetPosts(ctx, 2, 0, storage.PostFilter{Sort: models.PostSortNew})
+	require.NoError(t, err)
+	createPost(t, repo, "post 5")
+	_, err = repo.DeletePost(ctx, first[1].ID, "")
+	require.NoError(t, err)
+	rest, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: models.PostSortNew, AfterID: first[1].ID})
+	require.NoError(t, err)
+	require.Equal(t, postIDs(all[2:]), postIDs(rest))
+
+	page, err := repo.GetPosts(ctx, 10, 5, storage.PostFilter{})
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	_, err = repo.GetPostByID(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+}
+
+func testPostsTopAndHot(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	older := createPost(t, repo, "older")
+	newer := createPost(t, repo, "newer")
+
+	for _, voter := range []string{"a", "b", "c"} {
+		_, err := repo.VotePost(ctx, older.ID, voter, 1)
+		require.NoError(t, err)
+	}
+
+	p, err := repo.VotePost(ctx, older.ID, "a", -1)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Score)
+	p, err = repo.VotePost(ctx, older.ID, "a", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, p.Score)
+	p, err = repo.VotePost(ctx, older.ID, "b", 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, p.Score, "repeating a vote must not change the score")
+
+	for _, sort := range []models.PostSort{models.PostSortTop, models.PostSortHot} {
+		posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: sort})
+		require.NoError(t, err)
+		require.Equal(t, []string{older.ID, newer.ID}, postIDs(posts), sort)
+	}
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: models.PostSortTop, Since: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	_, err = repo.VotePost(ctx, "missing", "a", 1)
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+}
+
+func testPostsFilters(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	_, err := repo.CreateCommunity(ctx, &models.Community{Name: "golang", Rules: []string{}, Moderators: []string{"mod"}, CommentsEnabled: true})
+	require.NoError(t, err)
+
+	tagged := createPost(t, repo, "tagged", "go", "db")
+	require.Equal(t, []string{"db", "go"}, tagged.Tags)
+	createPost(t, repo, "also tagged", "go")
+	inCommunity, err := repo.CreatePost(ctx, "golang", "in community", "c", "alice", true, nil)
+	require.NoError(t, err)
+	require.Equal(t, "golang", inCommunity.CommunityName)
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Tag: "db"})
+	require.NoError(t, err)
+	require.Equal(t, []string{tagged.ID}, postIDs(posts))
+
+	posts, err = repo.GetPosts(ctx, 10, 0, storage.PostFilter{Community: "golang"})
+	require.NoError(t, err)
+	require.Equal(t, []string{inCommunity.ID}, postIDs(posts))
+
+	tags, err := repo.GetTags(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Tag{{Name: "go", PostCount: 2}, {Name: "db", PostCount: 1}}, tags)
+
+	_, err = repo.CreatePost(ctx, "missing", "t", "c", "alice", true, nil)
+	require.ErrorIs(t, err, storage.ErrCommunityNotFound)
+
+	deleted, err := repo.DeletePost(ctx, tagged.ID, "alice")
+	require.NoError(t, err)
+	require.True(t, deleted.Deleted)
+	_, err = repo.DeletePost(ctx, tagged.ID, "alice")
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+
+	posts, err = repo.GetPosts(ctx, 10, 0, storage.PostFilter{Tag: "db"})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	// Tag counts agree with the tag feed, which leaves out deleted and hidden posts.
+	hidden := createPost(t, repo, "hidden", "go")
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, hidden.ID))
+	tags, err = repo.GetTags(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []*models.Tag{{Name: "go", PostCount: 1}}, tags)
+}
+
+func testCommunities(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	c, err := repo.CreateCommunity(ctx, &models.Community{
+		Name: "golang", Description: "Go", Rules: []string{"be nice"}, Moderators: []string{"mod"},
+		CommentsEnabled: true, MaxCommentLength: 500,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"be nice"}, c.Rules)
+	require.False(t, c.CreatedAt.IsZero())
+
+	_, err = repo.CreateCommunity(ctx, &models.Community{Name: "golang", Rules: []string{}, Moderators: []string{}})
+	require.ErrorIs(t, err, storage.ErrCommunityExists)
+
+	got, err := repo.GetCommunity(ctx, "golang")
+	require.NoError(t, err)
+	require.Equal(t, []string{"mod"}, got.Moderators)
+	require.Equal(t, 500, got.MaxCommentLength)
+
+	_, err = repo.GetCommunity(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrCommunityNotFound)
+
+	all, err := repo.GetCommunities(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, storage.DefaultCommunity, all[0].Name)
+	require.Equal(t, "golang", all[1].Name)
+
+	page, err := repo.GetCommunities(ctx, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, "golang", page[0].Name)
+}
+
+func testCommentsOrderAndPagination(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	var created []string
+	for i := range 5 {
+		created = append(created, createComment(t, repo, p.ID, "", fmt.Sprintf("comment %d", i)).ID)
+	}
+	parent := created[0]
+	var replies []string
+	for i := range 3 {
+		replies = append(replies, createComment(t, repo, p.ID, parent, fmt.Sprintf("reply %d", i)).ID)
+	}
+
+	var paged []*models.Comment
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := repo.GetComments(ctx, p.ID, "", 2, offset)
+		require.NoError(t, err)
+		paged = append(paged, page...)
+	}
+	require.Equal(t, created, commentIDs(paged), "top-level comments must come in creation order")
+
+	children, err := repo.GetComments(ctx, p.ID, parent, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, replies, commentIDs(children))
+
+	children, err = repo.GetComments(ctx, p.ID, parent, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, replies[1:2], commentIDs(children))
+}
+
+func testCommentsHierarchy(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	first := createComment(t, repo, p.ID, "", "first")
+	second := createComment(t, repo, p.ID, "", "second")
+	reply := createComment(t, repo, p.ID, first.ID, "reply")
+	nested := createComment(t, repo, p.ID, reply.ID, "nested")
+	sibling := createComment(t, repo, p.ID, first.ID, "sibling")
+
+	require.Equal(t, 0, first.Depth)
+	require.Equal(t, 1, reply.Depth)
+	require.Equal(t, 2, nested.Depth)
+	require.Equal(t, first.ID, *reply.ParentID)
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{first.ID, reply.ID, nested.ID, sibling.ID, second.ID}, commentIDs(thread))
+
+	thread, err = repo.GetSubtree(ctx, p.ID, "", 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{nested.ID, sibling.ID}, commentIDs(thread))
+
+	subtree, err := repo.GetSubtree(ctx, p.ID, first.ID, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{reply.ID, nested.ID, sibling.ID}, commentIDs(subtree))
+
+	_, err = repo.GetSubtree(ctx, p.ID, "missing", 10, 0)
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	other := createPost(t, repo, "other")
+	_, err = repo.GetSubtree(ctx, other.ID, first.ID, 10, 0)
+	require.ErrorIs(t, err, storage.ErrCommentNotFound, "the root belongs to another post")
+
+	ancestors, err := repo.GetAncestors(ctx, nested.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{first.ID, reply.ID}, commentIDs(ancestors))
+
+	ancestors, err = repo.GetAncestors(ctx, first.ID)
+	require.NoError(t, err)
+	require.Empty(t, ancestors)
+
+	n, err := repo.CountDescendants(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	_, err = repo.CountDescendants(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	got, err := repo.GetCommentByID(ctx, nested.ID)
+	require.NoError(t, err)
+	require.Equal(t, "nested", got.Content)
+	require.Equal(t, 2, got.Depth)
+
+	_, err = repo.GetCommentByID(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+}
+
+func testCommentsValidation(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+	other := createPost(t, repo, "other")
+	foreign := createComment(t, repo, other.ID, "", "foreign")
+
+	_, err := repo.CreateComment(ctx, p.ID, "missing", "bob", "c", false)
+	require.ErrorIs(t, err, storage.ErrParentNotFound)
+
+	_, err = repo.CreateComment(ctx, p.ID, foreign.ID, "bob", "c", false)
+	require.ErrorIs(t, err, storage.ErrParentInOtherPost)
+
+	_, err = repo.CreateComment(ctx, "missing", "", "bob", "c", false)
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+
+	closed, err := repo.CreatePost(ctx, storage.DefaultCommunity, "closed", "c", "alice", false, nil)
+	require.NoError(t, err)
+	require.ErrorIs(t, repo.EnsureCommentsEnabled(ctx, closed.ID), storage.ErrCommentsDisabled)
+	require.ErrorIs(t, repo.EnsureCommentsEnabled(ctx, "missing"), storage.ErrPostNotFound)
+	require.NoError(t, repo.EnsureCommentsEnabled(ctx, p.ID))
+
+	_, err = repo.CreateComment(ctx, closed.ID, "", "bob", "c", false)
+	require.ErrorIs(t, err, storage.ErrCommentsDisabled)
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, thread, "rejected comments must not be stored")
+}
+
+func testCommentsCounters(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	root := createComment(t, repo, p.ID, "", "root")
+	reply := createComment(t, repo, p.ID, root.ID, "reply")
+	held, err := repo.CreateComment(ctx, p.ID, root.ID, "eve", "held", true)
+	require.NoError(t, err)
+	require.True(t, held.Pending)
+
+	counts := func() (int, int) {
+		t.Helper()
+		post, err := repo.GetPostByID(ctx, p.ID)
+		require.NoError(t, err)
+		c, err := repo.GetCommentByID(ctx, root.ID)
+		require.NoError(t, err)
+		return post.CommentCount, c.ReplyCount
+	}
+
+	comments, replies := counts()
+	require.Equal(t, 2, comments)
+	require.Equal(t, 1, replies)
+
+	children, err := repo.GetComments(ctx, p.ID, root.ID, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{reply.ID}, commentIDs(children), "pending comments stay out of threads")
+
+	approved, err := repo.ApproveComment(ctx, held.ID)
+	require.NoError(t, err)
+	require.False(t, approved.Pending)
+	_, err = repo.ApproveComment(ctx, held.ID)
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	comments, replies = counts()
+	require.Equal(t, 3, comments)
+	require.Equal(t, 2, replies)
+
+	_, err = repo.DeleteComment(ctx, reply.ID, "mallory")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	deleted, err := repo.DeleteComment(ctx, reply.ID, "bob")
+	require.NoError(t, err)
+	require.True(t, deleted.Deleted)
+	require.Equal(t, storage.DeletedPlaceholder, deleted.Author)
+	require.Equal(t, storage.DeletedPlaceholder, deleted.Content)
+
+	_, err = repo.DeleteComment(ctx, reply.ID, "")
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	comments, replies = counts()
+	require.Equal(t, 2, comments)
+	require.Equal(t, 1, replies)
+
+	thread, err := repo.GetSubtree(ctx, p.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, thread, 3, "deleted comments keep their place in the thread")
+
+	fixed, err := repo.ReconcileCounters(ctx)
+	require.NoError(t, err)
+	require.Zero(t, fixed)
+
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, held.ID))
+	children, err = repo.GetComments(ctx, p.ID, root.ID, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{reply.ID}, commentIDs(children))
+	require.ErrorIs(t, repo.HideContent(ctx, models.ReportTargetTypeComment, "missing"), storage.ErrCommentNotFound)
+}
+
+func testSearch(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	generics, err := repo.CreatePost(ctx, storage.DefaultCommunity, "Go generics", "type parameters in go", "alice", true, nil)
+	require.NoError(t, err)
+	rust, err := repo.CreatePost(ctx, storage.DefaultCommunity, "Rust", "traits instead of generics", "alice", true, nil)
+	require.NoError(t, err)
+	comment := createComment(t, repo, generics.ID, "", "generics are great")
+	_, err = repo.CreateComment(ctx, generics.ID, "", "eve", "generics held back", true)
+	require.NoError(t, err)
+	hidden := createComment(t, repo, generics.ID, "", "generics hidden")
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, hidden.ID))
+
+	hits, err := repo.Search(ctx, "generics", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 3)
+	for i := 1; i < len(hits); i++ {
+		require.GreaterOrEqual(t, hits[i-1].Rank, hits[i].Rank)
+	}
+
+	found := map[string]bool{}
+	for _, hit := range hits {
+		switch hit.Type {
+		case models.SearchTypePost:
+			found[hit.Post.ID] = true
+		case models.SearchTypeComment:
+			found[hit.Comment.ID] = true
+		}
+		require.Contains(t, hit.Snippet, "<mark>")
+	}
+	require.Equal(t, map[string]bool{generics.ID: true, rust.ID: true, comment.ID: true}, found)
+
+	hits, err = repo.Search(ctx, "go generics", models.SearchTypePost, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, generics.ID, hits[0].Post.ID)
+
+	hits, err = repo.Search(ctx, "generics", models.SearchTypeComment, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, comment.ID, hits[0].Comment.ID)
+
+	first, err := repo.Search(ctx, "generics", models.SearchTypeAll, 2, 0)
+	require.NoError(t, err)
+	rest, err := repo.Search(ctx, "generics", models.SearchTypeAll, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.Len(t, rest, 1)
+
+	_, err = repo.DeletePost(ctx, rust.ID, "")
+	require.NoError(t, err)
+	hits, err = repo.Search(ctx, "traits", models.SearchTypeAll, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, hits)
+
+	createComment(t, repo, generics.ID, "", `<script>alert("xss")</script> payload & more`)
+	hits, err = repo.Search(ctx, "payload", models.SearchTypeComment, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Contains(t, hits[0].Snippet, "<mark>payload</mark>")
+	require.Contains(t, hits[0].Snippet, "&amp;")
+	require.NotContains(t, hits[0].Snippet, "<script", "stored content is escaped in snippets")
+}
+
+func testModeration(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+	c := createComment(t, repo, p.ID, "", "spam")
+
+	r, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: c.ID, Reporter: "carol", Reason: "spam"})
+	require.NoError(t, err)
+	require.Equal(t, "bob", r.TargetAuthor)
+	require.Equal(t, storage.DefaultCommunity, r.Community)
+	require.Equal(t, models.ReportStatusOpen, r.Status)
+	require.Empty(t, r.Decisions)
+
+	again, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: c.ID, Reporter: "carol", Reason: "spam"})
+	require.NoError(t, err)
+	require.Equal(t, r.ID, again.ID)
+
+	postReport, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: p.ID, Reporter: "carol", Reason: "off-topic"})
+	require.NoError(t, err)
+	require.Equal(t, "alice", postReport.TargetAuthor)
+
+	_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: "missing", Reporter: "carol"})
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+	_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: "missing", Reporter: "carol"})
+	require.ErrorIs(t, err, storage.ErrCommentNotFound)
+
+	// Deleted content carries the placeholder instead of its author, so it
+	// cannot be reported, and hidden content is already moderated.
+	gone := createPost(t, repo, "gone")
+	deleted := createComment(t, repo, gone.ID, "", "deleted")
+	hidden := createComment(t, repo, gone.ID, "", "hidden")
+	_, err = repo.DeleteComment(ctx, deleted.ID, "")
+	require.NoError(t, err)
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, hidden.ID))
+	_, err = repo.DeletePost(ctx, gone.ID, "")
+	require.NoError(t, err)
+	for _, id := range []string{deleted.ID, hidden.ID} {
+		_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypeComment, TargetID: id, Reporter: "carol"})
+		require.ErrorIs(t, err, storage.ErrCommentNotFound)
+	}
+	_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: gone.ID, Reporter: "carol"})
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+	hiddenPost := createPost(t, repo, "hidden")
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, hiddenPost.ID))
+	_, err = repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: hiddenPost.ID, Reporter: "carol"})
+	require.ErrorIs(t, err, storage.ErrPostNotFound)
+
+	queue, err := repo.GetReports(ctx, storage.DefaultCommunity, models.ReportStatusOpen, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{r.ID, postReport.ID}, []string{queue[0].ID, queue[1].ID})
+
+	resolved, err := repo.ResolveReport(ctx, models.ReportStatusActioned, &models.ModerationDecision{
+		ReportID: r.ID, Moderator: "mod", Action: models.ModerationActionHide, Note: "spam",
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusActioned, resolved.Status)
+	require.Len(t, resolved.Decisions, 1)
+	require.Equal(t, "mod", resolved.Decisions[0].Moderator)
+
+	// Concurrent decisions on an open report, exactly one claims it.
+	contested, err := repo.CreateReport(ctx, &models.Report{TargetType: models.ReportTargetTypePost, TargetID: p.ID, Reporter: "dave", Reason: "off-topic"})
+	require.NoError(t, err)
+	var (
+		wg   sync.WaitGroup
+		wins atomic.Int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.ResolveReport(ctx, models.ReportStatusDismissed, &models.ModerationDecision{ReportID: contested.ID, Moderator: "mod"})
+			if err == nil {
+				wins.Add(1)
+				return
+			}
+			assert.ErrorIs(t, err, storage.ErrReportResolved)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), wins.Load())
+
+	decision := &models.ModerationDecision{ID: "undo", ReportID: r.ID, Moderator: "mod"}
+	_, err = repo.ResolveReport(ctx, models.ReportStatusDismissed, decision)
+	require.ErrorIs(t, err, storage.ErrReportResolved)
+	require.NoError(t, repo.ReopenReport(ctx, r.ID, resolved.Decisions[0].ID))
+	reopened, err := repo.GetReport(ctx, r.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.ReportStatusOpen, reopened.Status)
+	require.Empty(t, reopened.Decisions)
+	resolved, err = repo.ResolveReport(ctx, models.ReportStatusActioned, decision)
+	require.NoError(t, err)
+	require.Equal(t, "undo", resolved.Decisions[0].ID)
+
+	_, err = repo.ResolveReport(ctx, models.ReportStatusDismissed, &models.ModerationDecision{ReportID: "missing", Moderator: "mod"})
+	require.ErrorIs(t, err, storage.ErrReportNotFound)
+	_, err = repo.GetReport(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrReportNotFound)
+
+	queue, err = repo.GetReports(ctx, storage.DefaultCommunity, models.ReportStatusOpen, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, p.ID))
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{})
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.True(t, got.Hidden, "hidden posts stay reachable by id")
+	require.ErrorIs(t, repo.HideContent(ctx, models.ReportTargetTypePost, "missing"), storage.ErrPostNotFound)
+}
+
+func testBans(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	_, err := repo.BanUser(ctx, &models.Ban{UserID: "eve", Scope: models.BanScopePost, ScopeID: "p1", CreatedBy: "mod", Until: &past})
+	require.NoError(t, err)
+	ban, err := repo.ActiveBan(ctx, "eve", storage.DefaultCommunity, "p1")
+	require.NoError(t, err)
+	require.Nil(t, ban, "expired bans are lifted")
+
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "eve", Scope: models.BanScopePost, ScopeID: "p1", CreatedBy: "mod", Until: &future, Reason: "again"})
+	require.NoError(t, err)
+	ban, err = repo.ActiveBan(ctx, "eve", storage.DefaultCommunity, "p1")
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Equal(t, "again", ban.Reason)
+	require.WithinDuration(t, future, *ban.Until, time.Second)
+
+	ban, err = repo.ActiveBan(ctx, "eve", storage.DefaultCommunity, "p2")
+	require.NoError(t, err)
+	require.Nil(t, ban)
+
+	_, err = repo.BanUser(ctx, &models.Ban{UserID: "eve", Scope: models.BanScopeGlobal, CreatedBy: "admin"})
+	require.NoError(t, err)
+	ban, err = repo.ActiveBan(ctx, "eve", "", "")
+	require.NoError(t, err)
+	require.NotNil(t, ban)
+	require.Nil(t, ban.Until, "a permanent ban outranks an expiring one")
+
+	bans, err := repo.GetBans(ctx, models.BanScopePost, "p1", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+
+	bans, err = repo.GetBans(ctx, models.BanScopeCommunity, storage.DefaultCommunity, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, bans)
+}
+
+func testNotifications(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	c := createComment(t, repo, p.ID, "", "hi")
+	n, err := repo.CreateReplyNotification(ctx, c)
+	require.NoError(t, err)
+	require.Equal(t, "alice", n.Recipient)
+	require.Equal(t, models.NotificationTypeReply, n.Type)
+
+	self, err := repo.CreateComment(ctx, p.ID, c.ID, "bob", "me again", false)
+	require.NoError(t, err)
+	n, err = repo.CreateReplyNotification(ctx, self)
+	require.NoError(t, err)
+	require.Nil(t, n, "replying to yourself notifies nobody")
+
+	created, err := repo.CreateNotifications(ctx, []*models.Notification{
+		{Recipient: "alice", Type: models.NotificationTypeMention, Actor: "bob", PostID: p.ID, CommentID: c.ID},
+		{Recipient: "carol", Type: models.NotificationTypeMention, Actor: "bob", PostID: p.ID, CommentID: c.ID},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+
+	list, err := repo.GetNotifications(ctx, "alice", true, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	marked, err := repo.MarkNotificationsRead(ctx, "alice", []string{created[0].ID})
+	require.NoError(t, err)
+	require.Equal(t, 1, marked)
+
+	list, err = repo.GetNotifications(ctx, "alice", true, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	marked, err = repo.MarkNotificationsRead(ctx, "alice", nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, marked)
+
+	list, err = repo.GetNotifications(ctx, "alice", false, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.True(t, list[0].Read && list[1].Read)
+}
+
+func testAudit(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	before := `{"content":"old"}`
+	for i, action := range []string{"post.create", "comment.delete", "comment.delete"} {
+		err := repo.AppendAudit(ctx, &models.AuditEntry{
+			Actor: "alice", Action: action, TargetType: "comment", TargetID: fmt.Sprint(i), Before: &before, RequestID: "r",
+		})
+		require.NoError(t, err)
+	}
+
+	all, err := repo.GetAuditLog(ctx, storage.AuditFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	require.Equal(t, []string{"0", "1", "2"}, []string{all[0].TargetID, all[1].TargetID, all[2].TargetID})
+	require.JSONEq(t, before, *all[0].Before)
+	require.Nil(t, all[0].After)
+
+	deletes, err := repo.GetAuditLog(ctx, storage.AuditFilter{Action: "comment.delete"}, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, deletes, 1)
+	require.Equal(t, "2", deletes[0].TargetID)
+
+	none, err := repo.GetAuditLog(ctx, storage.AuditFilter{Until: time.Now().Add(-time.Hour)}, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, none)
+}
+
+func testIdempotencyKeys(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	resp, err := repo.ReserveIdempotencyKey(ctx, "k", "h1", time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	_, err = repo.ReserveIdempotencyKey(ctx, "k", "h1", time.Hour)
+	require.ErrorIs(t, err, storage.ErrIdempotencyInProgress)
+	_, err = repo.ReserveIdempotencyKey(ctx, "k", "h2", time.Hour)
+	require.ErrorIs(t, err, storage.ErrIdempotencyKeyReused)
+
+	require.NoError(t, repo.ReleaseIdempotencyKey(ctx, "k"))
+	resp, err = repo.ReserveIdempotencyKey(ctx, "k", "h1", time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, "k", []byte(`{"id":"1"}`), time.Hour))
+	require.NoError(t, repo.ReleaseIdempotencyKey(ctx, "k"), "completed keys survive a release")
+	resp, err = repo.ReserveIdempotencyKey(ctx, "k", "h1", time.Hour)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"1"}`, string(resp))
+	_, err = repo.ReserveIdempotencyKey(ctx, "k", "h2", time.Hour)
+	require.ErrorIs(t, err, storage.ErrIdempotencyKeyReused)
+
+	_, err = repo.ReserveIdempotencyKey(ctx, "lease", "h1", -time.Second)
+	require.NoError(t, err)
+	resp, err = repo.ReserveIdempotencyKey(ctx, "lease", "h2", time.Hour)
+	require.NoError(t, err, "an abandoned reservation is taken over once its lease ends")
+	require.Nil(t, resp)
+
+	require.NoError(t, repo.CompleteIdempotencyKey(ctx, "lease", []byte(`{}`), -time.Second))
+	n, err := repo.PurgeIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = repo.PurgeIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func testImport(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ptr := func(s string) *string { return &s }
+
+	post := &models.Post{
+		ID: "post-1", Title: "Imported", Content: "old forum", Author: "alice", CommentsEnabled: false,
+		CreatedAt: createdAt, Score: 7, CommentCount: 100, Tags: []string{"go", "db"}, CommunityName: storage.DefaultCommunity,
+	}
+	require.NoError(t, repo.ImportPost(ctx, post))
+	require.ErrorIs(t, repo.ImportPost(ctx, post), storage.ErrPostExists)
+	require.ErrorIs(t, repo.ImportPost(ctx, &models.Post{ID: "post-2", CommunityName: "missing", CreatedAt: createdAt}),
+		storage.ErrCommunityNotFound)
+
+	other := createPost(t, repo, "other")
+	foreign := createComment(t, repo, other.ID, "", "foreign")
+
+	comments := []*models.Comment{
+		{ID: "c-1", PostID: post.ID, Author: "bob", Content: "first", CreatedAt: createdAt.Add(time.Minute), ReplyCount: 50},
+		{ID: "c-2", PostID: post.ID, ParentID: ptr("c-1"), Author: "carol", Content: "reply", CreatedAt: createdAt.Add(2 * time.Minute)},
+		{ID: "c-3", PostID: post.ID, ParentID: ptr("c-1"), Author: storage.DeletedPlaceholder, Content: storage.DeletedPlaceholder,
+			CreatedAt: createdAt.Add(3 * time.Minute), Deleted: true},
+		{ID: "c-4", PostID: post.ID, ParentID: ptr(""), Author: "dave", Content: "second", CreatedAt: createdAt.Add(4 * time.Minute)},
+	}
+	for _, c := range comments {
+		require.NoError(t, repo.ImportComment(ctx, c))
+	}
+
+	require.ErrorIs(t, repo.ImportComment(ctx, comments[0]), storage.ErrCommentExists)
+	require.ErrorIs(t, repo.ImportComment(ctx, &models.Comment{ID: "x", PostID: "missing", CreatedAt: createdAt}), storage.ErrPostNotFound)
+	require.ErrorIs(t, repo.ImportComment(ctx, &models.Comment{ID: "x", PostID: post.ID, ParentID: ptr("missing"), CreatedAt: createdAt}),
+		storage.ErrParentNotFound)
+	require.ErrorIs(t, repo.ImportComment(ctx, &models.Comment{ID: "x", PostID: post.ID, ParentID: &foreign.ID, CreatedAt: createdAt}),
+		storage.ErrParentInOtherPost)
+
+	got, err := repo.GetPostByID(ctx, post.ID)
+	require.NoError(t, err)
+	require.True(t, createdAt.Equal(got.CreatedAt))
+	require.Equal(t, 7, got.Score)
+	require.Equal(t, 3, got.CommentCount, "counters are derived, deleted comments are not counted")
+	require.Equal(t, []string{"db", "go"}, got.Tags)
+	require.False(t, got.CommentsEnabled)
+
+	thread, err := repo.GetSubtree(ctx, post.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c-1", "c-2", "c-3", "c-4"}, commentIDs(thread))
+	require.Equal(t, 1, thread[0].ReplyCount)
+	require.Equal(t, 1, thread[1].Depth)
+	require.True(t, thread[2].Deleted)
+	require.True(t, createdAt.Add(time.Minute).Equal(thread[0].CreatedAt))
+
+	posts, err := repo.GetPosts(ctx, 10, 0, storage.PostFilter{Sort: models.PostSortNew})
+	require.NoError(t, err)
+	require.Equal(t, []string{other.ID, post.ID}, postIDs(posts), "imported posts keep their place in the feed")
+
+	hits, err := repo.Search(ctx, "forum", models.SearchTypePost, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+
+	fixed, err := repo.ReconcileCounters(ctx)
+	require.NoError(t, err)
+	require.Zero(t, fixed)
+}
+
+const concurrency = 16
+
+func testOutbox(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "outbox")
+	c := createComment(t, repo, p.ID, "", "visible")
+	held, err := repo.CreateComment(ctx, p.ID, "", "bob", "held", true)
+	require.NoError(t, err)
+
+	events, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 2, "pending comments emit no event")
+	require.Equal(t, models.OutboxPostCreated, events[0].Type)
+	require.Equal(t, p.ID, events[0].Key)
+
+	var post models.Post
+	require.NoError(t, json.Unmarshal(events[0].Payload, &post))
+	require.Equal(t, p.ID, post.ID)
+	require.Equal(t, "outbox", post.Title)
+
+	require.Equal(t, models.OutboxCommentPublished, events[1].Type)
+	require.Equal(t, p.ID, events[1].Key)
+	require.Equal(t, 1, events[1].Attempts)
+
+	var payload models.Comment
+	require.NoError(t, json.Unmarshal(events[1].Payload, &payload))
+	require.Equal(t, c.ID, payload.ID)
+	require.Equal(t, "visible", payload.Content)
+
+	_, err = repo.ApproveComment(ctx, held.ID)
+	require.NoError(t, err)
+
+	approved, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, approved, 1, "leased events are not claimed again")
+	require.NoError(t, json.Unmarshal(approved[0].Payload, &payload))
+	require.Equal(t, held.ID, payload.ID)
+	require.False(t, payload.Pending)
+
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{events[0].ID, events[1].ID, approved[0].ID}))
+
+	createComment(t, repo, p.ID, c.ID, "reply")
+	first, err := repo.ClaimOutboxEvents(ctx, 10, time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+
+	time.Sleep(20 * time.Millisecond)
+	retry, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, retry, 1, "an expired lease makes the event claimable")
+	require.Equal(t, first[0].ID, retry[0].ID)
+	require.Equal(t, 2, retry[0].Attempts)
+
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{retry[0].ID}))
+	rest, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+
+	heldAgain, err := repo.CreateComment(ctx, p.ID, "", "bob", "held again", true)
+	require.NoError(t, err)
+	_, err = repo.DeleteComment(ctx, heldAgain.ID, "")
+	require.NoError(t, err)
+	_, err = repo.DeleteComment(ctx, c.ID, "")
+	require.NoError(t, err)
+	_, err = repo.DeletePost(ctx, p.ID, "")
+	require.NoError(t, err)
+
+	deleted, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deleted, 2, "deleting a pending comment emits no event")
+	require.Equal(t, models.OutboxCommentDeleted, deleted[0].Type)
+	require.NoError(t, json.Unmarshal(deleted[0].Payload, &payload))
+	require.Equal(t, c.ID, payload.ID)
+	require.True(t, payload.Deleted)
+	require.Equal(t, models.OutboxPostDeleted, deleted[1].Type)
+	require.Equal(t, p.ID, deleted[1].Key)
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{deleted[0].ID, deleted[1].ID}))
+
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, p.ID))
+	visible := createPost(t, repo, "visible")
+	shown := createComment(t, repo, visible.ID, "", "shown")
+	heldHidden, err := repo.CreateComment(ctx, visible.ID, "", "bob", "held hidden", true)
+	require.NoError(t, err)
+	created, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, created, 2, "hiding a deleted post emits no event")
+	require.NoError(t, repo.AckOutboxEvents(ctx, []string{created[0].ID, created[1].ID}))
+
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, shown.ID))
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, shown.ID))
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypeComment, heldHidden.ID))
+	require.NoError(t, repo.HideContent(ctx, models.ReportTargetTypePost, visible.ID))
+
+	hidden, err := repo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, hidden, 2, "hiding again or hiding a pending comment emits no event")
+	require.Equal(t, models.OutboxCommentHidden, hidden[0].Type)
+	require.Equal(t, visible.ID, hidden[0].Key)
+	require.NoError(t, json.Unmarshal(hidden[0].Payload, &payload))
+	require.Equal(t, shown.ID, payload.ID)
+	require.True(t, payload.Hidden)
+	require.Equal(t, models.OutboxPostHidden, hidden[1].Type)
+	require.Equal(t, visible.ID, hidden[1].Key)
+}
+
+func testWebhooks(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+
+	a, err := repo.CreateWebhook(ctx, &models.Webhook{
+		URL: "http://a.test/hook", Secret: "a-secret", CreatedBy: "admin",
+		Events: []models.WebhookEvent{models.WebhookEventPostCreated, models.WebhookEventCommentCreated},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, a.ID)
+	b, err := repo.CreateWebhook(ctx, &models.Webhook{
+		URL: "http://b.test/hook", Secret: "b-secret", CreatedBy: "admin",
+		Events: []models.WebhookEvent{models.WebhookEventPostDeleted},
+	})
+	require.NoError(t, err)
+
+	hooks, err := repo.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 2)
+	require.Equal(t, a.ID, hooks[0].ID)
+	require.Equal(t, "a-secret", hooks[0].Secret)
+	require.Equal(t, a.Events, hooks[0].Events)
+
+	delivery := func(id, webhookID string) *models.WebhookDelivery {
+		return &models.WebhookDelivery{
+			ID: id, WebhookID: webhookID, EventID: "e1", Event: models.WebhookEventPostCreated, Payload: `{"id":"e1"}`,
+		}
+	}
+	err = repo.EnqueueWebhookDeliveries(ctx, []*models.WebhookDelivery{
+		delivery("d1", a.ID), delivery("d1-again", a.ID), delivery("d2", b.ID), delivery("d3", "missing"),
+	})
+	require.NoError(t, err)
+
+	claimed, err := repo.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2, "an event is queued once per webhook and only for existing webhooks")
+	require.Equal(t, "d1", claimed[0].ID)
+	require.Equal(t, "d2", claimed[1].ID)
+	require.Equal(t, models.WebhookDeliveryStatusPending, claimed[0].Status)
+	require.Equal(t, `{"id":"e1"}`, claimed[0].Payload)
+
+	leased, err := repo.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, leased, "claimed deliveries are not due until the lease runs out")
+
+	now := time.Now().UTC()
+	ok, failed := 200, 500
+	boom := "boom"
+	claimed[0].Status, claimed[0].Attempts, claimed[0].LastStatusCode, claimed[0].DeliveredAt =
+		models.WebhookDeliveryStatusDelivered, 1, &ok, &now
+	require.NoError(t, repo.UpdateWebhookDelivery(ctx, claimed[0]))
+	claimed[1].Status, claimed[1].Attempts, claimed[1].LastStatusCode, claimed[1].LastError =
+		models.WebhookDeliveryStatusDead, 3, &failed, &boom
+	require.NoError(t, repo.UpdateWebhookDelivery(ctx, claimed[1]))
+	require.ErrorIs(t, repo.UpdateWebhookDelivery(ctx, delivery("missing", a.ID)), storage.ErrDeliveryNotFound)
+
+	all, err := repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, "d2", all[0].ID, "newest first")
+	require.NotNil(t, all[1].DeliveredAt)
+
+	dead, err := repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{Status: models.WebhookDeliveryStatusDead}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	require.Equal(t, 3, dead[0].Attempts)
+	require.Equal(t, 500, *dead[0].LastStatusCode)
+	require.Equal(t, "boom", *dead[0].LastError)
+
+	ofA, err := repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{WebhookID: a.ID}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, ofA, 1)
+	require.Equal(t, "d1", ofA[0].ID)
+
+	_, err = repo.RetryWebhookDelivery(ctx, "d1")
+	require.ErrorIs(t, err, storage.ErrDeliveryNotFound, "only dead deliveries are retried")
+	retried, err := repo.RetryWebhookDelivery(ctx, "d2")
+	require.NoError(t, err)
+	require.Equal(t, models.WebhookDeliveryStatusPending, retried.Status)
+	require.Zero(t, retried.Attempts)
+
+	due, err := repo.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, "d2", due[0].ID)
+
+	require.NoError(t, repo.DeleteWebhook(ctx, b.ID))
+	require.ErrorIs(t, repo.DeleteWebhook(ctx, b.ID), storage.ErrWebhookNotFound)
+	ofB, err := repo.GetWebhookDeliveries(ctx, storage.DeliveryFilter{WebhookID: b.ID}, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, ofB, "deliveries go with their webhook")
+	hooks, err = repo.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+}
+
+func testConcurrentVotes(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency*2)
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			voter := fmt.Sprintf("voter %d", i)
+			_, err := repo.VotePost(ctx, p.ID, voter, -1)
+			errs <- err
+			_, err = repo.VotePost(ctx, p.ID, voter, 1)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, concurrency, got.Score)
+}
+
+func testConcurrentVotesOfOneVoter(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+
+	vote := func(value func(i int) int) {
+		var wg sync.WaitGroup
+		errs := make(chan error, concurrency)
+		for i := range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.VotePost(ctx, p.ID, "alice", value(i))
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	vote(func(int) int { return 1 })
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, got.Score, "a repeated vote counts once")
+
+	vote(func(i int) int { return 1 - 2*(i%2) })
+	got, err = repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Contains(t, []int{-1, 1}, got.Score, "the score is the last vote of the voter")
+
+	final, err := repo.VotePost(ctx, p.ID, "alice", 0)
+	require.NoError(t, err)
+	require.Zero(t, final.Score)
+}
+
+func testConcurrentComments(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	p := createPost(t, repo, "t")
+	root := createComment(t, repo, p.ID, "", "root")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CreateComment(ctx, p.ID, root.ID, "bob", fmt.Sprintf("reply %d", i), false)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := repo.GetPostByID(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, concurrency+1, got.CommentCount)
+
+	parent, err := repo.GetCommentByID(ctx, root.ID)
+	require.NoError(t, err)
+	require.Equal(t, concurrency, parent.ReplyCount)
+
+	subtree, err := repo.GetSubtree(ctx, p.ID, root.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, subtree, concurrency)
+
+	children, err := repo.GetComments(ctx, p.ID, root.ID, 100, 0)
+	require.NoError(t, err)
+	require.Equal(t, commentIDs(subtree), commentIDs(children))
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "ozonProject/internal/models"
+
+// eventNames and webhookEvents convert subscriptions for list columns.
+func eventNames(events []models.WebhookEvent) []string {
+	out := make([]string, 0, len(events))
+	for _, e := range events {
+		out = append(out, string(e))
+	}
+
+	return out
+}
+
+func webhookEvents(names []string) []models.WebhookEvent {
+	out := make([]models.WebhookEvent, 0, len(names))
+	for _, n := range names {
+		out = append(out, models.WebhookEvent(n))
+	}
+
+	return out
+}
@@ -0,0 +1,177 @@
+// Package transfer moves posts and comment threads between a Storage and
+// NDJSON dumps with one post or comment per line.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+)
+
+type RecordType string
+
+const (
+	RecordPost    RecordType = "post"
+	RecordComment RecordType = "comment"
+)
+
+// Record is one line of a dump. A comment follows its post and its parent,
+// so a dump can be imported in a single pass.
+type Record struct {
+	Type    RecordType      `json:"type"`
+	Post    *models.Post    `json:"post,omitempty"`
+	Comment *models.Comment `json:"comment,omitempty"`
+}
+
+type Stats struct {
+	Posts    int `json:"posts"`
+	Comments int `json:"comments"`
+	Skipped  int `json:"skipped"`
+}
+
+// pageSize is how many posts or comments are read from storage at once.
+const pageSize = 500
+
+// maxLineSize bounds a single record, contents are limited to a few
+// thousand characters.
+const maxLineSize = 1 << 20
+
+// Export writes every post of the feed followed by its thread in thread
+// order, deleted comments are kept as placeholders. Hidden and deleted
+// posts, hidden and pending comments with their replies and votes are not
+// exported. Posts are paged by a cursor, so posts created while exporting
+// are left out rather than shifting pages.
+func Export(ctx context.Context, repo storage.Storage, w io.Writer) (Stats, error) {
+	var stats Stats
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	var after string
+	for {
+		posts, err := repo.GetPosts(ctx, pageSize, 0, storage.PostFilter{Sort: models.PostSortNew, AfterID: after})
+		if err != nil {
+			return stats, err
+		}
+
+		for _, p := range posts {
+			if err := enc.Encode(Record{Type: RecordPost, Post: p}); err != nil {
+				return stats, err
+			}
+			stats.Posts++
+
+			n, err := exportThread(ctx, repo, enc, p.ID)
+			stats.Comments += n
+			if err != nil {
+				return stats, err
+			}
+		}
+
+		if len(posts) < pageSize {
+			return stats, bw.Flush()
+		}
+		after = posts[len(posts)-1].ID
+	}
+}
+
+// exportThread writes the visible thread of the post. Replies of a hidden or
+// pending comment are visible on their own but skipped with it, a dump must
+// not refer to a parent it does not contain.
+func exportThread(ctx context.Context, repo storage.Storage, enc *json.Encoder, postID string) (int, error) {
+	var n int
+	exported := make(map[string]bool)
+	for offset := 0; ; offset += pageSize {
+		comments, err := repo.GetSubtree(ctx, postID, "", pageSize, offset)
+		if err != nil {
+			return n, err
+		}
+
+		for _, c := range comments {
+			if parent := c.ParentID; parent != nil && *parent != "" && !exported[*parent] {
+				continue
+			}
+			exported[c.ID] = true
+
+			if err := enc.Encode(Record{Type: RecordComment, Comment: c}); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(comments) < pageSize {
+			return n, nil
+		}
+	}
+}
+
+type ImportOptions struct {
+	// SkipExisting skips records whose id is already taken instead of
+	// failing, so an interrupted import can be run again.
+	SkipExisting bool
+}
+
+// Import reads a dump line by line and stores every record keeping ids,
+// parent links and timestamps. It stops at the first invalid record, a
+// comment whose post or parent is neither in storage nor earlier in the
+// dump included, and reports its line number.
+func Import(ctx context.Context, repo storage.Storage, r io.Reader, opts ImportOptions) (Stats, error) {
+	var stats Stats
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		err := importRecord(ctx, repo, &rec, &stats)
+		if opts.SkipExisting && (errors.Is(err, storage.ErrPostExists) || errors.Is(err, storage.ErrCommentExists)) {
+			stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return stats, sc.Err()
+}
+
+func importRecord(ctx context.Context, repo storage.Storage, rec *Record, stats *Stats) error {
+	switch rec.Type {
+	case RecordPost:
+		if rec.Post == nil || rec.Post.ID == "" {
+			return errors.New("post record without post id")
+		}
+		if rec.Post.CommunityName == "" {
+			rec.Post.CommunityName = storage.DefaultCommunity
+		}
+		if err := repo.ImportPost(ctx, rec.Post); err != nil {
+			return fmt.Errorf("post %s: %w", rec.Post.ID, err)
+		}
+		stats.Posts++
+	case RecordComment:
+		if rec.Comment == nil || rec.Comment.ID == "" || rec.Comment.PostID == "" {
+			return errors.New("comment record without comment or post id")
+		}
+		if err := repo.ImportComment(ctx, rec.Comment); err != nil {
+			return fmt.Errorf("comment %s: %w", rec.Comment.ID, err)
+		}
+		stats.Comments++
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+
+	return nil
+}
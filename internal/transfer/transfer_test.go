@@ -0,0 +1,109 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/transfer"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewInMemoryStorage()
+
+	first, err := src.CreatePost(ctx, storage.DefaultCommunity, "first", "hello", "alice", true, []string{"go"})
+	require.NoError(t, err)
+	second, err := src.CreatePost(ctx, storage.DefaultCommunity, "second", "world", "bob", false, nil)
+	require.NoError(t, err)
+	_, err = src.VotePost(ctx, first.ID, "carol", 1)
+	require.NoError(t, err)
+
+	root, err := src.CreateComment(ctx, first.ID, "", "bob", "root", false)
+	require.NoError(t, err)
+	reply, err := src.CreateComment(ctx, first.ID, root.ID, "carol", "reply", false)
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, first.ID, reply.ID, "dave", "nested", false)
+	require.NoError(t, err)
+	_, err = src.DeleteComment(ctx, reply.ID, "")
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, first.ID, "", "eve", "held", true)
+	require.NoError(t, err)
+
+	var dump bytes.Buffer
+	stats, err := transfer.Export(ctx, src, &dump)
+	require.NoError(t, err)
+	require.Equal(t, transfer.Stats{Posts: 2, Comments: 3}, stats)
+	require.Equal(t, 5, strings.Count(dump.String(), "\n"), "one record per line")
+
+	dst := storage.NewInMemoryStorage()
+	stats, err = transfer.Import(ctx, dst, bytes.NewReader(dump.Bytes()), transfer.ImportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, transfer.Stats{Posts: 2, Comments: 3}, stats)
+
+	for _, id := range []string{first.ID, second.ID} {
+		want, err := src.GetPostByID(ctx, id)
+		require.NoError(t, err)
+		got, err := dst.GetPostByID(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	want, err := src.GetSubtree(ctx, first.ID, "", 10, 0)
+	require.NoError(t, err)
+	got, err := dst.GetSubtree(ctx, first.ID, "", 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	stats, err = transfer.Import(ctx, dst, bytes.NewReader(dump.Bytes()), transfer.ImportOptions{SkipExisting: true})
+	require.NoError(t, err)
+	require.Equal(t, transfer.Stats{Skipped: 5}, stats)
+}
+
+func TestExport_SkipsRepliesOfHiddenComments(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewInMemoryStorage()
+
+	post, err := src.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	hidden, err := src.CreateComment(ctx, post.ID, "", "troll", "spam", false)
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, post.ID, hidden.ID, "bob", "reply to spam", false)
+	require.NoError(t, err)
+	kept, err := src.CreateComment(ctx, post.ID, "", "carol", "kept", false)
+	require.NoError(t, err)
+	require.NoError(t, src.HideContent(ctx, models.ReportTargetTypeComment, hidden.ID))
+
+	var dump bytes.Buffer
+	stats, err := transfer.Export(ctx, src, &dump)
+	require.NoError(t, err)
+	require.Equal(t, transfer.Stats{Posts: 1, Comments: 1}, stats)
+
+	dst := storage.NewInMemoryStorage()
+	_, err = transfer.Import(ctx, dst, bytes.NewReader(dump.Bytes()), transfer.ImportOptions{})
+	require.NoError(t, err)
+	_, err = dst.GetCommentByID(ctx, kept.ID)
+	require.NoError(t, err)
+}
+
+func TestImport_ChecksReferences(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+
+	dump := `{"type":"post","post":{"id":"p1","title":"t","content":"c","author":"a","createdAt":"2020-01-01T00:00:00Z"}}
+
+{"type":"comment","comment":{"id":"c1","postId":"p1","parentId":"missing","author":"b","content":"c","createdAt":"2020-01-01T00:00:00Z"}}
+`
+
+	stats, err := transfer.Import(ctx, repo, strings.NewReader(dump), transfer.ImportOptions{})
+	require.ErrorIs(t, err, storage.ErrParentNotFound)
+	require.ErrorContains(t, err, "line 3")
+	require.Equal(t, transfer.Stats{Posts: 1}, stats)
+
+	_, err = transfer.Import(ctx, repo, strings.NewReader(`{"type":"vote"}`), transfer.ImportOptions{})
+	require.ErrorContains(t, err, `line 1: unknown record type "vote"`)
+}
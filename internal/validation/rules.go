@@ -0,0 +1,149 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	FieldTitle   = "title"
+	FieldContent = "content"
+	FieldAuthor  = "author"
+)
+
+var ErrControlChars = errors.New("control characters are not allowed")
+
+// FieldError ties a validation failure to the input field that caused it.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Errors collects field errors of a single input, errors.Is matches any of them.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	out := make([]error, 0, len(e))
+	for _, fe := range e {
+		out = append(out, fe)
+	}
+
+	return out
+}
+
+// Rule checks a value and returns it, possibly normalized, for the next rule.
+type Rule func(value string) (string, error)
+
+// Validator applies rules field by field and keeps the first failure of each field.
+type Validator struct {
+	errs Errors
+}
+
+// Field runs rules against *value in order and stores the normalized result back.
+func (v *Validator) Field(name string, value *string, rules ...Rule) {
+	s := *value
+	for _, rule := range rules {
+		var err error
+		if s, err = rule(s); err != nil {
+			v.errs = append(v.errs, &FieldError{Field: name, Err: err})
+			return
+		}
+	}
+
+	*value = s
+}
+
+// Check records err for the field unless it is nil.
+func (v *Validator) Check(name string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &FieldError{Field: name, Err: err})
+	}
+}
+
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+// Normalize converts the value to NFC and trims surrounding whitespace.
+func Normalize(s string) (string, error) {
+	return strings.TrimSpace(norm.NFC.String(s)), nil
+}
+
+func NotBlank(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", ErrEmptyContent
+	}
+
+	return s, nil
+}
+
+// MaxLen limits the value to n characters.
+func MaxLen(n int) Rule {
+	return func(s string) (string, error) {
+		if utf8.RuneCountInString(s) > n {
+			return "", ErrTooLong
+		}
+
+		return s, nil
+	}
+}
+
+// NoControl rejects control characters other than line breaks and tabs.
+func NoControl(s string) (string, error) {
+	for _, r := range s {
+		if r == '\n' || r == '\r' || r == '\t' {
+			continue
+		}
+		if isControl(r) {
+			return "", ErrControlChars
+		}
+	}
+
+	return s, nil
+}
+
+// SingleLine rejects every control character including line breaks.
+func SingleLine(s string) (string, error) {
+	for _, r := range s {
+		if isControl(r) {
+			return "", ErrControlChars
+		}
+	}
+
+	return s, nil
+}
+
+// isControl also covers invisible format characters such as bidi overrides,
+// zero-width joiners stay allowed because emoji and some scripts rely on them.
+func isControl(r rune) bool {
+	if r == '\u200c' || r == '\u200d' {
+		return false
+	}
+
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
@@ -1,22 +1,182 @@
 package validation
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
 
-const MaxCommentLen = 2000
+const (
+	MaxTitleLen         = 200
+	MaxPostLen          = 2000
+	MaxAuthorLen        = 200
+	MaxCommentLen       = 2000
+	MaxTagLen           = 50
+	MaxTagsPerPost      = 10
+	MinCommunityNameLen = 3
+	MaxCommunityNameLen = 50
+	MaxPageSize         = 100
+)
 
 var (
-	ErrTooLong      = errors.New("content too long")
-	ErrCommentsOff  = errors.New("comments are disabled for this post")
-	ErrEmptyContent = errors.New("content is empty")
+	ErrTooLong       = errors.New("content too long")
+	ErrCommentsOff   = errors.New("comments are disabled for this post")
+	ErrEmptyContent  = errors.New("content is empty")
+	ErrEmptyAuthor   = errors.New("author is empty")
+	ErrEmptyQuery    = errors.New("search query is empty")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = fmt.Errorf("limit must be between 1 and %d", MaxPageSize)
+	ErrInvalidOffset = errors.New("offset must not be negative")
+	ErrInvalidTag    = errors.New("tag must be 1-50 letters, digits, '-' or '_'")
+	ErrTooManyTags   = errors.New("too many tags")
+
+	ErrInvalidCommunityName = errors.New("community name must be 3-50 lowercase letters, digits or '_'")
+	ErrInvalidMaxCommentLen = errors.New("max comment length exceeds the allowed limit")
+	ErrInvalidVote          = errors.New("vote must be -1, 0 or 1")
+	ErrNotModerator         = errors.New("only community moderators can do this")
+	ErrNotAdmin             = errors.New("only administrators can do this")
+	ErrNotApprovable        = errors.New("only comments held for moderation can be approved")
+	ErrBanned               = errors.New("you are banned from posting here")
+	ErrBanExpired           = errors.New("ban expiry must be in the future")
+	ErrEmptyBanScopeID      = errors.New("scope id is required for community and post bans")
+	ErrTooDeep              = errors.New("reply nesting is too deep")
+	ErrInvalidWebhookURL    = errors.New("webhook url must be an absolute http or https url")
+	ErrShortWebhookSecret   = errors.New("webhook secret is too short")
+	ErrNoWebhookEvents      = errors.New("at least one webhook event is required")
 )
 
-func ValidateCommentBody(s string) error {
-	if len(s) == 0 {
-		return ErrEmptyContent
+// Limits are maximum field lengths in characters.
+type Limits struct {
+	MaxTitleLen   int
+	MaxPostLen    int
+	MaxCommentLen int
+	MaxAuthorLen  int
+}
+
+var DefaultLimits = Limits{
+	MaxTitleLen:   MaxTitleLen,
+	MaxPostLen:    MaxPostLen,
+	MaxCommentLen: MaxCommentLen,
+	MaxAuthorLen:  MaxAuthorLen,
+}
+
+// OrDefault replaces unset limits with DefaultLimits.
+func (l Limits) OrDefault() Limits {
+	if l.MaxTitleLen <= 0 {
+		l.MaxTitleLen = DefaultLimits.MaxTitleLen
+	}
+	if l.MaxPostLen <= 0 {
+		l.MaxPostLen = DefaultLimits.MaxPostLen
+	}
+	if l.MaxCommentLen <= 0 {
+		l.MaxCommentLen = DefaultLimits.MaxCommentLen
+	}
+	if l.MaxAuthorLen <= 0 {
+		l.MaxAuthorLen = DefaultLimits.MaxAuthorLen
+	}
+
+	return l
+}
+
+// ValidatePage checks limit and offset of a list query.
+func ValidatePage(limit, offset int) error {
+	if limit < 1 || limit > MaxPageSize {
+		return ErrInvalidLimit
+	}
+	if offset < 0 {
+		return ErrInvalidOffset
+	}
+
+	return nil
+}
+
+// ValidatePost normalizes the post fields in place.
+func ValidatePost(l Limits, title, content, author *string) error {
+	var v Validator
+	v.Field(FieldTitle, title, Normalize, NotBlank, SingleLine, MaxLen(l.MaxTitleLen))
+	v.Field(FieldContent, content, Normalize, NotBlank, NoControl, MaxLen(l.MaxPostLen))
+	v.Field(FieldAuthor, author, Normalize, NotBlank, SingleLine, MaxLen(l.MaxAuthorLen))
+
+	return v.Err()
+}
+
+// ValidateComment normalizes the comment fields in place.
+func ValidateComment(l Limits, author, content *string) error {
+	var v Validator
+	v.Field(FieldAuthor, author, Normalize, NotBlank, SingleLine, MaxLen(l.MaxAuthorLen))
+	v.Field(FieldContent, content, Normalize, NotBlank, NoControl, MaxLen(l.MaxCommentLen))
+
+	return v.Err()
+}
+
+// ValidateCommentLength checks content against a per-community limit.
+func ValidateCommentLength(content string, maxLen int) error {
+	var v Validator
+	v.Field(FieldContent, &content, MaxLen(maxLen))
+
+	return v.Err()
+}
+
+// ValidateMaxCommentLength checks a community setting against the global limit.
+func ValidateMaxCommentLength(n, limit int) error {
+	if n < 1 || n > limit {
+		return ErrInvalidMaxCommentLen
+	}
+
+	return nil
+}
+
+func NormalizeCommunityName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if len(name) < MinCommunityNameLen || len(name) > MaxCommunityNameLen {
+		return "", ErrInvalidCommunityName
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '_' {
+			return "", ErrInvalidCommunityName
+		}
+	}
+
+	return name, nil
+}
+
+// NormalizeTags lowercases and deduplicates tags keeping their original order.
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+
+	if len(out) > MaxTagsPerPost {
+		return nil, ErrTooManyTags
+	}
+
+	return out, nil
+}
+
+func validateTag(tag string) error {
+	if tag == "" || len([]rune(tag)) > MaxTagLen {
+		return ErrInvalidTag
 	}
 
-	if len(s) > MaxCommentLen {
-		return ErrTooLong
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return ErrInvalidTag
+		}
 	}
 
 	return nil
@@ -0,0 +1,64 @@
+package validation_test
+
+import (
+	"errors"
+	"ozonProject/internal/validation"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateComment_CountsRunes(t *testing.T) {
+	t.Parallel()
+	author := "Ярослав"
+	content := strings.Repeat("ж", validation.MaxCommentLen)
+
+	require.NoError(t, validation.ValidateComment(validation.DefaultLimits, &author, &content))
+
+	content += "ж"
+	err := validation.ValidateComment(validation.DefaultLimits, &author, &content)
+	require.ErrorIs(t, err, validation.ErrTooLong)
+}
+
+func TestValidateComment_NormalizesAndRejectsBlank(t *testing.T) {
+	t.Parallel()
+	author := "  bob "
+	content := "  café\n"
+
+	require.NoError(t, validation.ValidateComment(validation.DefaultLimits, &author, &content))
+	require.Equal(t, "bob", author)
+	require.Equal(t, "café", content)
+
+	blank := " \t\n "
+	err := validation.ValidateComment(validation.DefaultLimits, &author, &blank)
+	require.ErrorIs(t, err, validation.ErrEmptyContent)
+}
+
+func TestValidatePost_FieldErrors(t *testing.T) {
+	t.Parallel()
+	title := "line\nbreak"
+	content := "ok\x00"
+	author := "alice\u202e"
+
+	err := validation.ValidatePost(validation.DefaultLimits, &title, &content, &author)
+
+	var fieldErrs validation.Errors
+	require.True(t, errors.As(err, &fieldErrs))
+	require.Len(t, fieldErrs, 3)
+	require.Equal(t, validation.FieldTitle, fieldErrs[0].Field)
+	require.Equal(t, validation.FieldContent, fieldErrs[1].Field)
+	require.Equal(t, validation.FieldAuthor, fieldErrs[2].Field)
+	require.ErrorIs(t, err, validation.ErrControlChars)
+
+	title, content, author = "t", "multi\nline\tcontent", "alice\u200d"
+	require.NoError(t, validation.ValidatePost(validation.DefaultLimits, &title, &content, &author))
+}
+
+func TestLimits_OrDefault(t *testing.T) {
+	t.Parallel()
+	l := validation.Limits{MaxCommentLen: 10}.OrDefault()
+
+	require.Equal(t, 10, l.MaxCommentLen)
+	require.Equal(t, validation.MaxTitleLen, l.MaxTitleLen)
+}
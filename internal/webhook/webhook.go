@@ -0,0 +1,344 @@
+// Package webhook sends post and comment events to the URLs registered by
+// admins. The outbox hands events to Sender, which stores one delivery per
+// subscribed webhook and posts it in the background, retrying failures
+// with exponential backoff until MaxAttempts, after which the delivery is
+// dead and waits for a manual retry.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"ozonProject/internal/models"
+	"ozonProject/internal/storage"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultInterval    = time.Second
+	DefaultBatchSize   = 50
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = 5 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultTimeout     = 10 * time.Second
+	DefaultLease       = time.Minute
+)
+
+// Request headers, the signature covers the timestamp and the body.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// events maps outbox events to the webhook events subscribers choose from.
+var events = map[models.OutboxEventType]models.WebhookEvent{
+	models.OutboxPostCreated:      models.WebhookEventPostCreated,
+	models.OutboxPostDeleted:      models.WebhookEventPostDeleted,
+	models.OutboxCommentPublished: models.WebhookEventCommentCreated,
+	models.OutboxCommentDeleted:   models.WebhookEventCommentDeleted,
+	models.OutboxPostHidden:       models.WebhookEventPostHidden,
+	models.OutboxCommentHidden:    models.WebhookEventCommentHidden,
+}
+
+// Payload is the JSON body of every request, Data is the post or comment.
+type Payload struct {
+	// ID identifies the event, a receiver that got it before may skip it.
+	ID        string              `json:"id"`
+	Event     models.WebhookEvent `json:"event"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Data      json.RawMessage     `json:"data"`
+}
+
+type Config struct {
+	// Interval is how often due deliveries are polled when nobody calls Wake.
+	Interval  time.Duration
+	BatchSize int
+	// MaxAttempts is how many times a delivery is sent before it is dead.
+	MaxAttempts int
+	// Backoff is the delay after the first failure, it doubles with every
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout limits a single request.
+	Timeout time.Duration
+	// Lease hides claimed deliveries from other senders while a batch is
+	// sent, it must be longer than Timeout.
+	Lease time.Duration
+	// Client sends the requests, nil uses a client with Timeout that refuses
+	// to connect to non-public addresses.
+	Client *http.Client
+	// AllowPrivate lets the default client reach loopback, private and
+	// link-local addresses, for local testing only.
+	AllowPrivate bool
+}
+
+// Stats counts attempts since start.
+type Stats struct {
+	Delivered int64
+	Failed    int64
+	Dead      int64
+}
+
+// Sender is the outbox sink of webhooks.
+type Sender struct {
+	repo storage.Storage
+	cfg  Config
+	wake chan struct{}
+
+	delivered, failed, dead atomic.Int64
+}
+
+func NewSender(repo storage.Storage, cfg Config) *Sender {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.Backoff)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Lease <= cfg.Timeout {
+		cfg.Lease = max(DefaultLease, 2*cfg.Timeout)
+	}
+	if cfg.Client == nil {
+		cfg.Client = newClient(cfg.Timeout, cfg.AllowPrivate)
+	}
+
+	return &Sender{repo: repo, cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+func (s *Sender) Name() string {
+	return "webhooks"
+}
+
+// Deliver queues the event for every webhook subscribed to it, the requests
+// are sent by Run. Queueing the same event again is a no-op.
+func (s *Sender) Deliver(ctx context.Context, ev *models.OutboxEvent) error {
+	event, ok := events[ev.Type]
+	if !ok {
+		return nil
+	}
+
+	hooks, err := s.repo.GetWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(Payload{ID: ev.ID, Event: event, CreatedAt: ev.CreatedAt, Data: ev.Payload})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for _, h := range hooks {
+		if !slices.Contains(h.Events, event) {
+			continue
+		}
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: h.ID,
+			EventID:   ev.ID,
+			Event:     event,
+			Payload:   string(body),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := s.repo.EnqueueWebhookDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	s.Wake()
+
+	return nil
+}
+
+// Wake makes Run poll right away.
+func (s *Sender) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.SendOnce(ctx)
+			if err != nil {
+				log.Printf("webhooks: %v", err)
+				break
+			}
+			if n < s.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// SendOnce sends one batch of due deliveries concurrently and returns how
+// many were attempted.
+func (s *Sender) SendOnce(ctx context.Context) (int, error) {
+	batch, err := s.repo.ClaimWebhookDeliveries(ctx, s.cfg.BatchSize, s.cfg.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("claim deliveries: %w", err)
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	hooks, err := s.repo.GetWebhooks(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("load webhooks: %w", err)
+	}
+	byID := make(map[string]*models.Webhook, len(hooks))
+	for _, h := range hooks {
+		byID[h.ID] = h
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range batch {
+		// Deliveries of a webhook deleted after the claim are gone with it.
+		h, ok := byID[d.WebhookID]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.attempt(ctx, h, d)
+		}()
+	}
+	wg.Wait()
+
+	return len(batch), nil
+}
+
+func (s *Sender) attempt(ctx context.Context, h *models.Webhook, d *models.WebhookDelivery) {
+	code, err := s.post(ctx, h, d)
+	now := time.Now().UTC()
+
+	d.Attempts++
+	d.LastStatusCode = nil
+	if code != 0 {
+		d.LastStatusCode = &code
+	}
+
+	switch {
+	case err == nil:
+		s.delivered.Add(1)
+		d.Status, d.LastError, d.NextAttemptAt, d.DeliveredAt = models.WebhookDeliveryStatusDelivered, nil, nil, &now
+	case d.Attempts >= s.cfg.MaxAttempts:
+		s.dead.Add(1)
+		msg := err.Error()
+		d.Status, d.LastError, d.NextAttemptAt = models.WebhookDeliveryStatusDead, &msg, nil
+		log.Printf("webhooks: delivery %s to %s is dead after %d attempts: %v", d.ID, h.URL, d.Attempts, err)
+	default:
+		s.failed.Add(1)
+		msg := err.Error()
+		next := now.Add(s.backoff(d.Attempts))
+		d.LastError, d.NextAttemptAt = &msg, &next
+	}
+
+	if err := s.repo.UpdateWebhookDelivery(ctx, d); err != nil {
+		log.Printf("webhooks: update delivery %s: %v", d.ID, err)
+	}
+}
+
+// post sends the delivery and returns the response status, zero when no
+// response arrived.
+func (s *Sender) post(ctx context.Context, h *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(d.Event))
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(h.Secret, timestamp, []byte(d.Payload)))
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay after the given failed attempt.
+func (s *Sender) backoff(attempt int) time.Duration {
+	d := s.cfg.Backoff
+	for i := 1; i < attempt && d < s.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, s.cfg.MaxBackoff)
+}
+
+func (s *Sender) Stats() Stats {
+	return Stats{Delivered: s.delivered.Load(), Failed: s.failed.Load(), Dead: s.dead.Load()}
+}
+
+// Sign returns the X-Webhook-Signature value: sha256= and the hex encoded
+// HMAC-SHA256 of timestamp, a dot and the body, keyed with the secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature in constant time. Receivers should also reject
+// timestamps too far from their clock to stop replays.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,231 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"ozonProject/internal/models"
+	"ozonProject/internal/outbox"
+	"ozonProject/internal/storage"
+	"ozonProject/internal/webhook"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "0123456789abcdef"
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a local endpoint answering with status and recording requests.
+type receiver struct {
+	*httptest.Server
+	status atomic.Int32
+
+	mu  sync.Mutex
+	got []received
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{}
+	r.status.Store(int32(status))
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.got = append(r.got, received{header: req.Header.Clone(), body: body})
+		r.mu.Unlock()
+		w.WriteHeader(int(r.status.Load()))
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) requests() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]received(nil), r.got...)
+}
+
+func subscribe(t *testing.T, repo storage.Storage, url string, events ...models.WebhookEvent) *models.Webhook {
+	t.Helper()
+
+	w, err := repo.CreateWebhook(context.Background(), &models.Webhook{URL: url, Secret: secret, Events: events, CreatedBy: "admin"})
+	require.NoError(t, err)
+
+	return w
+}
+
+func deliveries(t *testing.T, repo storage.Storage, webhookID string) []*models.WebhookDelivery {
+	t.Helper()
+
+	out, err := repo.GetWebhookDeliveries(context.Background(), storage.DeliveryFilter{WebhookID: webhookID}, 10, 0)
+	require.NoError(t, err)
+
+	return out
+}
+
+func TestSender_DeliversSignedEvents(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	recv := newReceiver(t, http.StatusNoContent)
+
+	hook := subscribe(t, repo, recv.URL, models.WebhookEventPostCreated)
+	other := subscribe(t, repo, recv.URL, models.WebhookEventCommentDeleted)
+
+	sender := webhook.NewSender(repo, webhook.Config{AllowPrivate: true})
+	dispatcher := outbox.NewDispatcher(repo, outbox.Config{}, sender)
+
+	post, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	_, err = repo.CreateComment(ctx, post.ID, "", "bob", "hi", false)
+	require.NoError(t, err)
+
+	n, err := dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = sender.SendOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	reqs := recv.requests()
+	require.Len(t, reqs, 1, "only subscribed events are sent")
+	h := reqs[0].header
+	require.Equal(t, "application/json", h.Get("Content-Type"))
+	require.Equal(t, "POST_CREATED", h.Get(webhook.HeaderEvent))
+	require.True(t, webhook.Verify(secret, h.Get(webhook.HeaderTimestamp), reqs[0].body, h.Get(webhook.HeaderSignature)))
+	require.False(t, webhook.Verify("another secret!!", h.Get(webhook.HeaderTimestamp), reqs[0].body, h.Get(webhook.HeaderSignature)))
+
+	var payload webhook.Payload
+	require.NoError(t, json.Unmarshal(reqs[0].body, &payload))
+	require.Equal(t, models.WebhookEventPostCreated, payload.Event)
+	var got models.Post
+	require.NoError(t, json.Unmarshal(payload.Data, &got))
+	require.Equal(t, post.ID, got.ID)
+
+	log := deliveries(t, repo, hook.ID)
+	require.Len(t, log, 1)
+	require.Equal(t, h.Get(webhook.HeaderDelivery), log[0].ID)
+	require.Equal(t, payload.ID, log[0].EventID)
+	require.Equal(t, models.WebhookDeliveryStatusDelivered, log[0].Status)
+	require.Equal(t, 1, log[0].Attempts)
+	require.Equal(t, http.StatusNoContent, *log[0].LastStatusCode)
+	require.NotNil(t, log[0].DeliveredAt)
+	require.Empty(t, deliveries(t, repo, other.ID))
+	require.Equal(t, webhook.Stats{Delivered: 1}, sender.Stats())
+}
+
+func TestSender_RefusesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	recv := newReceiver(t, http.StatusNoContent)
+
+	hook := subscribe(t, repo, recv.URL, models.WebhookEventPostCreated)
+	sender := webhook.NewSender(repo, webhook.Config{})
+	dispatcher := outbox.NewDispatcher(repo, outbox.Config{}, sender)
+
+	_, err := repo.CreatePost(ctx, storage.DefaultCommunity, "t", "c", "alice", true, nil)
+	require.NoError(t, err)
+	_, err = dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	n, err := sender.SendOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.Empty(t, recv.requests(), "loopback receivers are not reached")
+	log := deliveries(t, repo, hook.ID)
+	require.Len(t, log, 1)
+	require.Equal(t, models.WebhookDeliveryStatusPending, log[0].Status)
+	require.Nil(t, log[0].LastStatusCode)
+	require.Contains(t, *log[0].LastError, webhook.ErrForbiddenAddress.Error())
+}
+
+func TestSender_RetriesWithBackoffUntilDead(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	recv := newReceiver(t, http.StatusInternalServerError)
+	hook := subscribe(t, repo, recv.URL, models.WebhookEventPostCreated)
+
+	backoff := 30 * time.Millisecond
+	sender := webhook.NewSender(repo, webhook.Config{MaxAttempts: 3, Backoff: backoff, MaxBackoff: 2 * backoff, AllowPrivate: true})
+	require.NoError(t, sender.Deliver(ctx, &models.OutboxEvent{
+		ID: "e1", Type: models.OutboxPostCreated, Payload: json.RawMessage(`{"id":"p1"}`), CreatedAt: time.Now(),
+	}))
+
+	attempt := func(wantDelay time.Duration) *models.WebhookDelivery {
+		t.Helper()
+
+		before := time.Now()
+		n, err := sender.SendOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		after := time.Now()
+
+		d := deliveries(t, repo, hook.ID)[0]
+		require.Equal(t, http.StatusInternalServerError, *d.LastStatusCode)
+		require.Equal(t, "unexpected status 500", *d.LastError)
+		if wantDelay > 0 {
+			require.Equal(t, models.WebhookDeliveryStatusPending, d.Status)
+			require.WithinRange(t, *d.NextAttemptAt, before.Add(wantDelay), after.Add(wantDelay))
+
+			n, err = sender.SendOnce(ctx)
+			require.NoError(t, err)
+			require.Zero(t, n, "a failed delivery waits for its backoff")
+			time.Sleep(time.Until(*d.NextAttemptAt) + 5*time.Millisecond)
+		}
+
+		return d
+	}
+
+	attempt(backoff)
+	attempt(2 * backoff)
+	dead := attempt(0)
+	require.Equal(t, models.WebhookDeliveryStatusDead, dead.Status)
+	require.Equal(t, 3, dead.Attempts)
+	require.Nil(t, dead.NextAttemptAt)
+	require.Len(t, recv.requests(), 3)
+	require.Equal(t, webhook.Stats{Failed: 2, Dead: 1}, sender.Stats())
+
+	n, err := sender.SendOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n, "dead deliveries are not retried automatically")
+
+	recv.status.Store(http.StatusOK)
+	_, err = repo.RetryWebhookDelivery(ctx, dead.ID)
+	require.NoError(t, err)
+	n, err = sender.SendOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	d := deliveries(t, repo, hook.ID)[0]
+	require.Equal(t, models.WebhookDeliveryStatusDelivered, d.Status)
+	require.Equal(t, 1, d.Attempts)
+	require.Nil(t, d.LastError)
+}
+
+func TestSender_QueuesAnEventOnce(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	hook := subscribe(t, repo, "http://127.0.0.1:1/unused", models.WebhookEventCommentCreated)
+	sender := webhook.NewSender(repo, webhook.Config{AllowPrivate: true})
+
+	ev := &models.OutboxEvent{ID: "e1", Type: models.OutboxCommentPublished, Payload: json.RawMessage(`{}`)}
+	require.NoError(t, sender.Deliver(ctx, ev))
+	require.NoError(t, sender.Deliver(ctx, ev), "the outbox may hand an event over again after a failure")
+	require.NoError(t, sender.Deliver(ctx, &models.OutboxEvent{ID: "e2", Type: models.OutboxPostDeleted}))
+
+	require.Len(t, deliveries(t, repo, hook.ID), 1)
+}
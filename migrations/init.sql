@@ -16,4 +16,250 @@ CREATE TABLE IF NOT EXISTS comments (
     created_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE INDEX idx_comments_parent_id ON comments(parent_id);
\ No newline at end of file
+CREATE INDEX idx_comments_parent_id ON comments(parent_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(400) PRIMARY KEY,
+    response JSONB,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS request_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(content, '')), 'B')
+    ) STORED;
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN(search_vector);
+CREATE INDEX IF NOT EXISTS idx_comments_search_vector ON comments USING GIN(search_vector);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id VARCHAR(200) NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (post_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag_id ON post_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS communities (
+    name VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(2000) NOT NULL DEFAULT '',
+    rules TEXT[] NOT NULL DEFAULT '{}',
+    moderators TEXT[] NOT NULL DEFAULT '{}',
+    comments_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    max_comment_length INTEGER NOT NULL DEFAULT 2000,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO communities (name, description) VALUES ('general', 'Default community')
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS community VARCHAR(50) NOT NULL DEFAULT 'general'
+    REFERENCES communities(name);
+
+CREATE INDEX IF NOT EXISTS idx_posts_community ON posts(community);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS score INTEGER NOT NULL DEFAULT 0;
+
+-- Reddit hot formula, mirrored by hotRank in internal/storage/ranking.go.
+CREATE OR REPLACE FUNCTION post_hot_rank(score INTEGER, created_at TIMESTAMP) RETURNS DOUBLE PRECISION AS $$
+    SELECT ROUND((
+        SIGN(score) * LOG(GREATEST(ABS(score), 1)) +
+        (EXTRACT(EPOCH FROM created_at) - 1134028003) / 45000
+    )::NUMERIC, 7)::DOUBLE PRECISION
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hot_rank DOUBLE PRECISION
+    GENERATED ALWAYS AS (post_hot_rank(score, created_at)) STORED;
+
+CREATE TABLE IF NOT EXISTS post_votes (
+    post_id VARCHAR(200) NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    voter VARCHAR(200) NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (post_id, voter)
+);
+
+CREATE INDEX IF NOT EXISTS idx_posts_new ON posts(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_top ON posts(score DESC, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_hot ON posts(hot_rank DESC, id DESC);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id VARCHAR(200) PRIMARY KEY,
+    recipient VARCHAR(200) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    actor VARCHAR(200) NOT NULL,
+    post_id VARCHAR(200) NOT NULL,
+    comment_id VARCHAR(200) NOT NULL,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient ON notifications(recipient, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(recipient) WHERE read_at IS NULL;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id VARCHAR(200) PRIMARY KEY,
+    target_type VARCHAR(20) NOT NULL,
+    target_id VARCHAR(200) NOT NULL,
+    target_author VARCHAR(200) NOT NULL,
+    community VARCHAR(50) NOT NULL REFERENCES communities(name),
+    reporter VARCHAR(200) NOT NULL,
+    reason VARCHAR(500) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (target_type, target_id, reporter)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_queue ON reports(community, status, created_at);
+
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+    id VARCHAR(200) PRIMARY KEY,
+    report_id VARCHAR(200) NOT NULL REFERENCES reports(id),
+    moderator VARCHAR(200) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    note VARCHAR(2000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_decisions_report ON moderation_decisions(report_id);
+
+CREATE TABLE IF NOT EXISTS bans (
+    id VARCHAR(200) PRIMARY KEY,
+    user_id VARCHAR(200) NOT NULL,
+    scope VARCHAR(20) NOT NULL,
+    scope_id VARCHAR(200) NOT NULL DEFAULT '',
+    reason VARCHAR(2000) NOT NULL DEFAULT '',
+    created_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_bans_user ON bans(user_id, scope, scope_id);
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Repeated bans used to be stored as separate rows, keep only the newest one
+-- per user and scope so the unique index can be built.
+DELETE FROM bans b
+USING bans newer
+WHERE newer.user_id = b.user_id AND newer.scope = b.scope AND newer.scope_id = b.scope_id
+AND (newer.created_at, newer.id) > (b.created_at, b.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bans_scope_user ON bans(user_id, scope, scope_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id VARCHAR(200) PRIMARY KEY,
+    actor VARCHAR(200) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50) NOT NULL,
+    target_id VARCHAR(200) NOT NULL,
+    before JSONB,
+    after JSONB,
+    request_id VARCHAR(200) NOT NULL DEFAULT '',
+    ip VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0;
+
+WITH RECURSIVE tree AS (
+    SELECT id, 0 AS depth FROM comments WHERE parent_id = ''
+    UNION ALL
+    SELECT c.id, t.depth + 1 FROM comments c JOIN tree t ON c.parent_id = t.id
+)
+UPDATE comments SET depth = tree.depth
+FROM tree
+WHERE comments.id = tree.id AND comments.depth <> tree.depth;
+
+CREATE SEQUENCE IF NOT EXISTS comments_path_seq;
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS path TEXT COLLATE "C" NOT NULL DEFAULT '';
+
+WITH RECURSIVE numbered AS (
+    SELECT id, parent_id, lpad(to_hex(nextval('comments_path_seq')), 12, '0') || '.' AS segment
+    FROM (SELECT id, parent_id FROM comments WHERE path = '' ORDER BY created_at, id) c
+), tree AS (
+    SELECT id, segment AS path FROM numbered WHERE parent_id = ''
+    UNION ALL
+    SELECT n.id, t.path || n.segment FROM numbered n JOIN tree t ON n.parent_id = t.id
+)
+UPDATE comments SET path = tree.path
+FROM tree
+WHERE comments.id = tree.id;
+
+CREATE INDEX IF NOT EXISTS idx_comments_path ON comments(post_id, path);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    seq BIGSERIAL PRIMARY KEY,
+    id VARCHAR(200) NOT NULL UNIQUE,
+    type VARCHAR(50) NOT NULL,
+    key VARCHAR(200) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(200) PRIMARY KEY,
+    url VARCHAR(2000) NOT NULL,
+    secret VARCHAR(200) NOT NULL,
+    events TEXT[] NOT NULL,
+    created_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- payload is TEXT, not JSONB, so the signed body is sent byte for byte.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    seq BIGSERIAL PRIMARY KEY,
+    id VARCHAR(200) NOT NULL UNIQUE,
+    webhook_id VARCHAR(200) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id VARCHAR(200) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    next_attempt_at TIMESTAMP,
+    delivered_at TIMESTAMP,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, seq);
@@ -0,0 +1,16 @@
+// Package migrations embeds the SQL schema for backends that apply it
+// themselves. In deployments Postgres loads init.sql through
+// docker-entrypoint-initdb.d.
+package migrations
+
+import "embed"
+
+// Postgres is init.sql, applied by tests that start their own server.
+//
+//go:embed init.sql
+var Postgres string
+
+// SQLite holds the SQLite schema, files are applied in name order.
+//
+//go:embed sqlite/*.sql
+var SQLite embed.FS
@@ -0,0 +1,103 @@
+package migrations_test
+
+import (
+	"io/fs"
+	"ozonProject/migrations"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	sqlComment  = regexp.MustCompile(`--[^\n]*`)
+	createTable = regexp.MustCompile(`(?is)^CREATE TABLE IF NOT EXISTS (\w+) \((.*)\)$`)
+	addColumn   = regexp.MustCompile(`(?is)^ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+)`)
+)
+
+// columns lists table.column pairs created by the script, tables and columns
+// added later by ALTER TABLE included.
+func columns(script string) []string {
+	var out []string
+	for _, stmt := range strings.Split(sqlComment.ReplaceAllString(script, ""), ";") {
+		stmt = strings.TrimSpace(stmt)
+
+		if m := addColumn.FindStringSubmatch(stmt); m != nil {
+			out = append(out, strings.ToLower(m[1]+"."+m[2]))
+			continue
+		}
+
+		m := createTable.FindStringSubmatch(stmt)
+		if m == nil {
+			continue
+		}
+		for _, def := range splitTopLevel(m[2]) {
+			name := strings.ToLower(strings.Fields(def)[0])
+			switch name {
+			case "primary", "unique", "foreign", "check", "constraint":
+				continue
+			}
+			out = append(out, strings.ToLower(m[1])+"."+name)
+		}
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// splitTopLevel splits a column list on commas outside parentheses.
+func splitTopLevel(s string) []string {
+	var (
+		out   []string
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(out, strings.TrimSpace(s[start:]))
+}
+
+// without drops the given columns, an entry ending in a dot drops the whole table.
+func without(list []string, drop ...string) []string {
+	out := make([]string, 0, len(list))
+next:
+	for _, v := range list {
+		for _, d := range drop {
+			if v == d || (strings.HasSuffix(d, ".") && strings.HasPrefix(v, d)) {
+				continue next
+			}
+		}
+		out = append(out, v)
+	}
+
+	return out
+}
+
+func TestSQLiteSchemaMatchesPostgres(t *testing.T) {
+	t.Parallel()
+
+	script, err := fs.ReadFile(migrations.SQLite, "sqlite/init.sql")
+	require.NoError(t, err)
+
+	// Full-text search and the comment path sequence are emulated by
+	// tables of their own in SQLite.
+	postgres := without(columns(migrations.Postgres), "posts.search_vector", "comments.search_vector")
+	sqlite := without(columns(string(script)), "sequences.", "search_documents.", "search_terms.")
+
+	require.NotEmpty(t, postgres)
+	require.Equal(t, postgres, sqlite, "tables and columns of migrations/init.sql and migrations/sqlite/init.sql differ")
+}
@@ -0,0 +1,229 @@
+-- SQLite version of ../init.sql, tables and columns are kept identical so
+-- both backends answer queries the same way, migrations_test.go checks it. Generated columns, sequences
+-- and tsvector are replaced by values computed in internal/storage/sqlite*.go.
+
+CREATE TABLE IF NOT EXISTS communities (
+    name VARCHAR(50) PRIMARY KEY,
+    description VARCHAR(2000) NOT NULL DEFAULT '',
+    rules TEXT NOT NULL DEFAULT '[]',
+    moderators TEXT NOT NULL DEFAULT '[]',
+    comments_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    max_comment_length INTEGER NOT NULL DEFAULT 2000,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO communities (name, description) VALUES ('general', 'Default community')
+ON CONFLICT (name) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS posts (
+    id VARCHAR(200) PRIMARY KEY,
+    title VARCHAR(200) NOT NULL,
+    content VARCHAR(2000) NOT NULL,
+    author VARCHAR(200) NOT NULL,
+    comments_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    community VARCHAR(50) NOT NULL DEFAULT 'general' REFERENCES communities(name),
+    score INTEGER NOT NULL DEFAULT 0,
+    hot_rank DOUBLE PRECISION NOT NULL DEFAULT 0,
+    comment_count INTEGER NOT NULL DEFAULT 0,
+    hidden_at TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_posts_community ON posts(community);
+CREATE INDEX IF NOT EXISTS idx_posts_new ON posts(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_top ON posts(score DESC, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_posts_hot ON posts(hot_rank DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS post_votes (
+    post_id VARCHAR(200) NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    voter VARCHAR(200) NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (post_id, voter)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id VARCHAR(200) NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (post_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag_id ON post_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id VARCHAR(200) PRIMARY KEY,
+    post_id VARCHAR(200) NOT NULL,
+    parent_id VARCHAR(200) NOT NULL,
+    author VARCHAR(200) NOT NULL,
+    content VARCHAR(2000) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reply_count INTEGER NOT NULL DEFAULT 0,
+    deleted_at TIMESTAMP,
+    hidden_at TIMESTAMP,
+    pending BOOLEAN NOT NULL DEFAULT FALSE,
+    depth INT NOT NULL DEFAULT 0,
+    path TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
+CREATE INDEX IF NOT EXISTS idx_comments_path ON comments(post_id, path);
+
+-- Replaces comments_path_seq.
+CREATE TABLE IF NOT EXISTS sequences (
+    name VARCHAR(50) PRIMARY KEY,
+    value INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO sequences (name) VALUES ('comments_path_seq')
+ON CONFLICT (name) DO NOTHING;
+
+-- Replaces the search_vector columns: term frequencies per document, the
+-- same inverted index InMemoryStorage keeps in memory.
+CREATE TABLE IF NOT EXISTS search_documents (
+    kind VARCHAR(20) NOT NULL,
+    doc_id VARCHAR(200) NOT NULL,
+    length INTEGER NOT NULL,
+    PRIMARY KEY (kind, doc_id)
+);
+
+CREATE TABLE IF NOT EXISTS search_terms (
+    term VARCHAR(200) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    doc_id VARCHAR(200) NOT NULL,
+    freq INTEGER NOT NULL,
+    PRIMARY KEY (term, kind, doc_id)
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(400) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL DEFAULT '',
+    response BLOB,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id VARCHAR(200) PRIMARY KEY,
+    recipient VARCHAR(200) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    actor VARCHAR(200) NOT NULL,
+    post_id VARCHAR(200) NOT NULL,
+    comment_id VARCHAR(200) NOT NULL,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient ON notifications(recipient, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(recipient) WHERE read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id VARCHAR(200) PRIMARY KEY,
+    target_type VARCHAR(20) NOT NULL,
+    target_id VARCHAR(200) NOT NULL,
+    target_author VARCHAR(200) NOT NULL,
+    community VARCHAR(50) NOT NULL REFERENCES communities(name),
+    reporter VARCHAR(200) NOT NULL,
+    reason VARCHAR(500) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'OPEN',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (target_type, target_id, reporter)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_queue ON reports(community, status, created_at);
+
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+    id VARCHAR(200) PRIMARY KEY,
+    report_id VARCHAR(200) NOT NULL REFERENCES reports(id),
+    moderator VARCHAR(200) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    note VARCHAR(2000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_decisions_report ON moderation_decisions(report_id);
+
+CREATE TABLE IF NOT EXISTS bans (
+    id VARCHAR(200) PRIMARY KEY,
+    user_id VARCHAR(200) NOT NULL,
+    scope VARCHAR(20) NOT NULL,
+    scope_id VARCHAR(200) NOT NULL DEFAULT '',
+    reason VARCHAR(2000) NOT NULL DEFAULT '',
+    created_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bans_scope_user ON bans(user_id, scope, scope_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id VARCHAR(200) PRIMARY KEY,
+    actor VARCHAR(200) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50) NOT NULL,
+    target_id VARCHAR(200) NOT NULL,
+    before TEXT,
+    after TEXT,
+    request_id VARCHAR(200) NOT NULL DEFAULT '',
+    ip VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS outbox (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    id VARCHAR(200) NOT NULL UNIQUE,
+    type VARCHAR(50) NOT NULL,
+    key VARCHAR(200) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(200) PRIMARY KEY,
+    url VARCHAR(2000) NOT NULL,
+    secret VARCHAR(200) NOT NULL,
+    events TEXT NOT NULL DEFAULT '[]',
+    created_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    id VARCHAR(200) NOT NULL UNIQUE,
+    webhook_id VARCHAR(200) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id VARCHAR(200) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at TIMESTAMP,
+    delivered_at TIMESTAMP,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, seq);
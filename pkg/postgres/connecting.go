@@ -2,19 +2,34 @@ package postgres
 
 import (
 	"context"
+	"log"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Postgres struct {
-	Pool *pgxpool.Pool
+	Pool     *pgxpool.Pool
+	Replicas []*pgxpool.Pool
 }
 
-func New(connectionString string) *Postgres {
-	return &Postgres{
+// New connects to the primary and to the read replicas, a replica that
+// cannot be connected to is skipped.
+func New(connectionString string, replicas ...string) *Postgres {
+	p := &Postgres{
 		Pool: newPool(context.Background(), 10, connectionString),
 	}
+
+	for i, dsn := range replicas {
+		pool := newPool(context.Background(), 1, dsn)
+		if pool == nil {
+			log.Printf("replica %d is unavailable, skipping it", i)
+			continue
+		}
+		p.Replicas = append(p.Replicas, pool)
+	}
+
+	return p
 }
 
 func newPool(ctx context.Context, maxAttempts int, connectionString string) (connectionPool *pgxpool.Pool) {
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"ozonProject/migrations"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Memory keeps the database in memory for the lifetime of the process.
+const Memory = ":memory:"
+
+type SQLite struct {
+	DB *sql.DB
+}
+
+// New opens the database file at path, creating it when missing, and applies
+// the embedded migrations.
+func New(path string) (*SQLite, error) {
+	// Write transactions take the lock up front, so concurrent writers wait
+	// for busy_timeout instead of failing on a lock upgrade.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	if path == Memory {
+		// Every connection to :memory: opens its own empty database.
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{DB: db}, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	files, err := fs.Glob(migrations.SQLite, "sqlite/*.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		script, err := fs.ReadFile(migrations.SQLite, name)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("apply %s: %w", name, err)
+		}
+	}
+
+	return nil
+}